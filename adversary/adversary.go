@@ -0,0 +1,181 @@
+// Package adversary provides malicious behaviours for DHT nodes, to test how IpfsDHT copes with
+// peers that lie or misbehave.
+//
+// Every behaviour is a dht.Interceptor, wrapping the handlers of an otherwise honest node. They
+// compose with each other, and with any other interceptor, through dht.WithInterceptors:
+//
+//	d, err := dht.New(ctx, h, dht.WithInterceptors(adversary.DropProviders(), adversary.Slow(time.Second, nil)))
+//
+// The behaviours only change what the node answers to its peers, the lookups the node runs itself
+// are left untouched.
+package adversary
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+)
+
+// eclipseCount is the number of closer peers the eclipsing nodes return, the default bucket size.
+const eclipseCount = 20
+
+// Group is a set of colluding peers.
+type Group struct {
+	mu    sync.Mutex
+	peers []peer.AddrInfo
+}
+
+// NewGroup returns a group of the given peers.
+func NewGroup(peers ...peer.AddrInfo) *Group {
+	return &Group{peers: append([]peer.AddrInfo(nil), peers...)}
+}
+
+// Add adds peers to the group. The peers don't have to exist, the members of a group may point
+// lookups to unreachable peers.
+func (g *Group) Add(peers ...peer.AddrInfo) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.peers = append(g.peers, peers...)
+}
+
+// Peers returns the members of the group.
+func (g *Group) Peers() []peer.AddrInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]peer.AddrInfo(nil), g.peers...)
+}
+
+// closest returns the count members of the group closest to the key.
+func (g *Group) closest(key []byte, count int) []peer.AddrInfo {
+	peers := g.Peers()
+	infos := make(map[peer.ID]peer.AddrInfo, len(peers))
+	ids := make([]peer.ID, 0, len(peers))
+	for _, pi := range peers {
+		if _, ok := infos[pi.ID]; !ok {
+			ids = append(ids, pi.ID)
+		}
+		infos[pi.ID] = pi
+	}
+	ids = kb.SortClosestPeers(ids, kb.ConvertKey(string(key)))
+	if len(ids) > count {
+		ids = ids[:count]
+	}
+	closest := make([]peer.AddrInfo, len(ids))
+	for i, id := range ids {
+		closest[i] = infos[id]
+	}
+	return closest
+}
+
+// Eclipse answers the FIND_NODE, GET_VALUE and GET_PROVIDERS requests with the members of the
+// group closest to the key as the closer peers, instead of the peers of the routing table, and
+// hides the records and providers it stores. The lookups reaching a member of the group then only
+// learn about other members, and never leave the group unless they know honest peers already.
+func Eclipse(g *Group) dht.Interceptor {
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next dht.Handler) (*pb.Message, error) {
+		switch req.GetType() {
+		case pb.Message_FIND_NODE, pb.Message_GET_VALUE, pb.Message_GET_PROVIDERS:
+		default:
+			return next(ctx, p, req)
+		}
+		resp := pb.NewMessage(req.GetType(), req.GetKey(), req.GetClusterLevel())
+		resp.CloserPeers = pb.RawPeerInfosToPBPeers(g.closest(req.GetKey(), eclipseCount))
+		return resp, nil
+	}
+}
+
+// StaleRecords stores the first record put for every key and ignores the later ones, while
+// acknowledging them, so that it keeps serving outdated records.
+func StaleRecords() dht.Interceptor {
+	var mu sync.Mutex
+	stored := make(map[string]struct{})
+
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next dht.Handler) (*pb.Message, error) {
+		if req.GetType() != pb.Message_PUT_VALUE {
+			return next(ctx, p, req)
+		}
+		key := string(req.GetKey())
+		mu.Lock()
+		_, ok := stored[key]
+		mu.Unlock()
+		if ok {
+			return req, nil
+		}
+
+		resp, err := next(ctx, p, req)
+		if err == nil {
+			mu.Lock()
+			stored[key] = struct{}{}
+			mu.Unlock()
+		}
+		return resp, err
+	}
+}
+
+// InvalidRecords answers every GET_VALUE request with a record of the given value, such as one
+// the validator of the requester rejects, whether or not it stores a record for the key.
+func InvalidRecords(value []byte) dht.Interceptor {
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next dht.Handler) (*pb.Message, error) {
+		if req.GetType() != pb.Message_GET_VALUE {
+			return next(ctx, p, req)
+		}
+		resp, err := next(ctx, p, req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Record = &recpb.Record{Key: req.GetKey(), Value: value}
+		return resp, nil
+	}
+}
+
+// DropProviders silently drops the ADD_PROVIDER requests, the providers never get stored.
+func DropProviders() dht.Interceptor {
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next dht.Handler) (*pb.Message, error) {
+		if req.GetType() == pb.Message_ADD_PROVIDER {
+			return nil, nil
+		}
+		return next(ctx, p, req)
+	}
+}
+
+// Slow delays every response by d. The delay is waited for with the sleep function, or on the
+// wall clock if it is nil, which allows delaying the responses in the virtual time of a simulated
+// network with simulation.Clock.Sleep.
+func Slow(d time.Duration, sleep func(context.Context, time.Duration) error) dht.Interceptor {
+	if sleep == nil {
+		sleep = sleepWallClock
+	}
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next dht.Handler) (*pb.Message, error) {
+		if err := sleep(ctx, d); err != nil {
+			return nil, err
+		}
+		return next(ctx, p, req)
+	}
+}
+
+func sleepWallClock(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unresponsive accepts the requests but never answers them, the requesters only give up when they
+// time out. The node still advertises the DHT protocols, and stays in the routing tables of the
+// peers that don't query it.
+func Unresponsive() dht.Interceptor {
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next dht.Handler) (*pb.Message, error) {
+		return nil, nil
+	}
+}
@@ -0,0 +1,237 @@
+package adversary
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	test "github.com/libp2p/go-libp2p-kad-dht/internal/testing"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/simulation"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// scenario is a simulated network of honest and adversarial nodes.
+type scenario struct {
+	net       *simulation.Network
+	honest    []*simulation.Node
+	attackers []*simulation.Node
+	group     *Group
+}
+
+// newScenario creates a network of honest nodes and attackers, the attackers running the
+// interceptors returned by attack, and fills the routing tables.
+func newScenario(t *testing.T, honest, attackers int, attack func(*simulation.Network, *Group) []dht.Interceptor) *scenario {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	n := simulation.NewNetwork(simulation.Config{Seed: 1, Latency: simulation.UniformLatency{Min: 10 * time.Millisecond, Max: 100 * time.Millisecond}})
+	t.Cleanup(func() { require.NoError(t, n.Close()) })
+
+	opts := []dht.Option{
+		dht.DisableAutoRefresh(),
+		dht.ProtocolPrefix("/test"),
+		dht.NamespacedValidator("v", test.TestValidator{}),
+	}
+	s := &scenario{net: n}
+	var err error
+	s.honest, err = n.AddNodes(ctx, honest, opts...)
+	require.NoError(t, err)
+
+	s.group = NewGroup()
+	for i := 0; i < attackers; i++ {
+		node, err := n.AddNode(ctx, append(opts, dht.WithInterceptors(attack(n, s.group)...))...)
+		require.NoError(t, err)
+		s.group.Add(peer.AddrInfo{ID: node.ID, Addrs: []ma.Multiaddr{node.Addr}})
+		s.attackers = append(s.attackers, node)
+	}
+	n.FillRoutingTables()
+	return s
+}
+
+// lookupSuccess runs lookups from the honest node for random keys, and returns the fraction of
+// them finding the honest node closest to the key.
+func (s *scenario) lookupSuccess(t *testing.T, from *simulation.Node, lookups int) float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	honest := make([]peer.ID, len(s.honest))
+	for i, n := range s.honest {
+		honest[i] = n.ID
+	}
+
+	var found int
+	for i := 0; i < lookups; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		closest := kb.SortClosestPeers(honest, kb.ConvertKey(key))[0]
+		if closest == from.ID {
+			closest = kb.SortClosestPeers(honest, kb.ConvertKey(key))[1]
+		}
+		peers, err := from.DHT.GetClosestPeers(ctx, key)
+		if err != nil {
+			continue
+		}
+		for _, p := range peers {
+			if p == closest {
+				found++
+				break
+			}
+		}
+	}
+	return float64(found) / float64(lookups)
+}
+
+func TestEclipse(t *testing.T) {
+	s := newScenario(t, 100, 25, func(_ *simulation.Network, g *Group) []dht.Interceptor {
+		return []dht.Interceptor{Eclipse(g)}
+	})
+
+	// a victim only knowing the attackers never reaches the honest nodes. It has to run its
+	// lookups first, before the other nodes connect to it and it learns about them.
+	victim := s.honest[1]
+	for _, n := range s.honest {
+		victim.DHT.RoutingTable().RemovePeer(n.ID)
+	}
+	require.NotZero(t, victim.DHT.RoutingTable().Size())
+	rate := s.lookupSuccess(t, victim, 20)
+	t.Logf("lookup success of an eclipsed node: %.2f", rate)
+	require.Zero(t, rate)
+
+	rate = s.lookupSuccess(t, s.honest[0], 20)
+	t.Logf("lookup success with 20%% of eclipsing nodes: %.2f", rate)
+	require.NotZero(t, rate)
+}
+
+func TestEclipseFakePeers(t *testing.T) {
+	s := newScenario(t, 100, 25, func(_ *simulation.Network, g *Group) []dht.Interceptor {
+		return []dht.Interceptor{Eclipse(g)}
+	})
+	// the attackers also point the lookups to peers that don't exist.
+	for i := 0; i < 50; i++ {
+		sk, _, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(int64(i))))
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+		s.group.Add(peer.AddrInfo{ID: id})
+	}
+
+	rate := s.lookupSuccess(t, s.honest[0], 20)
+	t.Logf("lookup success with eclipsing nodes pointing to fake peers: %.2f", rate)
+	require.NotZero(t, rate)
+}
+
+func TestInvalidRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	s := newScenario(t, 100, 25, func(*simulation.Network, *Group) []dht.Interceptor {
+		return []dht.Interceptor{InvalidRecords([]byte("expired"))}
+	})
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("/v/key-%d", i)
+		require.NoError(t, s.honest[0].DHT.PutValue(ctx, key, []byte("valid")))
+		val, err := s.honest[1].DHT.GetValue(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("valid"), val)
+	}
+}
+
+func TestStaleRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	s := newScenario(t, 100, 25, func(*simulation.Network, *Group) []dht.Interceptor {
+		return []dht.Interceptor{StaleRecords()}
+	})
+
+	var stale int
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("/v/key-%d", i)
+		require.NoError(t, s.honest[0].DHT.PutValue(ctx, key, []byte("valid")))
+		require.NoError(t, s.honest[0].DHT.PutValue(ctx, key, []byte("newer")))
+		val, err := s.honest[1].DHT.GetValue(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("newer"), val)
+
+		for _, a := range s.attackers {
+			resp, err := a.DHT.HandleRequest(s.honest[1].ID, "/test/kad/1.0.0", pb.NewMessage(pb.Message_GET_VALUE, []byte(key), 0))
+			require.NoError(t, err)
+			if string(resp.GetRecord().GetValue()) == "valid" {
+				stale++
+			}
+		}
+	}
+	// the attackers kept the outdated records.
+	require.NotZero(t, stale)
+}
+
+func TestDropProviders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	s := newScenario(t, 100, 25, func(*simulation.Network, *Group) []dht.Interceptor {
+		return []dht.Interceptor{DropProviders()}
+	})
+
+	for i := 0; i < 5; i++ {
+		c := testCid(t, i)
+		require.NoError(t, s.honest[0].DHT.Provide(ctx, c, true))
+		provs, err := s.honest[1].DHT.FindProviders(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, provs, 1)
+		require.Equal(t, s.honest[0].ID, provs[0].ID)
+
+		for _, a := range s.attackers {
+			provs, err := a.DHT.ProviderStore().GetProviders(ctx, c.Hash())
+			require.NoError(t, err)
+			require.Empty(t, provs)
+		}
+	}
+}
+
+func TestSlow(t *testing.T) {
+	baseline := newScenario(t, 100, 0, nil)
+	start := baseline.net.Clock().Now()
+	require.Equal(t, 1.0, baseline.lookupSuccess(t, baseline.honest[0], 10))
+	fast := baseline.net.Clock().Since(start)
+
+	s := newScenario(t, 100, 25, func(n *simulation.Network, _ *Group) []dht.Interceptor {
+		return []dht.Interceptor{Slow(time.Second, n.Clock().Sleep)}
+	})
+	start = s.net.Clock().Now()
+	rate := s.lookupSuccess(t, s.honest[0], 10)
+	slow := s.net.Clock().Since(start)
+	t.Logf("lookup success with 20%% of slow nodes: %.2f, in %s instead of %s", rate, slow, fast)
+	require.Equal(t, 1.0, rate)
+	require.Greater(t, slow, fast)
+}
+
+func TestUnresponsive(t *testing.T) {
+	s := newScenario(t, 100, 25, func(*simulation.Network, *Group) []dht.Interceptor {
+		return []dht.Interceptor{Unresponsive()}
+	})
+
+	start := s.net.Clock().Now()
+	rate := s.lookupSuccess(t, s.honest[0], 10)
+	t.Logf("lookup success with 20%% of unresponsive nodes: %.2f, in %s", rate, s.net.Clock().Since(start))
+	require.NotZero(t, rate)
+	// the lookups waited for the attackers to time out.
+	require.GreaterOrEqual(t, s.net.Clock().Since(start), 10*time.Second)
+}
+
+func testCid(t *testing.T, i int) cid.Cid {
+	mh, err := multihash.Sum([]byte(fmt.Sprintf("content-%d", i)), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
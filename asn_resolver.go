@@ -0,0 +1,154 @@
+package dht
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	asnutil "github.com/libp2p/go-libp2p-asn-util"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+)
+
+// ASNResolver maps IP addresses to the number of the autonomous system (ASN) announcing them, which
+// the diversity filters use to group the peers of the same network. ASN returns an empty string if
+// the ASN of the address isn't known.
+type ASNResolver = dhtcfg.ASNResolver
+
+// DefaultASNResolver resolves the ASNs of IPv6 addresses with the database embedded in
+// go-libp2p-asn-util. The ASNs of IPv4 addresses aren't known.
+var DefaultASNResolver ASNResolver = defaultASNResolver{}
+
+type defaultASNResolver struct{}
+
+func (defaultASNResolver) ASN(ip net.IP) (string, error) {
+	if ip.To4() != nil {
+		return "", nil
+	}
+	return asnutil.Store.AsnForIPv6(ip)
+}
+
+// ASNTable is an ASNResolver backed by a table of IP prefixes, such as the ones published by the
+// regional internet registries or derived from BGP dumps. Addresses are mapped to the ASN of the
+// longest prefix containing them. It doesn't need any network access.
+type ASNTable struct {
+	v4, v6 prefixTable
+}
+
+// prefixTable holds the prefixes of a single address family, by length.
+type prefixTable struct {
+	lens []int // prefix lengths in use, longest first
+	asns map[int]map[string]string
+}
+
+var _ ASNResolver = (*ASNTable)(nil)
+
+// NewASNTable returns an empty ASNTable.
+func NewASNTable() *ASNTable {
+	return &ASNTable{
+		v4: prefixTable{asns: make(map[int]map[string]string)},
+		v6: prefixTable{asns: make(map[int]map[string]string)},
+	}
+}
+
+// LoadASNTable reads an ASNTable from CSV records of the form "prefix,asn", e.g.
+// "192.0.2.0/24,64496" or "2001:db8::/32,AS64497". Any additional field is ignored, as well as empty
+// lines and lines starting with #.
+func LoadASNTable(r io.Reader) (*ASNTable, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	t := NewASNTable()
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected a prefix and an asn", line)
+		}
+		_, prefix, err := net.ParseCIDR(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		asn := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rec[1])), "AS")
+		if _, err := strconv.ParseUint(asn, 10, 32); err != nil {
+			return nil, fmt.Errorf("line %d: invalid asn %q", line, rec[1])
+		}
+		t.Add(prefix, asn)
+	}
+}
+
+// LoadASNTableFile reads an ASNTable from the CSV file at path, see LoadASNTable for the format.
+func LoadASNTableFile(path string) (*ASNTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadASNTable(f)
+}
+
+// Add maps the addresses of prefix to the given ASN, replacing the ASN of the same prefix if any.
+// It isn't safe to call Add concurrently with the other methods.
+func (t *ASNTable) Add(prefix *net.IPNet, asn string) {
+	ones, bits := prefix.Mask.Size()
+	if bits == 8*net.IPv4len {
+		t.v4.add(prefix.IP.To4().Mask(prefix.Mask), ones, asn)
+	} else {
+		t.v6.add(prefix.IP.To16().Mask(prefix.Mask), ones, asn)
+	}
+}
+
+// ASN returns the ASN of the longest prefix containing ip, or an empty string if there is none.
+func (t *ASNTable) ASN(ip net.IP) (string, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return t.v4.lookup(ip4, 8*net.IPv4len), nil
+	}
+	if ip6 := ip.To16(); ip6 != nil {
+		return t.v6.lookup(ip6, 8*net.IPv6len), nil
+	}
+	return "", fmt.Errorf("invalid ip address %v", ip)
+}
+
+// Len returns the number of prefixes in the table.
+func (t *ASNTable) Len() int {
+	n := 0
+	for _, pt := range []prefixTable{t.v4, t.v6} {
+		for _, m := range pt.asns {
+			n += len(m)
+		}
+	}
+	return n
+}
+
+func (pt *prefixTable) add(ip net.IP, ones int, asn string) {
+	m, ok := pt.asns[ones]
+	if !ok {
+		m = make(map[string]string)
+		pt.asns[ones] = m
+		pt.lens = append(pt.lens, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(pt.lens)))
+	}
+	m[string(ip)] = asn
+}
+
+func (pt *prefixTable) lookup(ip net.IP, bits int) string {
+	for _, ones := range pt.lens {
+		if asn, ok := pt.asns[ones][string(ip.Mask(net.CIDRMask(ones, bits)))]; ok {
+			return asn
+		}
+	}
+	return ""
+}
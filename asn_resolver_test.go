@@ -0,0 +1,55 @@
+package dht
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestASNTable(t *testing.T) {
+	table, err := LoadASNTable(strings.NewReader(`# prefix,asn,name
+192.0.2.0/24,64496,example
+192.0.2.128/25, AS64497
+198.51.0.0/16,as64498
+
+2001:db8::/32,64499
+`))
+	require.NoError(t, err)
+	require.Equal(t, 4, table.Len())
+
+	for ip, asn := range map[string]string{
+		"192.0.2.1":    "64496",
+		"192.0.2.200":  "64497", // longest prefix wins
+		"198.51.100.1": "64498",
+		"203.0.113.1":  "",
+		"2001:db8::1":  "64499",
+		"2001:db9::1":  "",
+	} {
+		got, err := table.ASN(net.ParseIP(ip))
+		require.NoError(t, err)
+		require.Equal(t, asn, got, ip)
+	}
+
+	for _, bad := range []string{"192.0.2.0/24", "192.0.2.0,64496", "192.0.2.0/24,foo"} {
+		_, err := LoadASNTable(strings.NewReader(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestLoadASNTableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.csv")
+	require.NoError(t, os.WriteFile(path, []byte("127.0.0.0/8,64496\n"), 0o644))
+
+	table, err := LoadASNTableFile(path)
+	require.NoError(t, err)
+	asn, err := table.ASN(net.ParseIP("127.0.0.1"))
+	require.NoError(t, err)
+	require.Equal(t, "64496", asn)
+
+	_, err = LoadASNTableFile(filepath.Join(t.TempDir(), "missing.csv"))
+	require.Error(t, err)
+}
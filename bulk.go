@@ -0,0 +1,323 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+)
+
+// bulkSendTimeout bounds the time spent dialing a peer, and sending a single message to it, during
+// ProvideMany and PutMany.
+const bulkSendTimeout = 10 * time.Second
+
+// BulkKeyResult is the outcome of sending a single key of a ProvideMany or PutMany call.
+type BulkKeyResult struct {
+	// Key is the provided multihash or the record key.
+	Key string
+	// Peers is the number of peers that stored the provider record or the value.
+	Peers int
+	// Err is the reason the key wasn't stored on any peer, nil if it was stored on at least one.
+	Err error
+}
+
+// WithBulkProgress returns a context that makes ProvideMany and PutMany, of both IpfsDHT and
+// fullrt.FullRT, call fn with the outcome of every key, as soon as the key has been sent to all of
+// its closest peers. The calls are serialized, and must not block for long as they hold up the
+// remaining keys.
+func WithBulkProgress(ctx context.Context, fn func(BulkKeyResult)) context.Context {
+	return context.WithValue(ctx, internal.BulkProgressKey{}, fn)
+}
+
+// bulkReporter collects the outcome of the keys of a bulk send, and forwards it to the progress
+// callback registered with WithBulkProgress, if any.
+type bulkReporter struct {
+	mu     sync.Mutex
+	fn     func(BulkKeyResult)
+	keys   int
+	failed int
+}
+
+func newBulkReporter(ctx context.Context) *bulkReporter {
+	fn, _ := ctx.Value(internal.BulkProgressKey{}).(func(BulkKeyResult))
+	return &bulkReporter{fn: fn}
+}
+
+func (r *bulkReporter) report(res BulkKeyResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys++
+	if res.Err != nil {
+		r.failed++
+	}
+	if r.fn != nil {
+		r.fn(res)
+	}
+}
+
+func (r *bulkReporter) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed > 0 {
+		return fmt.Errorf("failed to store %d out of %d keys", r.failed, r.keys)
+	}
+	return nil
+}
+
+// ProvideMany adds ourselves as a provider of all the given keys and announces them to the network,
+// like Provide does for a single key. The keys are sorted by their position in the keyspace, and
+// the keys of the same keyspace region share a single lookup. Every peer then receives all of its
+// keys over the same stream.
+//
+// The outcome of every key can be followed with WithBulkProgress. An error is returned if any of the
+// keys couldn't be stored on any peer.
+func (dht *IpfsDHT) ProvideMany(ctx context.Context, keys []multihash.Multihash) (err error) {
+	ctx, end := tracer.ProvideMany(dhtName, ctx, keys)
+	defer func() { end(err) }()
+
+	if !dht.enableProviders {
+		return routing.ErrNotSupported
+	}
+
+	unique := make(map[string]struct{}, len(keys))
+	strKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if _, ok := unique[string(k)]; ok {
+			continue
+		}
+		unique[string(k)] = struct{}{}
+		strKeys = append(strKeys, string(k))
+
+		// add self locally
+		dht.providerStore.AddProvider(ctx, k, peer.AddrInfo{ID: dht.self})
+	}
+
+	// remember the keys so that they get announced again before they expire
+	if dht.reprovider != nil {
+		if err := dht.reprovider.Add(ctx, keys...); err != nil {
+			logger.Warnw("failed to add keys to reprovider", "error", err)
+		}
+	}
+
+	// the encrypted provider records are announced alongside the classic ones, like Provide does.
+	var wg sync.WaitGroup
+	if dht.encryptedProviders != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dht.provideManyEncrypted(ctx, strKeys)
+		}()
+	}
+
+	rep := newBulkReporter(ctx)
+	dht.bulkSend(ctx, strKeys, rep, func(ctx context.Context, p peer.ID, k string) error {
+		keyMH := multihash.Multihash(k)
+		self, signed := dht.selfProviderRecord(keyMH)
+		return dht.protoMessenger.PutSignedProviderAddrs(ctx, p, keyMH, self, signed)
+	})
+	wg.Wait()
+	return rep.err()
+}
+
+// PutMany stores all the given records locally and on the network, like PutValue does for a single
+// record. Records are sent the same way as ProvideMany sends provider records. Records that are
+// invalid, or older than the record we already have, are reported as failed and aren't sent.
+//
+// The outcome of every key can be followed with WithBulkProgress. An error is returned if any of the
+// records couldn't be stored on any peer.
+func (dht *IpfsDHT) PutMany(ctx context.Context, keys []string, values [][]byte) error {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.PutMany", trace.WithAttributes(attribute.Int("NumKeys", len(keys))))
+	defer span.End()
+
+	if !dht.enableValues {
+		return routing.ErrNotSupported
+	}
+
+	if len(keys) != len(values) {
+		return fmt.Errorf("number of keys does not match the number of values")
+	}
+
+	recs := make(map[string]*recpb.Record, len(keys))
+	for _, k := range keys {
+		if _, ok := recs[k]; ok {
+			return fmt.Errorf("does not support duplicate keys")
+		}
+		recs[k] = nil
+	}
+
+	rep := newBulkReporter(ctx)
+	sendKeys := make([]string, 0, len(keys))
+	for i, k := range keys {
+		rec, err := dht.putValueLocal(ctx, k, values[i])
+		if err != nil {
+			rep.report(BulkKeyResult{Key: k, Err: err})
+			continue
+		}
+		recs[k] = rec
+		sendKeys = append(sendKeys, k)
+	}
+
+	dht.bulkSend(ctx, sendKeys, rep, func(ctx context.Context, p peer.ID, k string) error {
+		return dht.protoMessenger.PutValue(ctx, p, recs[k])
+	})
+	return rep.err()
+}
+
+// bulkSend sends every key to its closest peers with send, one keyspace region at a time, and
+// reports the outcome of every key to rep.
+func (dht *IpfsDHT) bulkSend(ctx context.Context, keys []string, rep *bulkReporter, send func(ctx context.Context, p peer.ID, k string) error) {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.BulkSend")
+	defer span.End()
+
+	regions := groupByRegion(keys, dht.regionPrefixLen())
+	logger.Infow("bulk sending keys", "keys", len(keys), "regions", len(regions))
+
+	for _, region := range regions {
+		if ctx.Err() != nil {
+			for _, k := range region {
+				rep.report(BulkKeyResult{Key: k, Err: ctx.Err()})
+			}
+			continue
+		}
+		dht.bulkSendRegion(ctx, region, rep, send)
+	}
+}
+
+// bulkSendRegion sends the keys of a single keyspace region. The lookups of the first, middle and
+// last keys of the region serve all of the keys, the peers of every key are picked among the peers
+// they found.
+func (dht *IpfsDHT) bulkSendRegion(ctx context.Context, keys []string, rep *bulkReporter, send func(ctx context.Context, p peer.ID, k string) error) {
+	peers, err := dht.regionPeers(ctx, keys)
+	if err != nil {
+		for _, k := range keys {
+			rep.report(BulkKeyResult{Key: k, Err: err})
+		}
+		return
+	}
+
+	keysPerPeer := make(map[peer.ID][]string)
+	for _, k := range keys {
+		closest := kb.SortClosestPeers(peers, kb.ConvertKey(k))
+		if len(closest) > dht.bucketSize {
+			closest = closest[:dht.bucketSize]
+		}
+		for _, p := range closest {
+			keysPerPeer[p] = append(keysPerPeer[p], k)
+		}
+	}
+
+	var mu sync.Mutex
+	stored := make(map[string]int, len(keys))
+	errs := make(map[string]error)
+	done := func(k string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			stored[k]++
+		} else {
+			errs[k] = err
+		}
+	}
+
+	connmgrTag := fmt.Sprintf("dht-bulk-send-%d", rand.Int())
+	workCh := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < dht.bulkSendParallelism && i < len(keysPerPeer); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range workCh {
+				dialCtx, cancel := context.WithTimeout(ctx, bulkSendTimeout)
+				err := dht.host.Connect(dialCtx, peer.AddrInfo{ID: p})
+				cancel()
+				if err != nil {
+					for _, k := range keysPerPeer[p] {
+						done(k, err)
+					}
+					continue
+				}
+
+				// all the keys go over the stream the message sender keeps open to the peer
+				dht.host.ConnManager().Protect(p, connmgrTag)
+				for _, k := range keysPerPeer[p] {
+					sendCtx, cancel := context.WithTimeout(ctx, bulkSendTimeout)
+					err := send(sendCtx, p, k)
+					cancel()
+					if err != nil {
+						logger.Debugw("bulk send failed", "peer", p, "error", err)
+					}
+					done(k, err)
+				}
+				dht.host.ConnManager().Unprotect(p, connmgrTag)
+			}
+		}()
+	}
+
+sendLoop:
+	for p := range keysPerPeer {
+		select {
+		case workCh <- p:
+		case <-ctx.Done():
+			break sendLoop
+		}
+	}
+	close(workCh)
+	wg.Wait()
+
+	for _, k := range keys {
+		res := BulkKeyResult{Key: k, Peers: stored[k]}
+		if res.Peers == 0 {
+			res.Err = errs[k]
+			if res.Err == nil {
+				res.Err = ctx.Err()
+			}
+			if res.Err == nil {
+				res.Err = ErrNoPeersStored
+			}
+		}
+		rep.report(res)
+	}
+}
+
+// regionPeers looks up the peers closest to the edges and to the middle of a region whose keys are
+// sorted by their position in the keyspace. A single lookup only finds bucketSize peers, which
+// would be too few for the keys far from where it was run. It fails only if all of the lookups fail.
+func (dht *IpfsDHT) regionPeers(ctx context.Context, keys []string) ([]peer.ID, error) {
+	var peers []peer.ID
+	var lastErr error
+	seen := make(map[peer.ID]struct{})
+	looked := make(map[string]struct{}, 3)
+	for _, k := range []string{keys[0], keys[len(keys)/2], keys[len(keys)-1]} {
+		if _, ok := looked[k]; ok {
+			continue
+		}
+		looked[k] = struct{}{}
+
+		closest, err := dht.GetClosestPeers(ctx, k)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, p := range closest {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				peers = append(peers, p)
+			}
+		}
+	}
+	if len(peers) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return peers, nil
+}
@@ -0,0 +1,137 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	test "github.com/libp2p/go-libp2p-kad-dht/internal/testing"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+func TestProvideMany(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider := setupDHT(ctx, t, false)
+	dhts := setupDHTS(t, ctx, 5)
+	for _, d := range dhts {
+		connect(t, ctx, provider, d)
+	}
+
+	keys := make([]multihash.Multihash, 0, 30)
+	for _, c := range testCaseCids[:30] {
+		keys = append(keys, c.Hash())
+	}
+
+	var results []BulkKeyResult
+	pctx := WithBulkProgress(ctx, func(r BulkKeyResult) { results = append(results, r) })
+	// duplicates are only announced once
+	require.NoError(t, provider.ProvideMany(pctx, append(keys, keys[0])))
+
+	require.Len(t, results, len(keys))
+	for _, r := range results {
+		require.NoError(t, r.Err)
+		require.Equal(t, len(dhts), r.Peers)
+	}
+
+	for _, c := range testCaseCids[:30] {
+		provs, err := dhts[0].FindProviders(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, provs, 1)
+		require.Equal(t, provider.self, provs[0].ID)
+	}
+}
+
+func TestPutMany(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	validator := NamespacedValidator("v", test.TestValidator{})
+	d := setupDHT(ctx, t, false, validator)
+	dhts := setupDHTS(t, ctx, 3, validator)
+	for _, p := range dhts {
+		connect(t, ctx, d, p)
+	}
+
+	var keys []string
+	var values [][]byte
+	for i := 0; i < 10; i++ {
+		keys = append(keys, fmt.Sprintf("/v/bulk-%d", i))
+		values = append(values, []byte("valid"))
+	}
+	values[3] = []byte("expired")
+
+	results := make(map[string]BulkKeyResult)
+	pctx := WithBulkProgress(ctx, func(r BulkKeyResult) { results[r.Key] = r })
+	require.Error(t, d.PutMany(pctx, keys, values), "an invalid record was put")
+
+	require.Len(t, results, len(keys))
+	require.Error(t, results[keys[3]].Err)
+	require.Zero(t, results[keys[3]].Peers)
+	for i, k := range keys {
+		if i == 3 {
+			continue
+		}
+		require.NoError(t, results[k].Err, k)
+		require.Equal(t, len(dhts), results[k].Peers)
+
+		rec, err := dhts[0].getLocal(ctx, k)
+		require.NoError(t, err)
+		require.NotNil(t, rec, k)
+		require.Equal(t, []byte("valid"), rec.GetValue())
+	}
+
+	require.Error(t, d.PutMany(ctx, []string{keys[0], keys[0]}, [][]byte{values[0], values[0]}))
+	require.Error(t, d.PutMany(ctx, keys, values[:1]))
+}
+
+func TestBulkSendRegionPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, BucketSize(2))
+	dhts := setupDHTS(t, ctx, 8, BucketSize(2))
+	peers := make([]peer.ID, 0, len(dhts))
+	for i, other := range dhts {
+		connect(t, ctx, d, other)
+		for _, prev := range dhts[:i] {
+			connect(t, ctx, other, prev)
+		}
+		peers = append(peers, other.self)
+	}
+
+	// two keys whose closest peers differ, the lookup of the middle key only finds the peers of the
+	// last one.
+	closest := func(k string) []peer.ID {
+		return kb.SortClosestPeers(peers, kb.ConvertKey(k))[:2]
+	}
+	keys := []string{"/v/region-0"}
+	for i := 1; len(keys) < 2; i++ {
+		k := fmt.Sprintf("/v/region-%d", i)
+		if !slices.Equal(closest(keys[0]), closest(k)) {
+			keys = append(keys, k)
+		}
+	}
+
+	var mu sync.Mutex
+	sent := make(map[string][]peer.ID)
+	rep := newBulkReporter(ctx)
+	d.bulkSendRegion(ctx, keys, rep, func(_ context.Context, p peer.ID, k string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent[k] = append(sent[k], p)
+		return nil
+	})
+	require.NoError(t, rep.err())
+	for _, k := range keys {
+		require.ElementsMatch(t, closest(k), sent[k], k)
+	}
+}
@@ -0,0 +1,299 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// checkpointPeersPrefix holds the latest result of every peer queried.
+	checkpointPeersPrefix = "/crawler/peers"
+	// checkpointFrontierPrefix holds the peers left to query by the crawl in progress.
+	checkpointFrontierPrefix = "/crawler/frontier"
+	// checkpointVisitedPrefix holds the peers already queried by the crawl in progress.
+	checkpointVisitedPrefix = "/crawler/visited"
+)
+
+// peerRecord is the persisted result of querying a peer.
+type peerRecord struct {
+	QueriedAt time.Time
+	Addrs     [][]byte
+	// Peers is the routing table of the peer, empty if the query failed.
+	Peers []recordPeer
+	// Error is why the query failed.
+	Error string
+}
+
+type recordPeer struct {
+	ID    peer.ID
+	Addrs [][]byte
+}
+
+// frontierEntry is the persisted form of a peer left to query.
+type frontierEntry struct {
+	Addrs [][]byte
+}
+
+// checkpoints persists the progress of the crawls. It is only used by the goroutine running the
+// crawl.
+type checkpoints struct {
+	ds           ds.Datastore
+	requeryAfter time.Duration
+
+	// records are the results of the peers queried by the previous crawls, loaded by resume. They
+	// are dropped as they are reported, by resume or fresh.
+	records map[peer.ID]*peerRecord
+}
+
+func peerKey(prefix string, p peer.ID) ds.Key {
+	return ds.NewKey(prefix).ChildString(p.String())
+}
+
+func addrsToBytes(addrs []ma.Multiaddr) [][]byte {
+	b := make([][]byte, 0, len(addrs))
+	for _, a := range addrs {
+		b = append(b, a.Bytes())
+	}
+	return b
+}
+
+func addrsFromBytes(b [][]byte) []ma.Multiaddr {
+	addrs := make([]ma.Multiaddr, 0, len(b))
+	for _, ab := range b {
+		a, err := ma.NewMultiaddrBytes(ab)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+// write applies f to a batch of the datastore if it supports batching, so that the updates of a
+// single result are committed together, or directly to the datastore otherwise.
+func (c *checkpoints) write(ctx context.Context, f func(w ds.Write) error) error {
+	b, ok := c.ds.(ds.Batching)
+	if !ok {
+		return f(c.ds)
+	}
+	batch, err := b.Batch(ctx)
+	if err == ds.ErrBatchUnsupported {
+		return f(c.ds)
+	} else if err != nil {
+		return err
+	}
+	if err := f(batch); err != nil {
+		return err
+	}
+	return batch.Commit(ctx)
+}
+
+// resume returns the state of the crawl in progress, if any: the peers left to query and the
+// results of the peers already queried. It loads the results of the other peers at the same time
+// if they may be reported again, see fresh.
+func (c *checkpoints) resume(ctx context.Context) (frontier []*peer.AddrInfo, visited map[peer.ID]*peerRecord, err error) {
+	res, err := c.ds.Query(ctx, query.Query{Prefix: checkpointFrontierPrefix})
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		p, err := peer.Decode(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid frontier key %s: %w", e.Key, err)
+		}
+		var fe frontierEntry
+		if err := json.Unmarshal(e.Value, &fe); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode frontier entry of %s: %w", p, err)
+		}
+		frontier = append(frontier, &peer.AddrInfo{ID: p, Addrs: addrsFromBytes(fe.Addrs)})
+	}
+
+	res, err = c.ds.Query(ctx, query.Query{Prefix: checkpointVisitedPrefix, KeysOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err = res.Rest()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.records = nil
+	if len(entries) > 0 || c.requeryAfter > 0 {
+		if c.records, err = c.loadRecords(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	visited = make(map[peer.ID]*peerRecord, len(entries))
+	for _, e := range entries {
+		p, err := peer.Decode(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid visited key %s: %w", e.Key, err)
+		}
+		visited[p] = c.records[p]
+		delete(c.records, p)
+	}
+	return frontier, visited, nil
+}
+
+// loadRecords returns the latest results of all the peers queried.
+func (c *checkpoints) loadRecords(ctx context.Context) (map[peer.ID]*peerRecord, error) {
+	res, err := c.ds.Query(ctx, query.Query{Prefix: checkpointPeersPrefix})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[peer.ID]*peerRecord, len(entries))
+	for _, e := range entries {
+		p, err := peer.Decode(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			return nil, fmt.Errorf("invalid crawl record key %s: %w", e.Key, err)
+		}
+		rec := new(peerRecord)
+		if err := json.Unmarshal(e.Value, rec); err != nil {
+			// the peer is queried again, overwriting the record.
+			logger.Warnf("failed to decode the crawl record of %v: %v", p, err)
+			continue
+		}
+		records[p] = rec
+	}
+	return records, nil
+}
+
+// fresh returns the result of querying p if it was queried successfully recently enough not to
+// query it again, according to the records loaded by resume.
+func (c *checkpoints) fresh(p peer.ID) *queryResult {
+	if c.requeryAfter <= 0 {
+		return nil
+	}
+	rec, ok := c.records[p]
+	if !ok {
+		return nil
+	}
+	delete(c.records, p)
+	if len(rec.Peers) == 0 || time.Since(rec.QueriedAt) >= c.requeryAfter {
+		return nil
+	}
+	return rec.result(p)
+}
+
+// result returns the query result the record was made of.
+func (rec *peerRecord) result(p peer.ID) *queryResult {
+	res := &queryResult{peer: p, addrs: addrsFromBytes(rec.Addrs), fromCheckpoint: true}
+	if len(rec.Peers) == 0 {
+		err := rec.Error
+		if err == "" {
+			err = errEmptyRoutingTable.Error()
+		}
+		res.err = errors.New(err)
+		return res
+	}
+	res.data = make(map[peer.ID]*peer.AddrInfo, len(rec.Peers))
+	for _, rp := range rec.Peers {
+		res.data[rp.ID] = &peer.AddrInfo{ID: rp.ID, Addrs: addrsFromBytes(rp.Addrs)}
+	}
+	return res
+}
+
+func putFrontier(ctx context.Context, w ds.Write, ai *peer.AddrInfo) error {
+	buf, err := json.Marshal(frontierEntry{Addrs: addrsToBytes(ai.Addrs)})
+	if err != nil {
+		return err
+	}
+	return w.Put(ctx, peerKey(checkpointFrontierPrefix, ai.ID), buf)
+}
+
+// addFrontier adds peers left to query.
+func (c *checkpoints) addFrontier(ctx context.Context, peers []*peer.AddrInfo) error {
+	return c.write(ctx, func(w ds.Write) error {
+		for _, ai := range peers {
+			if err := putFrontier(ctx, w, ai); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// visit records the result of querying a peer, along with the new peers it led to, and moves the
+// peer from the frontier to the visited peers.
+func (c *checkpoints) visit(ctx context.Context, res *queryResult, discovered []*peer.AddrInfo) error {
+	return c.write(ctx, func(w ds.Write) error {
+		if !res.fromCheckpoint {
+			rec := peerRecord{QueriedAt: time.Now(), Addrs: addrsToBytes(res.addrs)}
+			for _, ai := range res.data {
+				rec.Peers = append(rec.Peers, recordPeer{ID: ai.ID, Addrs: addrsToBytes(ai.Addrs)})
+			}
+			if len(rec.Peers) == 0 && res.err != nil {
+				rec.Error = res.err.Error()
+			}
+			buf, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if err := w.Put(ctx, peerKey(checkpointPeersPrefix, res.peer), buf); err != nil {
+				return err
+			}
+		}
+		for _, ai := range discovered {
+			if err := putFrontier(ctx, w, ai); err != nil {
+				return err
+			}
+		}
+		if err := w.Delete(ctx, peerKey(checkpointFrontierPrefix, res.peer)); err != nil {
+			return err
+		}
+		return w.Put(ctx, peerKey(checkpointVisitedPrefix, res.peer), []byte{})
+	})
+}
+
+// finish forgets the state of the crawl once it completed. The results of the peers it visited are
+// kept for the next crawls, those of the peers it did not reach anymore are deleted so that the
+// records don't grow with every peer ever seen.
+func (c *checkpoints) finish(ctx context.Context) error {
+	var keys []ds.Key
+	visited := make(map[string]struct{})
+	for _, prefix := range []string{checkpointFrontierPrefix, checkpointVisitedPrefix, checkpointPeersPrefix} {
+		res, err := c.ds.Query(ctx, query.Query{Prefix: prefix, KeysOnly: true})
+		if err != nil {
+			return err
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			k := ds.RawKey(e.Key)
+			switch prefix {
+			case checkpointVisitedPrefix:
+				visited[k.BaseNamespace()] = struct{}{}
+			case checkpointPeersPrefix:
+				if _, ok := visited[k.BaseNamespace()]; ok {
+					continue
+				}
+			}
+			keys = append(keys, k)
+		}
+	}
+	return c.write(ctx, func(w ds.Write) error {
+		for _, k := range keys {
+			if err := w.Delete(ctx, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
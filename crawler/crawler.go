@@ -2,6 +2,7 @@ package crawler
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
 
 	logging "github.com/ipfs/go-log/v2"
 	//lint:ignore SA1019 TODO migrate away from gogo pb
@@ -37,6 +39,8 @@ type (
 		host                 host.Host
 		dhtRPC               *pb.ProtocolMessenger
 		dialAddressExtendDur time.Duration
+		checkpoints          *checkpoints
+		limiter              *rateLimiter
 	}
 )
 
@@ -52,18 +56,29 @@ func NewDefaultCrawler(host host.Host, opts ...Option) (*DefaultCrawler, error)
 		}
 	}
 
+	if o.requeryAfter > 0 && o.checkpoints == nil {
+		return nil, fmt.Errorf("requerying peers after a given age requires checkpoints")
+	}
+
 	pm, err := pb.NewProtocolMessenger(&messageSender{h: host, protocols: o.protocols, timeout: o.perMsgTimeout})
 	if err != nil {
 		return nil, err
 	}
 
-	return &DefaultCrawler{
+	c := &DefaultCrawler{
 		parallelism:          o.parallelism,
 		connectTimeout:       o.connectTimeout,
 		host:                 host,
 		dhtRPC:               pm,
 		dialAddressExtendDur: o.dialAddressExtendDur,
-	}, nil
+	}
+	if o.checkpoints != nil {
+		c.checkpoints = &checkpoints{ds: o.checkpoints, requeryAfter: o.requeryAfter}
+	}
+	if o.requestRate > 0 {
+		c.limiter = newRateLimiter(o.requestRate, o.requestBurst)
+	}
+	return c, nil
 }
 
 // MessageSender handles sending wire protocol messages to a given peer
@@ -133,7 +148,8 @@ type HandleQueryResult func(p peer.ID, rtPeers []*peer.AddrInfo)
 // HandleQueryFail is a callback on failed peer query
 type HandleQueryFail func(p peer.ID, err error)
 
-// Run crawls dht peers from an initial seed of `startingPeers`
+// Run crawls dht peers from an initial seed of `startingPeers`. With checkpoints, an interrupted
+// crawl resumes instead, along with the starting peers it didn't know about.
 func (c *DefaultCrawler) Run(ctx context.Context, startingPeers []*peer.AddrInfo, handleSuccess HandleQueryResult, handleFail HandleQueryFail) {
 	jobs := make(chan peer.ID, 1)
 	results := make(chan *queryResult, 1)
@@ -154,11 +170,40 @@ func (c *DefaultCrawler) Run(ctx context.Context, startingPeers []*peer.AddrInfo
 	defer wg.Wait()
 	defer close(jobs)
 
+	// the checkpoints are written even once the crawl is cancelled, to keep track of the queries
+	// in flight.
+	cpCtx := context.WithoutCancel(ctx)
+
 	var toDial []*peer.AddrInfo
 	peersSeen := make(map[peer.ID]struct{})
 
+	if c.checkpoints != nil {
+		frontier, visited, err := c.checkpoints.resume(ctx)
+		if err != nil {
+			logger.Errorf("failed to resume the crawl from the checkpoints, starting over: %v", err)
+		}
+		for p, rec := range visited {
+			peersSeen[p] = struct{}{}
+			if rec != nil {
+				c.report(rec.result(p), handleSuccess, handleFail)
+			}
+		}
+		for _, ai := range frontier {
+			c.host.Peerstore().AddAddrs(ai.ID, ai.Addrs, c.dialAddressExtendDur)
+			toDial = append(toDial, ai)
+			peersSeen[ai.ID] = struct{}{}
+		}
+		if len(visited) > 0 || len(frontier) > 0 {
+			logger.Infof("resuming crawl with %d peers queried and %d peers left", len(visited), len(frontier))
+		}
+	}
+	resumed := len(toDial)
+
 	numSkipped := 0
 	for _, ai := range startingPeers {
+		if _, ok := peersSeen[ai.ID]; ok {
+			continue
+		}
 		extendAddrs := c.host.Peerstore().Addrs(ai.ID)
 		if len(ai.Addrs) > 0 {
 			extendAddrs = append(extendAddrs, ai.Addrs...)
@@ -176,37 +221,64 @@ func (c *DefaultCrawler) Run(ctx context.Context, startingPeers []*peer.AddrInfo
 	if numSkipped > 0 {
 		logger.Infof("%d starting peers were skipped due to lack of addresses. Starting crawl with %d peers", numSkipped, len(toDial))
 	}
+	if c.checkpoints != nil {
+		if err := c.checkpoints.addFrontier(cpCtx, toDial[resumed:]); err != nil {
+			logger.Errorf("failed to checkpoint the starting peers: %v", err)
+		}
+	}
+
+	handleResult := func(res *queryResult) {
+		var discovered []*peer.AddrInfo
+		for p, ai := range res.data {
+			c.host.Peerstore().AddAddrs(p, ai.Addrs, c.dialAddressExtendDur)
+			if _, ok := peersSeen[p]; !ok {
+				peersSeen[p] = struct{}{}
+				discovered = append(discovered, ai)
+			}
+		}
+		toDial = append(toDial, discovered...)
+
+		// the queries that failed because the crawl got cancelled are left in the frontier.
+		if c.checkpoints != nil && (res.err == nil || ctx.Err() == nil) {
+			if err := c.checkpoints.visit(cpCtx, res, discovered); err != nil {
+				logger.Errorf("failed to checkpoint the crawl of %v: %v", res.peer, err)
+			}
+		}
+		c.report(res, handleSuccess, handleFail)
+	}
 
 	numQueried := 0
 	outstanding := 0
 
 	for len(toDial) > 0 || outstanding > 0 {
+		if ctx.Err() != nil {
+			// the remaining peers would fail right away, don't bother querying them.
+			toDial = nil
+			if outstanding == 0 {
+				break
+			}
+		}
+
 		var jobCh chan peer.ID
 		var nextPeerID peer.ID
 		if len(toDial) > 0 {
+			// the peers crawled recently enough are reported from the checkpoints instead.
+			if c.checkpoints != nil {
+				if res := c.checkpoints.fresh(toDial[0].ID); res != nil {
+					c.host.Peerstore().AddAddrs(res.peer, res.addrs, c.dialAddressExtendDur)
+					toDial = toDial[1:]
+					handleResult(res)
+					continue
+				}
+			}
 			jobCh = jobs
 			nextPeerID = toDial[0].ID
 		}
 
 		select {
 		case res := <-results:
-			if len(res.data) > 0 {
-				logger.Debugf("peer %v had %d peers", res.peer, len(res.data))
-				rtPeers := make([]*peer.AddrInfo, 0, len(res.data))
-				for p, ai := range res.data {
-					c.host.Peerstore().AddAddrs(p, ai.Addrs, c.dialAddressExtendDur)
-					if _, ok := peersSeen[p]; !ok {
-						peersSeen[p] = struct{}{}
-						toDial = append(toDial, ai)
-					}
-					rtPeers = append(rtPeers, ai)
-				}
-				if handleSuccess != nil {
-					handleSuccess(res.peer, rtPeers)
-				}
-			} else if handleFail != nil {
-				handleFail(res.peer, res.err)
-			}
+			res.addrs = c.host.Peerstore().Addrs(res.peer)
+			handleResult(res)
 			outstanding--
 		case jobCh <- nextPeerID:
 			outstanding++
@@ -215,19 +287,46 @@ func (c *DefaultCrawler) Run(ctx context.Context, startingPeers []*peer.AddrInfo
 			logger.Debugf("starting %d out of %d", numQueried, len(peersSeen))
 		}
 	}
+
+	if c.checkpoints != nil && ctx.Err() == nil {
+		if err := c.checkpoints.finish(cpCtx); err != nil {
+			logger.Errorf("failed to clear the checkpoints of the finished crawl: %v", err)
+		}
+	}
+}
+
+// report passes the result of a query to the callbacks.
+func (c *DefaultCrawler) report(res *queryResult, handleSuccess HandleQueryResult, handleFail HandleQueryFail) {
+	if len(res.data) > 0 {
+		logger.Debugf("peer %v had %d peers", res.peer, len(res.data))
+		rtPeers := make([]*peer.AddrInfo, 0, len(res.data))
+		for _, ai := range res.data {
+			rtPeers = append(rtPeers, ai)
+		}
+		if handleSuccess != nil {
+			handleSuccess(res.peer, rtPeers)
+		}
+	} else if handleFail != nil {
+		handleFail(res.peer, res.err)
+	}
 }
 
 type queryResult struct {
 	peer peer.ID
 	data map[peer.ID]*peer.AddrInfo
 	err  error
+
+	// addrs are the addresses of the peer, as recorded in the checkpoints.
+	addrs []ma.Multiaddr
+	// fromCheckpoint is true for the results reported from the checkpoints.
+	fromCheckpoint bool
 }
 
 func (c *DefaultCrawler) queryPeer(ctx context.Context, nextPeer peer.ID) *queryResult {
 	tmpRT, err := kbucket.NewRoutingTable(20, kbucket.ConvertPeerID(nextPeer), time.Hour, c.host.Peerstore(), time.Hour, nil)
 	if err != nil {
 		logger.Errorf("error creating rt for peer %v : %v", nextPeer, err)
-		return &queryResult{nextPeer, nil, err}
+		return &queryResult{peer: nextPeer, err: err}
 	}
 
 	connCtx, cancel := context.WithTimeout(ctx, c.connectTimeout)
@@ -235,7 +334,7 @@ func (c *DefaultCrawler) queryPeer(ctx context.Context, nextPeer peer.ID) *query
 	err = c.host.Connect(connCtx, peer.AddrInfo{ID: nextPeer})
 	if err != nil {
 		logger.Debugf("could not connect to peer %v: %v", nextPeer, err)
-		return &queryResult{nextPeer, nil, err}
+		return &queryResult{peer: nextPeer, err: err}
 	}
 
 	localPeers := make(map[peer.ID]*peer.AddrInfo)
@@ -245,6 +344,12 @@ func (c *DefaultCrawler) queryPeer(ctx context.Context, nextPeer peer.ID) *query
 		if err != nil {
 			panic(err)
 		}
+		if c.limiter != nil {
+			if err := c.limiter.wait(ctx); err != nil {
+				retErr = err
+				break
+			}
+		}
 		peers, err := c.dhtRPC.GetClosestPeers(ctx, nextPeer, generatePeer)
 		if err != nil {
 			logger.Debugf("error finding data on peer %v with cpl %d : %v", nextPeer, cpl, err)
@@ -259,8 +364,8 @@ func (c *DefaultCrawler) queryPeer(ctx context.Context, nextPeer peer.ID) *query
 	}
 
 	if retErr != nil {
-		return &queryResult{nextPeer, nil, retErr}
+		return &queryResult{peer: nextPeer, err: retErr}
 	}
 
-	return &queryResult{nextPeer, localPeers, retErr}
+	return &queryResult{peer: nextPeer, data: localPeers}
 }
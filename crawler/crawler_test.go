@@ -0,0 +1,227 @@
+package crawler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// testNetwork is a network of DHT servers counting the FIND_NODE requests of the crawler.
+type testNetwork struct {
+	crawler host.Host
+	peers   []peer.ID
+
+	mu      sync.Mutex
+	queried map[peer.ID]int
+}
+
+func newTestNetwork(ctx context.Context, t *testing.T, size int) *testNetwork {
+	mn, err := mocknet.FullMeshLinked(size + 1)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = mn.Close() })
+
+	hosts := mn.Hosts()
+	n := &testNetwork{crawler: hosts[0], queried: make(map[peer.ID]int)}
+	var dhts []*dht.IpfsDHT
+	for _, h := range hosts[1:] {
+		self := h.ID()
+		count := func(ctx context.Context, p peer.ID, req *pb.Message, next dht.Handler) (*pb.Message, error) {
+			if p == n.crawler.ID() && req.GetType() == pb.Message_FIND_NODE {
+				n.mu.Lock()
+				n.queried[self]++
+				n.mu.Unlock()
+			}
+			return next(ctx, p, req)
+		}
+		d, err := dht.New(ctx, h, dht.ProtocolPrefix("/test"), dht.DisableAutoRefresh(), dht.Mode(dht.ModeServer), dht.WithInterceptors(count))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = d.Close() })
+		dhts = append(dhts, d)
+		n.peers = append(n.peers, self)
+	}
+
+	require.NoError(t, mn.ConnectAllButSelf())
+	require.Eventually(t, func() bool {
+		for _, d := range dhts {
+			if d.RoutingTable().Size() != size-1 {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+	return n
+}
+
+// takeQueried returns the number of peers queried since the last call.
+func (n *testNetwork) takeQueried() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	queried := len(n.queried)
+	n.queried = make(map[peer.ID]int)
+	return queried
+}
+
+func (n *testNetwork) newCrawler(t *testing.T, opts ...Option) *DefaultCrawler {
+	opts = append([]Option{WithProtocols([]protocol.ID{"/test/kad/1.0.0"})}, opts...)
+	c, err := NewDefaultCrawler(n.crawler, opts...)
+	require.NoError(t, err)
+	return c
+}
+
+func (n *testNetwork) start() []*peer.AddrInfo {
+	return []*peer.AddrInfo{{ID: n.peers[0], Addrs: n.crawler.Peerstore().Addrs(n.peers[0])}}
+}
+
+func crawlState(t *testing.T, d ds.Datastore, prefix string) int {
+	res, err := d.Query(context.Background(), query.Query{Prefix: prefix, KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	return len(entries)
+}
+
+// countingDatastore counts the records read one by one.
+type countingDatastore struct {
+	ds.Batching
+	gets int
+}
+
+func (d *countingDatastore) Get(ctx context.Context, k ds.Key) ([]byte, error) {
+	d.gets++
+	return d.Batching.Get(ctx, k)
+}
+
+func TestCrawlerRequery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	n := newTestNetwork(ctx, t, 8)
+	d := &countingDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	c := n.newCrawler(t, WithCheckpoints(d), WithRequeryAfter(time.Hour))
+
+	for i := 0; i < 2; i++ {
+		var crawled []peer.ID
+		c.Run(ctx, n.start(), func(p peer.ID, rtPeers []*peer.AddrInfo) {
+			require.Len(t, rtPeers, 7)
+			crawled = append(crawled, p)
+		}, func(p peer.ID, err error) {
+			t.Errorf("failed to crawl %s: %v", p, err)
+		})
+		require.ElementsMatch(t, n.peers, crawled)
+
+		require.Equal(t, 8, crawlState(t, d, checkpointPeersPrefix))
+		require.Zero(t, crawlState(t, d, checkpointFrontierPrefix))
+		require.Zero(t, crawlState(t, d, checkpointVisitedPrefix))
+	}
+	// the second crawl only reported the results of the first one, loaded all at once.
+	require.Equal(t, 8, n.takeQueried())
+	require.Zero(t, d.gets)
+
+	// without the requery age, every peer is queried again.
+	c = n.newCrawler(t, WithCheckpoints(d))
+	c.Run(ctx, n.start(), nil, nil)
+	require.Equal(t, 8, n.takeQueried())
+}
+
+func TestCrawlerPruneRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	n := newTestNetwork(ctx, t, 4)
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	c := n.newCrawler(t, WithCheckpoints(d), WithRequeryAfter(time.Hour))
+
+	// the record of a peer that has left the network.
+	gone := test.RandPeerIDFatal(t)
+	require.NoError(t, d.Put(ctx, peerKey(checkpointPeersPrefix, gone), []byte("{}")))
+
+	c.Run(ctx, n.start(), nil, nil)
+	require.Equal(t, 4, crawlState(t, d, checkpointPeersPrefix))
+	has, err := d.Has(ctx, peerKey(checkpointPeersPrefix, gone))
+	require.NoError(t, err)
+	require.False(t, has)
+	for _, p := range n.peers {
+		has, err := d.Has(ctx, peerKey(checkpointPeersPrefix, p))
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+}
+
+func TestCrawlerResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	n := newTestNetwork(ctx, t, 8)
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	c := n.newCrawler(t, WithCheckpoints(d), WithParallelism(1))
+
+	// interrupt the crawl after the first peer.
+	crawlCtx, stop := context.WithCancel(ctx)
+	var crawled []peer.ID
+	c.Run(crawlCtx, n.start(), func(p peer.ID, _ []*peer.AddrInfo) {
+		crawled = append(crawled, p)
+		stop()
+	}, nil)
+	require.Equal(t, []peer.ID{n.peers[0]}, crawled)
+	require.Equal(t, 1, n.takeQueried())
+	require.Equal(t, 1, crawlState(t, d, checkpointVisitedPrefix))
+	require.Equal(t, 7, crawlState(t, d, checkpointFrontierPrefix))
+
+	// the crawl resumes with the first peer reported from the checkpoints.
+	crawled = nil
+	c.Run(ctx, nil, func(p peer.ID, _ []*peer.AddrInfo) {
+		crawled = append(crawled, p)
+	}, func(p peer.ID, err error) {
+		t.Errorf("failed to crawl %s: %v", p, err)
+	})
+	require.ElementsMatch(t, n.peers, crawled)
+	require.Equal(t, 7, n.takeQueried())
+	require.Zero(t, crawlState(t, d, checkpointVisitedPrefix))
+	require.Zero(t, crawlState(t, d, checkpointFrontierPrefix))
+}
+
+func TestCrawlerRequestRate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	n := newTestNetwork(ctx, t, 2)
+	c := n.newCrawler(t, WithRequestRate(100, 1))
+
+	// every peer is sent 16 requests.
+	start := time.Now()
+	c.Run(ctx, n.start(), nil, nil)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	require.Equal(t, 2, n.takeQueried())
+
+	l := newRateLimiter(1, 1)
+	require.NoError(t, l.wait(ctx))
+	cctx, ccancel := context.WithCancel(ctx)
+	ccancel()
+	require.ErrorIs(t, l.wait(cctx), context.Canceled)
+}
+
+func TestCrawlerOptions(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	_, err = NewDefaultCrawler(h, WithRequeryAfter(time.Hour))
+	require.Error(t, err)
+	_, err = NewDefaultCrawler(h, WithRequestRate(0, 1))
+	require.Error(t, err)
+}
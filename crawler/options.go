@@ -1,8 +1,10 @@
 package crawler
 
 import (
+	"fmt"
 	"time"
 
+	ds "github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
@@ -15,6 +17,10 @@ type options struct {
 	connectTimeout       time.Duration
 	perMsgTimeout        time.Duration
 	dialAddressExtendDur time.Duration
+	checkpoints          ds.Datastore
+	requeryAfter         time.Duration
+	requestRate          float64
+	requestBurst         int
 }
 
 // defaults are the default crawler options. This option will be automatically
@@ -70,3 +76,42 @@ func WithDialAddrExtendDuration(ext time.Duration) Option {
 		return nil
 	}
 }
+
+// WithCheckpoints checkpoints the progress of the crawls to the datastore: the peers left to query,
+// the peers already queried and their routing tables. A crawl that was interrupted, by a crash or
+// by cancelling its context, resumes where it stopped on the next call to Run, which first reports
+// the results of the peers queried before the interruption.
+func WithCheckpoints(d ds.Datastore) Option {
+	return func(o *options) error {
+		o.checkpoints = d
+		return nil
+	}
+}
+
+// WithRequeryAfter only queries again the peers whose routing table was fetched longer ago than the
+// given age, the routing tables of the others are reported from the checkpoints. It requires
+// WithCheckpoints.
+// Defaults to 0 if unset, every peer is queried on every crawl.
+func WithRequeryAfter(age time.Duration) Option {
+	return func(o *options) error {
+		if age < 0 {
+			return fmt.Errorf("requery age must be positive; got: %s", age)
+		}
+		o.requeryAfter = age
+		return nil
+	}
+}
+
+// WithRequestRate limits the number of DHT requests per second the crawler sends, over all the
+// peers it queries in parallel, allowing bursts of up to burst requests.
+// Defaults to no limit if unset.
+func WithRequestRate(rate float64, burst int) Option {
+	return func(o *options) error {
+		if rate <= 0 || burst < 1 {
+			return fmt.Errorf("request rate and burst must be positive; got: %f, %d", rate, burst)
+		}
+		o.requestRate = rate
+		o.requestBurst = burst
+		return nil
+	}
+}
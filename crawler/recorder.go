@@ -0,0 +1,127 @@
+package crawler
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// errEmptyRoutingTable is the failure reason of the peers that answered but returned no peers.
+var errEmptyRoutingTable = errors.New("empty routing table")
+
+// Recorder collects the results of a crawl into a Snapshot. Its HandleSuccess and HandleFail
+// methods are meant to be passed to Crawler.Run:
+//
+//	rec := crawler.NewRecorder(h)
+//	c.Run(ctx, bootstrapPeers, rec.HandleSuccess, rec.HandleFail)
+//	snap := rec.Snapshot()
+type Recorder struct {
+	host  host.Host
+	start time.Time
+
+	mu    sync.Mutex
+	nodes map[peer.ID]*recordedNode
+}
+
+type recordedNode struct {
+	info  NodeInfo
+	addrs map[string]ma.Multiaddr
+}
+
+// NewRecorder returns a recorder reading the addresses and protocols of the crawled peers from the
+// peerstore of the host the crawler runs on. The crawl is deemed to start when the recorder is
+// created.
+func NewRecorder(h host.Host) *Recorder {
+	return &Recorder{
+		host:  h,
+		start: time.Now(),
+		nodes: make(map[peer.ID]*recordedNode),
+	}
+}
+
+// HandleSuccess records the routing table of a peer, as returned by the crawler.
+func (r *Recorder) HandleSuccess(p peer.ID, rtPeers []*peer.AddrInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.identified(p)
+	n.info.Error = ""
+	n.info.Neighbors = make([]peer.ID, 0, len(rtPeers))
+	for _, ai := range rtPeers {
+		r.node(ai.ID).addAddrs(ai.Addrs)
+		n.info.Neighbors = append(n.info.Neighbors, ai.ID)
+	}
+	sortPeers(n.info.Neighbors)
+}
+
+// HandleFail records why a peer couldn't be crawled.
+func (r *Recorder) HandleFail(p peer.ID, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		err = errEmptyRoutingTable
+	}
+	n := r.identified(p)
+	n.info.Neighbors = nil
+	n.info.Error = err.Error()
+}
+
+// identified returns the node of a peer the crawler tried to query, updated with what the host
+// learned about it.
+func (r *Recorder) identified(p peer.ID) *recordedNode {
+	n := r.node(p)
+	n.addAddrs(r.host.Peerstore().Addrs(p))
+	if protos, err := r.host.Peerstore().GetProtocols(p); err == nil && len(protos) > 0 {
+		n.info.Protocols = append(n.info.Protocols[:0], protos...)
+		sortProtocols(n.info.Protocols)
+	}
+	return n
+}
+
+func (r *Recorder) node(p peer.ID) *recordedNode {
+	n, ok := r.nodes[p]
+	if !ok {
+		n = &recordedNode{info: NodeInfo{ID: p}, addrs: make(map[string]ma.Multiaddr)}
+		r.nodes[p] = n
+	}
+	return n
+}
+
+func (n *recordedNode) addAddrs(addrs []ma.Multiaddr) {
+	for _, a := range addrs {
+		n.addrs[string(a.Bytes())] = a
+	}
+}
+
+// Snapshot returns the snapshot of what has been recorded so far, ending now. It holds every peer
+// the crawl came across, crawled or not.
+func (r *Recorder) Snapshot() *Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &Snapshot{
+		Version: SnapshotVersion,
+		Start:   r.start,
+		End:     time.Now(),
+		Nodes:   make([]*NodeInfo, 0, len(r.nodes)),
+	}
+	for _, n := range r.nodes {
+		info := n.info
+		info.Addrs = nil
+		for _, a := range n.addrs {
+			info.Addrs = append(info.Addrs, a)
+		}
+		sort.Slice(info.Addrs, func(i, j int) bool { return info.Addrs[i].String() < info.Addrs[j].String() })
+		info.Protocols = append(info.Protocols[:0:0], info.Protocols...)
+		info.Neighbors = append(info.Neighbors[:0:0], info.Neighbors...)
+		s.Nodes = append(s.Nodes, &info)
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].ID < s.Nodes[j].ID })
+	return s
+}
@@ -0,0 +1,474 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// SnapshotVersion is the version of the snapshot format written by this package. Snapshots of a
+// newer version can't be read.
+const SnapshotVersion = 1
+
+// binaryMagic starts the binary form of the snapshots.
+const binaryMagic = "dhtcrawl"
+
+// maxBinaryLength bounds the lengths and counts read from a binary snapshot, to fail early on
+// corrupted input.
+const maxBinaryLength = 1 << 24
+
+// Snapshot is the state of the DHT as seen by a crawl.
+type Snapshot struct {
+	Version int
+	Start   time.Time
+	End     time.Time
+	// Nodes are sorted by peer ID.
+	Nodes []*NodeInfo
+}
+
+// NodeInfo is a peer seen during a crawl.
+type NodeInfo struct {
+	ID    peer.ID
+	Addrs []ma.Multiaddr
+	// Protocols are the protocols the peer announced when identified by the crawler.
+	Protocols []protocol.ID
+	// Neighbors are the peers of the routing table of the peer, sorted. They are only set if the
+	// peer was crawled successfully, every neighbor is a node of the snapshot as well.
+	Neighbors []peer.ID
+	// Error is why the peer couldn't be crawled. Peers that have neither neighbors nor an error
+	// were seen in the routing table of other peers but not crawled, e.g. because the crawl was
+	// interrupted.
+	Error string
+}
+
+// Crawled returns true if the peer was crawled successfully.
+func (n *NodeInfo) Crawled() bool {
+	return len(n.Neighbors) > 0
+}
+
+// Node returns the node with the given ID, or nil if the snapshot doesn't have it.
+func (s *Snapshot) Node(p peer.ID) *NodeInfo {
+	i := sort.Search(len(s.Nodes), func(i int) bool { return s.Nodes[i].ID >= p })
+	if i < len(s.Nodes) && s.Nodes[i].ID == p {
+		return s.Nodes[i]
+	}
+	return nil
+}
+
+// Edge is a peer of the routing table of another.
+type Edge struct {
+	From, To peer.ID
+}
+
+// Diff is the churn between two snapshots.
+type Diff struct {
+	// Joined are the peers crawled successfully in the new snapshot but not in the old one.
+	Joined []peer.ID
+	// Left are the peers crawled successfully in the old snapshot but not in the new one.
+	Left []peer.ID
+	// AddrsChanged and ProtocolsChanged are the peers crawled successfully in both snapshots
+	// whose addresses or protocols changed.
+	AddrsChanged     []peer.ID
+	ProtocolsChanged []peer.ID
+	// EdgesAdded and EdgesRemoved are the changes of the routing tables of the peers crawled
+	// successfully in both snapshots.
+	EdgesAdded   []Edge
+	EdgesRemoved []Edge
+}
+
+// DiffSnapshots reports the churn from the old snapshot to the current one.
+func DiffSnapshots(old, cur *Snapshot) *Diff {
+	d := &Diff{}
+	for _, n := range cur.Nodes {
+		if !n.Crawled() {
+			continue
+		}
+		o := old.Node(n.ID)
+		if o == nil || !o.Crawled() {
+			d.Joined = append(d.Joined, n.ID)
+			continue
+		}
+		if !equalAddrs(o.Addrs, n.Addrs) {
+			d.AddrsChanged = append(d.AddrsChanged, n.ID)
+		}
+		if !equalProtocols(o.Protocols, n.Protocols) {
+			d.ProtocolsChanged = append(d.ProtocolsChanged, n.ID)
+		}
+		added, removed := diffPeers(o.Neighbors, n.Neighbors)
+		for _, p := range added {
+			d.EdgesAdded = append(d.EdgesAdded, Edge{From: n.ID, To: p})
+		}
+		for _, p := range removed {
+			d.EdgesRemoved = append(d.EdgesRemoved, Edge{From: n.ID, To: p})
+		}
+	}
+	for _, o := range old.Nodes {
+		if !o.Crawled() {
+			continue
+		}
+		if n := cur.Node(o.ID); n == nil || !n.Crawled() {
+			d.Left = append(d.Left, o.ID)
+		}
+	}
+	return d
+}
+
+// diffPeers returns the peers of b that aren't in a and the peers of a that aren't in b, both
+// slices being sorted.
+func diffPeers(a, b []peer.ID) (added, removed []peer.ID) {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			removed = append(removed, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			added = append(added, b[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return added, removed
+}
+
+func equalAddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalProtocols(a, b []protocol.ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortPeers(peers []peer.ID) {
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+}
+
+func sortProtocols(protos []protocol.ID) {
+	sort.Slice(protos, func(i, j int) bool { return protos[i] < protos[j] })
+}
+
+// jsonHeader is the first line of the JSON lines form of a snapshot.
+type jsonHeader struct {
+	Version int       `json:"version"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Nodes   int       `json:"nodes"`
+}
+
+// jsonNode is every following line.
+type jsonNode struct {
+	ID        peer.ID       `json:"id"`
+	Addrs     []string      `json:"addrs,omitempty"`
+	Protocols []protocol.ID `json:"protocols,omitempty"`
+	Neighbors []peer.ID     `json:"neighbors,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// WriteJSON writes the snapshot as JSON lines: a header with the version, the time span of the
+// crawl and the number of nodes, followed by a line per node.
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(jsonHeader{Version: SnapshotVersion, Start: s.Start, End: s.End, Nodes: len(s.Nodes)}); err != nil {
+		return err
+	}
+	for _, n := range s.Nodes {
+		jn := jsonNode{ID: n.ID, Protocols: n.Protocols, Neighbors: n.Neighbors, Error: n.Error}
+		for _, a := range n.Addrs {
+			jn.Addrs = append(jn.Addrs, a.String())
+		}
+		if err := enc.Encode(jn); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadJSON reads a snapshot written by WriteJSON.
+func ReadJSON(r io.Reader) (*Snapshot, error) {
+	dec := json.NewDecoder(r)
+	var h jsonHeader
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("reading snapshot header: %w", err)
+	}
+	if h.Version < 1 || h.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", h.Version)
+	}
+
+	s := &Snapshot{Version: h.Version, Start: h.Start, End: h.End}
+	for {
+		var jn jsonNode
+		if err := dec.Decode(&jn); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading snapshot node %d: %w", len(s.Nodes), err)
+		}
+		n := &NodeInfo{ID: jn.ID, Protocols: jn.Protocols, Neighbors: jn.Neighbors, Error: jn.Error}
+		for _, a := range jn.Addrs {
+			addr, err := ma.NewMultiaddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("reading address of %s: %w", jn.ID, err)
+			}
+			n.Addrs = append(n.Addrs, addr)
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+	if len(s.Nodes) != h.Nodes {
+		return nil, fmt.Errorf("truncated snapshot: read %d nodes out of %d", len(s.Nodes), h.Nodes)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WriteBinary writes the snapshot in a compact binary form. The peer IDs and protocols are written
+// once, and referred to by their index afterwards:
+//
+//	magic "dhtcrawl", version, start and end in unix nanoseconds,
+//	peer IDs, protocols,
+//	for every node: addresses, protocol indexes, neighbor indexes, error.
+//
+// All the integers are varints and all the lists and byte strings are prefixed by their length.
+func (s *Snapshot) WriteBinary(w io.Writer) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	peers := make(map[peer.ID]uint64, len(s.Nodes))
+	for i, n := range s.Nodes {
+		peers[n.ID] = uint64(i)
+	}
+	protos := make(map[protocol.ID]uint64)
+	var protoList []protocol.ID
+	for _, n := range s.Nodes {
+		for _, p := range n.Protocols {
+			if _, ok := protos[p]; !ok {
+				protos[p] = uint64(len(protoList))
+				protoList = append(protoList, p)
+			}
+		}
+	}
+
+	bw := &binaryWriter{w: bufio.NewWriter(w)}
+	bw.raw([]byte(binaryMagic))
+	bw.uvarint(SnapshotVersion)
+	bw.varint(s.Start.UnixNano())
+	bw.varint(s.End.UnixNano())
+	bw.uvarint(uint64(len(s.Nodes)))
+	for _, n := range s.Nodes {
+		bw.bytes([]byte(n.ID))
+	}
+	bw.uvarint(uint64(len(protoList)))
+	for _, p := range protoList {
+		bw.bytes([]byte(p))
+	}
+	for _, n := range s.Nodes {
+		bw.uvarint(uint64(len(n.Addrs)))
+		for _, a := range n.Addrs {
+			bw.bytes(a.Bytes())
+		}
+		bw.uvarint(uint64(len(n.Protocols)))
+		for _, p := range n.Protocols {
+			bw.uvarint(protos[p])
+		}
+		bw.uvarint(uint64(len(n.Neighbors)))
+		for _, p := range n.Neighbors {
+			bw.uvarint(peers[p])
+		}
+		bw.bytes([]byte(n.Error))
+	}
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.w.Flush()
+}
+
+// ReadBinary reads a snapshot written by WriteBinary.
+func ReadBinary(r io.Reader) (*Snapshot, error) {
+	br := &binaryReader{r: bufio.NewReader(r)}
+	if magic := br.raw(len(binaryMagic)); br.err == nil && string(magic) != binaryMagic {
+		return nil, errors.New("not a binary crawl snapshot")
+	}
+	version := br.uvarint()
+	if br.err == nil && (version < 1 || version > SnapshotVersion) {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	s := &Snapshot{Version: int(version)}
+	s.Start = time.Unix(0, br.varint())
+	s.End = time.Unix(0, br.varint())
+
+	count := br.length()
+	s.Nodes = make([]*NodeInfo, 0, count)
+	for i := 0; i < count; i++ {
+		s.Nodes = append(s.Nodes, &NodeInfo{ID: peer.ID(br.bytes())})
+	}
+	protoList := make([]protocol.ID, br.length())
+	for i := range protoList {
+		protoList[i] = protocol.ID(br.bytes())
+	}
+	for _, n := range s.Nodes {
+		for i, c := 0, br.length(); i < c; i++ {
+			addr, err := ma.NewMultiaddrBytes(br.bytes())
+			if err != nil && br.err == nil {
+				br.err = fmt.Errorf("reading address of %s: %w", n.ID, err)
+			}
+			n.Addrs = append(n.Addrs, addr)
+		}
+		for i, c := 0, br.length(); i < c; i++ {
+			if j := br.index(len(protoList)); br.err == nil {
+				n.Protocols = append(n.Protocols, protoList[j])
+			}
+		}
+		for i, c := 0, br.length(); i < c; i++ {
+			if j := br.index(len(s.Nodes)); br.err == nil {
+				n.Neighbors = append(n.Neighbors, s.Nodes[j].ID)
+			}
+		}
+		n.Error = string(br.bytes())
+		if br.err != nil {
+			break
+		}
+	}
+	if br.err != nil {
+		if br.err == io.EOF {
+			br.err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("reading binary snapshot: %w", br.err)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// check makes sure the nodes are sorted and unique and every neighbor is a node of the snapshot.
+func (s *Snapshot) check() error {
+	for i, n := range s.Nodes {
+		if i > 0 && s.Nodes[i-1].ID >= n.ID {
+			return fmt.Errorf("snapshot nodes aren't sorted by peer ID at %s", n.ID)
+		}
+	}
+	for _, n := range s.Nodes {
+		for _, p := range n.Neighbors {
+			if s.Node(p) == nil {
+				return fmt.Errorf("neighbor %s of %s isn't a node of the snapshot", p, n.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// binaryWriter writes the binary form of the snapshots, keeping the first error.
+type binaryWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (w *binaryWriter) raw(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	w.raw(w.buf[:binary.PutUvarint(w.buf[:], v)])
+}
+
+func (w *binaryWriter) varint(v int64) {
+	w.raw(w.buf[:binary.PutVarint(w.buf[:], v)])
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.raw(b)
+}
+
+// binaryReader reads the binary form of the snapshots, keeping the first error. Once it failed, it
+// only returns zero values.
+type binaryReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *binaryReader) raw(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, b)
+	return b
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	v, r.err = binary.ReadUvarint(r.r)
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	var v int64
+	v, r.err = binary.ReadVarint(r.r)
+	return v
+}
+
+// length reads a length or a count.
+func (r *binaryReader) length() int {
+	v := r.uvarint()
+	if v > maxBinaryLength && r.err == nil {
+		r.err = fmt.Errorf("length %d out of bounds", v)
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(v)
+}
+
+// index reads an index into a list of the given size.
+func (r *binaryReader) index(size int) int {
+	v := r.uvarint()
+	if v >= uint64(size) && r.err == nil {
+		r.err = fmt.Errorf("index %d out of bounds", v)
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(v)
+}
+
+func (r *binaryReader) bytes() []byte {
+	return r.raw(r.length())
+}
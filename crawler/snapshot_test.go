@@ -0,0 +1,164 @@
+package crawler
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// sortedPeers returns count random peer IDs, sorted.
+func sortedPeers(t *testing.T, count int) []peer.ID {
+	peers := make([]peer.ID, count)
+	for i := range peers {
+		peers[i] = test.RandPeerIDFatal(t)
+	}
+	sortPeers(peers)
+	return peers
+}
+
+func TestRecorder(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	peers := sortedPeers(t, 4)
+	a, b, c, d := peers[0], peers[1], peers[2], peers[3]
+	addr := func(s string) ma.Multiaddr { return ma.StringCast(s) }
+
+	rec := NewRecorder(h)
+	h.Peerstore().AddAddr(a, addr("/ip4/1.1.1.1/tcp/4001"), peerstore.PermanentAddrTTL)
+	require.NoError(t, h.Peerstore().SetProtocols(a, "/ipfs/kad/1.0.0", "/ipfs/id/1.0.0"))
+	rec.HandleSuccess(a, []*peer.AddrInfo{
+		{ID: c, Addrs: []ma.Multiaddr{addr("/ip4/3.3.3.3/tcp/4001")}},
+		{ID: b, Addrs: []ma.Multiaddr{addr("/ip4/2.2.2.2/tcp/4001")}},
+	})
+	rec.HandleFail(b, errors.New("connection refused"))
+	rec.HandleFail(d, nil)
+
+	snap := rec.Snapshot()
+	require.Equal(t, SnapshotVersion, snap.Version)
+	require.False(t, snap.End.Before(snap.Start))
+	require.Len(t, snap.Nodes, 4)
+
+	na := snap.Node(a)
+	require.True(t, na.Crawled())
+	require.Equal(t, []peer.ID{b, c}, na.Neighbors)
+	require.Equal(t, []protocol.ID{"/ipfs/id/1.0.0", "/ipfs/kad/1.0.0"}, na.Protocols)
+	require.Equal(t, []ma.Multiaddr{addr("/ip4/1.1.1.1/tcp/4001")}, na.Addrs)
+	require.Empty(t, na.Error)
+
+	nb := snap.Node(b)
+	require.False(t, nb.Crawled())
+	require.Equal(t, "connection refused", nb.Error)
+	require.Equal(t, []ma.Multiaddr{addr("/ip4/2.2.2.2/tcp/4001")}, nb.Addrs)
+
+	// c was seen but never crawled.
+	nc := snap.Node(c)
+	require.False(t, nc.Crawled())
+	require.Empty(t, nc.Error)
+
+	require.Equal(t, errEmptyRoutingTable.Error(), snap.Node(d).Error)
+	require.Nil(t, snap.Node(test.RandPeerIDFatal(t)))
+}
+
+func testSnapshot(t *testing.T) *Snapshot {
+	peers := sortedPeers(t, 3)
+	return &Snapshot{
+		Version: SnapshotVersion,
+		Start:   time.Unix(1600000000, 0),
+		End:     time.Unix(1600000600, 0),
+		Nodes: []*NodeInfo{
+			{
+				ID:        peers[0],
+				Addrs:     []ma.Multiaddr{ma.StringCast("/ip4/1.1.1.1/tcp/4001"), ma.StringCast("/ip6/::1/udp/4001/quic-v1")},
+				Protocols: []protocol.ID{"/ipfs/id/1.0.0", "/ipfs/kad/1.0.0"},
+				Neighbors: []peer.ID{peers[1], peers[2]},
+			},
+			{
+				ID:        peers[1],
+				Protocols: []protocol.ID{"/ipfs/kad/1.0.0"},
+				Neighbors: []peer.ID{peers[0]},
+			},
+			{
+				ID:    peers[2],
+				Error: "dial backoff",
+			},
+		},
+	}
+}
+
+func requireEqualSnapshots(t *testing.T, expected, actual *Snapshot) {
+	require.Equal(t, expected.Version, actual.Version)
+	require.True(t, expected.Start.Equal(actual.Start))
+	require.True(t, expected.End.Equal(actual.End))
+	require.Equal(t, expected.Nodes, actual.Nodes)
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	snap := testSnapshot(t)
+
+	var js bytes.Buffer
+	require.NoError(t, snap.WriteJSON(&js))
+	require.Equal(t, len(snap.Nodes)+1, bytes.Count(js.Bytes(), []byte("\n")))
+	fromJSON, err := ReadJSON(bytes.NewReader(js.Bytes()))
+	require.NoError(t, err)
+	requireEqualSnapshots(t, snap, fromJSON)
+
+	var bin bytes.Buffer
+	require.NoError(t, snap.WriteBinary(&bin))
+	require.Less(t, bin.Len(), js.Len())
+	fromBinary, err := ReadBinary(bytes.NewReader(bin.Bytes()))
+	require.NoError(t, err)
+	requireEqualSnapshots(t, snap, fromBinary)
+
+	// truncated or corrupted snapshots are rejected.
+	for i := 0; i < bin.Len(); i++ {
+		_, err := ReadBinary(bytes.NewReader(bin.Bytes()[:i]))
+		require.Error(t, err)
+	}
+	_, err = ReadJSON(bytes.NewReader(js.Bytes()[:bytes.LastIndexByte(js.Bytes()[:js.Len()-1], '\n')+1]))
+	require.Error(t, err)
+	_, err = ReadJSON(bytes.NewReader([]byte(`{"version":2}`)))
+	require.Error(t, err)
+
+	// neighbors have to be nodes of the snapshot.
+	snap.Nodes = snap.Nodes[:2]
+	require.Error(t, snap.WriteBinary(&bin))
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	old := testSnapshot(t)
+	p0, p1, p2 := old.Nodes[0].ID, old.Nodes[1].ID, old.Nodes[2].ID
+
+	cur := testSnapshot(t)
+	cur.Nodes = []*NodeInfo{
+		{
+			ID:        p0,
+			Addrs:     []ma.Multiaddr{ma.StringCast("/ip4/1.1.1.1/tcp/4001")},
+			Protocols: old.Nodes[0].Protocols,
+			Neighbors: []peer.ID{p2},
+		},
+		{ID: p1, Error: "connection refused"},
+		{ID: p2, Neighbors: []peer.ID{p0}},
+	}
+
+	require.Equal(t, &Diff{
+		Joined:           []peer.ID{p2},
+		Left:             []peer.ID{p1},
+		AddrsChanged:     []peer.ID{p0},
+		ProtocolsChanged: nil,
+		EdgesAdded:       nil,
+		EdgesRemoved:     []Edge{{From: p0, To: p1}},
+	}, DiffSnapshots(old, cur))
+	require.Equal(t, &Diff{}, DiffSnapshots(old, old))
+}
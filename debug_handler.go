@@ -0,0 +1,190 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
+)
+
+// debugProviderStatsTimeout bounds the time spent counting the records of the provider store.
+const debugProviderStatsTimeout = 10 * time.Second
+
+// DebugState is the state of the DHT served by the DebugHandler.
+type DebugState struct {
+	Self peer.ID
+	// Mode is the mode the DHT currently operates in, "client" or "server".
+	Mode         string
+	RoutingTable []DebugBucket
+	// Diversity are the peer diversity stats of the routing table, if it has a diversity filter.
+	Diversity     []peerdiversity.CplDiversityStats
+	NetworkSize   DebugNetworkSize
+	Refresh       DebugRefreshStatus
+	ProviderStore DebugProviderStore
+	// InFlightLookups are the lookup paths in progress.
+	InFlightLookups []LookupInfo
+	// RecentLookupTraces are only kept if enabled with KeepRecentLookupTraces.
+	RecentLookupTraces []*LookupTrace
+}
+
+// DebugBucket lists the routing table peers sharing a common prefix length with us.
+type DebugBucket struct {
+	Cpl   int
+	Peers []DebugPeer
+}
+
+// DebugPeer describes a routing table peer.
+type DebugPeer struct {
+	ID                            peer.ID
+	AddedAt                       time.Time
+	LastUsefulAt                  time.Time
+	LastSuccessfulOutboundQueryAt time.Time
+}
+
+// DebugNetworkSize is the network size estimate, or the reason it isn't available.
+type DebugNetworkSize struct {
+	Size  int32
+	Error string `json:",omitempty"`
+}
+
+// DebugRefreshStatus is the state of the routing table refreshes.
+type DebugRefreshStatus struct {
+	Refreshing  bool
+	LastRefresh time.Time
+	LastError   string `json:",omitempty"`
+}
+
+// DebugProviderStore counts the records of the provider store, if it can count them (see
+// providers.StatsReporter).
+type DebugProviderStore struct {
+	providers.Stats
+	Error string `json:",omitempty"`
+}
+
+// DebugHandler returns an http.Handler serving the internal state of the DHT as JSON, for debugging
+// and introspection. The whole DebugState is served at the root, and each of its sections on its
+// own path: /self, /mode, /routing-table, /diversity, /network-size, /refresh, /provider-store,
+// /lookups and /traces.
+//
+// The handler is meant to be mounted on an existing admin server, e.g. with http.StripPrefix. It
+// exposes the peers we know and the keys we look up, it must not be reachable by untrusted clients.
+func (dht *IpfsDHT) DebugHandler() http.Handler {
+	sections := map[string]func(context.Context) interface{}{
+		"self":           func(context.Context) interface{} { return dht.self },
+		"mode":           func(context.Context) interface{} { return dht.debugMode() },
+		"routing-table":  func(context.Context) interface{} { return dht.debugRoutingTable() },
+		"diversity":      func(context.Context) interface{} { return dht.GetRoutingTableDiversityStats() },
+		"network-size":   func(context.Context) interface{} { return dht.debugNetworkSize() },
+		"refresh":        func(context.Context) interface{} { return dht.debugRefreshStatus() },
+		"provider-store": func(ctx context.Context) interface{} { return dht.debugProviderStore(ctx) },
+		"lookups":        func(context.Context) interface{} { return dht.InFlightLookups() },
+		"traces":         func(context.Context) interface{} { return dht.RecentLookupTraces() },
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var v interface{}
+		if name := strings.Trim(r.URL.Path, "/"); name == "" {
+			v = dht.DebugState(r.Context())
+		} else if section, ok := sections[name]; ok {
+			v = section(r.Context())
+		} else {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			logger.Debugw("failed to write debug response", "error", err)
+		}
+	})
+}
+
+// DebugState returns a snapshot of the internal state of the DHT, as served by the DebugHandler.
+func (dht *IpfsDHT) DebugState(ctx context.Context) *DebugState {
+	return &DebugState{
+		Self:               dht.self,
+		Mode:               dht.debugMode(),
+		RoutingTable:       dht.debugRoutingTable(),
+		Diversity:          dht.GetRoutingTableDiversityStats(),
+		NetworkSize:        dht.debugNetworkSize(),
+		Refresh:            dht.debugRefreshStatus(),
+		ProviderStore:      dht.debugProviderStore(ctx),
+		InFlightLookups:    dht.InFlightLookups(),
+		RecentLookupTraces: dht.RecentLookupTraces(),
+	}
+}
+
+func (dht *IpfsDHT) debugMode() string {
+	if dht.getMode() == modeServer {
+		return "server"
+	}
+	return "client"
+}
+
+func (dht *IpfsDHT) debugRoutingTable() []DebugBucket {
+	byCpl := make(map[int][]DebugPeer)
+	for _, pi := range dht.routingTable.GetPeerInfos() {
+		cpl := kb.CommonPrefixLen(dht.selfKey, kb.ConvertPeerID(pi.Id))
+		byCpl[cpl] = append(byCpl[cpl], DebugPeer{
+			ID:                            pi.Id,
+			AddedAt:                       pi.AddedAt,
+			LastUsefulAt:                  pi.LastUsefulAt,
+			LastSuccessfulOutboundQueryAt: pi.LastSuccessfulOutboundQueryAt,
+		})
+	}
+
+	buckets := make([]DebugBucket, 0, len(byCpl))
+	for cpl, peers := range byCpl {
+		sort.Slice(peers, func(i, j int) bool { return peers[i].AddedAt.Before(peers[j].AddedAt) })
+		buckets = append(buckets, DebugBucket{Cpl: cpl, Peers: peers})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Cpl < buckets[j].Cpl })
+	return buckets
+}
+
+func (dht *IpfsDHT) debugNetworkSize() DebugNetworkSize {
+	size, err := dht.NetworkSize()
+	if err != nil {
+		return DebugNetworkSize{Error: err.Error()}
+	}
+	return DebugNetworkSize{Size: size}
+}
+
+func (dht *IpfsDHT) debugRefreshStatus() DebugRefreshStatus {
+	s := dht.rtRefreshManager.Status()
+	status := DebugRefreshStatus{Refreshing: s.Refreshing, LastRefresh: s.LastRefresh}
+	if s.LastError != nil {
+		status.LastError = s.LastError.Error()
+	}
+	return status
+}
+
+func (dht *IpfsDHT) debugProviderStore(ctx context.Context) DebugProviderStore {
+	sr, ok := dht.providerStore.(providers.StatsReporter)
+	if !ok {
+		return DebugProviderStore{Error: "the provider store can't count its records"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, debugProviderStatsTimeout)
+	defer cancel()
+	stats, err := sr.Stats(ctx)
+	if err != nil {
+		return DebugProviderStore{Error: err.Error()}
+	}
+	return DebugProviderStore{Stats: stats}
+}
@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+func TestDebugHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// the other peers hold the FIND_NODE requests for the blocked key until it is released.
+	blockedKey := "/v/blocked"
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	defer releaseOnce.Do(func() { close(release) })
+	block := func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+		if req.GetType() == pb.Message_FIND_NODE && string(req.GetKey()) == blockedKey {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return next(ctx, p, req)
+	}
+
+	d := setupDHT(ctx, t, false, KeepRecentLookupTraces(2))
+	others := setupDHTS(t, ctx, 3, WithInterceptors(block))
+	for _, o := range others {
+		connect(t, ctx, d, o)
+	}
+	require.NoError(t, d.Provide(ctx, testCaseCids[0], true))
+	require.NoError(t, <-d.RefreshRoutingTable())
+
+	srv := httptest.NewServer(http.StripPrefix("/debug", d.DebugHandler()))
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(srv.URL + "/debug" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	var state DebugState
+	require.Equal(t, http.StatusOK, get("/", &state))
+	require.Equal(t, d.self, state.Self)
+	require.Equal(t, "server", state.Mode)
+	var peers int
+	for _, b := range state.RoutingTable {
+		peers += len(b.Peers)
+	}
+	require.Equal(t, len(others), peers)
+	require.False(t, state.Refresh.LastRefresh.IsZero())
+	require.Empty(t, state.ProviderStore.Error)
+	require.Equal(t, 1, state.ProviderStore.Keys)
+	require.Len(t, state.RecentLookupTraces, 2)
+
+	// a lookup shows up while it is in flight, and goes away once it completes.
+	inFlight := func() bool {
+		var lookups []LookupInfo
+		require.Equal(t, http.StatusOK, get("/lookups", &lookups))
+		for _, l := range lookups {
+			if string(l.Key) == blockedKey {
+				return true
+			}
+		}
+		return false
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.GetClosestPeers(ctx, blockedKey)
+		done <- err
+	}()
+	for deadline := time.Now().Add(10 * time.Second); !inFlight(); time.Sleep(10 * time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "the lookup never showed up in flight")
+	}
+	releaseOnce.Do(func() { close(release) })
+	require.NoError(t, <-done)
+	require.False(t, inFlight())
+
+	var buckets []DebugBucket
+	require.Equal(t, http.StatusOK, get("/routing-table", &buckets))
+	require.Len(t, buckets, len(state.RoutingTable))
+	for i, b := range buckets {
+		require.Equal(t, state.RoutingTable[i].Cpl, b.Cpl)
+		require.Len(t, b.Peers, len(state.RoutingTable[i].Peers))
+	}
+
+	require.Equal(t, http.StatusNotFound, get("/unknown", nil))
+
+	resp, err := http.Post(srv.URL+"/debug/", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
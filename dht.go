@@ -6,6 +6,7 @@ import (
 	"math"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/libp2p/go-libp2p-routing-helpers/tracing"
@@ -115,21 +116,39 @@ type IpfsDHT struct {
 	mode   mode
 	modeLk sync.Mutex
 
+	// cfg is the configuration the DHT runs with, reconfigureLk serializes its changes.
+	cfg           dhtcfg.Config
+	reconfigureLk sync.Mutex
+
+	// paramsLk guards alpha, beta, the peer filters and the query diversity limits, which can be
+	// changed with Reconfigure.
+	paramsLk sync.RWMutex
+
 	bucketSize int
 	alpha      int // The concurrency parameter per path
 	beta       int // The number of peers closest to a target that must have responded for a query path to terminate
 
+	disjointPaths int // The number of disjoint paths lookups are run over
+
 	queryPeerFilter        QueryFilterFunc
 	routingTablePeerFilter RouteTableFilterFunc
 	rtPeerDiversityFilter  peerdiversity.PeerIPGroupFilter
 
+	// maximum number of peers of a lookup in the same IP group or ASN, zero if unlimited
+	queryMaxPerIPGroup int
+	queryMaxPerASN     int
+
+	// groups the peers of the same network for the diversity filters
+	asnResolver ASNResolver
+
 	autoRefresh bool
 
 	// timeout for the lookupCheck operation
 	lookupCheckTimeout time.Duration
-	// number of concurrent lookupCheck operations
-	lookupCheckCapacity int
-	lookupChecksLk      sync.Mutex
+	// maximum and available number of concurrent lookupCheck operations
+	lookupCheckConcurrency int
+	lookupCheckCapacity    int
+	lookupChecksLk         sync.Mutex
 
 	// A function returning a set of bootstrap peers to fallback on if all other attempts to fix
 	// the routing table fail (or, e.g., this is the first time this node is
@@ -158,6 +177,63 @@ type IpfsDHT struct {
 	// a bound channel to limit asynchronicity of in-flight ADD_PROVIDER RPCs
 	optProvJobsPool chan struct{}
 
+	// number of peers ProvideMany and PutMany send messages to in parallel
+	bulkSendParallelism int
+
+	// interval at which the routing table is persisted to the datastore, zero if disabled
+	rtPersistInterval time.Duration
+	// rtRestored is set once the persisted snapshot was restored, the routing table isn't persisted
+	// before so that a partially restored table doesn't replace the snapshot.
+	rtRestored atomic.Bool
+
+	// signed provider records are attached to provider announcements and verified on receipt.
+	// signedProvidersProto is the protocol extension they are negotiated with.
+	enableSignedProviders bool
+	signedProvidersProto  protocol.ID
+
+	// encrypted provider records stored under the double hash of the provided keys, nil if
+	// disabled. doubleHashProto is the protocol extension their messages are sent over, with
+	// doubleHashMessenger.
+	encryptedProviders  *providers.EncryptedProviderStore
+	doubleHashProto     protocol.ID
+	doubleHashMsgSender pb.MessageSenderWithDisconnect
+	doubleHashMessenger *pb.ProtocolMessenger
+
+	// reprovider periodically announces the provided keys again, nil if disabled
+	reprovider *Reprovider
+
+	// republisher periodically stores the records we put again, nil if disabled
+	republisher *Republisher
+
+	// subscribers to the routing table changes
+	rtEvents rtEvents
+
+	// per-peer rate limits and quotas on the messages we receive, nil if disabled
+	inboundLimiter *inboundLimiter
+
+	// interceptors wrapping the request handlers, outermost first
+	interceptors []Interceptor
+
+	// closestPeers replaces the routing table when answering requests, nil if not set
+	closestPeers func(key []byte, from peer.ID, count int) []peer.ID
+
+	// lookupTracer records every lookup, nil if disabled
+	lookupTracer *LookupTracer
+	// recentTraces keeps the traces of the most recent lookups, nil if disabled
+	recentTraces *lookupTraceRing
+
+	// lookups in progress, by lookup path
+	lookupsLk sync.Mutex
+	lookups   map[*query]LookupInfo
+
+	// per-RPC lookup timeouts, disabled if the multiplier is zero
+	rpcTimeoutMultiplier float64
+	rpcTimeoutMin        time.Duration
+	rpcTimeoutMax        time.Duration
+
+	// clock times the lookups and their RPCs
+	clock Clock
+
 	// configuration variables for tests
 	testAddressUpdateProcessing bool
 
@@ -206,11 +282,29 @@ func New(ctx context.Context, h host.Host, options ...Option) (*IpfsDHT, error)
 	dht.disableFixLowPeers = cfg.DisableFixLowPeers
 
 	dht.Validator = cfg.Validator
-	dht.msgSender = net.NewMessageSenderImpl(h, dht.protocols)
+	if cfg.MsgSenderBuilder != nil {
+		dht.msgSender = cfg.MsgSenderBuilder(h, dht.protocols)
+	} else {
+		dht.msgSender = net.NewMessageSenderImpl(h, dht.protocols)
+	}
 	dht.protoMessenger, err = pb.NewProtocolMessenger(dht.msgSender)
 	if err != nil {
 		return nil, err
 	}
+	if dht.doubleHashProto != "" {
+		// peers that don't know the encrypted provider messages must not receive them over the
+		// regular protocol, so they get streams of their own.
+		protos := []protocol.ID{dht.doubleHashProto}
+		if cfg.MsgSenderBuilder != nil {
+			dht.doubleHashMsgSender = cfg.MsgSenderBuilder(h, protos)
+		} else {
+			dht.doubleHashMsgSender = net.NewMessageSenderImpl(h, protos)
+		}
+		dht.doubleHashMessenger, err = pb.NewProtocolMessenger(dht.doubleHashMsgSender)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	dht.testAddressUpdateProcessing = cfg.TestAddressUpdateProcessing
 
@@ -248,9 +342,29 @@ func New(ctx context.Context, h host.Host, options ...Option) (*IpfsDHT, error)
 
 	dht.rtRefreshManager.Start()
 
+	if dht.rtPersistInterval > 0 {
+		dht.runRoutingTablePersistence()
+	}
+
+	if dht.reprovider != nil {
+		dht.reprovider.run()
+	}
+
+	if dht.republisher != nil {
+		dht.republisher.run()
+	}
+
 	// listens to the fix low peers chan and tries to fix the Routing Table
 	if !dht.disableFixLowPeers {
 		dht.runFixLowPeersLoop()
+	} else if dht.rtPersistInterval > 0 {
+		// the fix low peers loop restores the snapshot before its first run, restore it on our own
+		// without the loop.
+		dht.wg.Add(1)
+		go func() {
+			defer dht.wg.Done()
+			dht.restoreRoutingTable(dht.ctx)
+		}()
 	}
 
 	return dht, nil
@@ -290,7 +404,24 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 	protocols = []protocol.ID{v1proto}
 	serverProtocols = []protocol.ID{v1proto}
 
+	// the signed provider records extension is preferred when both sides speak it.
+	var signedProvidersProto protocol.ID
+	if cfg.EnableSignedProviderRecords {
+		signedProvidersProto = v1proto + signedProvidersSuffix
+		protocols = append([]protocol.ID{signedProvidersProto}, protocols...)
+		serverProtocols = append([]protocol.ID{signedProvidersProto}, serverProtocols...)
+	}
+
+	// the double hashed provider records extension is only used for the encrypted provider
+	// messages, never preferred for the other requests.
+	var doubleHashProto protocol.ID
+	if cfg.EnableDoubleHashedProviders {
+		doubleHashProto = v1proto + doubleHashSuffix
+		serverProtocols = append(serverProtocols, doubleHashProto)
+	}
+
 	dht := &IpfsDHT{
+		cfg:                    cfg,
 		datastore:              cfg.Datastore,
 		self:                   h.ID(),
 		selfKey:                kb.ConvertPeerID(h.ID()),
@@ -302,12 +433,18 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 		bucketSize:             cfg.BucketSize,
 		alpha:                  cfg.Concurrency,
 		beta:                   cfg.Resiliency,
+		disjointPaths:          cfg.DisjointPaths,
+		lookupCheckConcurrency: cfg.LookupCheckConcurrency,
 		lookupCheckCapacity:    cfg.LookupCheckConcurrency,
 		queryPeerFilter:        cfg.QueryPeerFilter,
 		routingTablePeerFilter: cfg.RoutingTable.PeerFilter,
 		rtPeerDiversityFilter:  cfg.RoutingTable.DiversityFilter,
 		addrFilter:             cfg.AddressFilter,
 
+		queryMaxPerIPGroup: cfg.QueryDiversity.MaxPerIPGroup,
+		queryMaxPerASN:     cfg.QueryDiversity.MaxPerASN,
+		asnResolver:        DefaultASNResolver,
+
 		fixLowPeersChan: make(chan struct{}, 1),
 
 		addPeerToRTChan:   make(chan peer.ID),
@@ -315,22 +452,42 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 
 		enableOptProv:   cfg.EnableOptimisticProvide,
 		optProvJobsPool: nil,
+
+		bulkSendParallelism: cfg.BulkSendParallelism,
+
+		enableSignedProviders: cfg.EnableSignedProviderRecords,
+		signedProvidersProto:  signedProvidersProto,
+
+		doubleHashProto: doubleHashProto,
+
+		inboundLimiter: newInboundLimiter(cfg, providers.ProvideValidity, cfg.MaxRecordAge),
+		interceptors:   cfg.Interceptors,
+		closestPeers:   cfg.ClosestPeers,
+
+		rpcTimeoutMultiplier: cfg.RPCTimeouts.Multiplier,
+		rpcTimeoutMin:        cfg.RPCTimeouts.Min,
+		rpcTimeoutMax:        cfg.RPCTimeouts.Max,
+		clock:                cfg.Clock,
+
+		lookups: make(map[*query]LookupInfo),
 	}
 
-	var maxLastSuccessfulOutboundThreshold time.Duration
+	if cfg.LookupTraceWriter != nil {
+		dht.lookupTracer = NewLookupTracer(cfg.LookupTraceWriter)
+	}
+	if cfg.RecentLookupTraces > 0 {
+		dht.recentTraces = newLookupTraceRing(cfg.RecentLookupTraces)
+	}
 
-	// The threshold is calculated based on the expected amount of time that should pass before we
-	// query a peer as part of our refresh cycle.
-	// To grok the Math Wizardy that produced these exact equations, please be patient as a document explaining it will
-	// be published soon.
-	if cfg.Concurrency < cfg.BucketSize { // (alpha < K)
-		l1 := math.Log(float64(1) / float64(cfg.BucketSize))                              // (Log(1/K))
-		l2 := math.Log(float64(1) - (float64(cfg.Concurrency) / float64(cfg.BucketSize))) // Log(1 - (alpha / K))
-		maxLastSuccessfulOutboundThreshold = time.Duration(l1 / l2 * float64(cfg.RoutingTable.RefreshInterval))
-	} else {
-		maxLastSuccessfulOutboundThreshold = cfg.RoutingTable.RefreshInterval
+	if cfg.ASNResolver != nil {
+		dht.asnResolver = cfg.ASNResolver
+		if f, ok := dht.rtPeerDiversityFilter.(*rtPeerIPGroupFilter); ok {
+			f.setASNResolver(cfg.ASNResolver)
+		}
 	}
 
+	maxLastSuccessfulOutboundThreshold := computeMaxLastSuccessfulOutboundThreshold(cfg)
+
 	// construct routing table
 	// use twice the theoritical usefulness threhold to keep older peers around longer
 	rt, err := makeRoutingTable(dht, cfg, 2*maxLastSuccessfulOutboundThreshold)
@@ -341,6 +498,7 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 	dht.bootstrapPeers = cfg.BootstrapPeers
 
 	dht.lookupCheckTimeout = cfg.RoutingTable.RefreshQueryTimeout
+	dht.rtPersistInterval = cfg.RoutingTable.PersistInterval
 
 	// init network size estimator
 	dht.nsEstimator = netsize.NewEstimator(h.ID(), rt, cfg.BucketSize)
@@ -370,9 +528,34 @@ func makeDHT(h host.Host, cfg dhtcfg.Config) (*IpfsDHT, error) {
 
 	dht.rtFreezeTimeout = rtFreezeTimeout
 
+	if cfg.Reprovider.Enabled {
+		dht.reprovider = newReprovider(dht, cfg.Reprovider.Interval, cfg.Reprovider.Concurrency)
+	}
+	if cfg.EnableDoubleHashedProviders {
+		dht.encryptedProviders = providers.NewEncryptedProviderStore(cfg.Datastore)
+	}
+	if cfg.Republisher.Enabled {
+		dht.republisher = newRepublisher(dht, cfg.Republisher.Interval, cfg.Republisher.Concurrency)
+	}
+
 	return dht, nil
 }
 
+// computeMaxLastSuccessfulOutboundThreshold returns the time after which we expect to have queried every
+// routing table peer as part of our refresh cycle.
+func computeMaxLastSuccessfulOutboundThreshold(cfg dhtcfg.Config) time.Duration {
+	// The threshold is calculated based on the expected amount of time that should pass before we
+	// query a peer as part of our refresh cycle.
+	// To grok the Math Wizardy that produced these exact equations, please be patient as a document explaining it will
+	// be published soon.
+	if cfg.Concurrency < cfg.BucketSize { // (alpha < K)
+		l1 := math.Log(float64(1) / float64(cfg.BucketSize))                              // (Log(1/K))
+		l2 := math.Log(float64(1) - (float64(cfg.Concurrency) / float64(cfg.BucketSize))) // Log(1 - (alpha / K))
+		return time.Duration(l1 / l2 * float64(cfg.RoutingTable.RefreshInterval))
+	}
+	return cfg.RoutingTable.RefreshInterval
+}
+
 // lookupCheck performs a lookup request to a remote peer.ID, verifying that it is able to
 // answer it correctly
 func (dht *IpfsDHT) lookupCheck(ctx context.Context, p peer.ID) error {
@@ -406,7 +589,10 @@ func makeRtRefreshManager(dht *IpfsDHT, cfg dhtcfg.Config, maxLastSuccessfulOutb
 		cfg.RoutingTable.RefreshQueryTimeout,
 		cfg.RoutingTable.RefreshInterval,
 		maxLastSuccessfulOutboundThreshold,
-		dht.refreshFinishedCh)
+		dht.refreshFinishedCh,
+		rtrefresh.EvictPeerFunc(func(p peer.ID, err error) {
+			dht.removePeerFromRT(p, RoutingTablePeerEvicted, err.Error())
+		}))
 
 	return r, err
 }
@@ -444,6 +630,7 @@ func makeRoutingTable(dht *IpfsDHT, cfg dhtcfg.Config, maxLastSuccessfulOutbound
 	rt.PeerRemoved = func(p peer.ID) {
 		cmgr.Unprotect(p, kbucketTag)
 		cmgr.UntagPeer(p, kbucketTag)
+		dht.peerRemovedFromRT(p)
 
 		// try to fix the RT
 		dht.fixRTIfNeeded()
@@ -473,6 +660,12 @@ func (dht *IpfsDHT) runFixLowPeersLoop() {
 	go func() {
 		defer dht.wg.Done()
 
+		// seed the routing table with the peers we knew of before the restart, so that we
+		// only fall back to the bootstrappers if none of them is reachable anymore.
+		if dht.rtPersistInterval > 0 {
+			dht.restoreRoutingTable(dht.ctx)
+		}
+
 		dht.fixLowPeers()
 
 		ticker := time.NewTicker(periodicBootstrapInterval)
@@ -503,10 +696,6 @@ func (dht *IpfsDHT) fixLowPeers() {
 		dht.peerFound(p)
 	}
 
-	// TODO Active Bootstrapping
-	// We should first use non-bootstrap peers we knew of from previous
-	// snapshots of the Routing Table before we connect to the bootstrappers.
-	// See https://github.com/libp2p/go-libp2p-kad-dht/issues/387.
 	if dht.routingTable.Size() == 0 && dht.bootstrapPeers != nil {
 		bootstrapPeers := dht.bootstrapPeers()
 		if len(bootstrapPeers) == 0 {
@@ -625,9 +814,15 @@ func (dht *IpfsDHT) rtPeerLoop() {
 				newlyAdded, err := dht.routingTable.TryAddPeer(p, true, isBootsrapping)
 				if err != nil {
 					// peer not added.
+					dht.publishRoutingTableEvent(RoutingTablePeerRejected, p, err.Error())
 					continue
 				}
 				if newlyAdded {
+					reason := rtReasonQueried
+					if isBootsrapping {
+						reason = rtReasonBootstrapping
+					}
+					dht.publishRoutingTableEvent(RoutingTablePeerAdded, p, reason)
 					// peer was added to the RT, it can now be fixed if needed.
 					dht.fixRTIfNeeded()
 				} else {
@@ -672,10 +867,11 @@ func (dht *IpfsDHT) peerFound(p peer.ID) {
 
 		// check if the maximal number of concurrent lookup checks is reached
 		dht.lookupChecksLk.Lock()
-		if dht.lookupCheckCapacity == 0 {
+		if dht.lookupCheckCapacity <= 0 {
 			dht.lookupChecksLk.Unlock()
 			// drop the new peer.ID if the maximal number of concurrent lookup
 			// checks is reached
+			dht.publishRoutingTableEvent(RoutingTablePeerDropped, p, rtReasonNoCapacity)
 			return
 		}
 		dht.lookupCheckCapacity--
@@ -722,7 +918,7 @@ func (dht *IpfsDHT) peerStoppedDHT(p peer.ID) {
 	logger.Debugw("peer stopped dht", "peer", p)
 	// A peer that does not support the DHT protocol is dead for us.
 	// There's no point in talking to anymore till it starts supporting the DHT protocol again.
-	dht.routingTable.RemovePeer(p)
+	dht.removePeerFromRT(p, RoutingTablePeerRemoved, rtReasonStoppedDHT)
 }
 
 func (dht *IpfsDHT) fixRTIfNeeded() {
@@ -753,7 +949,12 @@ func (dht *IpfsDHT) nearestPeersToQuery(pmes *pb.Message, count int) []peer.ID {
 
 // betterPeersToQuery returns nearestPeersToQuery with some additional filtering
 func (dht *IpfsDHT) betterPeersToQuery(pmes *pb.Message, from peer.ID, count int) []peer.ID {
-	closer := dht.nearestPeersToQuery(pmes, count)
+	var closer []peer.ID
+	if dht.closestPeers != nil {
+		closer = dht.closestPeers(pmes.GetKey(), from, count)
+	} else {
+		closer = dht.nearestPeersToQuery(pmes, count)
+	}
 
 	// no node? nil
 	if closer == nil {
@@ -863,6 +1064,18 @@ func (dht *IpfsDHT) Close() error {
 	closes := [...]func() error{
 		dht.rtRefreshManager.Close,
 		dht.providerStore.Close,
+		func() error {
+			if dht.encryptedProviders == nil {
+				return nil
+			}
+			return dht.encryptedProviders.Close()
+		},
+		func() error {
+			if dht.rtPersistInterval <= 0 {
+				return nil
+			}
+			return dht.saveRoutingTableSnapshot(context.Background())
+		},
 	}
 	var errors [len(closes)]error
 	wg.Add(len(errors))
@@ -0,0 +1,109 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// Handler handles a DHT request received from a remote peer, returning the response to send back.
+// A nil response sends nothing back, an error resets the stream the request came in on.
+type Handler = dhtcfg.Handler
+
+// Interceptor wraps the handling of the DHT requests received from remote peers. It is called with
+// the next Handler of the chain, which it may call with the (possibly rewritten) request, or not
+// call at all to answer or reject the request itself.
+type Interceptor = dhtcfg.Interceptor
+
+// ErrPeerDenied is returned by the interceptors rejecting the requests of a peer.
+var ErrPeerDenied = errors.New("peer is not allowed to send dht requests")
+
+func intercept(i Interceptor, next dhtHandler) dhtHandler {
+	return func(ctx context.Context, p peer.ID, req *pb.Message) (*pb.Message, error) {
+		return i(ctx, p, req, Handler(next))
+	}
+}
+
+// LoggingInterceptor logs every request we handle, along with its outcome.
+func LoggingInterceptor() Interceptor {
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+		start := time.Now()
+		resp, err := next(ctx, p, req)
+		logger.Infow("handled dht request",
+			"from", p,
+			"type", req.GetType(),
+			"key", internal.LoggableRecordKeyBytes(req.GetKey()),
+			"duration", time.Since(start),
+			"error", err,
+		)
+		return resp, err
+	}
+}
+
+// PeerFilterInterceptor rejects the requests of the peers the filter returns false for with
+// ErrPeerDenied.
+func PeerFilterInterceptor(allow func(peer.ID) bool) Interceptor {
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+		if !allow(p) {
+			return nil, ErrPeerDenied
+		}
+		return next(ctx, p, req)
+	}
+}
+
+// AllowListInterceptor only handles the requests of the given peers.
+func AllowListInterceptor(peers ...peer.ID) Interceptor {
+	allowed := peerSet(peers)
+	return PeerFilterInterceptor(func(p peer.ID) bool {
+		_, ok := allowed[p]
+		return ok
+	})
+}
+
+// DenyListInterceptor rejects the requests of the given peers.
+func DenyListInterceptor(peers ...peer.ID) Interceptor {
+	denied := peerSet(peers)
+	return PeerFilterInterceptor(func(p peer.ID) bool {
+		_, ok := denied[p]
+		return !ok
+	})
+}
+
+func peerSet(peers []peer.ID) map[peer.ID]struct{} {
+	set := make(map[peer.ID]struct{}, len(peers))
+	for _, p := range peers {
+		set[p] = struct{}{}
+	}
+	return set
+}
+
+// SamplingInterceptor passes a random fraction, between 0 and 1, of the requests we handle to the
+// sample function, along with their outcome. The sample function is called synchronously, before
+// the response is sent back, and must not modify the request or the response.
+func SamplingInterceptor(fraction float64, sample func(ctx context.Context, p peer.ID, req, resp *pb.Message, err error)) Interceptor {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+		mu.Lock()
+		sampled := rng.Float64() < fraction
+		mu.Unlock()
+
+		if !sampled {
+			return next(ctx, p, req)
+		}
+		// the handlers may modify the request in place, keep the original around for the sample.
+		orig := *req
+		resp, err := next(ctx, p, req)
+		sample(ctx, p, &orig, resp, err)
+		return resp, err
+	}
+}
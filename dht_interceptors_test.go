@@ -0,0 +1,129 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+)
+
+func TestInterceptorsOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+			calls = append(calls, name)
+			resp, err := next(ctx, p, req)
+			calls = append(calls, name)
+			return resp, err
+		}
+	}
+	d := setupDHT(ctx, t, false, WithInterceptors(trace("outer")), WithInterceptors(trace("inner")))
+
+	resp, err := d.handlerForMsgType(pb.Message_PING)(ctx, d.self, pb.NewMessage(pb.Message_PING, nil, 0))
+	require.NoError(t, err)
+	require.Equal(t, pb.Message_PING, resp.GetType())
+	require.Equal(t, []string{"outer", "inner", "inner", "outer"}, calls)
+}
+
+func TestInterceptorsUnsupportedMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	custom := pb.Message_MessageType(100)
+	answer := func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+		if req.GetType() == custom || req.GetType() == pb.Message_ADD_PROVIDER {
+			return pb.NewMessage(req.GetType(), req.GetKey(), 0), nil
+		}
+		return next(ctx, p, req)
+	}
+	d := setupDHT(ctx, t, false, DisableProviders(), WithInterceptors(answer))
+
+	// the interceptors answer the types the DHT doesn't handle.
+	for _, typ := range []pb.Message_MessageType{custom, pb.Message_ADD_PROVIDER} {
+		resp, err := d.handlerForMsgType(typ)(ctx, d.self, pb.NewMessage(typ, []byte("key"), 0))
+		require.NoError(t, err)
+		require.Equal(t, typ, resp.GetType())
+	}
+
+	// the others are rejected at the end of the chain.
+	_, err := d.handlerForMsgType(pb.Message_GET_PROVIDERS)(ctx, d.self, pb.NewMessage(pb.Message_GET_PROVIDERS, []byte("key"), 0))
+	require.Error(t, err)
+}
+
+func TestInterceptorsOverNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	denied := setupDHT(ctx, t, false)
+	client := setupDHT(ctx, t, false)
+
+	// answers GET_VALUE for /v/fixed itself, and serves /v/hello under /v/alias.
+	custom := func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+		if req.GetType() != pb.Message_GET_VALUE {
+			return next(ctx, p, req)
+		}
+		switch string(req.GetKey()) {
+		case "/v/fixed":
+			resp := pb.NewMessage(req.GetType(), req.GetKey(), 0)
+			resp.Record = &recpb.Record{Key: req.GetKey(), Value: []byte("fixed")}
+			return resp, nil
+		case "/v/alias":
+			req.Key = []byte("/v/hello")
+			resp, err := next(ctx, p, req)
+			if err == nil && resp.GetRecord() != nil {
+				resp.Key = []byte("/v/alias")
+				resp.Record.Key = resp.Key
+			}
+			return resp, err
+		}
+		return next(ctx, p, req)
+	}
+	server := setupDHT(ctx, t, false, WithInterceptors(DenyListInterceptor(denied.self), custom))
+	connect(t, ctx, client, server)
+	connectNoSync(t, ctx, denied, server)
+
+	require.NoError(t, server.PutValue(ctx, "/v/hello", []byte("world")))
+
+	rec, _, err := client.protoMessenger.GetValue(ctx, server.self, "/v/fixed")
+	require.NoError(t, err)
+	require.Equal(t, []byte("fixed"), rec.GetValue())
+
+	rec, _, err = client.protoMessenger.GetValue(ctx, server.self, "/v/alias")
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), rec.GetValue())
+
+	_, _, err = denied.protoMessenger.GetValue(ctx, server.self, "/v/fixed")
+	require.Error(t, err)
+}
+
+func TestSamplingInterceptor(t *testing.T) {
+	ctx := context.Background()
+	handler := func(ctx context.Context, p peer.ID, req *pb.Message) (*pb.Message, error) {
+		req.Key = []byte("modified")
+		return req, nil
+	}
+
+	var sampled []*pb.Message
+	always := SamplingInterceptor(1, func(ctx context.Context, p peer.ID, req, resp *pb.Message, err error) {
+		sampled = append(sampled, req)
+	})
+	never := SamplingInterceptor(0, func(ctx context.Context, p peer.ID, req, resp *pb.Message, err error) {
+		t.Fatal("unexpected sample")
+	})
+
+	_, err := always(ctx, "", pb.NewMessage(pb.Message_GET_VALUE, []byte("key"), 0), handler)
+	require.NoError(t, err)
+	_, err = never(ctx, "", pb.NewMessage(pb.Message_GET_VALUE, []byte("key"), 0), handler)
+	require.NoError(t, err)
+
+	require.Len(t, sampled, 1)
+	require.Equal(t, []byte("key"), sampled[0].GetKey())
+}
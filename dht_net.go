@@ -1,10 +1,14 @@
 package dht
 
 import (
+	"context"
+	"errors"
 	"io"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 
 	"github.com/libp2p/go-libp2p-kad-dht/internal/net"
 	"github.com/libp2p/go-libp2p-kad-dht/metrics"
@@ -21,6 +25,11 @@ var dhtStreamIdleTimeout = 1 * time.Minute
 // ErrReadTimeout is an error that occurs when no message is read within the timeout period.
 var ErrReadTimeout = net.ErrReadTimeout
 
+var (
+	errRateLimited = errors.New("rate limited")
+	errNotServer   = errors.New("dht is not in server mode")
+)
+
 // handleNewStream implements the network.StreamHandler
 func (dht *IpfsDHT) handleNewStream(s network.Stream) {
 	if dht.handleNewMessage(s) {
@@ -35,6 +44,9 @@ func (dht *IpfsDHT) handleNewStream(s network.Stream) {
 // Returns true on orderly completion of writes (so we can Close the stream).
 func (dht *IpfsDHT) handleNewMessage(s network.Stream) bool {
 	ctx := dht.ctx
+	if dht.enableSignedProviders && s.Protocol() == dht.signedProvidersProto {
+		ctx = withSignedProviders(ctx)
+	}
 	r := msgio.NewVarintReaderSize(s, network.MessageSizeMax)
 
 	mPeer := s.Conn().RemotePeer()
@@ -100,40 +112,11 @@ func (dht *IpfsDHT) handleNewMessage(s network.Stream) bool {
 			metrics.ReceivedBytes.M(int64(msgLen)),
 		)
 
-		handler := dht.handlerForMsgType(req.GetType())
-		if handler == nil {
-			stats.Record(ctx, metrics.ReceivedMessageErrors.M(1))
-			if c := baseLogger.Check(zap.DebugLevel, "can't handle received message"); c != nil {
-				c.Write(zap.String("from", mPeer.String()),
-					zap.Int32("type", int32(req.GetType())))
-			}
-			return false
-		}
-
-		if c := baseLogger.Check(zap.DebugLevel, "handling message"); c != nil {
-			c.Write(zap.String("from", mPeer.String()),
-				zap.Int32("type", int32(req.GetType())),
-				zap.Binary("key", req.GetKey()))
-		}
-		resp, err := handler(ctx, mPeer, &req)
+		resp, err := dht.handleMessage(ctx, mPeer, &req, startTime)
 		if err != nil {
-			stats.Record(ctx, metrics.ReceivedMessageErrors.M(1))
-			if c := baseLogger.Check(zap.DebugLevel, "error handling message"); c != nil {
-				c.Write(zap.String("from", mPeer.String()),
-					zap.Int32("type", int32(req.GetType())),
-					zap.Binary("key", req.GetKey()),
-					zap.Error(err))
-			}
 			return false
 		}
 
-		if c := baseLogger.Check(zap.DebugLevel, "handled message"); c != nil {
-			c.Write(zap.String("from", mPeer.String()),
-				zap.Int32("type", int32(req.GetType())),
-				zap.Binary("key", req.GetKey()),
-				zap.Duration("time", time.Since(startTime)))
-		}
-
 		if resp == nil {
 			continue
 		}
@@ -164,3 +147,64 @@ func (dht *IpfsDHT) handleNewMessage(s network.Stream) bool {
 		stats.Record(ctx, metrics.InboundRequestLatency.M(latencyMillis))
 	}
 }
+
+// handleMessage dispatches a request received from p to the handler of its type, within the
+// inbound limits.
+func (dht *IpfsDHT) handleMessage(ctx context.Context, p peer.ID, req *pb.Message, startTime time.Time) (*pb.Message, error) {
+	if !dht.inboundLimiter.allowMessage(p, req.GetType()) {
+		recordRejected(ctx, rejectedRateLimit)
+		if c := baseLogger.Check(zap.DebugLevel, "rate limited message"); c != nil {
+			c.Write(zap.String("from", p.String()),
+				zap.Int32("type", int32(req.GetType())))
+		}
+		return nil, errRateLimited
+	}
+
+	handler := dht.handlerForMsgType(req.GetType())
+
+	if c := baseLogger.Check(zap.DebugLevel, "handling message"); c != nil {
+		c.Write(zap.String("from", p.String()),
+			zap.Int32("type", int32(req.GetType())),
+			zap.Binary("key", req.GetKey()))
+	}
+	resp, err := handler(ctx, p, req)
+	if err != nil {
+		stats.Record(ctx, metrics.ReceivedMessageErrors.M(1))
+		if c := baseLogger.Check(zap.DebugLevel, "error handling message"); c != nil {
+			c.Write(zap.String("from", p.String()),
+				zap.Int32("type", int32(req.GetType())),
+				zap.Binary("key", req.GetKey()),
+				zap.Error(err))
+		}
+		return nil, err
+	}
+
+	if c := baseLogger.Check(zap.DebugLevel, "handled message"); c != nil {
+		c.Write(zap.String("from", p.String()),
+			zap.Int32("type", int32(req.GetType())),
+			zap.Binary("key", req.GetKey()),
+			zap.Duration("time", time.Since(startTime)))
+	}
+	return resp, nil
+}
+
+// HandleRequest handles a request received from peer p over the given DHT protocol by other means
+// than a libp2p stream, such as the in-memory transport of the simulation package. The request is
+// subject to the same inbound limits and interceptors as the ones received over streams. The
+// returned response is nil for the requests that don't have one.
+func (dht *IpfsDHT) HandleRequest(p peer.ID, proto protocol.ID, req *pb.Message) (*pb.Message, error) {
+	if dht.getMode() != modeServer {
+		return nil, errNotServer
+	}
+
+	ctx := dht.ctx
+	if dht.enableSignedProviders && proto == dht.signedProvidersProto {
+		ctx = withSignedProviders(ctx)
+	}
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.KeyMessageType, req.GetType().String()))
+	stats.Record(ctx,
+		metrics.ReceivedMessages.M(1),
+		metrics.ReceivedBytes.M(int64(req.Size())),
+	)
+	return dht.handleMessage(ctx, p, req, time.Now())
+}
@@ -2,13 +2,16 @@ package dht
 
 import (
 	"fmt"
+	"io"
 	"testing"
 	"time"
 
 	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
 	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 
@@ -75,6 +78,22 @@ func RoutingTableRefreshPeriod(period time.Duration) Option {
 	}
 }
 
+// RoutingTablePersistence snapshots the routing table to the datastore every interval and when the
+// DHT is closed. On startup, the peers of the last snapshot are validated and used to seed the
+// routing table before falling back to the bootstrap peers. Use a persistent datastore for the
+// snapshot to survive restarts.
+//
+// Disabled by default.
+func RoutingTablePersistence(interval time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		if interval <= 0 {
+			return fmt.Errorf("routing table persist interval must be positive, got %s", interval)
+		}
+		c.RoutingTable.PersistInterval = interval
+		return nil
+	}
+}
+
 // Datastore configures the DHT to use the specified datastore.
 //
 // Defaults to an in-memory (temporary) map.
@@ -194,6 +213,19 @@ func Resiliency(beta int) Option {
 	}
 }
 
+// DisjointPaths configures the number of disjoint paths (d in the S/Kademlia paper) lookups are run over.
+// The closest peers from the routing table are distributed over the paths and every peer is used by
+// at most one path, so that a malicious peer can only influence the path it belongs to. The results
+// of all paths are merged. Every path queries up to Concurrency peers in parallel.
+//
+// The default value is 1.
+func DisjointPaths(d int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.DisjointPaths = d
+		return nil
+	}
+}
+
 // LookupInterval configures maximal number of go routines that can be used to
 // perform a lookup check operation, before adding a new node to the routing table.
 func LookupCheckConcurrency(n int) Option {
@@ -356,3 +388,276 @@ func AddressFilter(f func([]ma.Multiaddr) []ma.Multiaddr) Option {
 		return nil
 	}
 }
+
+// EnableReprovider enables the built-in reprovider. Every key announced through Provide is persisted
+// in the DHT's datastore and announced again every ReprovideInterval, so that the provider records
+// don't expire from the network. The set of keys survives restarts when a persistent datastore is used.
+func EnableReprovider() Option {
+	return func(c *dhtcfg.Config) error {
+		c.Reprovider.Enabled = true
+		return nil
+	}
+}
+
+// ReprovideInterval configures how often the reprovider announces all of its keys again.
+// It should be well below the provider record expiration interval (providers.ProvideValidity).
+//
+// The default value is 22 hours.
+func ReprovideInterval(interval time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		c.Reprovider.Interval = interval
+		return nil
+	}
+}
+
+// ReprovideConcurrency configures the number of keys the reprovider announces in parallel.
+//
+// The default value is 8.
+func ReprovideConcurrency(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.Reprovider.Concurrency = n
+		return nil
+	}
+}
+
+// EnableSignedProviderRecords makes the DHT sign the provider records it announces and verify the
+// signed provider records it receives. Signed records are carried in ADD_PROVIDER and GET_PROVIDERS
+// messages and are negotiated through a protocol extension, so that peers which don't support them
+// keep working with unsigned records. Since a signed record vouches for the provider itself, it is
+// accepted even when relayed by another peer, and providers returned with an invalid record are
+// dropped by FindProvidersAsync.
+//
+// EXPERIMENTAL: This is an experimental option and might be removed in the future. Use at your own risk.
+func EnableSignedProviderRecords() Option {
+	return func(c *dhtcfg.Config) error {
+		c.EnableSignedProviderRecords = true
+		return nil
+	}
+}
+
+// InboundRateLimit limits the rate of the messages every remote peer may send us, regardless of
+// their type. A peer may send burst messages at once and rate messages per second on average.
+// Messages over the limit are rejected by resetting the stream they came in on.
+//
+// Defaults to no limit.
+func InboundRateLimit(rate float64, burst int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.InboundLimits.PerPeer = dhtcfg.RateLimit{Rate: rate, Burst: burst}
+		return nil
+	}
+}
+
+// InboundMessageRateLimit limits the rate of the messages of the given type every remote peer may
+// send us. It applies on top of InboundRateLimit, and can be used multiple times to limit multiple
+// message types, e.g. to keep PUT_VALUE and ADD_PROVIDER requests well below the rate of lookups.
+//
+// Defaults to no limit.
+func InboundMessageRateLimit(t pb.Message_MessageType, rate float64, burst int) Option {
+	return func(c *dhtcfg.Config) error {
+		if c.InboundLimits.PerMessageType == nil {
+			c.InboundLimits.PerMessageType = make(map[pb.Message_MessageType]dhtcfg.RateLimit)
+		}
+		c.InboundLimits.PerMessageType[t] = dhtcfg.RateLimit{Rate: rate, Burst: burst}
+		return nil
+	}
+}
+
+// ProviderQuota limits the number of keys a single remote peer may store provider records for at
+// any point in time. Keys stop counting against the quota once their provider records expire.
+//
+// Defaults to no limit.
+func ProviderQuota(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.InboundLimits.MaxProvidersPerPeer = n
+		return nil
+	}
+}
+
+// ValueQuota limits the number of values a single remote peer may store at any point in time.
+// Values stop counting against the quota after MaxRecordAge.
+//
+// Defaults to no limit.
+func ValueQuota(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.InboundLimits.MaxValuesPerPeer = n
+		return nil
+	}
+}
+
+// WithInterceptors adds interceptors wrapping the handling of every request received from remote
+// peers. The interceptors see the remote peer, the request and the response, and may reject the
+// request by returning an error, rewrite the request or the response, or answer without calling the
+// next handler at all. Interceptors are called in the order they are added, the first one being the
+// outermost. The option can be used multiple times.
+//
+// The interceptors also see the requests of the types the DHT doesn't handle, such as ADD_PROVIDER
+// with providers disabled, which they may answer. Those that reach the end of the chain are
+// rejected.
+func WithInterceptors(is ...Interceptor) Option {
+	return func(c *dhtcfg.Config) error {
+		c.Interceptors = append(c.Interceptors, is...)
+		return nil
+	}
+}
+
+// TraceLookups records every lookup the DHT runs, and writes them to w as newline-delimited JSON
+// LookupTrace objects, one per line. Each path of a disjoint lookup is traced separately. The traces
+// can be read back with ReadLookupTraces, and turned into lookup graphs for visualisation.
+//
+// The DHT doesn't close w.
+func TraceLookups(w io.Writer) Option {
+	return func(c *dhtcfg.Config) error {
+		c.LookupTraceWriter = w
+		return nil
+	}
+}
+
+// AdaptiveRPCTimeouts gives every RPC of a lookup its own timeout, derived from the latency we
+// observed for the queried peer (the peerstore latency EWMA) and from the median duration of the
+// RPCs of the lookup so far, whichever is larger, times the multiplier. The timeout is clamped
+// between min and max, and max is used when nothing is known yet. Dialing the peer, when we aren't
+// connected to it yet, is bounded by max.
+//
+// A peer that doesn't answer in time is marked unreachable for the lookup, and its slot is handed to
+// the next peer, so that slow peers don't hold up the whole lookup. Unlike peers failing to answer,
+// slow peers are kept in the routing table.
+//
+// Defaults to no per-RPC timeout, RPCs are only bounded by the lookup context.
+func AdaptiveRPCTimeouts(multiplier float64, min, max time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		c.RPCTimeouts.Multiplier = multiplier
+		c.RPCTimeouts.Min = min
+		c.RPCTimeouts.Max = max
+		return nil
+	}
+}
+
+// EnableRepublisher enables the record republisher. Every record put through PutValue is persisted
+// in the DHT's datastore, then validated and stored again on the closest peers every
+// RepublishInterval, so that it outlives the peers that stored it first. The outcome of the last
+// republish of every record is available through IpfsDHT.Republisher.
+func EnableRepublisher() Option {
+	return func(c *dhtcfg.Config) error {
+		c.Republisher.Enabled = true
+		return nil
+	}
+}
+
+// RepublishInterval configures how often the republisher stores all of its records again.
+// It should be well below the record expiration interval (MaxRecordAge).
+//
+// The default value is 22 hours.
+func RepublishInterval(interval time.Duration) Option {
+	return func(c *dhtcfg.Config) error {
+		c.Republisher.Interval = interval
+		return nil
+	}
+}
+
+// RepublishConcurrency configures the number of records the republisher stores in parallel.
+//
+// The default value is 8.
+func RepublishConcurrency(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.Republisher.Concurrency = n
+		return nil
+	}
+}
+
+// BulkSendParallelism configures the number of peers ProvideMany and PutMany send messages to in
+// parallel.
+//
+// The default value is 20.
+func BulkSendParallelism(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.BulkSendParallelism = n
+		return nil
+	}
+}
+
+// EnableDoubleHashedProviders enables the privacy preserving content routing mode. On top of the
+// classic provider records, Provide announces provider records encrypted with a key derived from the
+// provided multihash, and stored under its double hash, the hash of the multihash. They can be
+// looked up with FindProvidersPrivate, which only reveals the double hash to the queried peers, who
+// can't tell which content is looked up.
+//
+// Support is advertised to the other peers through a protocol extension, and the classic provider
+// records keep working with all peers. Received encrypted records are kept in a datastore
+// namespace of their own.
+func EnableDoubleHashedProviders() Option {
+	return func(c *dhtcfg.Config) error {
+		c.EnableDoubleHashedProviders = true
+		return nil
+	}
+}
+
+// QueryDiversityLimits limits the number of peers a single lookup considers from the same IP group
+// and from the same ASN. IPv4 addresses are grouped by /16 prefix and IPv6 addresses by /32 prefix,
+// and the ASNs are resolved by the ASNResolver (see DiversityASNResolver). Peers heard of once a group is full are skipped,
+// and reported in the Skipped field of the LookupUpdateEvent. The peers the lookup starts from are
+// taken from the routing table, which has its own diversity filter, and are never skipped.
+//
+// A limit of zero disables it. Both limits are disabled by default.
+func QueryDiversityLimits(maxPerIPGroup, maxPerASN int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.QueryDiversity.MaxPerIPGroup = maxPerIPGroup
+		c.QueryDiversity.MaxPerASN = maxPerASN
+		return nil
+	}
+}
+
+// DiversityASNResolver configures the ASNResolver the diversity filters use to group the peers of
+// the same network. It is used by the query diversity limits (see QueryDiversityLimits) and, when
+// the routing table uses the filter returned by NewRTPeerDiversityFilter, by the routing table too,
+// whose peers are then also limited per ASN, with the same limits as per IP group.
+//
+// Defaults to DefaultASNResolver for the query diversity limits, which only knows the ASNs of IPv6
+// addresses. The routing table only groups peers by ASN of their IPv6 addresses by default. An
+// ASNTable can be loaded from a local file to resolve the ASNs without network access.
+func DiversityASNResolver(r ASNResolver) Option {
+	return func(c *dhtcfg.Config) error {
+		c.ASNResolver = r
+		return nil
+	}
+}
+
+// KeepRecentLookupTraces keeps the traces of the last n lookup paths in memory, as written by
+// TraceLookups, so that they can be inspected with IpfsDHT.RecentLookupTraces or the DebugHandler.
+//
+// Defaults to 0, no traces are kept.
+func KeepRecentLookupTraces(n int) Option {
+	return func(c *dhtcfg.Config) error {
+		c.RecentLookupTraces = n
+		return nil
+	}
+}
+
+// WithCustomMessageSender configures the pb.MessageSender the DHT sends its messages with, instead
+// of sending them over libp2p streams. The builder is called with the host of the DHT and the
+// protocols it queries other peers with, in order of preference, and once more with the protocol of
+// the double hashed provider records if they are enabled. It is meant for in-memory transports such
+// as the one of the simulation package.
+func WithCustomMessageSender(builder func(h host.Host, protos []protocol.ID) pb.MessageSenderWithDisconnect) Option {
+	return func(c *dhtcfg.Config) error {
+		c.MsgSenderBuilder = builder
+		return nil
+	}
+}
+
+// Clock is the time source the lookups time their RPCs with.
+type Clock = dhtcfg.Clock
+
+// WithClock configures the clock the lookups time out their RPCs on and measure their latencies,
+// and their traces, with. It is meant for simulations running the DHT in virtual time, such as the
+// ones of the simulation package.
+//
+// Defaults to the wall clock.
+func WithClock(clock Clock) Option {
+	return func(c *dhtcfg.Config) error {
+		if clock == nil {
+			return fmt.Errorf("clock must not be nil")
+		}
+		c.Clock = clock
+		return nil
+	}
+}
@@ -2465,3 +2465,35 @@ func TestAddrFilter(t *testing.T) {
 	}
 	require.Equal(t, len(publicAddrs)+len(privAddrs), len(d3.host.Peerstore().Addrs(peerid)))
 }
+
+func TestPutValueQuorum(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+	peers := setupDHTS(t, ctx, 2)
+	rejecting := &expiringValidator{}
+	rejecting.expired.Store(true)
+	peers = append(peers, setupDHT(ctx, t, false, NamespacedValidator("v", rejecting)))
+	for _, p := range peers {
+		connect(t, ctx, d, p)
+	}
+
+	res, err := d.PutValueDetailed(ctx, "/v/quorum", []byte("valid"), Quorum(3))
+	require.ErrorIs(t, err, ErrQuorumNotReached)
+	require.NotNil(t, res)
+	require.Equal(t, 2, res.Stored)
+	require.Len(t, res.Peers, len(peers))
+	for _, r := range res.Peers {
+		if r.Peer == peers[2].self {
+			require.Error(t, r.Err)
+		} else {
+			require.NoError(t, r.Err, "put to %s", r.Peer)
+		}
+	}
+
+	require.ErrorIs(t, d.PutValue(ctx, "/v/quorum", []byte("valid"), Quorum(3)), ErrQuorumNotReached)
+	require.NoError(t, d.PutValue(ctx, "/v/quorum", []byte("valid"), Quorum(2)))
+	// without a quorum, the put only fails when the lookup does
+	require.NoError(t, d.PutValue(ctx, "/v/quorum", []byte("valid")))
+}
@@ -0,0 +1,267 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+// doubleHashSuffix is appended to the DHT protocol to form the protocol extension of the double
+// hashed provider records. Unlike the signed provider records extension, it is only used to open the
+// streams of the dedicated message types, which older peers don't understand, and is never
+// negotiated for the other requests.
+const doubleHashSuffix protocol.ID = "/double-hash"
+
+var errDoubleHashNotSupported = errors.New("peer doesn't support double hashed provider records")
+
+// supportsDoubleHash returns true if p is known to support double hashed provider records. Peers
+// whose protocols aren't known yet are assumed not to support them.
+func (dht *IpfsDHT) supportsDoubleHash(p peer.ID) bool {
+	protos, err := dht.peerstore.GetProtocols(p)
+	if err != nil {
+		return false
+	}
+	for _, proto := range protos {
+		if proto == dht.doubleHashProto {
+			return true
+		}
+	}
+	return false
+}
+
+// provideEncrypted announces an encrypted provider record for the given key under its double hash,
+// to the closest peers that support double hashed provider records.
+func (dht *IpfsDHT) provideEncrypted(ctx context.Context, keyMH multihash.Multihash) error {
+	dh := providers.DoubleHash(keyMH)
+	rec, err := dht.selfEncryptedProviderRecord(ctx, keyMH)
+	if err != nil {
+		return err
+	}
+
+	peers, err := dht.GetClosestPeers(ctx, string(dh))
+	if err != nil {
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	for _, p := range peers {
+		if !dht.supportsDoubleHash(p) {
+			continue
+		}
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if err := dht.doubleHashMessenger.PutEncryptedProvider(ctx, p, dh, rec); err != nil {
+				logger.Debugw("failed to put encrypted provider record", "peer", p, "error", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// selfEncryptedProviderRecord returns our encrypted provider record for the given key, signed with
+// our private key, after storing it locally.
+func (dht *IpfsDHT) selfEncryptedProviderRecord(ctx context.Context, keyMH multihash.Multihash) (*pb.EncryptedProviderRecord, error) {
+	sk := dht.peerstore.PrivKey(dht.self)
+	if sk == nil {
+		return nil, fmt.Errorf("no private key to sign the encrypted provider record with")
+	}
+	rec, err := providers.EncryptProviderRecord(keyMH, peer.AddrInfo{
+		ID:    dht.self,
+		Addrs: dht.filterAddrs(dht.host.Addrs()),
+	}, sk)
+	if err != nil {
+		return nil, err
+	}
+	if err := dht.encryptedProviders.AddEncryptedProvider(ctx, providers.DoubleHash(keyMH), dht.self, rec); err != nil {
+		logger.Debugw("failed to store own encrypted provider record", "mh", internal.LoggableProviderRecordBytes(keyMH), "error", err)
+	}
+	return rec, nil
+}
+
+// provideManyEncrypted announces the encrypted provider records of the given keys the same way
+// ProvideMany announces the classic ones. The peers that don't support double hashed provider
+// records are skipped, and failures are only logged, like provideEncrypted does.
+func (dht *IpfsDHT) provideManyEncrypted(ctx context.Context, keys []string) {
+	recs := make(map[string]*pb.EncryptedProviderRecord, len(keys))
+	dhKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		rec, err := dht.selfEncryptedProviderRecord(ctx, multihash.Multihash(k))
+		if err != nil {
+			logger.Warnw("failed to create encrypted provider record", "mh", internal.LoggableProviderRecordBytes(k), "error", err)
+			continue
+		}
+		dh := string(providers.DoubleHash(multihash.Multihash(k)))
+		recs[dh] = rec
+		dhKeys = append(dhKeys, dh)
+	}
+
+	// the outcome of the double hashed keys isn't reported to the caller of ProvideMany.
+	rep := &bulkReporter{}
+	dht.bulkSend(ctx, dhKeys, rep, func(ctx context.Context, p peer.ID, dh string) error {
+		if !dht.supportsDoubleHash(p) {
+			return errDoubleHashNotSupported
+		}
+		return dht.doubleHashMessenger.PutEncryptedProvider(ctx, p, multihash.Multihash(dh), recs[dh])
+	})
+	if err := rep.err(); err != nil {
+		logger.Debugw("failed to announce encrypted provider records", "error", err)
+	}
+}
+
+// FindProvidersPrivate is like FindProviders, but only looks up the double hash of the key, so
+// that the peers on the lookup path never learn which key is looked up. Only the providers that
+// announced the key with double hashed provider records enabled are found.
+func (dht *IpfsDHT) FindProvidersPrivate(ctx context.Context, c cid.Cid) ([]peer.AddrInfo, error) {
+	if !dht.enableProviders || dht.encryptedProviders == nil {
+		return nil, routing.ErrNotSupported
+	} else if !c.Defined() {
+		return nil, fmt.Errorf("invalid cid: undefined")
+	}
+
+	var provs []peer.AddrInfo
+	for p := range dht.FindProvidersPrivateAsync(ctx, c, dht.bucketSize) {
+		provs = append(provs, p)
+	}
+	return provs, nil
+}
+
+// FindProvidersPrivateAsync is the same thing as FindProvidersPrivate, but returns a channel, like
+// FindProvidersAsync does.
+func (dht *IpfsDHT) FindProvidersPrivateAsync(ctx context.Context, key cid.Cid, count int) <-chan peer.AddrInfo {
+	peerOut := make(chan peer.AddrInfo)
+	if !dht.enableProviders || dht.encryptedProviders == nil || !key.Defined() {
+		close(peerOut)
+		return peerOut
+	}
+
+	go dht.findProvidersPrivateRoutine(ctx, key.Hash(), count, peerOut)
+	return peerOut
+}
+
+func (dht *IpfsDHT) findProvidersPrivateRoutine(ctx context.Context, keyMH multihash.Multihash, count int, peerOut chan peer.AddrInfo) {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.FindProvidersPrivateRoutine")
+	defer span.End()
+
+	defer close(peerOut)
+
+	findAll := count == 0
+	dh := providers.DoubleHash(keyMH)
+
+	ps := make(map[peer.ID]struct{})
+	var psLock sync.Mutex
+	psTryAdd := func(p peer.ID) bool {
+		psLock.Lock()
+		defer psLock.Unlock()
+		if _, ok := ps[p]; ok || (!findAll && len(ps) >= count) {
+			return false
+		}
+		ps[p] = struct{}{}
+		return true
+	}
+	psSize := func() int {
+		psLock.Lock()
+		defer psLock.Unlock()
+		return len(ps)
+	}
+
+	// returns false if the lookup should stop.
+	consume := func(from peer.ID, recs []*pb.EncryptedProviderRecord) bool {
+		for _, rec := range recs {
+			prov, err := providers.DecryptProviderRecord(keyMH, rec)
+			if err != nil {
+				logger.Debugw("dropping undecryptable provider record", "from", from, "error", err)
+				continue
+			}
+			if prov.ID != dht.self {
+				dht.maybeAddAddrs(prov.ID, prov.Addrs, peerstore.TempAddrTTL)
+			}
+			if psTryAdd(prov.ID) {
+				select {
+				case peerOut <- prov:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			if !findAll && psSize() >= count {
+				return false
+			}
+		}
+		return true
+	}
+
+	recs, err := dht.encryptedProviders.GetEncryptedProviders(ctx, dh)
+	if err != nil {
+		return
+	}
+	if !consume(dht.self, recs) {
+		return
+	}
+
+	_, _ = dht.runLookupWithFollowup(ctx, string(dh),
+		func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
+			routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+				Type: routing.SendingQuery,
+				ID:   p,
+			})
+
+			// peers that don't support double hashed provider records still route the lookup.
+			if !dht.supportsDoubleHash(p) {
+				closest, err := dht.protoMessenger.GetClosestPeers(ctx, p, peer.ID(dh))
+				if err != nil {
+					return nil, err
+				}
+				routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+					Type:      routing.PeerResponse,
+					ID:        p,
+					Responses: closest,
+				})
+				return closest, nil
+			}
+
+			recs, closest, err := dht.doubleHashMessenger.GetEncryptedProviders(ctx, p, dh)
+			if err != nil {
+				return nil, err
+			}
+			if !consume(p, recs) {
+				return nil, ctx.Err()
+			}
+
+			routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+				Type:      routing.PeerResponse,
+				ID:        p,
+				Responses: closest,
+			})
+			return closest, nil
+		},
+		func(*qpeerset.QueryPeerset) bool {
+			return !findAll && psSize() >= count
+		},
+	)
+}
+
+// checkDoubleHashKey checks that the key of a double hashed provider request is a SHA2-256 multihash.
+func checkDoubleHashKey(key []byte) error {
+	dh, err := multihash.Decode(key)
+	if err != nil {
+		return fmt.Errorf("invalid double hashed key: %w", err)
+	}
+	if dh.Code != multihash.SHA2_256 {
+		return fmt.Errorf("double hashed key must be a sha2-256 multihash")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+func TestDoubleHashedProviders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// records the keys of all the requests received by the peers on the lookup path.
+	var mu sync.Mutex
+	var seen [][]byte
+	record := func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error) {
+		mu.Lock()
+		seen = append(seen, req.GetKey())
+		mu.Unlock()
+		return next(ctx, p, req)
+	}
+
+	provider := setupDHT(ctx, t, false, EnableDoubleHashedProviders())
+	reader := setupDHT(ctx, t, false, EnableDoubleHashedProviders())
+	dhts := setupDHTS(t, ctx, 3, EnableDoubleHashedProviders(), WithInterceptors(record))
+	// a peer that doesn't support double hashed provider records still routes the lookups
+	classic := setupDHT(ctx, t, false, WithInterceptors(record))
+
+	connect(t, ctx, provider, dhts[0])
+	connect(t, ctx, dhts[0], dhts[1])
+	connect(t, ctx, dhts[1], dhts[2])
+	connect(t, ctx, dhts[2], classic)
+	connect(t, ctx, classic, reader)
+
+	c := testCaseCids[0]
+	require.NoError(t, provider.Provide(ctx, c, true))
+
+	// the classic provider records keep working
+	provs, err := reader.FindProviders(ctx, c)
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+
+	mu.Lock()
+	seen = nil
+	mu.Unlock()
+
+	provs, err = reader.FindProvidersPrivate(ctx, c)
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+	require.Equal(t, provider.self, provs[0].ID)
+	require.NotEmpty(t, provs[0].Addrs)
+
+	mu.Lock()
+	require.Contains(t, seen, []byte(providers.DoubleHash(c.Hash())))
+	for _, k := range seen {
+		require.False(t, bytes.Equal(k, c.Hash()), "a peer saw the looked up key")
+	}
+	mu.Unlock()
+
+	// lookups of other keys find nothing
+	provs, err = reader.FindProvidersPrivate(ctx, testCaseCids[1])
+	require.NoError(t, err)
+	require.Empty(t, provs)
+
+	// the peers that don't support them never receive the encrypted provider messages
+	require.NotEmpty(t, reader.routingTable.Find(classic.self))
+	require.False(t, reader.supportsDoubleHash(classic.self))
+	require.False(t, reader.supportsDoubleHash(peer.ID("unknown")), "unknown peers are assumed not to support them")
+}
+
+func TestDoubleHashedProvideMany(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider := setupDHT(ctx, t, false, EnableDoubleHashedProviders())
+	reader := setupDHT(ctx, t, false, EnableDoubleHashedProviders())
+	dhts := setupDHTS(t, ctx, 3, EnableDoubleHashedProviders())
+	for _, d := range dhts {
+		connect(t, ctx, provider, d)
+		connect(t, ctx, d, reader)
+	}
+
+	keys := make([]multihash.Multihash, 0, 5)
+	for _, c := range testCaseCids[:5] {
+		keys = append(keys, c.Hash())
+	}
+	require.NoError(t, provider.ProvideMany(ctx, keys))
+
+	for _, c := range testCaseCids[:5] {
+		// the records are sent without waiting for the peers to store them
+		require.Eventually(t, func() bool {
+			provs, err := reader.FindProvidersPrivate(ctx, c)
+			return err == nil && len(provs) == 1 && provs[0].ID == provider.self
+		}, 5*time.Second, 10*time.Millisecond, "the encrypted provider record of %s wasn't announced", c)
+	}
+}
+
+func TestDoubleHashedProvidersDisabled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+	_, err := d.handlerForMsgType(pb.Message_GET_ENCRYPTED_PROVIDERS)(ctx, d.self, pb.NewMessage(pb.Message_GET_ENCRYPTED_PROVIDERS, nil, 0))
+	require.Error(t, err)
+	_, err = d.FindProvidersPrivate(ctx, testCaseCids[0])
+	require.Error(t, err)
+}
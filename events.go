@@ -3,6 +3,7 @@ package dht
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"sync"
 
 	"github.com/google/uuid"
@@ -127,6 +128,9 @@ type LookupUpdateEvent struct {
 	Queried []*PeerKadID
 	// Unreachable is a set of peers whose state in the lookup's peerset is being set to "unreachable".
 	Unreachable []*PeerKadID
+	// Skipped is a set of peers that were heard of, but not added to the lookup's peerset because
+	// they would have exceeded the lookup's diversity limits (see QueryDiversityLimits).
+	Skipped []*PeerKadID
 }
 
 // LookupTerminateEvent describes a lookup termination event.
@@ -148,6 +152,21 @@ func (r LookupTerminationReason) MarshalJSON() ([]byte, error) {
 	return json.Marshal(r.String())
 }
 
+// UnmarshalJSON decodes a lookup termination reason encoded by MarshalJSON.
+func (r *LookupTerminationReason) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	for _, reason := range []LookupTerminationReason{LookupStopped, LookupCancelled, LookupStarvation, LookupCompleted} {
+		if reason.String() == s {
+			*r = reason
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown lookup termination reason %q", s)
+}
+
 func (r LookupTerminationReason) String() string {
 	switch r {
 	case LookupStopped:
@@ -0,0 +1,125 @@
+package fullrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// errNoBulkPeers is the error of the keys of a bulk operation which weren't sent to any peer.
+var errNoBulkPeers = errors.New("no peers to send the key to")
+
+// KeyResult is the outcome of sending a single key of a bulk operation.
+type KeyResult struct {
+	// Key is the key sent: the multihash of ProvideMany, or the record key of PutMany.
+	Key string
+	// Attempted lists the peers the key was sent to, or which couldn't be dialed to send it.
+	Attempted []peer.ID
+	// Successes is the number of peers which accepted the key.
+	Successes int
+	// Errors holds why the key couldn't be sent to the peers that failed.
+	Errors map[peer.ID]error
+
+	// accepted lists the peers which accepted the key, so that they are only counted once across
+	// retries.
+	accepted []peer.ID
+}
+
+// BulkResult is the outcome of ProvideManyWithResult and PutManyWithResult, key by key.
+type BulkResult struct {
+	// Keys holds the result of every unique key, in the order they were given.
+	Keys []*KeyResult
+	// Threshold is the number of peers a key has to reach to be considered sent.
+	Threshold int
+
+	// op is the operation the result comes from, so that it can be retried.
+	op bulkOp
+}
+
+// bulkOp is the message sent to every peer by a bulk operation.
+type bulkOp struct {
+	fn        func(ctx context.Context, target, k peer.ID) error
+	isProvRec bool
+}
+
+// Failed returns the results of the keys which reached fewer peers than the threshold.
+func (r *BulkResult) Failed() []*KeyResult {
+	var failed []*KeyResult
+	for _, kr := range r.Keys {
+		if kr.Successes < r.Threshold {
+			failed = append(failed, kr)
+		}
+	}
+	return failed
+}
+
+// RetryBulk sends the keys of a bulk operation which reached fewer peers than the threshold again,
+// with the same message. It returns the result of all the keys of the operation: the peers which
+// accepted a retried key on either attempt count as successes. The keys which reached the threshold
+// are left untouched, and the previous result isn't modified.
+func (dht *FullRT) RetryBulk(ctx context.Context, res *BulkResult) (*BulkResult, error) {
+	if res.op.fn == nil {
+		return nil, fmt.Errorf("bulk result is not retriable")
+	}
+	failed := res.Failed()
+	if len(failed) == 0 {
+		return res, nil
+	}
+
+	keys := make([]peer.ID, 0, len(failed))
+	for _, kr := range failed {
+		keys = append(keys, peer.ID(kr.Key))
+	}
+	retried, err := dht.bulkMessageSend(ctx, keys, res.op.fn, res.op.isProvRec)
+
+	byKey := make(map[string]*KeyResult, len(retried.Keys))
+	for _, kr := range retried.Keys {
+		byKey[kr.Key] = kr
+	}
+	merged := &BulkResult{
+		Keys:      make([]*KeyResult, 0, len(res.Keys)),
+		Threshold: res.Threshold,
+		op:        res.op,
+	}
+	for _, kr := range res.Keys {
+		if r, ok := byKey[kr.Key]; ok {
+			kr = mergeKeyResults(kr, r)
+		}
+		merged.Keys = append(merged.Keys, kr)
+	}
+	return merged, err
+}
+
+// mergeKeyResults adds up two attempts at sending a key. A peer which accepted the key on either
+// attempt counts as a success, the errors of the latest attempt win for the others.
+func mergeKeyResults(prev, cur *KeyResult) *KeyResult {
+	kr := &KeyResult{
+		Key:    prev.Key,
+		Errors: make(map[peer.ID]error, len(prev.Errors)+len(cur.Errors)),
+	}
+	attempted := make(map[peer.ID]bool, len(prev.Attempted)+len(cur.Attempted))
+	for _, p := range append(append([]peer.ID{}, prev.Attempted...), cur.Attempted...) {
+		if !attempted[p] {
+			attempted[p] = true
+			kr.Attempted = append(kr.Attempted, p)
+		}
+	}
+	accepted := make(map[peer.ID]bool, len(prev.accepted)+len(cur.accepted))
+	for _, p := range append(append([]peer.ID{}, prev.accepted...), cur.accepted...) {
+		if !accepted[p] {
+			accepted[p] = true
+			kr.accepted = append(kr.accepted, p)
+		}
+	}
+	kr.Successes = len(kr.accepted)
+	for _, errs := range []map[peer.ID]error{prev.Errors, cur.Errors} {
+		for p, err := range errs {
+			if !accepted[p] {
+				kr.Errors[p] = err
+			}
+		}
+	}
+	return kr
+}
@@ -0,0 +1,121 @@
+package fullrt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	dhttest "github.com/libp2p/go-libp2p-kad-dht/internal/testing"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+func TestPutManyWithResult(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mn, err := mocknet.FullMeshLinked(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	// the servers reject the flaky key until told otherwise.
+	var flaky atomic.Bool
+	flaky.Store(true)
+	reject := func(ctx context.Context, p peer.ID, req *pb.Message, next kaddht.Handler) (*pb.Message, error) {
+		if req.GetType() == pb.Message_PUT_VALUE && string(req.GetKey()) == "/v/flaky" && flaky.Load() {
+			return nil, errors.New("rejected")
+		}
+		return next(ctx, p, req)
+	}
+	peerAddrs := make(map[peer.ID][]multiaddr.Multiaddr)
+	for _, h := range hosts[1:] {
+		d, err := kaddht.New(ctx, h,
+			kaddht.ProtocolPrefix("/test"),
+			kaddht.Mode(kaddht.ModeServer),
+			kaddht.DisableAutoRefresh(),
+			kaddht.NamespacedValidator("v", dhttest.TestValidator{}),
+			kaddht.WithInterceptors(reject),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		peerAddrs[h.ID()] = h.Addrs()
+	}
+
+	rt, err := NewFullRT(hosts[0], "/test", DHTOption(kaddht.BootstrapPeers(), kaddht.BucketSize(20)), WithCrawler(idleCrawler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	rt.setRoutingTable(peerAddrs)
+
+	var mu sync.Mutex
+	progress := make(map[string]kaddht.BulkKeyResult)
+	pctx := kaddht.WithBulkProgress(ctx, func(r kaddht.BulkKeyResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := progress[r.Key]; ok {
+			t.Errorf("key %s reported twice", r.Key)
+		}
+		progress[r.Key] = r
+	})
+
+	keys := []string{"/v/a", "/v/flaky", "/v/b"}
+	res, err := rt.PutManyWithResult(pctx, keys, [][]byte{[]byte("valid"), []byte("valid"), []byte("valid")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Keys) != len(keys) {
+		t.Fatalf("expected %d key results, got %d", len(keys), len(res.Keys))
+	}
+	for i, kr := range res.Keys {
+		if kr.Key != keys[i] {
+			t.Fatalf("expected key %d to be %s, got %s", i, keys[i], kr.Key)
+		}
+		if r, ok := progress[kr.Key]; !ok || r.Peers != kr.Successes {
+			t.Fatalf("expected the progress of %s to match its result, got %v", kr.Key, r)
+		}
+	}
+	failed := res.Failed()
+	if len(failed) != 1 || failed[0].Key != "/v/flaky" {
+		t.Fatalf("expected only the flaky key to fail, got %v", failed)
+	}
+	if failed[0].Successes != 0 || len(failed[0].Attempted) != len(peerAddrs) || len(failed[0].Errors) != len(peerAddrs) {
+		t.Fatalf("expected the flaky key to fail on all the %d peers, got %+v", len(peerAddrs), failed[0])
+	}
+	if progress["/v/flaky"].Err == nil {
+		t.Fatal("expected the progress of the flaky key to report an error")
+	}
+
+	// only the flaky key is sent again.
+	flaky.Store(false)
+	progress = make(map[string]kaddht.BulkKeyResult)
+	retried, err := rt.RetryBulk(pctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 1 {
+		t.Fatalf("expected a single key to be retried, got %v", progress)
+	}
+	if failed := retried.Failed(); len(failed) != 0 {
+		t.Fatalf("expected no key to fail after the retry, got %v", failed)
+	}
+	kr := retried.Keys[1]
+	if kr.Key != "/v/flaky" || len(kr.Errors) != 0 || len(kr.Attempted) != len(peerAddrs) {
+		t.Fatalf("expected the flaky key to succeed on retry, got %+v", kr)
+	}
+	if res.Keys[0] != retried.Keys[0] || len(res.Failed()) != 1 {
+		t.Fatal("expected the keys which succeeded and the previous result to be left untouched")
+	}
+}
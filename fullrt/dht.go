@@ -71,8 +71,15 @@ type FullRT struct {
 
 	crawlerInterval time.Duration
 	lastCrawlTime   time.Time
-
-	crawler        crawler.Crawler
+	// staleReady is true while the routing table comes from a snapshot, until the first crawl
+	// completes.
+	staleReady     bool
+	snapshotMaxAge time.Duration
+
+	crawler crawler.Crawler
+	// server answers the requests of the other peers from our routing table, nil if not in server
+	// mode.
+	server         *kaddht.IpfsDHT
 	protoMessenger *dht_pb.ProtocolMessenger
 	messageSender  dht_pb.MessageSender
 
@@ -134,7 +141,7 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 		return nil, err
 	}
 
-	if err := dhtcfg.Validate(); err != nil {
+	if err := dhtcfg.ValidateProtocol(); err != nil {
 		return nil, err
 	}
 
@@ -145,10 +152,19 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 	}
 
 	if fullrtcfg.crawler == nil {
-		fullrtcfg.crawler, err = crawler.NewDefaultCrawler(h, crawler.WithParallelism(200))
+		crawlerOpts := []crawler.Option{crawler.WithParallelism(200)}
+		if fullrtcfg.requeryAfter > 0 {
+			crawlerOpts = append(crawlerOpts,
+				crawler.WithCheckpoints(dhtcfg.Datastore),
+				crawler.WithRequeryAfter(fullrtcfg.requeryAfter),
+			)
+		}
+		fullrtcfg.crawler, err = crawler.NewDefaultCrawler(h, crawlerOpts...)
 		if err != nil {
 			return nil, err
 		}
+	} else if fullrtcfg.requeryAfter > 0 {
+		return nil, fmt.Errorf("incremental crawls require the default crawler")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -194,12 +210,26 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 		timeoutPerOp: fullrtcfg.timeoutPerOp,
 
 		crawlerInterval: fullrtcfg.crawlInterval,
+		snapshotMaxAge:  fullrtcfg.snapshotMaxAge,
 
 		bulkSendParallelism: fullrtcfg.bulkSendParallelism,
 
 		self: self,
 	}
 
+	if fullrtcfg.serverMode {
+		rt.server, err = kaddht.New(ctx, h, rt.serverOptions(protocolPrefix, dhtcfg.Datastore, pm, fullrtcfg.dhtOpts)...)
+		if err != nil {
+			cancel()
+			_ = pm.Close()
+			return nil, fmt.Errorf("failed to start the DHT server: %w", err)
+		}
+	}
+
+	if rt.snapshotMaxAge > 0 {
+		rt.restoreRoutingTable(ctx)
+	}
+
 	rt.wg.Add(1)
 	go rt.runCrawler(ctx)
 
@@ -208,7 +238,6 @@ func NewFullRT(h host.Host, protocolPrefix protocol.ID, options ...Option) (*Ful
 
 type crawlVal struct {
 	addrs []multiaddr.Multiaddr
-	key   kadkey.Key
 }
 
 func (dht *FullRT) TriggerRefresh(ctx context.Context) error {
@@ -233,12 +262,15 @@ func (dht *FullRT) Stat() map[string]peer.ID {
 	return newMap
 }
 
+// Ready returns true if the routing table is fresh enough to serve requests, or was restored from a
+// snapshot and the first crawl didn't complete yet, see Stale.
 func (dht *FullRT) Ready() bool {
 	dht.rtLk.RLock()
 	lastCrawlTime := dht.lastCrawlTime
+	staleReady := dht.staleReady
 	dht.rtLk.RUnlock()
 
-	if time.Since(lastCrawlTime) > dht.crawlerInterval {
+	if !staleReady && time.Since(lastCrawlTime) > dht.crawlerInterval {
 		return false
 	}
 
@@ -251,6 +283,14 @@ func (dht *FullRT) Ready() bool {
 	return rtSize > len(dht.bootstrapPeers)+1
 }
 
+// Stale returns true while the routing table is the one restored from the snapshot of a previous
+// run, until the first crawl completes.
+func (dht *FullRT) Stale() bool {
+	dht.rtLk.RLock()
+	defer dht.rtLk.RUnlock()
+	return dht.staleReady
+}
+
 func (dht *FullRT) Host() host.Host {
 	return dht.h
 }
@@ -262,6 +302,13 @@ func (dht *FullRT) runCrawler(ctx context.Context) {
 	m := make(map[peer.ID]*crawlVal)
 	mxLk := sync.Mutex{}
 
+	// the first crawl starts from the peers of the restored snapshot, if any.
+	dht.peerAddrsLk.RLock()
+	for p, addrs := range dht.peerAddrs {
+		m[p] = &crawlVal{addrs: addrs}
+	}
+	dht.peerAddrsLk.RUnlock()
+
 	initialTrigger := make(chan struct{}, 1)
 	initialTrigger <- struct{}{}
 
@@ -331,34 +378,60 @@ func (dht *FullRT) runCrawler(ctx context.Context) {
 		dur := time.Since(start)
 		logger.Infof("crawl took %v", dur)
 
-		peerAddrs := make(map[peer.ID][]multiaddr.Multiaddr)
-		kPeerMap := make(map[string]peer.ID)
-		newRt := trie.New()
+		if ctx.Err() != nil {
+			// the crawl was interrupted, keep the routing table we have.
+			return
+		}
+
+		peerAddrs := make(map[peer.ID][]multiaddr.Multiaddr, len(m))
 		for k, v := range m {
-			v.key = kadkey.KbucketIDToKey(kb.ConvertPeerID(k))
 			peerAddrs[k] = v.addrs
-			kPeerMap[string(v.key)] = k
-			newRt.Add(v.key)
 		}
-
-		dht.peerAddrsLk.Lock()
-		dht.peerAddrs = peerAddrs
-		dht.peerAddrsLk.Unlock()
-
-		dht.kMapLk.Lock()
-		dht.keyToPeerMap = kPeerMap
-		dht.kMapLk.Unlock()
+		dht.setRoutingTable(peerAddrs)
 
 		dht.rtLk.Lock()
-		dht.rt = newRt
 		dht.lastCrawlTime = time.Now()
+		dht.staleReady = false
 		dht.rtLk.Unlock()
+
+		if dht.snapshotMaxAge > 0 {
+			if err := dht.saveRoutingTableSnapshot(ctx); err != nil {
+				logger.Warnw("failed to persist routing table", "error", err)
+			}
+		}
 	}
 }
 
+// setRoutingTable replaces the routing table with the given peers.
+func (dht *FullRT) setRoutingTable(peerAddrs map[peer.ID][]multiaddr.Multiaddr) {
+	kPeerMap := make(map[string]peer.ID, len(peerAddrs))
+	newRt := trie.New()
+	for p := range peerAddrs {
+		key := kadkey.KbucketIDToKey(kb.ConvertPeerID(p))
+		kPeerMap[string(key)] = p
+		newRt.Add(key)
+	}
+
+	dht.peerAddrsLk.Lock()
+	dht.peerAddrs = peerAddrs
+	dht.peerAddrsLk.Unlock()
+
+	dht.kMapLk.Lock()
+	dht.keyToPeerMap = kPeerMap
+	dht.kMapLk.Unlock()
+
+	dht.rtLk.Lock()
+	dht.rt = newRt
+	dht.rtLk.Unlock()
+}
+
 func (dht *FullRT) Close() error {
 	dht.cancel()
 	dht.wg.Wait()
+	if dht.server != nil {
+		// the server shares the provider manager, which it closes.
+		return dht.server.Close()
+	}
 	return dht.ProviderManager.Close()
 }
 
@@ -436,10 +509,16 @@ func (dht *FullRT) GetClosestPeers(ctx context.Context, key string) ([]peer.ID,
 	_, span := internal.StartSpan(ctx, "FullRT.GetClosestPeers", trace.WithAttributes(internal.KeyAsAttribute("Key", key)))
 	defer span.End()
 
+	return dht.closestPeers(key, dht.bucketSize), nil
+}
+
+// closestPeers returns the count peers of the routing table closest to the key, and adds their
+// addresses to the peerstore for a short while so that they can be dialed or sent.
+func (dht *FullRT) closestPeers(key string, count int) []peer.ID {
 	kbID := kb.ConvertKey(key)
 	kadKey := kadkey.KbucketIDToKey(kbID)
 	dht.rtLk.RLock()
-	closestKeys := kademlia.ClosestN(kadKey, dht.rt, dht.bucketSize)
+	closestKeys := kademlia.ClosestN(kadKey, dht.rt, count)
 	dht.rtLk.RUnlock()
 
 	peers := make([]peer.ID, 0, len(closestKeys))
@@ -457,7 +536,7 @@ func (dht *FullRT) GetClosestPeers(ctx context.Context, key string) ([]peer.ID,
 		dht.h.Peerstore().AddAddrs(p, peerAddrs, peerstore.TempAddrTTL)
 		peers = append(peers, p)
 	}
-	return peers, nil
+	return peers
 }
 
 // PutValue adds value corresponding to given Key.
@@ -940,12 +1019,23 @@ func (dht *FullRT) execOnMany(ctx context.Context, fn func(context.Context, peer
 	return numSuccess
 }
 
+// ProvideMany announces that we provide the given keys to the peers closest to each of them. It only
+// fails if none of the keys could be provided, see ProvideManyWithResult to tell which keys failed.
 func (dht *FullRT) ProvideMany(ctx context.Context, keys []multihash.Multihash) (err error) {
+	_, err = dht.ProvideManyWithResult(ctx, keys)
+	return err
+}
+
+// ProvideManyWithResult is ProvideMany, returning the result of every key. The result is returned
+// along with the error when the announcements were sent, so that the keys which failed can be
+// retried with RetryBulk. The outcome of every key can be followed while the announcements are
+// sent with kaddht.WithBulkProgress.
+func (dht *FullRT) ProvideManyWithResult(ctx context.Context, keys []multihash.Multihash) (_ *BulkResult, err error) {
 	ctx, end := tracer.ProvideMany(dhtName, ctx, keys)
 	defer func() { end(err) }()
 
 	if !dht.enableProviders {
-		return routing.ErrNotSupported
+		return nil, routing.ErrNotSupported
 	}
 
 	// Compute addresses once for all provides
@@ -958,7 +1048,7 @@ func (dht *FullRT) ProvideMany(ctx context.Context, keys []multihash.Multihash)
 	// TODO: We may want to limit the type of addresses in our provider records
 	// For example, in a WAN-only DHT prohibit sharing non-WAN addresses (e.g. 192.168.0.100)
 	if len(pi.Addrs) < 1 {
-		return fmt.Errorf("no known addresses for self, cannot put provider")
+		return nil, fmt.Errorf("no known addresses for self, cannot put provider")
 	}
 
 	fn := func(ctx context.Context, p, k peer.ID) error {
@@ -976,16 +1066,27 @@ func (dht *FullRT) ProvideMany(ctx context.Context, keys []multihash.Multihash)
 	return dht.bulkMessageSend(ctx, keysAsPeerIDs, fn, true)
 }
 
+// PutMany stores the given records on the peers closest to each of their keys. It only fails if
+// none of the records could be stored, see PutManyWithResult to tell which records failed.
 func (dht *FullRT) PutMany(ctx context.Context, keys []string, values [][]byte) error {
+	_, err := dht.PutManyWithResult(ctx, keys, values)
+	return err
+}
+
+// PutManyWithResult is PutMany, returning the result of every key. The result is returned along
+// with the error when the records were sent, so that the keys which failed can be retried with
+// RetryBulk. The outcome of every key can be followed while the records are sent with
+// kaddht.WithBulkProgress.
+func (dht *FullRT) PutManyWithResult(ctx context.Context, keys []string, values [][]byte) (*BulkResult, error) {
 	ctx, span := internal.StartSpan(ctx, "FullRT.PutMany", trace.WithAttributes(attribute.Int("NumKeys", len(keys))))
 	defer span.End()
 
 	if !dht.enableValues {
-		return routing.ErrNotSupported
+		return nil, routing.ErrNotSupported
 	}
 
 	if len(keys) != len(values) {
-		return fmt.Errorf("number of keys does not match the number of values")
+		return nil, fmt.Errorf("number of keys does not match the number of values")
 	}
 
 	keysAsPeerIDs := make([]peer.ID, 0, len(keys))
@@ -996,7 +1097,7 @@ func (dht *FullRT) PutMany(ctx context.Context, keys []string, values [][]byte)
 	}
 
 	if len(keys) != len(keyRecMap) {
-		return fmt.Errorf("does not support duplicate keys")
+		return nil, fmt.Errorf("does not support duplicate keys")
 	}
 
 	fn := func(ctx context.Context, p, k peer.ID) error {
@@ -1007,30 +1108,102 @@ func (dht *FullRT) PutMany(ctx context.Context, keys []string, values [][]byte)
 	return dht.bulkMessageSend(ctx, keysAsPeerIDs, fn, false)
 }
 
-func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(ctx context.Context, target, k peer.ID) error, isProvRec bool) error {
+// bulkMessageSend sends every key to its closest peers with fn. The result is always returned, even
+// along with an error.
+func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(ctx context.Context, target, k peer.ID) error, isProvRec bool) (*BulkResult, error) {
 	ctx, span := internal.StartSpan(ctx, "FullRT.BulkMessageSend")
 	defer span.End()
 
+	numSuccessfulToWaitFor := int(float64(dht.bucketSize) * dht.waitFrac * 1.2)
+	res := &BulkResult{
+		Threshold: numSuccessfulToWaitFor,
+		op:        bulkOp{fn: fn, isProvRec: isProvRec},
+	}
+
 	if len(keys) == 0 {
-		return nil
+		return res, nil
 	}
 
 	type report struct {
 		successes   int
 		failures    int
 		lastSuccess time.Time
-		mx          sync.RWMutex
+		attempted   []peer.ID
+		accepted    []peer.ID
+		errors      map[peer.ID]error
+		lastErr     error
+		// pending is the number of peers the key is yet to be sent to.
+		pending  int
+		reported bool
+		mx       sync.RWMutex
 	}
 
 	keySuccesses := make(map[peer.ID]*report, len(keys))
 	var numSkipped int64
 
+	// the unique keys, in the order they were given.
+	uniqueKeys := make([]peer.ID, 0, len(keys))
 	for _, k := range keys {
-		keySuccesses[k] = &report{}
+		if _, ok := keySuccesses[k]; !ok {
+			keySuccesses[k] = &report{}
+			uniqueKeys = append(uniqueKeys, k)
+		}
 	}
 
 	logger.Infof("bulk send: number of keys %d, unique %d", len(keys), len(keySuccesses))
-	numSuccessfulToWaitFor := int(float64(dht.bucketSize) * dht.waitFrac * 1.2)
+
+	progress, _ := ctx.Value(internal.BulkProgressKey{}).(func(kaddht.BulkKeyResult))
+	var progressMx sync.Mutex
+	// keyDone reports the outcome of a key to the progress callback registered with
+	// kaddht.WithBulkProgress, once.
+	keyDone := func(k peer.ID, err error) {
+		keyReport := keySuccesses[k]
+		keyReport.mx.Lock()
+		if keyReport.reported {
+			keyReport.mx.Unlock()
+			return
+		}
+		keyReport.reported = true
+		kr := kaddht.BulkKeyResult{Key: string(k), Peers: keyReport.successes}
+		if kr.Peers == 0 {
+			kr.Err = keyReport.lastErr
+			if kr.Err == nil {
+				kr.Err = err
+			}
+		}
+		keyReport.mx.Unlock()
+
+		if progress != nil {
+			progressMx.Lock()
+			progress(kr)
+			progressMx.Unlock()
+		}
+	}
+	// sent records that a key was handled for one of its peers, and reports it once it was handled
+	// for all of them.
+	sent := func(k peer.ID) {
+		keyReport := keySuccesses[k]
+		keyReport.mx.Lock()
+		keyReport.pending--
+		complete := keyReport.pending == 0
+		keyReport.mx.Unlock()
+		if complete {
+			keyDone(k, nil)
+		}
+	}
+	// failed records the failure to send a key to a peer.
+	failed := func(k, p peer.ID, err error) {
+		keyReport := keySuccesses[k]
+		keyReport.mx.Lock()
+		keyReport.failures++
+		keyReport.attempted = append(keyReport.attempted, p)
+		if keyReport.errors == nil {
+			keyReport.errors = make(map[peer.ID]error)
+		}
+		keyReport.errors[p] = err
+		keyReport.lastErr = err
+		keyReport.mx.Unlock()
+	}
 
 	sortedKeys := make([]peer.ID, 0, len(keySuccesses))
 	for k := range keySuccesses {
@@ -1071,6 +1244,10 @@ func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(
 				if err := dht.h.Connect(dialCtx, peer.AddrInfo{ID: p, Addrs: peerAddrs}); err != nil {
 					dialCancel()
 					atomic.AddInt64(&numSkipped, 1)
+					for _, k := range workKeys {
+						failed(k, p, err)
+						sent(k)
+					}
 					continue
 				}
 				dialCancel()
@@ -1083,6 +1260,7 @@ func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(
 					if keyReport.successes >= numSuccessfulToWaitFor {
 						if time.Since(keyReport.lastSuccess) > time.Millisecond*500 {
 							keyReport.mx.RUnlock()
+							sent(k)
 							continue
 						}
 						queryTimeout = time.Millisecond * 500
@@ -1093,20 +1271,22 @@ func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(
 					if err := fn(fnCtx, p, k); err == nil {
 						keyReport.mx.Lock()
 						keyReport.successes++
+						keyReport.attempted = append(keyReport.attempted, p)
+						keyReport.accepted = append(keyReport.accepted, p)
 						if keyReport.successes >= numSuccessfulToWaitFor {
 							keyReport.lastSuccess = time.Now()
 						}
 						keyReport.mx.Unlock()
 					} else {
-						keyReport.mx.Lock()
-						keyReport.failures++
-						keyReport.mx.Unlock()
+						failed(k, p, err)
 						if ctx.Err() != nil {
 							fnCancel()
+							sent(k)
 							break
 						}
 					}
 					fnCancel()
+					sent(k)
 				}
 
 				dht.h.ConnManager().Unprotect(p, connmgrTag)
@@ -1133,6 +1313,23 @@ func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(
 
 		logger.Debugf("bulk send: %d peers for group size %d", len(keysPerPeer), len(g))
 
+		// count the peers of every key of the group before handing any of them out.
+		for _, workKeys := range keysPerPeer {
+			for _, k := range workKeys {
+				keySuccesses[k].mx.Lock()
+				keySuccesses[k].pending++
+				keySuccesses[k].mx.Unlock()
+			}
+		}
+		for _, k := range g {
+			keySuccesses[k].mx.RLock()
+			noPeers := keySuccesses[k].pending == 0
+			keySuccesses[k].mx.RUnlock()
+			if noPeers {
+				keyDone(k, errNoBulkPeers)
+			}
+		}
+
 	keyloop:
 		for p, workKeys := range keysPerPeer {
 			select {
@@ -1151,6 +1348,15 @@ func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(
 
 	wg.Wait()
 
+	// the keys which weren't sent to all of their peers, because the context was done.
+	for _, k := range uniqueKeys {
+		err := ctx.Err()
+		if err == nil {
+			err = errNoBulkPeers
+		}
+		keyDone(k, err)
+	}
+
 	numSendsSuccessful := 0
 	numFails := 0
 	// generate a histogram of how many successful sends occurred per key
@@ -1167,9 +1373,21 @@ func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(
 		numFails += v.failures
 	}
 
+	res.Keys = make([]*KeyResult, 0, len(uniqueKeys))
+	for _, k := range uniqueKeys {
+		v := keySuccesses[k]
+		res.Keys = append(res.Keys, &KeyResult{
+			Key:       string(k),
+			Attempted: v.attempted,
+			Successes: v.successes,
+			Errors:    v.errors,
+			accepted:  v.accepted,
+		})
+	}
+
 	if numSendsSuccessful == 0 {
 		logger.Infof("bulk send failed")
-		return fmt.Errorf("failed to complete bulk sending")
+		return res, fmt.Errorf("failed to complete bulk sending")
 	}
 
 	logger.Infof("bulk send complete: %d keys, %d unique, %d successful, %d skipped peers, %d fails",
@@ -1177,7 +1395,7 @@ func (dht *FullRT) bulkMessageSend(ctx context.Context, keys []peer.ID, fn func(
 
 	logger.Infof("bulk send summary: successHist %v, failHist %v", successHist, failHist)
 
-	return nil
+	return res, nil
 }
 
 // divideByChunkSize divides the set of keys into groups of (at most) chunkSize. Chunk size must be greater than 0.
@@ -1,10 +1,27 @@
 package fullrt
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p-kad-dht/internal/net"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kb "github.com/libp2p/go-libp2p-kbucket"
 )
 
 func TestDivideByChunkSize(t *testing.T) {
@@ -84,3 +101,223 @@ func TestDivideByChunkSize(t *testing.T) {
 		}
 	})
 }
+
+func TestNewFullRT(t *testing.T) {
+	mn, err := mocknet.WithNPeers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+
+	rt, err := NewFullRT(mn.Hosts()[0], kaddht.DefaultPrefix, DHTOption(kaddht.BootstrapPeers(), kaddht.BucketSize(20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalCrawlOption(t *testing.T) {
+	mn, err := mocknet.WithNPeers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	h := mn.Hosts()[0]
+
+	rt, err := NewFullRT(h, kaddht.DefaultPrefix, DHTOption(kaddht.BootstrapPeers(), kaddht.BucketSize(20)), WithIncrementalCrawl(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := crawler.NewDefaultCrawler(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFullRT(h, kaddht.DefaultPrefix, DHTOption(kaddht.BootstrapPeers(), kaddht.BucketSize(20)), WithCrawler(c), WithIncrementalCrawl(time.Hour)); err == nil {
+		t.Fatal("expected incremental crawls to require the default crawler")
+	}
+}
+
+// idleCrawler never completes a crawl.
+type idleCrawler struct{}
+
+func (idleCrawler) Run(ctx context.Context, _ []*peer.AddrInfo, _ crawler.HandleQueryResult, _ crawler.HandleQueryFail) {
+	<-ctx.Done()
+}
+
+func TestRoutingTablePersistence(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.WithNPeers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	h := mn.Hosts()[0]
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	newFullRT := func() *FullRT {
+		rt, err := NewFullRT(h, kaddht.DefaultPrefix,
+			DHTOption(kaddht.BootstrapPeers(), kaddht.BucketSize(20), kaddht.Datastore(d)),
+			WithCrawler(idleCrawler{}),
+			WithRoutingTablePersistence(time.Hour),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rt
+	}
+
+	rt := newFullRT()
+	if rt.Ready() || rt.Stale() {
+		t.Fatal("expected the dht not to be ready without a snapshot")
+	}
+	peerAddrs := make(map[peer.ID][]multiaddr.Multiaddr)
+	for i := 0; i < 5; i++ {
+		peerAddrs[test.RandPeerIDFatal(t)] = []multiaddr.Multiaddr{multiaddr.StringCast(fmt.Sprintf("/ip4/1.2.3.%d/tcp/4001", i))}
+	}
+	rt.setRoutingTable(peerAddrs)
+	if err := rt.saveRoutingTableSnapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rt = newFullRT()
+	if !rt.Ready() || !rt.Stale() {
+		t.Fatal("expected the dht to be stale-ready with a snapshot")
+	}
+	if len(rt.Stat()) != len(peerAddrs) {
+		t.Fatalf("expected %d peers in the routing table, got %d", len(peerAddrs), len(rt.Stat()))
+	}
+	for p, addrs := range peerAddrs {
+		if got := h.Peerstore().Addrs(p); len(got) != 1 || !got[0].Equal(addrs[0]) {
+			t.Fatalf("expected the addresses of %s to be restored, got %v", p, got)
+		}
+	}
+	if err := rt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// outdated snapshots are ignored.
+	snap, err := rt.loadRoutingTableSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Taken = snap.Taken.Add(-2 * time.Hour)
+	buf, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, ds.NewKey(rtSnapshotKey), buf); err != nil {
+		t.Fatal(err)
+	}
+	rt = newFullRT()
+	defer rt.Close()
+	if rt.Ready() || rt.Stale() {
+		t.Fatal("expected an outdated snapshot to be ignored")
+	}
+}
+
+func TestServerMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	server, client := mn.Hosts()[0], mn.Hosts()[1]
+
+	rt, err := NewFullRT(server, kaddht.DefaultPrefix,
+		DHTOption(kaddht.BootstrapPeers(), kaddht.BucketSize(20)),
+		WithCrawler(idleCrawler{}),
+		WithServerMode(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+
+	// a crawled table much larger than a bucket, with ourselves and the client in it.
+	peerAddrs := map[peer.ID][]multiaddr.Multiaddr{server.ID(): server.Addrs(), client.ID(): client.Addrs()}
+	var peers []peer.ID
+	for i := 0; i < 200; i++ {
+		p := test.RandPeerIDFatal(t)
+		peerAddrs[p] = []multiaddr.Multiaddr{multiaddr.StringCast(fmt.Sprintf("/ip4/1.2.%d.%d/tcp/4001", i/256, i%256))}
+		peers = append(peers, p)
+	}
+	rt.setRoutingTable(peerAddrs)
+
+	ms := net.NewMessageSenderImpl(client, []protocol.ID{kaddht.DefaultPrefix + "/kad/1.0.0"})
+	pm, err := pb.NewProtocolMessenger(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the closer peers are the closest peers of the whole table.
+	target := test.RandPeerIDFatal(t)
+	closer, err := pm.GetClosestPeers(ctx, server.ID(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := kb.SortClosestPeers(peers, kb.ConvertPeerID(target))[:rt.bucketSize]
+	if len(closer) != len(expected) {
+		t.Fatalf("expected %d closer peers, got %d", len(expected), len(closer))
+	}
+	for i, ai := range closer {
+		if ai.ID != expected[i] {
+			t.Fatalf("expected closer peer %d to be %s, got %s", i, expected[i], ai.ID)
+		}
+		if len(ai.Addrs) != 1 || !ai.Addrs[0].Equal(peerAddrs[ai.ID][0]) {
+			t.Fatalf("expected the crawled addresses of %s, got %v", ai.ID, ai.Addrs)
+		}
+	}
+
+	// the requester is not sent back itself, even when it is the closest peer.
+	closer, err = pm.GetClosestPeers(ctx, server.ID(), client.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = kb.SortClosestPeers(peers, kb.ConvertPeerID(client.ID()))[:rt.bucketSize]
+	if len(closer) != len(expected) {
+		t.Fatalf("expected %d closer peers, got %d", len(expected), len(closer))
+	}
+	for i, ai := range closer {
+		if ai.ID != expected[i] {
+			t.Fatalf("expected closer peer %d to be %s, got %s", i, expected[i], ai.ID)
+		}
+	}
+
+	// the providers are stored in the provider manager of the FullRT.
+	mh, err := multihash.Sum([]byte("hello"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.PutProvider(ctx, server.ID(), mh, client); err != nil {
+		t.Fatal(err)
+	}
+	provs, closer, err := pm.GetProviders(ctx, server.ID(), mh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 1 || provs[0].ID != client.ID() {
+		t.Fatalf("expected the client to be the provider, got %v", provs)
+	}
+	if len(closer) != rt.bucketSize {
+		t.Fatalf("expected %d closer peers, got %d", rt.bucketSize, len(closer))
+	}
+	local, err := rt.ProviderManager.GetProviders(ctx, mh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) != 1 || local[0].ID != client.ID() {
+		t.Fatalf("expected the provider to be stored locally, got %v", local)
+	}
+}
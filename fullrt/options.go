@@ -17,6 +17,9 @@ type config struct {
 	bulkSendParallelism int
 	timeoutPerOp        time.Duration
 	crawler             crawler.Crawler
+	requeryAfter        time.Duration
+	snapshotMaxAge      time.Duration
+	serverMode          bool
 	pmOpts              []providers.Option
 }
 
@@ -56,6 +59,51 @@ func WithCrawlInterval(i time.Duration) Option {
 	}
 }
 
+// WithIncrementalCrawl makes the crawls only query the peers that were last queried longer ago than
+// the given age, the routing tables of the others are reused from the previous crawls. The progress
+// of the crawls is checkpointed to the datastore of the DHT, so that a crawl interrupted by a
+// restart resumes where it stopped when the datastore is persistent. It can't be used along with
+// WithCrawler.
+// Defaults to 0 if unspecified, every crawl queries all the peers.
+func WithIncrementalCrawl(requeryAfter time.Duration) Option {
+	return func(opt *config) error {
+		if requeryAfter < 0 {
+			return fmt.Errorf("requery age must be positive; got: %s", requeryAfter)
+		}
+		opt.requeryAfter = requeryAfter
+		return nil
+	}
+}
+
+// WithRoutingTablePersistence persists the routing table to the datastore of the DHT after every
+// crawl. On start, the routing table of the last snapshot is served right away, as long as the
+// snapshot isn't older than maxAge, and the DHT is "stale-ready" until the first crawl completes, see
+// FullRT.Stale. Use a persistent datastore for the routing table to survive restarts.
+// Defaults to disabled if unspecified.
+func WithRoutingTablePersistence(maxAge time.Duration) Option {
+	return func(opt *config) error {
+		if maxAge <= 0 {
+			return fmt.Errorf("routing table snapshot max age must be positive; got: %s", maxAge)
+		}
+		opt.snapshotMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithServerMode makes the DHT answer the requests of the other peers, like an IpfsDHT in server
+// mode: FIND_NODE, GET_VALUE, PUT_VALUE, GET_PROVIDERS, ADD_PROVIDER and PING. The closer peers of
+// the responses come from the crawled routing table, so they are the closest peers of the network
+// rather than those of a Kademlia routing table. The records and the providers are kept in the
+// datastore and the provider manager of the DHT, and the DHT options given with DHTOption, such as
+// the validator or the interceptors, apply to the requests.
+// Defaults to disabled if unspecified.
+func WithServerMode() Option {
+	return func(opt *config) error {
+		opt.serverMode = true
+		return nil
+	}
+}
+
 // WithSuccessWaitFraction sets the fraction of peers to wait for before considering an operation a success defined as a number between (0, 1].
 // Defaults to 30% if unspecified.
 func WithSuccessWaitFraction(f float64) Option {
@@ -0,0 +1,123 @@
+package fullrt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// rtSnapshotKey is the datastore key holding the routing table of the latest crawl.
+	rtSnapshotKey = "/fullrt/routing-table"
+	// rtSnapshotVersion is bumped whenever the snapshot format changes in an incompatible way.
+	rtSnapshotVersion = 1
+)
+
+// rtSnapshot is the persisted form of the routing table.
+type rtSnapshot struct {
+	Version int
+	Taken   time.Time
+	Peers   []rtSnapshotPeer
+}
+
+// rtSnapshotPeer is the persisted form of a single peer of the routing table.
+type rtSnapshotPeer struct {
+	ID    peer.ID
+	Addrs [][]byte
+}
+
+// saveRoutingTableSnapshot writes the routing table, along with the addresses of its peers, to the
+// datastore.
+func (dht *FullRT) saveRoutingTableSnapshot(ctx context.Context) error {
+	dht.peerAddrsLk.RLock()
+	snap := &rtSnapshot{
+		Version: rtSnapshotVersion,
+		Taken:   time.Now(),
+		Peers:   make([]rtSnapshotPeer, 0, len(dht.peerAddrs)),
+	}
+	for p, addrs := range dht.peerAddrs {
+		sp := rtSnapshotPeer{ID: p, Addrs: make([][]byte, 0, len(addrs))}
+		for _, a := range addrs {
+			sp.Addrs = append(sp.Addrs, a.Bytes())
+		}
+		snap.Peers = append(snap.Peers, sp)
+	}
+	dht.peerAddrsLk.RUnlock()
+
+	buf, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := dht.datastore.Put(ctx, ds.NewKey(rtSnapshotKey), buf); err != nil {
+		return fmt.Errorf("failed to persist routing table snapshot: %w", err)
+	}
+	logger.Debugw("persisted routing table snapshot", "peers", len(snap.Peers))
+	return nil
+}
+
+// loadRoutingTableSnapshot reads the routing table snapshot from the datastore. It returns nil, nil
+// if there is no snapshot.
+func (dht *FullRT) loadRoutingTableSnapshot(ctx context.Context) (*rtSnapshot, error) {
+	buf, err := dht.datastore.Get(ctx, ds.NewKey(rtSnapshotKey))
+	if err == ds.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap := new(rtSnapshot)
+	if err := json.Unmarshal(buf, snap); err != nil {
+		return nil, fmt.Errorf("failed to decode routing table snapshot: %w", err)
+	}
+	if snap.Version != rtSnapshotVersion {
+		return nil, fmt.Errorf("unsupported routing table snapshot version %d", snap.Version)
+	}
+	return snap, nil
+}
+
+// restoreRoutingTable serves the routing table of the snapshot, if it isn't older than the maximum
+// age, until the first crawl completes. The peers aren't checked, the snapshot is as good as the
+// crawl it was taken after.
+func (dht *FullRT) restoreRoutingTable(ctx context.Context) {
+	snap, err := dht.loadRoutingTableSnapshot(ctx)
+	if err != nil {
+		logger.Warnw("failed to load routing table snapshot", "error", err)
+		return
+	}
+	if snap == nil || len(snap.Peers) == 0 {
+		return
+	}
+	if age := time.Since(snap.Taken); age > dht.snapshotMaxAge {
+		logger.Infow("ignoring outdated routing table snapshot", "taken", snap.Taken, "age", age)
+		return
+	}
+
+	peerAddrs := make(map[peer.ID][]multiaddr.Multiaddr, len(snap.Peers))
+	for _, sp := range snap.Peers {
+		addrs := make([]multiaddr.Multiaddr, 0, len(sp.Addrs))
+		for _, b := range sp.Addrs {
+			a, err := multiaddr.NewMultiaddrBytes(b)
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, a)
+		}
+		peerAddrs[sp.ID] = addrs
+		// the first crawl starts from the peers of the snapshot, keep their addresses around
+		// until then.
+		dht.h.Peerstore().AddAddrs(sp.ID, addrs, peerstore.AddressTTL)
+	}
+	dht.setRoutingTable(peerAddrs)
+
+	dht.rtLk.Lock()
+	dht.staleReady = true
+	dht.rtLk.Unlock()
+
+	logger.Infow("restored routing table from snapshot", "peers", len(peerAddrs), "taken", snap.Taken)
+}
@@ -0,0 +1,47 @@
+package fullrt
+
+import (
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	internalConfig "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// serverOptions returns the options of the IpfsDHT answering the requests of the other peers in
+// server mode. It shares the datastore and the provider manager of the FullRT, and answers the
+// closer peers from the crawled table. Its own routing table is kept empty, it never bootstraps nor
+// refreshes it.
+func (dht *FullRT) serverOptions(protocolPrefix protocol.ID, d ds.Batching, pm providers.ProviderStore, dhtOpts []kaddht.Option) []kaddht.Option {
+	opts := make([]kaddht.Option, 0, len(dhtOpts)+8)
+	opts = append(opts, dhtOpts...)
+	return append(opts,
+		kaddht.ProtocolPrefix(protocolPrefix),
+		kaddht.Mode(kaddht.ModeServer),
+		kaddht.Datastore(d),
+		kaddht.ProviderStore(pm),
+		kaddht.BootstrapPeers(),
+		kaddht.DisableAutoRefresh(),
+		kaddht.RoutingTableFilter(func(interface{}, peer.ID) bool { return false }),
+		func(c *internalConfig.Config) error {
+			c.DisableFixLowPeers = true
+			c.ClosestPeers = dht.serverClosestPeers
+			return nil
+		},
+	)
+}
+
+// serverClosestPeers returns the peers of the crawled table closest to the key of a request,
+// without ourselves nor the requester.
+func (dht *FullRT) serverClosestPeers(key []byte, from peer.ID, count int) []peer.ID {
+	closest := dht.closestPeers(string(key), count+2)
+	peers := make([]peer.ID, 0, count)
+	for _, p := range closest {
+		if p != dht.self && p != from && len(peers) < count {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
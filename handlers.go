@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -15,6 +16,7 @@ import (
 	ds "github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p-kad-dht/internal"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	recpb "github.com/libp2p/go-libp2p-record/pb"
 	"github.com/multiformats/go-base32"
 )
@@ -22,7 +24,21 @@ import (
 // dhthandler specifies the signature of functions that handle DHT messages.
 type dhtHandler func(context.Context, peer.ID, *pb.Message) (*pb.Message, error)
 
+// handlerForMsgType returns the handler for the given message type, wrapped by the interceptors.
+// The interceptors see the messages of every type, the ones the DHT doesn't handle end up being
+// rejected by handleUnsupported if no interceptor answers them.
 func (dht *IpfsDHT) handlerForMsgType(t pb.Message_MessageType) dhtHandler {
+	h := dht.baseHandlerForMsgType(t)
+	if h == nil {
+		h = handleUnsupported
+	}
+	for i := len(dht.interceptors) - 1; i >= 0; i-- {
+		h = intercept(dht.interceptors[i], h)
+	}
+	return h
+}
+
+func (dht *IpfsDHT) baseHandlerForMsgType(t pb.Message_MessageType) dhtHandler {
 	switch t {
 	case pb.Message_FIND_NODE:
 		return dht.handleFindPeer
@@ -48,9 +64,23 @@ func (dht *IpfsDHT) handlerForMsgType(t pb.Message_MessageType) dhtHandler {
 		}
 	}
 
+	if dht.encryptedProviders != nil {
+		switch t {
+		case pb.Message_ADD_ENCRYPTED_PROVIDER:
+			return dht.handleAddEncryptedProvider
+		case pb.Message_GET_ENCRYPTED_PROVIDERS:
+			return dht.handleGetEncryptedProviders
+		}
+	}
+
 	return nil
 }
 
+// handleUnsupported rejects the messages of the types the DHT doesn't handle.
+func handleUnsupported(_ context.Context, _ peer.ID, pmes *pb.Message) (*pb.Message, error) {
+	return nil, fmt.Errorf("can't handle messages of type %s", pmes.GetType())
+}
+
 func (dht *IpfsDHT) handleGetValue(ctx context.Context, p peer.ID, pmes *pb.Message) (_ *pb.Message, err error) {
 	// first, is there even a key?
 	k := pmes.GetKey()
@@ -205,16 +235,27 @@ func (dht *IpfsDHT) handlePutValue(ctx context.Context, p peer.ID, pmes *pb.Mess
 		}
 	}
 
+	ok, refund := dht.inboundLimiter.allowValue(p, rec.GetKey())
+	if !ok {
+		recordRejected(ctx, rejectedValueQuota)
+		logger.Debugw("value quota exceeded", "from", p, "key", internal.LoggableRecordKeyBytes(rec.GetKey()))
+		return nil, errValueQuotaExceeded
+	}
+
 	// record the time we receive every record
 	rec.TimeReceived = u.FormatRFC3339(time.Now())
 
 	data, err := proto.Marshal(rec)
 	if err != nil {
+		refund()
 		return nil, err
 	}
 
-	err = dht.datastore.Put(ctx, dskey, data)
-	return pmes, err
+	if err := dht.datastore.Put(ctx, dskey, data); err != nil {
+		refund()
+		return pmes, err
+	}
+	return pmes, nil
 }
 
 // returns nil, nil when either nothing is found or the value found doesn't properly validate.
@@ -327,6 +368,11 @@ func (dht *IpfsDHT) handleGetProviders(ctx context.Context, p peer.ID, pmes *pb.
 	}
 
 	resp.ProviderPeers = pb.PeerInfosToPBPeers(dht.host.Network(), filtered)
+	if dht.enableSignedProviders && wantsSignedProviders(ctx) {
+		if err := dht.attachSignedProviderRecords(ctx, key, resp.ProviderPeers); err != nil {
+			logger.Debugw("failed to load signed provider records", "key", internal.LoggableProviderRecordBytes(key), "error", err)
+		}
+	}
 
 	// Also send closer peers.
 	closer := dht.betterPeersToQuery(pmes, p, dht.bucketSize)
@@ -349,12 +395,36 @@ func (dht *IpfsDHT) handleAddProvider(ctx context.Context, p peer.ID, pmes *pb.M
 
 	logger.Debugw("adding provider", "from", p, "key", internal.LoggableProviderRecordBytes(key))
 
+	// the requested TTL is bounded by the TTL policy of the store, if it supports per-record TTLs.
+	if ttl := pmes.GetProviderTtl(); ttl > 0 {
+		d := time.Duration(math.MaxInt64)
+		if ttl < uint64(math.MaxInt64/int64(time.Second)) {
+			d = time.Duration(ttl) * time.Second
+		}
+		ctx = providers.ContextWithTTL(ctx, d)
+	}
+
 	// add provider should use the address given in the message
-	pinfos := pb.PBPeersToPeerInfos(pmes.GetProviderPeers())
-	for _, pi := range pinfos {
+	for _, pbp := range pmes.GetProviderPeers() {
+		// signed provider records vouch for the provider themselves, so they may be relayed by
+		// other peers.
+		if dht.enableSignedProviders && len(pbp.SignedRecord) > 0 {
+			ok, refund := dht.inboundLimiter.allowProvider(p, key)
+			if !ok {
+				recordRejected(ctx, rejectedProviderQuota)
+				return nil, errProviderQuotaExceeded
+			}
+			if err := dht.addSignedProvider(ctx, key, pbp); err != nil {
+				refund()
+				logger.Debugw("rejected signed provider record", "from", p, "peer", peer.ID(pbp.Id), "error", err)
+			}
+			continue
+		}
+
+		pi := pb.PBPeerToPeerInfo(pbp)
 		if pi.ID != p {
 			// we should ignore this provider record! not from originator.
-			// (we should sign them and check signature later...)
+			// (unless it is signed, see above)
 			logger.Debugw("received provider from wrong peer", "from", p, "peer", pi.ID)
 			continue
 		}
@@ -367,12 +437,69 @@ func (dht *IpfsDHT) handleAddProvider(ctx context.Context, p peer.ID, pmes *pb.M
 		// We run the addrs filter after checking for the length,
 		// this allows transient nodes with varying /p2p-circuit addresses to still have their anouncement go through.
 		addrs := dht.filterAddrs(pi.Addrs)
-		dht.providerStore.AddProvider(ctx, key, peer.AddrInfo{ID: pi.ID, Addrs: addrs})
+		ok, refund := dht.inboundLimiter.allowProvider(p, key)
+		if !ok {
+			recordRejected(ctx, rejectedProviderQuota)
+			logger.Debugw("provider quota exceeded", "from", p, "key", internal.LoggableProviderRecordBytes(key))
+			return nil, errProviderQuotaExceeded
+		}
+		if err := dht.providerStore.AddProvider(ctx, key, peer.AddrInfo{ID: pi.ID, Addrs: addrs}); err != nil {
+			refund()
+			logger.Debugw("failed to add provider", "from", p, "key", internal.LoggableProviderRecordBytes(key), "error", err)
+		}
 	}
 
 	return nil, nil
 }
 
+func (dht *IpfsDHT) handleAddEncryptedProvider(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
+	key := pmes.GetKey()
+	if err := checkDoubleHashKey(key); err != nil {
+		return nil, err
+	}
+	recs := pmes.GetEncryptedProviders()
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("handleAddEncryptedProvider but no record was provided")
+	}
+
+	ok, refund := dht.inboundLimiter.allowProvider(p, key)
+	if !ok {
+		recordRejected(ctx, rejectedProviderQuota)
+		return nil, errProviderQuotaExceeded
+	}
+
+	// the records can't be read without the key they are for, they are kept per sender and only
+	// the readers verify that they are signed by their provider.
+	logger.Debugw("adding encrypted provider", "from", p, "key", internal.LoggableProviderRecordBytes(key))
+	if err := dht.encryptedProviders.AddEncryptedProvider(ctx, key, p, recs[0]); err != nil {
+		refund()
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (dht *IpfsDHT) handleGetEncryptedProviders(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
+	key := pmes.GetKey()
+	if err := checkDoubleHashKey(key); err != nil {
+		return nil, err
+	}
+
+	resp := pb.NewMessage(pmes.GetType(), key, pmes.GetClusterLevel())
+	recs, err := dht.encryptedProviders.GetEncryptedProviders(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	resp.EncryptedProviders = recs
+
+	// Also send closer peers.
+	closer := dht.betterPeersToQuery(pmes, p, dht.bucketSize)
+	if closer != nil {
+		infos := pstore.PeerInfos(dht.peerstore, closer)
+		resp.CloserPeers = pb.PeerInfosToPBPeers(dht.host.Network(), infos)
+	}
+	return resp, nil
+}
+
 func convertToDsKey(s []byte) ds.Key {
 	return ds.NewKey(base32.RawStdEncoding.EncodeToString(s))
 }
@@ -9,11 +9,16 @@ import (
 	"time"
 
 	proto "github.com/gogo/protobuf/proto"
+	u "github.com/ipfs/boxo/util"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/libp2p/go-libp2p"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	recpb "github.com/libp2p/go-libp2p-record/pb"
 	crypto "github.com/libp2p/go-libp2p/core/crypto"
 	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
@@ -139,3 +144,42 @@ func BenchmarkHandleFindPeer(b *testing.B) {
 	}
 
 }
+
+func TestAddProviderTTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested := make(chan time.Duration, 1)
+	store, err := providers.NewTTLProviderStore("", ps, dssync.MutexWrap(ds.NewMapDatastore()),
+		providers.WithTTLPolicy(func(_ []byte, _ peer.ID, ttl time.Duration) time.Duration {
+			requested <- ttl
+			return ttl
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	d := setupDHT(ctx, t, false, ProviderStore(store))
+	a := setupDHT(ctx, t, false)
+	connect(t, ctx, a, d)
+
+	key := u.Hash([]byte("ttl"))
+	self := peer.AddrInfo{ID: a.self, Addrs: a.host.Addrs()}
+	if err := a.protoMessenger.PutProviderAddrs(providers.ContextWithTTL(ctx, 90*time.Minute+time.Millisecond), d.self, key, self); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ttl := <-requested:
+		if ttl != 90*time.Minute+time.Second {
+			t.Fatalf("expected the requested ttl rounded up to the second, got %s", ttl)
+		}
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
@@ -0,0 +1,285 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	"github.com/libp2p/go-libp2p-kad-dht/metrics"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+var (
+	errProviderQuotaExceeded = errors.New("provider record quota exceeded")
+	errValueQuotaExceeded    = errors.New("value quota exceeded")
+)
+
+// Reasons inbound messages get rejected for, as reported by metrics.RejectedMessages.
+const (
+	rejectedRateLimit     = "rate_limit"
+	rejectedProviderQuota = "provider_quota"
+	rejectedValueQuota    = "value_quota"
+)
+
+// inboundLimitsSweepInterval is how often the state of the peers that went idle is dropped.
+var inboundLimitsSweepInterval = 10 * time.Minute
+
+// tokenBucket is a token bucket refilled lazily, every time it is used.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(l dhtcfg.RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: float64(l.Burst), last: now}
+}
+
+func (b *tokenBucket) refill(l dhtcfg.RateLimit, now time.Time) {
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+}
+
+// ready refills the bucket and returns true if it has a token to take.
+func (b *tokenBucket) ready(l dhtcfg.RateLimit, now time.Time) bool {
+	b.refill(l, now)
+	return b.tokens >= 1
+}
+
+// quota keeps track of the keys a peer stored, along with when they were stored.
+type quota map[string]time.Time
+
+// use accounts key against the quota, it returns false if the quota is exhausted. Storing a key
+// again doesn't use any more of the quota. The returned function undoes the accounting.
+func (q quota) use(key string, max int, ttl time.Duration, now time.Time) (bool, func()) {
+	prev, had := q[key]
+	if !had {
+		q.expire(ttl, now)
+		if len(q) >= max {
+			return false, nil
+		}
+	}
+	q[key] = now
+	return true, func() {
+		// leave the key alone if it has been stored again since
+		if t, ok := q[key]; !ok || !t.Equal(now) {
+			return
+		}
+		if had {
+			q[key] = prev
+		} else {
+			delete(q, key)
+		}
+	}
+}
+
+func (q quota) expire(ttl time.Duration, now time.Time) {
+	for k, t := range q {
+		if now.Sub(t) >= ttl {
+			delete(q, k)
+		}
+	}
+}
+
+type peerLimits struct {
+	all       *tokenBucket
+	byType    map[pb.Message_MessageType]*tokenBucket
+	providers quota
+	values    quota
+}
+
+// inboundLimiter enforces the per-peer rate limits and quotas on the messages we receive.
+// A nil inboundLimiter doesn't limit anything.
+type inboundLimiter struct {
+	perPeer        dhtcfg.RateLimit
+	perMessageType map[pb.Message_MessageType]dhtcfg.RateLimit
+	maxProviders   int
+	maxValues      int
+	providerTTL    time.Duration
+	valueTTL       time.Duration
+
+	now func() time.Time
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*peerLimits
+	lastSweep time.Time
+}
+
+// newInboundLimiter returns the limiter for the given configuration, or nil if no limit is set.
+func newInboundLimiter(cfg dhtcfg.Config, providerTTL, valueTTL time.Duration) *inboundLimiter {
+	l := cfg.InboundLimits
+	perMessageType := make(map[pb.Message_MessageType]dhtcfg.RateLimit, len(l.PerMessageType))
+	for t, rl := range l.PerMessageType {
+		if rl.Enabled() {
+			perMessageType[t] = rl
+		}
+	}
+	if !l.PerPeer.Enabled() && len(perMessageType) == 0 && l.MaxProvidersPerPeer == 0 && l.MaxValuesPerPeer == 0 {
+		return nil
+	}
+
+	return &inboundLimiter{
+		perPeer:        l.PerPeer,
+		perMessageType: perMessageType,
+		maxProviders:   l.MaxProvidersPerPeer,
+		maxValues:      l.MaxValuesPerPeer,
+		providerTTL:    providerTTL,
+		valueTTL:       valueTTL,
+		now:            time.Now,
+		peers:          make(map[peer.ID]*peerLimits),
+		lastSweep:      time.Now(),
+	}
+}
+
+// limitsFor returns the state of p, creating it if needed. It must be called with the lock held.
+func (l *inboundLimiter) limitsFor(p peer.ID, now time.Time) *peerLimits {
+	if now.Sub(l.lastSweep) >= inboundLimitsSweepInterval {
+		l.sweep(now)
+	}
+
+	pl, ok := l.peers[p]
+	if !ok {
+		pl = &peerLimits{}
+		l.peers[p] = pl
+	}
+	return pl
+}
+
+// sweep drops the state of the peers whose buckets are full again and whose quotas are unused, as
+// it's no different from the state of a peer we never heard of.
+func (l *inboundLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for p, pl := range l.peers {
+		idle := true
+		if pl.all != nil {
+			pl.all.refill(l.perPeer, now)
+			idle = pl.all.tokens >= float64(l.perPeer.Burst)
+		}
+		for t, b := range pl.byType {
+			rl := l.perMessageType[t]
+			b.refill(rl, now)
+			idle = idle && b.tokens >= float64(rl.Burst)
+		}
+		pl.providers.expire(l.providerTTL, now)
+		pl.values.expire(l.valueTTL, now)
+		if idle && len(pl.providers) == 0 && len(pl.values) == 0 {
+			delete(l.peers, p)
+		}
+	}
+}
+
+// allowMessage returns true if p may send us a message of the given type. A token is only taken
+// from the per-peer and per-message-type buckets if both of them allow the message, so that the
+// rejected messages of a type don't use up the per-peer budget of the others.
+func (l *inboundLimiter) allowMessage(p peer.ID, t pb.Message_MessageType) bool {
+	if l == nil {
+		return true
+	}
+	typeLimit, limitType := l.perMessageType[t]
+	if !l.perPeer.Enabled() && !limitType {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pl := l.limitsFor(p, now)
+	var all, byType *tokenBucket
+	if l.perPeer.Enabled() {
+		if pl.all == nil {
+			pl.all = newTokenBucket(l.perPeer, now)
+		}
+		all = pl.all
+		if !all.ready(l.perPeer, now) {
+			return false
+		}
+	}
+	if limitType {
+		if pl.byType == nil {
+			pl.byType = make(map[pb.Message_MessageType]*tokenBucket)
+		}
+		b, ok := pl.byType[t]
+		if !ok {
+			b = newTokenBucket(typeLimit, now)
+			pl.byType[t] = b
+		}
+		byType = b
+		if !byType.ready(typeLimit, now) {
+			return false
+		}
+	}
+	for _, b := range [...]*tokenBucket{all, byType} {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	return true
+}
+
+// allowProvider accounts a provider record for key stored by p against its quota. The
+// returned function refunds it, it must be called if the record isn't stored after all.
+func (l *inboundLimiter) allowProvider(p peer.ID, key []byte) (bool, func()) {
+	if l == nil || l.maxProviders == 0 {
+		return true, func() {}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pl := l.limitsFor(p, now)
+	if pl.providers == nil {
+		pl.providers = make(quota)
+	}
+	ok, undo := pl.providers.use(string(key), l.maxProviders, l.providerTTL, now)
+	if !ok {
+		return false, nil
+	}
+	return true, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		undo()
+	}
+}
+
+// allowValue accounts a value for key stored by p against its quota. The
+// returned function refunds it, it must be called if the record isn't stored after all.
+func (l *inboundLimiter) allowValue(p peer.ID, key []byte) (bool, func()) {
+	if l == nil || l.maxValues == 0 {
+		return true, func() {}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pl := l.limitsFor(p, now)
+	if pl.values == nil {
+		pl.values = make(quota)
+	}
+	ok, undo := pl.values.use(string(key), l.maxValues, l.valueTTL, now)
+	if !ok {
+		return false, nil
+	}
+	return true, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		undo()
+	}
+}
+
+// recordRejected counts a message rejected by the inbound limits. The context is expected to be
+// tagged with the message type.
+func recordRejected(ctx context.Context, reason string) {
+	_ = stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyRejectionReason, reason)},
+		metrics.RejectedMessages.M(1),
+	)
+}
@@ -0,0 +1,123 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+func newTestInboundLimiter(t *testing.T, opts ...Option) (*inboundLimiter, *time.Time) {
+	t.Helper()
+	var cfg dhtcfg.Config
+	require.NoError(t, cfg.Apply(append([]Option{dhtcfg.Defaults, testPrefix}, opts...)...))
+	require.NoError(t, cfg.Validate())
+
+	l := newInboundLimiter(cfg, time.Hour, time.Hour)
+	require.NotNil(t, l)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+	return l, &now
+}
+
+// allowed drops the refund of allowProvider and allowValue.
+func allowed(ok bool, _ func()) bool { return ok }
+
+func TestInboundRateLimit(t *testing.T) {
+	l, now := newTestInboundLimiter(t,
+		InboundRateLimit(10, 3),
+		InboundMessageRateLimit(pb.Message_PUT_VALUE, 1, 1),
+	)
+	a, b := peer.ID("a"), peer.ID("b")
+
+	require.True(t, l.allowMessage(a, pb.Message_PUT_VALUE))
+	require.False(t, l.allowMessage(a, pb.Message_PUT_VALUE), "PUT_VALUE burst exhausted")
+	// the rejected PUT_VALUE didn't use up the per peer burst
+	require.True(t, l.allowMessage(a, pb.Message_FIND_NODE))
+	require.True(t, l.allowMessage(a, pb.Message_FIND_NODE))
+	require.False(t, l.allowMessage(a, pb.Message_FIND_NODE), "per peer burst exhausted")
+
+	// other peers have their own buckets
+	require.True(t, l.allowMessage(b, pb.Message_PUT_VALUE))
+
+	*now = now.Add(time.Second)
+	require.True(t, l.allowMessage(a, pb.Message_PUT_VALUE))
+	require.True(t, l.allowMessage(a, pb.Message_FIND_NODE))
+
+	// idle peers are forgotten
+	*now = now.Add(inboundLimitsSweepInterval)
+	l.allowMessage(b, pb.Message_FIND_NODE)
+	require.Len(t, l.peers, 1)
+}
+
+func TestInboundQuotas(t *testing.T) {
+	l, now := newTestInboundLimiter(t, ProviderQuota(2), ValueQuota(1))
+	a, b := peer.ID("a"), peer.ID("b")
+
+	require.True(t, allowed(l.allowProvider(a, []byte("k1"))))
+	require.True(t, allowed(l.allowProvider(a, []byte("k2"))))
+	require.True(t, allowed(l.allowProvider(a, []byte("k1"))), "announcing a key again doesn't use more quota")
+	require.False(t, allowed(l.allowProvider(a, []byte("k3"))))
+	require.True(t, allowed(l.allowProvider(b, []byte("k3"))))
+
+	require.True(t, allowed(l.allowValue(a, []byte("k1"))))
+	require.False(t, allowed(l.allowValue(a, []byte("k2"))))
+
+	// the quota is freed once the records expire
+	*now = now.Add(time.Hour)
+	require.True(t, allowed(l.allowProvider(a, []byte("k3"))))
+	require.True(t, allowed(l.allowValue(a, []byte("k2"))))
+}
+
+func TestInboundQuotasRefund(t *testing.T) {
+	l, _ := newTestInboundLimiter(t, ProviderQuota(1), ValueQuota(1))
+	a := peer.ID("a")
+
+	ok, refund := l.allowValue(a, []byte("k1"))
+	require.True(t, ok)
+	refund()
+	require.True(t, allowed(l.allowValue(a, []byte("k2"))), "a refunded record doesn't use the quota")
+
+	require.True(t, allowed(l.allowProvider(a, []byte("k1"))))
+	ok, refund = l.allowProvider(a, []byte("k1"))
+	require.True(t, ok)
+	refund()
+	require.False(t, allowed(l.allowProvider(a, []byte("k2"))), "refunding an announcement again keeps the stored record")
+}
+
+func TestInboundLimitsDisabled(t *testing.T) {
+	var cfg dhtcfg.Config
+	require.NoError(t, cfg.Apply(dhtcfg.Defaults))
+	require.Nil(t, newInboundLimiter(cfg, time.Hour, time.Hour))
+
+	var l *inboundLimiter
+	require.True(t, l.allowMessage(peer.ID("a"), pb.Message_PUT_VALUE))
+	require.True(t, allowed(l.allowProvider(peer.ID("a"), []byte("k"))))
+
+	require.NoError(t, cfg.Apply(testPrefix, InboundRateLimit(1, 0)))
+	require.Error(t, cfg.Validate(), "a rate limit needs a burst")
+}
+
+func TestInboundRateLimitRejects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	limited := setupDHT(ctx, t, false, InboundMessageRateLimit(pb.Message_GET_VALUE, 0.001, 1))
+	d := setupDHT(ctx, t, false)
+	connect(t, ctx, d, limited)
+
+	_, _, err := d.protoMessenger.GetValue(ctx, limited.self, "/v/hello")
+	require.NoError(t, err)
+	_, _, err = d.protoMessenger.GetValue(ctx, limited.self, "/v/hello")
+	require.Error(t, err)
+
+	// other message types aren't limited
+	_, err = d.protoMessenger.GetClosestPeers(ctx, limited.self, d.self)
+	require.NoError(t, err)
+}
@@ -0,0 +1,5 @@
+package internal
+
+// BulkProgressKey is the context key of the progress callback of ProvideMany and PutMany, shared by
+// the DHT implementations.
+type BulkProgressKey struct{}
@@ -1,12 +1,16 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"time"
 
 	"github.com/ipfs/boxo/ipns"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
 	record "github.com/libp2p/go-libp2p-record"
@@ -31,6 +35,55 @@ type QueryFilterFunc func(dht interface{}, ai peer.AddrInfo) bool
 // the local route table.
 type RouteTableFilterFunc func(dht interface{}, p peer.ID) bool
 
+// Handler handles a DHT request received from a remote peer, returning the response to send back.
+type Handler func(ctx context.Context, p peer.ID, req *pb.Message) (*pb.Message, error)
+
+// Interceptor wraps the handling of the DHT requests received from remote peers.
+type Interceptor func(ctx context.Context, p peer.ID, req *pb.Message, next Handler) (*pb.Message, error)
+
+// ASNResolver maps IP addresses to the number of the autonomous system announcing them.
+type ASNResolver interface {
+	ASN(ip net.IP) (string, error)
+}
+
+// Clock is the time source the lookups time their RPCs with.
+type Clock interface {
+	Now() time.Time
+	// WithTimeout is context.WithTimeout on this clock.
+	WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc)
+}
+
+// wallClock is the Clock of the system.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time { return time.Now() }
+
+func (wallClock) WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d)
+}
+
+// RateLimit describes a token bucket: Burst requests may be made at once, and the bucket refills at
+// Rate requests per second. The zero value disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled returns true if the limit is set.
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0
+}
+
+func (l RateLimit) validate() error {
+	if l.Rate < 0 {
+		return fmt.Errorf("rate must not be negative, got %f", l.Rate)
+	}
+	if l.Enabled() && l.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1, got %d", l.Burst)
+	}
+	return nil
+}
+
 // Config is a structure containing all the options that can be used when constructing a DHT.
 type Config struct {
 	Datastore              ds.Batching
@@ -41,6 +94,7 @@ type Config struct {
 	V1ProtocolOverride     protocol.ID
 	BucketSize             int
 	Concurrency            int
+	DisjointPaths          int
 	Resiliency             int
 	MaxRecordAge           time.Duration
 	EnableProviders        bool
@@ -57,8 +111,16 @@ type Config struct {
 		CheckInterval       time.Duration
 		PeerFilter          RouteTableFilterFunc
 		DiversityFilter     peerdiversity.PeerIPGroupFilter
+		PersistInterval     time.Duration
+	}
+
+	QueryDiversity struct {
+		MaxPerIPGroup int
+		MaxPerASN     int
 	}
 
+	ASNResolver ASNResolver
+
 	BootstrapPeers func() []peer.AddrInfo
 	AddressFilter  func([]ma.Multiaddr) []ma.Multiaddr
 
@@ -68,6 +130,51 @@ type Config struct {
 
 	EnableOptimisticProvide       bool
 	OptimisticProvideJobsPoolSize int
+
+	EnableSignedProviderRecords bool
+
+	EnableDoubleHashedProviders bool
+
+	BulkSendParallelism int
+
+	Interceptors []Interceptor
+
+	LookupTraceWriter  io.Writer
+	RecentLookupTraces int
+
+	MsgSenderBuilder func(h host.Host, protos []protocol.ID) pb.MessageSenderWithDisconnect
+
+	Clock Clock
+
+	RPCTimeouts struct {
+		Multiplier float64
+		Min        time.Duration
+		Max        time.Duration
+	}
+
+	Reprovider struct {
+		Enabled     bool
+		Interval    time.Duration
+		Concurrency int
+	}
+
+	Republisher struct {
+		Enabled     bool
+		Interval    time.Duration
+		Concurrency int
+	}
+
+	InboundLimits struct {
+		PerPeer             RateLimit
+		PerMessageType      map[pb.Message_MessageType]RateLimit
+		MaxProvidersPerPeer int
+		MaxValuesPerPeer    int
+	}
+
+	// ClosestPeers, if set, replaces the routing table when answering the closer peers of a
+	// request. It returns the count peers closest to the key, without ourselves nor the requester
+	// from. It is how the FullRT DHT serves requests from its crawled table.
+	ClosestPeers func(key []byte, from peer.ID, count int) []peer.ID
 }
 
 func EmptyQueryFilter(_ interface{}, ai peer.AddrInfo) bool { return true }
@@ -114,6 +221,7 @@ var Defaults = func(o *Config) error {
 	o.EnableProviders = true
 	o.EnableValues = true
 	o.QueryPeerFilter = EmptyQueryFilter
+	o.Clock = wallClock{}
 
 	o.RoutingTable.LatencyTolerance = 10 * time.Second
 	o.RoutingTable.RefreshQueryTimeout = 10 * time.Second
@@ -125,16 +233,114 @@ var Defaults = func(o *Config) error {
 
 	o.BucketSize = defaultBucketSize
 	o.Concurrency = 10
+	o.DisjointPaths = 1
 	o.Resiliency = 3
 	o.LookupCheckConcurrency = 256
 
 	// MAGIC: It makes sense to set it to a multiple of OptProvReturnRatio * BucketSize. We chose a multiple of 4.
 	o.OptimisticProvideJobsPoolSize = 60
 
+	o.BulkSendParallelism = 20
+
+	// Reprovide well within the provider record expiration interval.
+	o.Reprovider.Interval = 22 * time.Hour
+	o.Reprovider.Concurrency = 8
+
+	// Republish well within the record expiration interval (MaxRecordAge).
+	o.Republisher.Interval = 22 * time.Hour
+	o.Republisher.Concurrency = 8
+
 	return nil
 }
 
+// Validate checks the config of an IpfsDHT.
 func (c *Config) Validate() error {
+	if c.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1, got %d", c.Concurrency)
+	}
+	if c.Resiliency < 1 {
+		return fmt.Errorf("resiliency must be at least 1, got %d", c.Resiliency)
+	}
+	if c.LookupCheckConcurrency < 0 {
+		return fmt.Errorf("lookup check concurrency must not be negative, got %d", c.LookupCheckConcurrency)
+	}
+	if c.QueryPeerFilter == nil {
+		return fmt.Errorf("query filter must not be nil")
+	}
+	if c.Clock == nil {
+		return fmt.Errorf("clock must not be nil")
+	}
+	if c.RoutingTable.AutoRefresh && c.RoutingTable.RefreshInterval <= 0 {
+		return fmt.Errorf("routing table refresh interval must be positive, got %s", c.RoutingTable.RefreshInterval)
+	}
+	if c.DisjointPaths < 1 {
+		return fmt.Errorf("number of disjoint paths must be at least 1, got %d", c.DisjointPaths)
+	}
+	if c.EnableSignedProviderRecords && !c.EnableProviders {
+		return fmt.Errorf("signed provider records require providers to be enabled")
+	}
+	if c.EnableDoubleHashedProviders && !c.EnableProviders {
+		return fmt.Errorf("double hashed provider records require providers to be enabled")
+	}
+	if c.BulkSendParallelism < 1 {
+		return fmt.Errorf("bulk send parallelism must be at least 1, got %d", c.BulkSendParallelism)
+	}
+	if c.RecentLookupTraces < 0 {
+		return fmt.Errorf("number of recent lookup traces must not be negative, got %d", c.RecentLookupTraces)
+	}
+	if c.QueryDiversity.MaxPerIPGroup < 0 || c.QueryDiversity.MaxPerASN < 0 {
+		return fmt.Errorf("query diversity limits must not be negative")
+	}
+	if c.RoutingTable.PersistInterval < 0 {
+		return fmt.Errorf("routing table persist interval must not be negative, got %s", c.RoutingTable.PersistInterval)
+	}
+	if err := c.InboundLimits.PerPeer.validate(); err != nil {
+		return fmt.Errorf("invalid inbound rate limit: %w", err)
+	}
+	for t, l := range c.InboundLimits.PerMessageType {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("invalid inbound rate limit for %s messages: %w", t, err)
+		}
+	}
+	if c.InboundLimits.MaxProvidersPerPeer < 0 || c.InboundLimits.MaxValuesPerPeer < 0 {
+		return fmt.Errorf("inbound quotas must not be negative")
+	}
+	if c.RPCTimeouts.Multiplier < 0 {
+		return fmt.Errorf("rpc timeout multiplier must not be negative, got %f", c.RPCTimeouts.Multiplier)
+	}
+	if c.RPCTimeouts.Multiplier > 0 && (c.RPCTimeouts.Min <= 0 || c.RPCTimeouts.Max < c.RPCTimeouts.Min) {
+		return fmt.Errorf("rpc timeouts must be positive with min <= max, got %s and %s", c.RPCTimeouts.Min, c.RPCTimeouts.Max)
+	}
+	if c.Reprovider.Enabled {
+		if !c.EnableProviders {
+			return fmt.Errorf("the reprovider requires providers to be enabled")
+		}
+		if c.Reprovider.Interval <= 0 {
+			return fmt.Errorf("reprovide interval must be positive, got %s", c.Reprovider.Interval)
+		}
+		if c.Reprovider.Concurrency < 1 {
+			return fmt.Errorf("reprovide concurrency must be at least 1, got %d", c.Reprovider.Concurrency)
+		}
+	}
+	if c.Republisher.Enabled {
+		if !c.EnableValues {
+			return fmt.Errorf("the republisher requires values to be enabled")
+		}
+		if c.Republisher.Interval <= 0 {
+			return fmt.Errorf("republish interval must be positive, got %s", c.Republisher.Interval)
+		}
+		if c.Republisher.Concurrency < 1 {
+			return fmt.Errorf("republish concurrency must be at least 1, got %d", c.Republisher.Concurrency)
+		}
+	}
+
+	return c.ValidateProtocol()
+}
+
+// ValidateProtocol checks that the config is compatible with its protocol prefix. It is the only
+// validation of the FullRT DHT, which doesn't use the query engine and server options of the
+// IpfsDHT.
+func (c *Config) ValidateProtocol() error {
 	if c.ProtocolPrefix != DefaultPrefix {
 		return nil
 	}
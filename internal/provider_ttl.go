@@ -0,0 +1,5 @@
+package internal
+
+// ProviderTTLKey is the context key of the TTL requested for provider records, shared by the
+// provider stores and the ADD_PROVIDER requests.
+type ProviderTTLKey struct{}
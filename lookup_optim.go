@@ -62,6 +62,10 @@ type optimisticState struct {
 	// the key to provide
 	key string
 
+	// our own provider info and the signed provider record to announce, if any
+	self         peer.AddrInfo
+	signedRecord []byte
+
 	// the key to provide transformed into the Kademlia key space
 	ksKey ks.Key
 
@@ -92,8 +96,12 @@ func (dht *IpfsDHT) newOptimisticState(ctx context.Context, key string) (*optimi
 	setThreshold := mathext.GammaIncRegInv(float64(dht.bucketSize)/2.0+1, 1-optProvSetThresholdStrictness) / float64(networkSize)
 	returnThreshold := int(math.Ceil(float64(dht.bucketSize) * optProvReturnRatio))
 
+	self, signedRecord := dht.selfProviderRecord(multihash.Multihash(key))
+
 	return &optimisticState{
 		putCtx:              ctx,
+		self:                self,
+		signedRecord:        signedRecord,
 		dht:                 dht,
 		key:                 key,
 		doneChan:            make(chan struct{}, returnThreshold), // buffered channel to not miss events
@@ -236,10 +244,7 @@ func (os *optimisticState) stopFn(qps *qpeerset.QueryPeerset) bool {
 }
 
 func (os *optimisticState) putProviderRecord(pid peer.ID) {
-	err := os.dht.protoMessenger.PutProviderAddrs(os.putCtx, pid, []byte(os.key), peer.AddrInfo{
-		ID:    os.dht.self,
-		Addrs: os.dht.filterAddrs(os.dht.host.Addrs()),
-	})
+	err := os.dht.protoMessenger.PutSignedProviderAddrs(os.putCtx, pid, []byte(os.key), os.self, os.signedRecord)
 	os.peerStatesLk.Lock()
 	if err != nil {
 		os.peerStates[pid] = failure
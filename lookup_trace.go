@@ -0,0 +1,280 @@
+package dht
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// LookupRPCOutcome is the outcome of a single RPC of a lookup.
+type LookupRPCOutcome string
+
+const (
+	// LookupRPCSuccess indicates that the peer answered the query.
+	LookupRPCSuccess LookupRPCOutcome = "success"
+	// LookupRPCDialFailure indicates that we couldn't connect to the peer.
+	LookupRPCDialFailure LookupRPCOutcome = "dial_failure"
+	// LookupRPCQueryFailure indicates that the peer failed to answer the query.
+	LookupRPCQueryFailure LookupRPCOutcome = "query_failure"
+	// LookupRPCTimeout indicates that the peer didn't answer the query within its RPC timeout.
+	LookupRPCTimeout LookupRPCOutcome = "timeout"
+	// LookupRPCAborted indicates that the lookup terminated before the RPC completed.
+	LookupRPCAborted LookupRPCOutcome = "aborted"
+)
+
+// LookupTraceRPC describes a single RPC sent during a lookup.
+type LookupTraceRPC struct {
+	// Peer is the queried peer.
+	Peer peer.ID
+	// Referrer is the peer that told us about Peer first, or ourselves for the seed peers.
+	Referrer peer.ID `json:",omitempty"`
+	// Start is when the RPC was sent.
+	Start time.Time
+	// Latency is the duration of the query, or of the failed dial or query.
+	Latency time.Duration
+	Outcome LookupRPCOutcome
+	Error   string `json:",omitempty"`
+	// Heard are the peers returned by Peer that were considered for the lookup.
+	Heard []peer.ID `json:",omitempty"`
+}
+
+// LookupTrace is the record of a whole lookup, as run by a single lookup path.
+type LookupTrace struct {
+	ID uuid.UUID
+	// Node is the peer that ran the lookup.
+	Node peer.ID
+	// Key is the lookup target.
+	Key []byte
+	// Path is the index of the path within a disjoint lookup, 0 for regular lookups.
+	Path  int
+	Start time.Time
+	End   time.Time
+	Seeds []peer.ID
+	// RPCs are the RPCs sent by the lookup, in the order they completed.
+	RPCs        []LookupTraceRPC
+	Termination LookupTerminationReason
+}
+
+// LookupTracer writes the traces of lookups as newline-delimited JSON, one lookup per line.
+type LookupTracer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewLookupTracer returns a tracer writing to w. Writes are serialized, so w doesn't have to be
+// safe for concurrent use.
+func NewLookupTracer(w io.Writer) *LookupTracer {
+	return &LookupTracer{enc: json.NewEncoder(w)}
+}
+
+// Trace writes a lookup trace.
+func (t *LookupTracer) Trace(lt *LookupTrace) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.enc.Encode(lt)
+}
+
+// lookupTraceRing keeps the most recent lookup traces in memory.
+type lookupTraceRing struct {
+	mu     sync.Mutex
+	traces []*LookupTrace
+}
+
+func newLookupTraceRing(n int) *lookupTraceRing {
+	return &lookupTraceRing{traces: make([]*LookupTrace, 0, n)}
+}
+
+func (r *lookupTraceRing) add(lt *LookupTrace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.traces) == cap(r.traces) {
+		copy(r.traces, r.traces[1:])
+		r.traces = r.traces[:len(r.traces)-1]
+	}
+	r.traces = append(r.traces, lt)
+}
+
+func (r *lookupTraceRing) list() []*LookupTrace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*LookupTrace(nil), r.traces...)
+}
+
+// RecentLookupTraces returns the traces of the most recent lookup paths, oldest first, or nil if
+// they aren't kept (see KeepRecentLookupTraces).
+func (dht *IpfsDHT) RecentLookupTraces() []*LookupTrace {
+	if dht.recentTraces == nil {
+		return nil
+	}
+	return dht.recentTraces.list()
+}
+
+// LookupTraceReader reads lookup traces written by a LookupTracer.
+type LookupTraceReader struct {
+	dec *json.Decoder
+}
+
+// NewLookupTraceReader returns a reader of the lookup traces in r.
+func NewLookupTraceReader(r io.Reader) *LookupTraceReader {
+	return &LookupTraceReader{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+// Next returns the next lookup trace, or io.EOF once all of them were read.
+func (r *LookupTraceReader) Next() (*LookupTrace, error) {
+	var lt LookupTrace
+	if err := r.dec.Decode(&lt); err != nil {
+		return nil, err
+	}
+	return &lt, nil
+}
+
+// ReadLookupTraces reads all the lookup traces in r.
+func ReadLookupTraces(r io.Reader) ([]*LookupTrace, error) {
+	tr := NewLookupTraceReader(r)
+	var traces []*LookupTrace
+	for {
+		lt, err := tr.Next()
+		if err == io.EOF {
+			return traces, nil
+		}
+		if err != nil {
+			return traces, err
+		}
+		traces = append(traces, lt)
+	}
+}
+
+// LookupGraphNode is a peer of a lookup graph.
+type LookupGraphNode struct {
+	Peer peer.ID
+	// Referrer is the peer we heard about Peer from first, empty for the root of the graph.
+	Referrer peer.ID
+	// Children are the peers we heard about from Peer first.
+	Children []peer.ID
+	// RPC is the RPC sent to Peer, nil if it was never queried.
+	RPC *LookupTraceRPC
+}
+
+// LookupGraph is the tree of the peers a lookup heard about, every peer being the child of the
+// peer that referred it.
+type LookupGraph struct {
+	// Root is the peer that ran the lookup, it refers the seed peers.
+	Root  peer.ID
+	Nodes map[peer.ID]*LookupGraphNode
+}
+
+// Graph rebuilds the lookup graph from the trace.
+func (lt *LookupTrace) Graph() *LookupGraph {
+	g := &LookupGraph{
+		Root:  lt.Node,
+		Nodes: map[peer.ID]*LookupGraphNode{lt.Node: {Peer: lt.Node}},
+	}
+	add := func(p, referrer peer.ID) *LookupGraphNode {
+		if n, ok := g.Nodes[p]; ok {
+			return n
+		}
+		n := &LookupGraphNode{Peer: p, Referrer: referrer}
+		g.Nodes[p] = n
+		if r, ok := g.Nodes[referrer]; ok {
+			r.Children = append(r.Children, p)
+		}
+		return n
+	}
+
+	for _, p := range lt.Seeds {
+		add(p, lt.Node)
+	}
+	for i := range lt.RPCs {
+		rpc := &lt.RPCs[i]
+		referrer := rpc.Referrer
+		if referrer == "" {
+			referrer = lt.Node
+		}
+		add(rpc.Peer, referrer).RPC = rpc
+		for _, p := range rpc.Heard {
+			add(p, rpc.Peer)
+		}
+	}
+	return g
+}
+
+// WriteDOT writes the graph in the Graphviz DOT format. Queried peers are labelled with the outcome
+// and the latency of their RPC.
+func (g *LookupGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph lookup {")
+	fmt.Fprintf(bw, "\t%q [shape=doublecircle];\n", g.Root.String())
+	for _, n := range g.Nodes {
+		if n.RPC != nil {
+			fmt.Fprintf(bw, "\t%q [label=%q];\n", n.Peer.String(), fmt.Sprintf("%s\n%s %s", n.Peer.ShortString(), n.RPC.Outcome, n.RPC.Latency))
+		}
+		for _, c := range n.Children {
+			fmt.Fprintf(bw, "\t%q -> %q;\n", n.Peer.String(), c.String())
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// lookupTraceRecorder records the trace of a single lookup path. It is only used by the goroutine
+// running the query, so it needs no locking.
+type lookupTraceRecorder struct {
+	clock   Clock
+	trace   LookupTrace
+	pending map[peer.ID]LookupTraceRPC
+}
+
+func newLookupTraceRecorder(q *query) *lookupTraceRecorder {
+	return &lookupTraceRecorder{
+		clock: q.dht.clock,
+		trace: LookupTrace{
+			ID:    q.id,
+			Node:  q.dht.self,
+			Key:   []byte(q.key),
+			Path:  q.path,
+			Start: q.dht.clock.Now(),
+			Seeds: append([]peer.ID(nil), q.seedPeers...),
+		},
+		pending: make(map[peer.ID]LookupTraceRPC),
+	}
+}
+
+// sent records that an RPC to p was sent.
+func (r *lookupTraceRecorder) sent(p, referrer peer.ID) {
+	r.pending[p] = LookupTraceRPC{Peer: p, Referrer: referrer, Start: r.clock.Now()}
+}
+
+// done records the outcome of the RPC to the cause of the update.
+func (r *lookupTraceRecorder) done(up *queryUpdate) {
+	rpc, ok := r.pending[up.cause]
+	if !ok {
+		return
+	}
+	delete(r.pending, up.cause)
+
+	rpc.Latency = up.queryDuration
+	rpc.Outcome = up.outcome
+	if up.err != nil {
+		rpc.Error = up.err.Error()
+	}
+	rpc.Heard = up.heard
+	r.trace.RPCs = append(r.trace.RPCs, rpc)
+}
+
+// finish completes the trace once the lookup terminated, and returns it.
+func (r *lookupTraceRecorder) finish(reason LookupTerminationReason) *LookupTrace {
+	r.trace.End = r.clock.Now()
+	r.trace.Termination = reason
+	for _, rpc := range r.pending {
+		rpc.Outcome = LookupRPCAborted
+		r.trace.RPCs = append(r.trace.RPCs, rpc)
+	}
+	r.pending = nil
+	return &r.trace
+}
@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer that can be written to while background lookups run.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLookupTrace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dhts := setupDHTS(t, ctx, 5)
+	for i := 0; i < len(dhts)-1; i++ {
+		connect(t, ctx, dhts[i], dhts[i+1])
+	}
+
+	var buf syncBuffer
+	d := setupDHT(ctx, t, false, TraceLookups(&buf))
+	connect(t, ctx, d, dhts[0])
+
+	key := "trace"
+	_, err := d.GetClosestPeers(ctx, key)
+	require.NoError(t, err)
+
+	traces, err := ReadLookupTraces(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	var lt *LookupTrace
+	for _, tr := range traces {
+		if string(tr.Key) == key {
+			lt = tr
+		}
+	}
+	require.NotNil(t, lt, "no trace for the lookup")
+	require.Equal(t, d.self, lt.Node)
+	require.Equal(t, []peer.ID{dhts[0].self}, lt.Seeds)
+	require.NotEqual(t, LookupCancelled, lt.Termination)
+	require.NotEmpty(t, lt.RPCs)
+	for _, rpc := range lt.RPCs {
+		// RPCs still in flight when the lookup terminates are aborted.
+		require.Contains(t, []LookupRPCOutcome{LookupRPCSuccess, LookupRPCAborted}, rpc.Outcome, "rpc to %s", rpc.Peer)
+		require.NotEmpty(t, rpc.Referrer)
+	}
+
+	// the peers are found along the chain
+	g := lt.Graph()
+	require.Equal(t, d.self, g.Root)
+	require.Equal(t, []peer.ID{dhts[0].self}, g.Nodes[d.self].Children)
+	require.Contains(t, g.Nodes, dhts[1].self)
+	require.Equal(t, dhts[0].self, g.Nodes[dhts[1].self].Referrer)
+	for p, n := range g.Nodes {
+		if p != g.Root {
+			require.Contains(t, g.Nodes[n.Referrer].Children, p)
+		}
+	}
+
+	var dot bytes.Buffer
+	require.NoError(t, g.WriteDOT(&dot))
+	require.Contains(t, dot.String(), "digraph lookup {")
+	require.Contains(t, dot.String(), dhts[0].self.String())
+}
+
+func TestLookupTraceGraph(t *testing.T) {
+	self, a, b, c := peer.ID("self"), peer.ID("a"), peer.ID("b"), peer.ID("c")
+	lt := &LookupTrace{
+		Node:  self,
+		Seeds: []peer.ID{a},
+		RPCs: []LookupTraceRPC{
+			{Peer: a, Referrer: self, Outcome: LookupRPCSuccess, Heard: []peer.ID{b, c}},
+			{Peer: b, Referrer: a, Outcome: LookupRPCSuccess, Heard: []peer.ID{c}},
+			{Peer: c, Referrer: a, Outcome: LookupRPCDialFailure, Error: "dial failed"},
+		},
+	}
+	g := lt.Graph()
+	require.Len(t, g.Nodes, 4)
+	require.Equal(t, []peer.ID{b, c}, g.Nodes[a].Children)
+	require.Empty(t, g.Nodes[b].Children, "c was referred by a first")
+	require.Equal(t, LookupRPCDialFailure, g.Nodes[c].RPC.Outcome)
+}
@@ -19,6 +19,8 @@ var (
 	// KeyInstanceID identifies a dht instance by the pointer address.
 	// Useful for differentiating between different dhts that have the same peer id.
 	KeyInstanceID, _ = tag.NewKey("instance_id")
+	// KeyRejectionReason identifies the inbound limit a rejected message ran into.
+	KeyRejectionReason, _ = tag.NewKey("rejection_reason")
 )
 
 // UpsertMessageType is a convenience upserts the message type
@@ -40,6 +42,7 @@ var (
 	SentRequestErrors      = stats.Int64("libp2p.io/dht/kad/sent_request_errors", "Total number of errors for requests sent per RPC", stats.UnitDimensionless)
 	SentBytes              = stats.Int64("libp2p.io/dht/kad/sent_bytes", "Total sent bytes per RPC", stats.UnitBytes)
 	NetworkSize            = stats.Int64("libp2p.io/dht/kad/network_size", "Network size estimation", stats.UnitDimensionless)
+	RejectedMessages       = stats.Int64("libp2p.io/dht/kad/rejected_messages", "Total number of messages rejected by the inbound rate limits and quotas per RPC", stats.UnitDimensionless)
 )
 
 // Views
@@ -99,6 +102,11 @@ var (
 		TagKeys:     []tag.Key{KeyPeerID, KeyInstanceID},
 		Aggregation: view.Count(),
 	}
+	RejectedMessagesView = &view.View{
+		Measure:     RejectedMessages,
+		TagKeys:     []tag.Key{KeyMessageType, KeyRejectionReason, KeyPeerID, KeyInstanceID},
+		Aggregation: view.Count(),
+	}
 )
 
 // DefaultViews with all views in it.
@@ -114,4 +122,5 @@ var DefaultViews = []*view.View{
 	SentRequestErrorsView,
 	SentBytesView,
 	NetworkSizeView,
+	RejectedMessagesView,
 }
@@ -34,6 +34,10 @@ const (
 	Message_GET_PROVIDERS Message_MessageType = 3
 	Message_FIND_NODE     Message_MessageType = 4
 	Message_PING          Message_MessageType = 5
+	// stores an encrypted provider record under a double hashed key
+	Message_ADD_ENCRYPTED_PROVIDER Message_MessageType = 6
+	// looks up the encrypted provider records of a double hashed key
+	Message_GET_ENCRYPTED_PROVIDERS Message_MessageType = 7
 )
 
 var Message_MessageType_name = map[int32]string{
@@ -43,15 +47,19 @@ var Message_MessageType_name = map[int32]string{
 	3: "GET_PROVIDERS",
 	4: "FIND_NODE",
 	5: "PING",
+	6: "ADD_ENCRYPTED_PROVIDER",
+	7: "GET_ENCRYPTED_PROVIDERS",
 }
 
 var Message_MessageType_value = map[string]int32{
-	"PUT_VALUE":     0,
-	"GET_VALUE":     1,
-	"ADD_PROVIDER":  2,
-	"GET_PROVIDERS": 3,
-	"FIND_NODE":     4,
-	"PING":          5,
+	"PUT_VALUE":               0,
+	"GET_VALUE":               1,
+	"ADD_PROVIDER":            2,
+	"GET_PROVIDERS":           3,
+	"FIND_NODE":               4,
+	"PING":                    5,
+	"ADD_ENCRYPTED_PROVIDER":  6,
+	"GET_ENCRYPTED_PROVIDERS": 7,
 }
 
 func (x Message_MessageType) String() string {
@@ -115,10 +123,18 @@ type Message struct {
 	CloserPeers []Message_Peer `protobuf:"bytes,8,rep,name=closerPeers,proto3" json:"closerPeers"`
 	// Used to return Providers
 	// GET_VALUE, ADD_PROVIDER, GET_PROVIDERS
-	ProviderPeers        []Message_Peer `protobuf:"bytes,9,rep,name=providerPeers,proto3" json:"providerPeers"`
-	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
-	XXX_unrecognized     []byte         `json:"-"`
-	XXX_sizecache        int32          `json:"-"`
+	ProviderPeers []Message_Peer `protobuf:"bytes,9,rep,name=providerPeers,proto3" json:"providerPeers"`
+	// Used to carry encrypted provider records, the key is the double hash of the
+	// provided multihash
+	// ADD_ENCRYPTED_PROVIDER, GET_ENCRYPTED_PROVIDERS
+	EncryptedProviders []*EncryptedProviderRecord `protobuf:"bytes,11,rep,name=encryptedProviders,proto3" json:"encryptedProviders,omitempty"`
+	// TTL in seconds requested by the announcer for its provider records, zero
+	// for the default TTL of the receiver
+	// ADD_PROVIDER
+	ProviderTtl          uint64   `protobuf:"varint,12,opt,name=providerTtl,proto3" json:"providerTtl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -196,16 +212,34 @@ func (m *Message) GetProviderPeers() []Message_Peer {
 	return nil
 }
 
+func (m *Message) GetEncryptedProviders() []*EncryptedProviderRecord {
+	if m != nil {
+		return m.EncryptedProviders
+	}
+	return nil
+}
+
+func (m *Message) GetProviderTtl() uint64 {
+	if m != nil {
+		return m.ProviderTtl
+	}
+	return 0
+}
+
 type Message_Peer struct {
 	// ID of a given peer.
 	Id byteString `protobuf:"bytes,1,opt,name=id,proto3,customtype=byteString" json:"id"`
 	// multiaddrs for a given peer
 	Addrs [][]byte `protobuf:"bytes,2,rep,name=addrs,proto3" json:"addrs,omitempty"`
 	// used to signal the sender's connection capabilities to the peer
-	Connection           Message_ConnectionType `protobuf:"varint,3,opt,name=connection,proto3,enum=dht.pb.Message_ConnectionType" json:"connection,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
-	XXX_unrecognized     []byte                 `json:"-"`
-	XXX_sizecache        int32                  `json:"-"`
+	Connection Message_ConnectionType `protobuf:"varint,3,opt,name=connection,proto3,enum=dht.pb.Message_ConnectionType" json:"connection,omitempty"`
+	// signed provider record (a serialized record envelope) vouching for the
+	// peer as a provider of the message key, only set for provider peers
+	// ADD_PROVIDER, GET_PROVIDERS
+	SignedRecord         []byte   `protobuf:"bytes,4,opt,name=signedRecord,proto3" json:"signedRecord,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Message_Peer) Reset()         { *m = Message_Peer{} }
@@ -255,47 +289,201 @@ func (m *Message_Peer) GetConnection() Message_ConnectionType {
 	return Message_NOT_CONNECTED
 }
 
+func (m *Message_Peer) GetSignedRecord() []byte {
+	if m != nil {
+		return m.SignedRecord
+	}
+	return nil
+}
+
+// ProviderRecord is the payload of a signed provider record envelope.
+type ProviderRecord struct {
+	// key the provider record is for
+	Key []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// ID of the provider
+	PeerId []byte `protobuf:"bytes,2,opt,name=peerId,proto3" json:"peerId,omitempty"`
+	// multiaddrs of the provider
+	Addrs [][]byte `protobuf:"bytes,3,rep,name=addrs,proto3" json:"addrs,omitempty"`
+	// unix time in nanoseconds at which the record was signed
+	Timestamp            int64    `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ProviderRecord) Reset()         { *m = ProviderRecord{} }
+func (m *ProviderRecord) String() string { return proto.CompactTextString(m) }
+func (*ProviderRecord) ProtoMessage()    {}
+func (*ProviderRecord) Descriptor() ([]byte, []int) {
+	return fileDescriptor_616a434b24c97ff4, []int{1}
+}
+func (m *ProviderRecord) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ProviderRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ProviderRecord.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ProviderRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProviderRecord.Merge(m, src)
+}
+func (m *ProviderRecord) XXX_Size() int {
+	return m.Size()
+}
+func (m *ProviderRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProviderRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProviderRecord proto.InternalMessageInfo
+
+func (m *ProviderRecord) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *ProviderRecord) GetPeerId() []byte {
+	if m != nil {
+		return m.PeerId
+	}
+	return nil
+}
+
+func (m *ProviderRecord) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func (m *ProviderRecord) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+// EncryptedProviderRecord is a ProviderRecord encrypted with a key derived from the
+// provided multihash, so that only the peers that know the multihash can read it.
+type EncryptedProviderRecord struct {
+	// AES-GCM nonce
+	Nonce []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// encrypted ProviderRecord, authenticated along with the message key
+	Ciphertext           []byte   `protobuf:"bytes,2,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EncryptedProviderRecord) Reset()         { *m = EncryptedProviderRecord{} }
+func (m *EncryptedProviderRecord) String() string { return proto.CompactTextString(m) }
+func (*EncryptedProviderRecord) ProtoMessage()    {}
+func (*EncryptedProviderRecord) Descriptor() ([]byte, []int) {
+	return fileDescriptor_616a434b24c97ff4, []int{2}
+}
+func (m *EncryptedProviderRecord) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *EncryptedProviderRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_EncryptedProviderRecord.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *EncryptedProviderRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EncryptedProviderRecord.Merge(m, src)
+}
+func (m *EncryptedProviderRecord) XXX_Size() int {
+	return m.Size()
+}
+func (m *EncryptedProviderRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_EncryptedProviderRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EncryptedProviderRecord proto.InternalMessageInfo
+
+func (m *EncryptedProviderRecord) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+func (m *EncryptedProviderRecord) GetCiphertext() []byte {
+	if m != nil {
+		return m.Ciphertext
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("dht.pb.Message_MessageType", Message_MessageType_name, Message_MessageType_value)
 	proto.RegisterEnum("dht.pb.Message_ConnectionType", Message_ConnectionType_name, Message_ConnectionType_value)
 	proto.RegisterType((*Message)(nil), "dht.pb.Message")
 	proto.RegisterType((*Message_Peer)(nil), "dht.pb.Message.Peer")
+	proto.RegisterType((*ProviderRecord)(nil), "dht.pb.ProviderRecord")
+	proto.RegisterType((*EncryptedProviderRecord)(nil), "dht.pb.EncryptedProviderRecord")
 }
 
 func init() { proto.RegisterFile("dht.proto", fileDescriptor_616a434b24c97ff4) }
 
 var fileDescriptor_616a434b24c97ff4 = []byte{
-	// 469 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x92, 0xb1, 0x6f, 0x9b, 0x40,
-	0x18, 0xc5, 0x73, 0x80, 0xdd, 0xf8, 0x03, 0x3b, 0xe4, 0x94, 0x01, 0xb9, 0x92, 0x83, 0x3c, 0xd1,
-	0xc1, 0x20, 0xd1, 0xb5, 0xaa, 0x6a, 0x03, 0x8d, 0x2c, 0xa5, 0xd8, 0xba, 0x38, 0xe9, 0x68, 0x19,
-	0xb8, 0x12, 0x54, 0xd7, 0x87, 0x00, 0xa7, 0xf2, 0xd6, 0x3f, 0x2f, 0x63, 0xe7, 0x0e, 0x51, 0xe5,
-	0xa9, 0x7f, 0x46, 0xc5, 0x11, 0x5a, 0xec, 0x25, 0x13, 0xef, 0x7d, 0xf7, 0x7e, 0xe2, 0xdd, 0xa7,
-	0x83, 0x4e, 0x74, 0x5f, 0x98, 0x69, 0xc6, 0x0a, 0x86, 0xdb, 0x5c, 0x06, 0x7d, 0x3b, 0x4e, 0x8a,
-	0xfb, 0x6d, 0x60, 0x86, 0xec, 0x9b, 0xb5, 0x4e, 0x82, 0xd4, 0x4e, 0xad, 0x98, 0x8d, 0x2a, 0x35,
-	0xca, 0x68, 0xc8, 0xb2, 0xc8, 0x4a, 0x03, 0xab, 0x52, 0x15, 0xdb, 0x1f, 0x35, 0x98, 0x98, 0xc5,
-	0xcc, 0xe2, 0xe3, 0x60, 0xfb, 0x85, 0x3b, 0x6e, 0xb8, 0xaa, 0xe2, 0xc3, 0x3f, 0x12, 0xbc, 0xfa,
-	0x44, 0xf3, 0x7c, 0x15, 0x53, 0x6c, 0x81, 0x54, 0xec, 0x52, 0xaa, 0x21, 0x1d, 0x19, 0x3d, 0xfb,
-	0xb5, 0x59, 0xb5, 0x30, 0x9f, 0x8f, 0xeb, 0xef, 0x62, 0x97, 0x52, 0xc2, 0x83, 0xd8, 0x80, 0xb3,
-	0x70, 0xbd, 0xcd, 0x0b, 0x9a, 0x5d, 0xd3, 0x07, 0xba, 0x26, 0xab, 0xef, 0x1a, 0xe8, 0xc8, 0x68,
-	0x91, 0xe3, 0x31, 0x56, 0x41, 0xfc, 0x4a, 0x77, 0x9a, 0xa0, 0x23, 0x43, 0x21, 0xa5, 0xc4, 0x6f,
-	0xa0, 0x5d, 0xf5, 0xd6, 0x44, 0x1d, 0x19, 0xb2, 0x7d, 0x6e, 0xd6, 0xd7, 0x08, 0x4c, 0xc2, 0x15,
-	0x79, 0x0e, 0xe0, 0x77, 0x20, 0x87, 0x6b, 0x96, 0xd3, 0x6c, 0x4e, 0x69, 0x96, 0x6b, 0xa7, 0xba,
-	0x68, 0xc8, 0xf6, 0xc5, 0x71, 0xbd, 0xf2, 0x70, 0x22, 0x3d, 0x3e, 0x5d, 0x9e, 0x90, 0x66, 0x1c,
-	0x7f, 0x80, 0x6e, 0x9a, 0xb1, 0x87, 0x24, 0xaa, 0xf9, 0xce, 0x8b, 0xfc, 0x21, 0xd0, 0xff, 0x81,
-	0x40, 0x2a, 0x15, 0x1e, 0x82, 0x90, 0x44, 0x7c, 0x3d, 0xca, 0x04, 0x97, 0xc9, 0x5f, 0x4f, 0x97,
-	0x10, 0xec, 0x0a, 0x7a, 0x53, 0x64, 0xc9, 0x26, 0x26, 0x42, 0x12, 0xe1, 0x0b, 0x68, 0xad, 0xa2,
-	0x28, 0xcb, 0x35, 0x41, 0x17, 0x0d, 0x85, 0x54, 0x06, 0xbf, 0x07, 0x08, 0xd9, 0x66, 0x43, 0xc3,
-	0x22, 0x61, 0x1b, 0x7e, 0xe3, 0x9e, 0x3d, 0x38, 0x6e, 0xe0, 0xfc, 0x4b, 0xf0, 0x1d, 0x37, 0x88,
-	0x61, 0x02, 0x72, 0x63, 0xfd, 0xb8, 0x0b, 0x9d, 0xf9, 0xed, 0x62, 0x79, 0x37, 0xbe, 0xbe, 0xf5,
-	0xd4, 0x93, 0xd2, 0x5e, 0x79, 0xb5, 0x45, 0x58, 0x05, 0x65, 0xec, 0xba, 0xcb, 0x39, 0x99, 0xdd,
-	0x4d, 0x5d, 0x8f, 0xa8, 0x02, 0x3e, 0x87, 0x6e, 0x19, 0xa8, 0x27, 0x37, 0xaa, 0x58, 0x32, 0x1f,
-	0xa7, 0xbe, 0xbb, 0xf4, 0x67, 0xae, 0xa7, 0x4a, 0xf8, 0x14, 0xa4, 0xf9, 0xd4, 0xbf, 0x52, 0x5b,
-	0xc3, 0xcf, 0xd0, 0x3b, 0x2c, 0x52, 0xd2, 0xfe, 0x6c, 0xb1, 0x74, 0x66, 0xbe, 0xef, 0x39, 0x0b,
-	0xcf, 0xad, 0xfe, 0xf8, 0xdf, 0x22, 0x7c, 0x06, 0xb2, 0x33, 0xf6, 0xeb, 0x84, 0x2a, 0x60, 0x0c,
-	0x3d, 0x67, 0xec, 0x37, 0x28, 0x55, 0x9c, 0x28, 0x8f, 0xfb, 0x01, 0xfa, 0xb9, 0x1f, 0xa0, 0xdf,
-	0xfb, 0x01, 0x0a, 0xda, 0xfc, 0xfd, 0xbd, 0xfd, 0x1b, 0x00, 0x00, 0xff, 0xff, 0x8d, 0x1a, 0xa1,
-	0xbe, 0xf7, 0x02, 0x00, 0x00,
+	// 626 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x54, 0xcd, 0x6e, 0x9b, 0x40,
+	0x10, 0xce, 0x1a, 0xe2, 0xc4, 0x03, 0x71, 0xc8, 0x2a, 0x4a, 0x90, 0x53, 0x39, 0x88, 0x13, 0x3d,
+	0xc4, 0x96, 0xdc, 0x6b, 0x55, 0xd5, 0xb1, 0x69, 0x64, 0x29, 0xc5, 0xd6, 0x86, 0xa4, 0xea, 0xc9,
+	0x32, 0xb0, 0x25, 0xa8, 0x0e, 0x20, 0xd8, 0xa4, 0xf5, 0xeb, 0x54, 0x7d, 0x89, 0xbe, 0x41, 0x8e,
+	0x3d, 0xf7, 0x10, 0x55, 0x7e, 0x92, 0x8a, 0x05, 0x6a, 0xec, 0xb6, 0xea, 0x89, 0xf9, 0x66, 0xbe,
+	0x6f, 0x7e, 0x76, 0x46, 0x40, 0xc3, 0xbb, 0x65, 0x9d, 0x38, 0x89, 0x58, 0x84, 0xeb, 0xdc, 0x74,
+	0x5a, 0x3d, 0x3f, 0x60, 0xb7, 0xf7, 0x4e, 0xc7, 0x8d, 0xee, 0xba, 0xf3, 0xc0, 0x89, 0x7b, 0x71,
+	0xd7, 0x8f, 0xce, 0x72, 0xeb, 0x2c, 0xa1, 0x6e, 0x94, 0x78, 0xdd, 0xd8, 0xe9, 0xe6, 0x56, 0xae,
+	0x6d, 0x9d, 0x55, 0x34, 0x7e, 0xe4, 0x47, 0x5d, 0xee, 0x76, 0xee, 0x3f, 0x70, 0xc4, 0x01, 0xb7,
+	0x72, 0xba, 0xfe, 0xad, 0x0e, 0x3b, 0x6f, 0x69, 0x9a, 0xce, 0x7c, 0x8a, 0xbb, 0x20, 0xb2, 0x45,
+	0x4c, 0x55, 0xa4, 0x21, 0xa3, 0xd9, 0x3b, 0xe9, 0xe4, 0x5d, 0x74, 0x8a, 0x70, 0xf9, 0xb5, 0x17,
+	0x31, 0x25, 0x9c, 0x88, 0x0d, 0xd8, 0x77, 0xe7, 0xf7, 0x29, 0xa3, 0xc9, 0x25, 0x7d, 0xa0, 0x73,
+	0x32, 0xfb, 0xa4, 0x82, 0x86, 0x8c, 0x6d, 0xb2, 0xe9, 0xc6, 0x0a, 0x08, 0x1f, 0xe9, 0x42, 0xad,
+	0x69, 0xc8, 0x90, 0x49, 0x66, 0xe2, 0xe7, 0x50, 0xcf, 0xfb, 0x56, 0x05, 0x0d, 0x19, 0x52, 0xef,
+	0xa0, 0x53, 0x8e, 0xe1, 0x74, 0x08, 0xb7, 0x48, 0x41, 0xc0, 0x2f, 0x41, 0x72, 0xe7, 0x51, 0x4a,
+	0x93, 0x09, 0xa5, 0x49, 0xaa, 0xee, 0x6a, 0x82, 0x21, 0xf5, 0x0e, 0x37, 0xdb, 0xcb, 0x82, 0xe7,
+	0xe2, 0xe3, 0xd3, 0xe9, 0x16, 0xa9, 0xd2, 0xf1, 0x6b, 0xd8, 0x8b, 0x93, 0xe8, 0x21, 0xf0, 0x4a,
+	0x7d, 0xe3, 0xbf, 0xfa, 0x75, 0x01, 0x1e, 0x03, 0xa6, 0xa1, 0x9b, 0x2c, 0x62, 0x46, 0xbd, 0x49,
+	0x11, 0x49, 0x55, 0x89, 0xa7, 0x39, 0x2d, 0xd3, 0x98, 0x9b, 0x8c, 0x62, 0x88, 0xbf, 0x48, 0xb1,
+	0x06, 0x52, 0x59, 0xc1, 0x66, 0x73, 0x55, 0xd6, 0x90, 0x21, 0x92, 0xaa, 0xab, 0xf5, 0x15, 0x81,
+	0x98, 0x15, 0xc7, 0x3a, 0xd4, 0x02, 0x8f, 0x6f, 0x44, 0x3e, 0xc7, 0x59, 0x73, 0x3f, 0x9e, 0x4e,
+	0xc1, 0x59, 0x30, 0x7a, 0xc5, 0x92, 0x20, 0xf4, 0x49, 0x2d, 0xf0, 0xf0, 0x21, 0x6c, 0xcf, 0x3c,
+	0x2f, 0x49, 0xd5, 0x9a, 0x26, 0x18, 0x32, 0xc9, 0x01, 0x7e, 0x05, 0xe0, 0x46, 0x61, 0x48, 0x5d,
+	0x16, 0x44, 0x21, 0x7f, 0xe4, 0x66, 0xaf, 0xbd, 0x39, 0xf4, 0xe0, 0x37, 0x83, 0xaf, 0xb5, 0xa2,
+	0xc0, 0x3a, 0xc8, 0x69, 0xe0, 0x87, 0xd4, 0xcb, 0x07, 0x51, 0x45, 0xbe, 0xbb, 0x35, 0x9f, 0xfe,
+	0x05, 0x81, 0x54, 0x39, 0x0b, 0xbc, 0x07, 0x8d, 0xc9, 0xb5, 0x3d, 0xbd, 0xe9, 0x5f, 0x5e, 0x9b,
+	0xca, 0x56, 0x06, 0x2f, 0xcc, 0x12, 0x22, 0xac, 0x80, 0xdc, 0x1f, 0x0e, 0xa7, 0x13, 0x32, 0xbe,
+	0x19, 0x0d, 0x4d, 0xa2, 0xd4, 0xf0, 0x01, 0xec, 0x65, 0x84, 0xd2, 0x73, 0xa5, 0x08, 0x99, 0xe6,
+	0xcd, 0xc8, 0x1a, 0x4e, 0xad, 0xf1, 0xd0, 0x54, 0x44, 0xbc, 0x0b, 0xe2, 0x64, 0x64, 0x5d, 0x28,
+	0xdb, 0xb8, 0x05, 0x47, 0x99, 0xda, 0xb4, 0x06, 0xe4, 0xfd, 0xc4, 0x36, 0x2b, 0x79, 0xea, 0xf8,
+	0x04, 0x8e, 0xb3, 0x3c, 0x7f, 0xc6, 0xae, 0x94, 0x1d, 0xfd, 0x1d, 0x34, 0xd7, 0xc7, 0xcc, 0xca,
+	0x5a, 0x63, 0x7b, 0x3a, 0x18, 0x5b, 0x96, 0x39, 0xb0, 0xcd, 0x61, 0xde, 0xea, 0x0a, 0x22, 0xbc,
+	0x0f, 0xd2, 0xa0, 0x6f, 0x95, 0x0c, 0xa5, 0x86, 0x31, 0x34, 0x07, 0x7d, 0xab, 0xa2, 0x52, 0x04,
+	0x3d, 0x84, 0xe6, 0xfa, 0xb2, 0xcb, 0x33, 0x47, 0xab, 0x33, 0x3f, 0x82, 0x7a, 0x4c, 0x69, 0x32,
+	0xf2, 0x8a, 0xdb, 0x2f, 0xd0, 0x6a, 0x67, 0x42, 0x75, 0x67, 0xcf, 0xa0, 0xc1, 0x82, 0x3b, 0x9a,
+	0xb2, 0xd9, 0x5d, 0xcc, 0x1f, 0x5c, 0x20, 0x2b, 0x87, 0x3e, 0x86, 0xe3, 0x7f, 0x5c, 0x59, 0x96,
+	0x2e, 0x8c, 0x42, 0x97, 0x16, 0xa5, 0x73, 0x80, 0xdb, 0x00, 0x6e, 0x10, 0xdf, 0xd2, 0x84, 0xd1,
+	0xcf, 0xac, 0x68, 0xa0, 0xe2, 0x39, 0x97, 0x1f, 0x97, 0x6d, 0xf4, 0x7d, 0xd9, 0x46, 0x3f, 0x97,
+	0x6d, 0xe4, 0xd4, 0xf9, 0x1f, 0xe1, 0xc5, 0xaf, 0x01, 0x00, 0x60, 0x30, 0x44, 0xda, 0x89, 0x04,
+	0x00, 0x00,
 }
 
 func (m *Message) Marshal() (dAtA []byte, err error) {
@@ -322,6 +510,25 @@ func (m *Message) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.ProviderTtl != 0 {
+		i = encodeVarintDht(dAtA, i, uint64(m.ProviderTtl))
+		i--
+		dAtA[i] = 0x60
+	}
+	if len(m.EncryptedProviders) > 0 {
+		for iNdEx := len(m.EncryptedProviders) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.EncryptedProviders[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintDht(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x5a
+		}
+	}
 	if m.ClusterLevelRaw != 0 {
 		i = encodeVarintDht(dAtA, i, uint64(m.ClusterLevelRaw))
 		i--
@@ -406,6 +613,13 @@ func (m *Message_Peer) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if len(m.SignedRecord) > 0 {
+		i -= len(m.SignedRecord)
+		copy(dAtA[i:], m.SignedRecord)
+		i = encodeVarintDht(dAtA, i, uint64(len(m.SignedRecord)))
+		i--
+		dAtA[i] = 0x22
+	}
 	if m.Connection != 0 {
 		i = encodeVarintDht(dAtA, i, uint64(m.Connection))
 		i--
@@ -433,6 +647,102 @@ func (m *Message_Peer) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *ProviderRecord) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ProviderRecord) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ProviderRecord) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Timestamp != 0 {
+		i = encodeVarintDht(dAtA, i, uint64(m.Timestamp))
+		i--
+		dAtA[i] = 0x20
+	}
+	if len(m.Addrs) > 0 {
+		for iNdEx := len(m.Addrs) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Addrs[iNdEx])
+			copy(dAtA[i:], m.Addrs[iNdEx])
+			i = encodeVarintDht(dAtA, i, uint64(len(m.Addrs[iNdEx])))
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if len(m.PeerId) > 0 {
+		i -= len(m.PeerId)
+		copy(dAtA[i:], m.PeerId)
+		i = encodeVarintDht(dAtA, i, uint64(len(m.PeerId)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Key) > 0 {
+		i -= len(m.Key)
+		copy(dAtA[i:], m.Key)
+		i = encodeVarintDht(dAtA, i, uint64(len(m.Key)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *EncryptedProviderRecord) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *EncryptedProviderRecord) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *EncryptedProviderRecord) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Ciphertext) > 0 {
+		i -= len(m.Ciphertext)
+		copy(dAtA[i:], m.Ciphertext)
+		i = encodeVarintDht(dAtA, i, uint64(len(m.Ciphertext)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Nonce) > 0 {
+		i -= len(m.Nonce)
+		copy(dAtA[i:], m.Nonce)
+		i = encodeVarintDht(dAtA, i, uint64(len(m.Nonce)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintDht(dAtA []byte, offset int, v uint64) int {
 	offset -= sovDht(v)
 	base := offset
@@ -476,6 +786,15 @@ func (m *Message) Size() (n int) {
 	if m.ClusterLevelRaw != 0 {
 		n += 1 + sovDht(uint64(m.ClusterLevelRaw))
 	}
+	if len(m.EncryptedProviders) > 0 {
+		for _, e := range m.EncryptedProviders {
+			l = e.Size()
+			n += 1 + l + sovDht(uint64(l))
+		}
+	}
+	if m.ProviderTtl != 0 {
+		n += 1 + sovDht(uint64(m.ProviderTtl))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -499,6 +818,59 @@ func (m *Message_Peer) Size() (n int) {
 	if m.Connection != 0 {
 		n += 1 + sovDht(uint64(m.Connection))
 	}
+	l = len(m.SignedRecord)
+	if l > 0 {
+		n += 1 + l + sovDht(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *ProviderRecord) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Key)
+	if l > 0 {
+		n += 1 + l + sovDht(uint64(l))
+	}
+	l = len(m.PeerId)
+	if l > 0 {
+		n += 1 + l + sovDht(uint64(l))
+	}
+	if len(m.Addrs) > 0 {
+		for _, b := range m.Addrs {
+			l = len(b)
+			n += 1 + l + sovDht(uint64(l))
+		}
+	}
+	if m.Timestamp != 0 {
+		n += 1 + sovDht(uint64(m.Timestamp))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *EncryptedProviderRecord) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Nonce)
+	if l > 0 {
+		n += 1 + l + sovDht(uint64(l))
+	}
+	l = len(m.Ciphertext)
+	if l > 0 {
+		n += 1 + l + sovDht(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -716,20 +1088,73 @@ func (m *Message) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
-		default:
-			iNdEx = preIndex
-			skippy, err := skipDht(dAtA[iNdEx:])
-			if err != nil {
-				return err
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EncryptedProviders", wireType)
 			}
-			if (skippy < 0) || (iNdEx+skippy) < 0 {
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
 				return ErrInvalidLengthDht
 			}
-			if (iNdEx + skippy) > l {
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDht
+			}
+			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
-			iNdEx += skippy
+			m.EncryptedProviders = append(m.EncryptedProviders, &EncryptedProviderRecord{})
+			if err := m.EncryptedProviders[len(m.EncryptedProviders)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ProviderTtl", wireType)
+			}
+			m.ProviderTtl = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ProviderTtl |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipDht(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthDht
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
 		}
 	}
 
@@ -851,6 +1276,329 @@ func (m *Message_Peer) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignedRecord", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDht
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDht
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignedRecord = append(m.SignedRecord[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignedRecord == nil {
+				m.SignedRecord = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipDht(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthDht
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ProviderRecord) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowDht
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ProviderRecord: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ProviderRecord: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDht
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDht
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = append(m.Key[:0], dAtA[iNdEx:postIndex]...)
+			if m.Key == nil {
+				m.Key = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PeerId", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDht
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDht
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PeerId = append(m.PeerId[:0], dAtA[iNdEx:postIndex]...)
+			if m.PeerId == nil {
+				m.PeerId = []byte{}
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Addrs", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDht
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDht
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Addrs = append(m.Addrs, make([]byte, postIndex-iNdEx))
+			copy(m.Addrs[len(m.Addrs)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			m.Timestamp = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Timestamp |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipDht(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthDht
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *EncryptedProviderRecord) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowDht
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: EncryptedProviderRecord: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: EncryptedProviderRecord: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Nonce", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDht
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDht
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Nonce = append(m.Nonce[:0], dAtA[iNdEx:postIndex]...)
+			if m.Nonce == nil {
+				m.Nonce = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Ciphertext", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDht
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDht
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDht
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Ciphertext = append(m.Ciphertext[:0], dAtA[iNdEx:postIndex]...)
+			if m.Ciphertext == nil {
+				m.Ciphertext = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipDht(dAtA[iNdEx:])
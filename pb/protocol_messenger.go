@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	logging "github.com/ipfs/go-log/v2"
 	recpb "github.com/libp2p/go-libp2p-record/pb"
@@ -178,7 +179,14 @@ func (pm *ProtocolMessenger) PutProvider(ctx context.Context, p peer.ID, key mul
 }
 
 // PutProviderAddrs asks a peer to store that we are a provider for the given key.
-func (pm *ProtocolMessenger) PutProviderAddrs(ctx context.Context, p peer.ID, key multihash.Multihash, self peer.AddrInfo) (err error) {
+func (pm *ProtocolMessenger) PutProviderAddrs(ctx context.Context, p peer.ID, key multihash.Multihash, self peer.AddrInfo) error {
+	return pm.PutSignedProviderAddrs(ctx, p, key, self, nil)
+}
+
+// PutSignedProviderAddrs asks a peer to store that we are a provider for the given key, attaching
+// the given signed provider record (a marshaled record envelope) so that the peer can relay it.
+// Peers that don't support signed provider records ignore it.
+func (pm *ProtocolMessenger) PutSignedProviderAddrs(ctx context.Context, p peer.ID, key multihash.Multihash, self peer.AddrInfo, signedRecord []byte) (err error) {
 	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.PutProvider")
 	defer span.End()
 	if span.IsRecording() {
@@ -198,6 +206,11 @@ func (pm *ProtocolMessenger) PutProviderAddrs(ctx context.Context, p peer.ID, ke
 
 	pmes := NewMessage(Message_ADD_PROVIDER, key, 0)
 	pmes.ProviderPeers = RawPeerInfosToPBPeers([]peer.AddrInfo{self})
+	pmes.ProviderPeers[0].SignedRecord = signedRecord
+	// ask for the TTL requested for our own store, see providers.ContextWithTTL
+	if ttl, _ := ctx.Value(internal.ProviderTTLKey{}).(time.Duration); ttl > 0 {
+		pmes.ProviderTtl = uint64((ttl + time.Second - 1) / time.Second)
+	}
 
 	return pm.m.SendMessage(ctx, p, pmes)
 }
@@ -226,14 +239,84 @@ func (pm *ProtocolMessenger) GetProviders(ctx context.Context, p peer.ID, key mu
 		}()
 	}
 
+	provs, _, closerPeers, err = pm.getProviders(ctx, p, key)
+	return provs, closerPeers, err
+}
+
+// GetSignedProviders is like GetProviders, but also returns the signed provider records the peer
+// sent along with the providers. signed is indexed like provs, with nil entries for the providers
+// that came without a record. The records are not verified.
+func (pm *ProtocolMessenger) GetSignedProviders(ctx context.Context, p peer.ID, key multihash.Multihash) (provs []*peer.AddrInfo, signed [][]byte, closerPeers []*peer.AddrInfo, err error) {
+	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.GetSignedProviders")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Stringer("to", p), attribute.Stringer("key", key))
+		defer func() {
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}()
+	}
+
+	return pm.getProviders(ctx, p, key)
+}
+
+func (pm *ProtocolMessenger) getProviders(ctx context.Context, p peer.ID, key multihash.Multihash) (provs []*peer.AddrInfo, signed [][]byte, closerPeers []*peer.AddrInfo, err error) {
 	pmes := NewMessage(Message_GET_PROVIDERS, key, 0)
 	respMsg, err := pm.m.SendRequest(ctx, p, pmes)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	provPeers := respMsg.GetProviderPeers()
+	provs = PBPeersToPeerInfos(provPeers)
+	signed = make([][]byte, len(provPeers))
+	for i, pbp := range provPeers {
+		signed[i] = pbp.GetSignedRecord()
 	}
-	provs = PBPeersToPeerInfos(respMsg.GetProviderPeers())
 	closerPeers = PBPeersToPeerInfos(respMsg.GetCloserPeers())
-	return provs, closerPeers, nil
+	return provs, signed, closerPeers, nil
+}
+
+// PutEncryptedProvider asks a peer to store an encrypted provider record under the given double
+// hashed key. Only peers supporting double hashed provider records understand the request.
+func (pm *ProtocolMessenger) PutEncryptedProvider(ctx context.Context, p peer.ID, key multihash.Multihash, rec *EncryptedProviderRecord) (err error) {
+	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.PutEncryptedProvider")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Stringer("to", p), attribute.Stringer("key", key))
+		defer func() {
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}()
+	}
+
+	pmes := NewMessage(Message_ADD_ENCRYPTED_PROVIDER, key, 0)
+	pmes.EncryptedProviders = []*EncryptedProviderRecord{rec}
+	return pm.m.SendMessage(ctx, p, pmes)
+}
+
+// GetEncryptedProviders asks a peer for the encrypted provider records it knows of for the given
+// double hashed key. Also returns the K closest peers to the key as described in GetClosestPeers.
+// The records are not decrypted.
+func (pm *ProtocolMessenger) GetEncryptedProviders(ctx context.Context, p peer.ID, key multihash.Multihash) (recs []*EncryptedProviderRecord, closerPeers []*peer.AddrInfo, err error) {
+	ctx, span := internal.StartSpan(ctx, "ProtocolMessenger.GetEncryptedProviders")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Stringer("to", p), attribute.Stringer("key", key))
+		defer func() {
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}()
+	}
+
+	pmes := NewMessage(Message_GET_ENCRYPTED_PROVIDERS, key, 0)
+	respMsg, err := pm.m.SendRequest(ctx, p, pmes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return respMsg.GetEncryptedProviders(), PBPeersToPeerInfos(respMsg.GetCloserPeers()), nil
 }
 
 // Ping sends a ping message to the passed peer and waits for a response.
@@ -0,0 +1,223 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-base32"
+	"github.com/multiformats/go-multihash"
+)
+
+// Double hashed provider records let readers look up the providers of a multihash without
+// revealing it to the peers they query. The records are stored under the double hash of the
+// multihash, and their content is encrypted with a key derived from the multihash, so that only
+// the peers that already know the multihash can read them.
+const (
+	doubleHashPrefix    = "CR_DOUBLEHASH\n"
+	encryptionKeyPrefix = "CR_ENCRYPTIONKEY\n"
+)
+
+// EncryptedProvidersKeyPrefix is the namespace of the datastore keys used by the
+// EncryptedProviderStore.
+const EncryptedProvidersKeyPrefix = "/encrypted-providers/"
+
+// DoubleHash returns the key the encrypted provider records of mh are stored under, the SHA2-256
+// multihash of mh.
+func DoubleHash(mh multihash.Multihash) multihash.Multihash {
+	h := sha256.Sum256(append([]byte(doubleHashPrefix), mh...))
+	dh, err := multihash.Encode(h[:], multihash.SHA2_256)
+	if err != nil {
+		panic(err) // SHA2_256 digests always encode
+	}
+	return dh
+}
+
+func encryptionKey(mh multihash.Multihash) []byte {
+	k := sha256.Sum256(append([]byte(encryptionKeyPrefix), mh...))
+	return k[:]
+}
+
+func newProviderRecordCipher(mh multihash.Multihash) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey(mh))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptProviderRecord encrypts the provider record of prov for mh, signed with the private key of
+// the provider. The record is bound to the double hash of mh, under which it must be stored.
+func EncryptProviderRecord(mh multihash.Multihash, prov peer.AddrInfo, sk crypto.PrivKey) (*pb.EncryptedProviderRecord, error) {
+	aead, err := newProviderRecordCipher(mh)
+	if err != nil {
+		return nil, err
+	}
+	dh := DoubleHash(mh)
+	env, err := SealProviderRecord(dh, prov, sk)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := env.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &pb.EncryptedProviderRecord{
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, dh),
+	}, nil
+}
+
+// DecryptProviderRecord decrypts a provider record of mh, as returned by EncryptProviderRecord. The
+// peers storing encrypted records can't tell who they are for, the record is only accepted if it is
+// signed by the provider it announces.
+func DecryptProviderRecord(mh multihash.Multihash, rec *pb.EncryptedProviderRecord) (peer.AddrInfo, error) {
+	aead, err := newProviderRecordCipher(mh)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if len(rec.GetNonce()) != aead.NonceSize() {
+		return peer.AddrInfo{}, fmt.Errorf("invalid nonce size %d", len(rec.GetNonce()))
+	}
+	dh := DoubleHash(mh)
+	plaintext, err := aead.Open(nil, rec.GetNonce(), rec.GetCiphertext(), dh)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+
+	_, prov, err := ConsumeProviderRecord(plaintext, dh)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	return prov.Provider, nil
+}
+
+// EncryptedProviderStore keeps the encrypted provider records received by the DHT, in a datastore
+// namespace of its own. Records are opaque to the store, they are indexed by double hashed key and
+// by the peer that sent them, and expire after ProvideValidity.
+type EncryptedProviderStore struct {
+	dstore ds.Datastore
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEncryptedProviderStore returns an EncryptedProviderStore keeping its records in dstore.
+func NewEncryptedProviderStore(dstore ds.Datastore) *EncryptedProviderStore {
+	s := &EncryptedProviderStore{dstore: dstore}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(defaultCleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := s.removeExpired(s.ctx, now); err != nil && s.ctx.Err() == nil {
+					log.Error("failed to remove expired encrypted provider records: ", err)
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Close stops the garbage collection.
+func (s *EncryptedProviderStore) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// AddEncryptedProvider stores the encrypted provider record sent by from for the double hashed key,
+// replacing the record previously sent by the same peer.
+func (s *EncryptedProviderStore) AddEncryptedProvider(ctx context.Context, key []byte, from peer.ID, rec *pb.EncryptedProviderRecord) error {
+	data, err := rec.Marshal()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data))
+	n := binary.PutVarint(buf, time.Now().Add(ProvideValidity).UnixNano())
+	return s.dstore.Put(ctx, mkEncryptedProviderKey(key, from), append(buf[:n], data...))
+}
+
+// GetEncryptedProviders returns the unexpired encrypted provider records of the double hashed key.
+func (s *EncryptedProviderStore) GetEncryptedProviders(ctx context.Context, key []byte) ([]*pb.EncryptedProviderRecord, error) {
+	res, err := s.dstore.Query(ctx, dsq.Query{Prefix: EncryptedProvidersKeyPrefix + base32.RawStdEncoding.EncodeToString(key) + "/"})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	var recs []*pb.EncryptedProviderRecord
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		expiry, data, err := decodeEncryptedProviderEntry(e.Value)
+		if err != nil || !now.Before(expiry) {
+			continue
+		}
+		rec := new(pb.EncryptedProviderRecord)
+		if err := rec.Unmarshal(data); err != nil {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+func (s *EncryptedProviderStore) removeExpired(ctx context.Context, now time.Time) error {
+	res, err := s.dstore.Query(ctx, dsq.Query{Prefix: EncryptedProvidersKeyPrefix})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		if expiry, _, err := decodeEncryptedProviderEntry(e.Value); err == nil && now.Before(expiry) {
+			continue
+		}
+		if err := s.dstore.Delete(ctx, ds.RawKey(e.Key)); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func mkEncryptedProviderKey(key []byte, from peer.ID) ds.Key {
+	return ds.NewKey(EncryptedProvidersKeyPrefix + base32.RawStdEncoding.EncodeToString(key) + "/" + base32.RawStdEncoding.EncodeToString([]byte(from)))
+}
+
+func decodeEncryptedProviderEntry(v []byte) (time.Time, []byte, error) {
+	nsec, n := binary.Varint(v)
+	if n <= 0 {
+		return time.Time{}, nil, fmt.Errorf("failed to parse expiration time")
+	}
+	return time.Unix(0, nsec), v[n:], nil
+}
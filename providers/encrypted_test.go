@@ -0,0 +1,98 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	u "github.com/ipfs/boxo/util"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestEncryptedProviderRecord(t *testing.T) {
+	mh := multihash.Multihash(u.Hash([]byte("test")))
+	other := multihash.Multihash(u.Hash([]byte("other")))
+	prov, sk := newProviderIdentity(t)
+
+	if dh := DoubleHash(mh); dh.String() == mh.String() || dh.String() != DoubleHash(mh).String() {
+		t.Fatal("the double hash must be deterministic and differ from the key")
+	}
+
+	rec, err := EncryptProviderRecord(mh, prov, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptProviderRecord(mh, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != prov.ID || len(got.Addrs) != 1 || !got.Addrs[0].Equal(prov.Addrs[0]) {
+		t.Fatalf("decrypted %v, expected %v", got, prov)
+	}
+
+	if _, err := DecryptProviderRecord(other, rec); err == nil {
+		t.Fatal("decrypted a record with the key of another multihash")
+	}
+	rec.Ciphertext[0] ^= 1
+	if _, err := DecryptProviderRecord(mh, rec); err == nil {
+		t.Fatal("decrypted a tampered record")
+	}
+
+	// a peer can't announce records for another provider
+	_, otherSk := newProviderIdentity(t)
+	forged, err := EncryptProviderRecord(mh, prov, otherSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptProviderRecord(mh, forged); err == nil {
+		t.Fatal("decrypted a record not signed by its provider")
+	}
+}
+
+func TestEncryptedProviderStore(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	s := NewEncryptedProviderStore(dstore)
+	defer s.Close()
+
+	mh := multihash.Multihash(u.Hash([]byte("test")))
+	dh := DoubleHash(mh)
+	a, aSk := newProviderIdentity(t)
+	b, bSk := newProviderIdentity(t)
+	for _, p := range []struct {
+		prov peer.AddrInfo
+		sk   crypto.PrivKey
+	}{{a, aSk}, {b, bSk}, {a, aSk}} {
+		rec, err := EncryptProviderRecord(mh, p.prov, p.sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddEncryptedProvider(ctx, dh, p.prov.ID, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recs, err := s.GetEncryptedProviders(ctx, dh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected a record per sender, got %d", len(recs))
+	}
+	for _, rec := range recs {
+		if _, err := DecryptProviderRecord(mh, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the records live in their own namespace
+	if n := countKeys(t, dstore, EncryptedProvidersKeyPrefix); n != 2 {
+		t.Fatalf("expected 2 entries, got %d", n)
+	}
+	if n := countKeys(t, dstore, ProvidersKeyPrefix); n != 0 {
+		t.Fatalf("expected no classic provider records, got %d", n)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/libp2p/go-libp2p-kad-dht/internal"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
 	peerstoreImpl "github.com/libp2p/go-libp2p/p2p/host/peerstore"
 	"github.com/multiformats/go-base32"
 )
@@ -48,6 +49,31 @@ type ProviderStore interface {
 	io.Closer
 }
 
+// SignedProviderStore is a ProviderStore that also keeps signed provider records, so that they
+// can be handed out to other peers.
+type SignedProviderStore interface {
+	ProviderStore
+	// AddSignedProvider adds the provider announced by a verified signed provider record.
+	AddSignedProvider(ctx context.Context, key []byte, rec *record.Envelope) error
+	// GetSignedProviders returns the signed provider records known for the given key.
+	GetSignedProviders(ctx context.Context, key []byte) ([]*record.Envelope, error)
+}
+
+// Stats counts the provider records held by a provider store, including the expired records that
+// haven't been garbage collected yet.
+type Stats struct {
+	// Keys is the number of keys with at least one provider.
+	Keys int
+	// Records is the number of provider records, one per key and provider.
+	Records int
+}
+
+// StatsReporter is implemented by the provider stores that can count their records. Counting may
+// require a scan of the whole store.
+type StatsReporter interface {
+	Stats(ctx context.Context) (Stats, error)
+}
+
 // ProviderManager adds and pulls providers out of the datastore,
 // caching them in between
 type ProviderManager struct {
@@ -57,9 +83,14 @@ type ProviderManager struct {
 	cache  lru.LRUCache
 	pstore peerstore.Peerstore
 	dstore *autobatch.Datastore
+	// backend is the datastore under dstore, which is safe for concurrent use. Stats reads it
+	// outside of the run method, once dstore has been flushed.
+	backend ds.Batching
 
-	newprovs chan *addProv
-	getprovs chan *getProv
+	newprovs  chan *addProv
+	getprovs  chan *getProv
+	getsigned chan *getSignedProv
+	flushes   chan *flushReq
 
 	cleanupInterval time.Duration
 
@@ -68,7 +99,10 @@ type ProviderManager struct {
 	wg     sync.WaitGroup
 }
 
-var _ ProviderStore = (*ProviderManager)(nil)
+var (
+	_ SignedProviderStore = (*ProviderManager)(nil)
+	_ StatsReporter       = (*ProviderManager)(nil)
+)
 
 // Option is a function that sets a provider manager option.
 type Option func(*ProviderManager) error
@@ -104,6 +138,8 @@ type addProv struct {
 	ctx context.Context
 	key []byte
 	val peer.ID
+	// signed is the marshaled signed provider record, if any
+	signed []byte
 }
 
 type getProv struct {
@@ -112,14 +148,28 @@ type getProv struct {
 	resp chan []peer.ID
 }
 
+type getSignedProv struct {
+	ctx  context.Context
+	key  []byte
+	resp chan []*record.Envelope
+}
+
+type flushReq struct {
+	ctx  context.Context
+	resp chan error
+}
+
 // NewProviderManager constructor
 func NewProviderManager(local peer.ID, ps peerstore.Peerstore, dstore ds.Batching, opts ...Option) (*ProviderManager, error) {
 	pm := new(ProviderManager)
 	pm.self = local
 	pm.getprovs = make(chan *getProv)
 	pm.newprovs = make(chan *addProv)
+	pm.getsigned = make(chan *getSignedProv)
+	pm.flushes = make(chan *flushReq)
 	pm.pstore = ps
 	pm.dstore = autobatch.NewAutoBatching(dstore, batchBufferSize)
+	pm.backend = dstore
 	cache, err := lru.NewLRU(lruCacheSize, nil)
 	if err != nil {
 		return nil, err
@@ -159,7 +209,7 @@ func (pm *ProviderManager) run() {
 		for {
 			select {
 			case np := <-pm.newprovs:
-				err := pm.addProv(np.ctx, np.key, np.val)
+				err := pm.addProv(np.ctx, np.key, np.val, np.signed)
 				if err != nil {
 					log.Error("error adding new providers: ", err)
 					continue
@@ -177,6 +227,14 @@ func (pm *ProviderManager) run() {
 
 				// set the cap so the user can't append to this.
 				gp.resp <- provs[0:len(provs):len(provs)]
+			case gp := <-pm.getsigned:
+				recs, err := loadSignedProviders(gp.ctx, pm.dstore, gp.key)
+				if err != nil {
+					log.Error("error reading signed providers: ", err)
+				}
+				gp.resp <- recs
+			case f := <-pm.flushes:
+				f.resp <- pm.dstore.Flush(f.ctx)
 			case res, ok := <-gcQueryRes:
 				if !ok {
 					if err := gcQuery.Close(); err != nil {
@@ -267,24 +325,65 @@ func (pm *ProviderManager) AddProvider(ctx context.Context, k []byte, provInfo p
 	}
 }
 
+// AddSignedProvider adds the provider announced by the given signed provider record, which must
+// have been verified by the caller. The record is stored alongside the provider entry and expires
+// with it.
+func (pm *ProviderManager) AddSignedProvider(ctx context.Context, k []byte, env *record.Envelope) error {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.AddSignedProvider")
+	defer span.End()
+
+	rec := new(ProviderRecord)
+	if err := env.TypedRecord(rec); err != nil {
+		return err
+	}
+	signed, err := env.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if rec.Provider.ID != pm.self { // don't add own addrs.
+		pm.pstore.AddAddrs(rec.Provider.ID, rec.Provider.Addrs, ProviderAddrTTL)
+	}
+	prov := &addProv{
+		ctx:    ctx,
+		key:    k,
+		val:    rec.Provider.ID,
+		signed: signed,
+	}
+	select {
+	case pm.newprovs <- prov:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // addProv updates the cache if needed
-func (pm *ProviderManager) addProv(ctx context.Context, k []byte, p peer.ID) error {
+func (pm *ProviderManager) addProv(ctx context.Context, k []byte, p peer.ID, signed []byte) error {
 	now := time.Now()
 	if provs, ok := pm.cache.Get(string(k)); ok {
 		provs.(*providerSet).setVal(p, now)
 	} // else not cached, just write through
 
-	return writeProviderEntry(ctx, pm.dstore, k, p, now)
+	return writeSignedProviderEntry(ctx, pm.dstore, k, p, now, signed)
 }
 
 // writeProviderEntry writes the provider into the datastore
 func writeProviderEntry(ctx context.Context, dstore ds.Datastore, k []byte, p peer.ID, t time.Time) error {
+	return writeSignedProviderEntry(ctx, dstore, k, p, t, nil)
+}
+
+// writeSignedProviderEntry writes the provider into the datastore, along with its signed provider
+// record if there is one. The record is appended to the time value, which keeps the entry readable
+// by readTimeValue.
+func writeSignedProviderEntry(ctx context.Context, dstore ds.Datastore, k []byte, p peer.ID, t time.Time, signed []byte) error {
 	dsk := mkProvKeyFor(k, p)
 
-	buf := make([]byte, 16)
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(signed))
 	n := binary.PutVarint(buf, t.UnixNano())
+	buf = append(buf[:n], signed...)
 
-	return dstore.Put(ctx, ds.NewKey(dsk), buf[:n])
+	return dstore.Put(ctx, ds.NewKey(dsk), buf)
 }
 
 func mkProvKeyFor(k []byte, p peer.ID) string {
@@ -319,6 +418,77 @@ func (pm *ProviderManager) GetProviders(ctx context.Context, k []byte) ([]peer.A
 	}
 }
 
+// GetSignedProviders returns the signed provider records stored for the given key.
+func (pm *ProviderManager) GetSignedProviders(ctx context.Context, k []byte) ([]*record.Envelope, error) {
+	ctx, span := internal.StartSpan(ctx, "ProviderManager.GetSignedProviders")
+	defer span.End()
+
+	gp := &getSignedProv{
+		ctx:  ctx,
+		key:  k,
+		resp: make(chan []*record.Envelope, 1), // buffered to prevent sender from blocking
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case pm.getsigned <- gp:
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case recs := <-gp.resp:
+		return recs, nil
+	}
+}
+
+// Stats counts the provider records in the datastore. The records buffered for writing are flushed
+// first, the count itself doesn't hold up the other operations of the ProviderManager.
+func (pm *ProviderManager) Stats(ctx context.Context) (Stats, error) {
+	f := &flushReq{
+		ctx:  ctx,
+		resp: make(chan error, 1), // buffered to prevent sender from blocking
+	}
+	select {
+	case <-ctx.Done():
+		return Stats{}, ctx.Err()
+	case pm.flushes <- f:
+	}
+	select {
+	case <-ctx.Done():
+		return Stats{}, ctx.Err()
+	case err := <-f.resp:
+		if err != nil {
+			return Stats{}, err
+		}
+	}
+	return countProviderRecords(ctx, pm.backend, ProvidersKeyPrefix)
+}
+
+// countProviderRecords counts the provider records stored under prefix, as <prefix><key>/<provider>.
+func countProviderRecords(ctx context.Context, dstore ds.Read, prefix string) (Stats, error) {
+	res, err := dstore.Query(ctx, dsq.Query{Prefix: prefix, KeysOnly: true})
+	if err != nil {
+		return Stats{}, err
+	}
+	defer res.Close()
+
+	var stats Stats
+	keys := make(map[string]struct{})
+	for e := range res.Next() {
+		if e.Error != nil {
+			return Stats{}, e.Error
+		}
+		k := strings.TrimPrefix(e.Key, prefix)
+		if i := strings.IndexByte(k, '/'); i >= 0 {
+			k = k[:i]
+		}
+		keys[k] = struct{}{}
+		stats.Records++
+	}
+	stats.Keys = len(keys)
+	return stats, nil
+}
+
 func (pm *ProviderManager) getProvidersForKey(ctx context.Context, k []byte) ([]peer.ID, error) {
 	pset, err := pm.getProviderSetForKey(ctx, k)
 	if err != nil {
@@ -402,6 +572,47 @@ func loadProviderSet(ctx context.Context, dstore ds.Datastore, k []byte) (*provi
 	return out, nil
 }
 
+// loads the signed provider records out of the datastore. Expired entries are left for the
+// garbage collector to remove.
+func loadSignedProviders(ctx context.Context, dstore ds.Datastore, k []byte) ([]*record.Envelope, error) {
+	res, err := dstore.Query(ctx, dsq.Query{Prefix: mkProvKey(k)})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	var out []*record.Envelope
+	for {
+		e, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if e.Error != nil {
+			log.Error("got an error: ", e.Error)
+			continue
+		}
+
+		nsec, n := binary.Varint(e.Value)
+		if n <= 0 || len(e.Value) == n || now.Sub(time.Unix(0, nsec)) > ProvideValidity {
+			continue
+		}
+
+		rec := new(ProviderRecord)
+		env, err := record.ConsumeTypedEnvelope(e.Value[n:], rec)
+		if err != nil {
+			log.Error("parsing signed provider record from disk: ", err)
+			continue
+		}
+		if err := verifyProviderRecord(env, rec, k, now); err != nil {
+			continue
+		}
+		out = append(out, env)
+	}
+
+	return out, nil
+}
+
 func readTimeValue(data []byte) (time.Time, error) {
 	nsec, n := binary.Varint(data)
 	if n <= 0 {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -364,3 +365,92 @@ func TestWriteUpdatesCache(t *testing.T) {
 		t.Fatalf("expected h1 to be provided by 2 peers, is by %d", len(c1Provs))
 	}
 }
+
+func TestProviderManagerStats(t *testing.T) {
+	ctx := context.Background()
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderManager(peer.ID("testing"), ps, dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	a, b := u.Hash([]byte("a")), u.Hash([]byte("b"))
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("provider1")})
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("provider2")})
+	p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("provider2")})
+	p.AddProvider(ctx, b, peer.AddrInfo{ID: peer.ID("provider1")})
+
+	stats, err := p.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Keys: 2, Records: 3}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// blockingCountDatastore blocks the queries counting the provider records until release is closed.
+type blockingCountDatastore struct {
+	ds.Batching
+	counting chan struct{}
+	release  chan struct{}
+}
+
+func (d *blockingCountDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	if q.Prefix == ProvidersKeyPrefix && q.KeysOnly {
+		close(d.counting)
+		<-d.release
+	}
+	return d.Batching.Query(ctx, q)
+}
+
+func TestProviderManagerStatsDoesNotBlock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstore := &blockingCountDatastore{
+		Batching: dssync.MutexWrap(ds.NewMapDatastore()),
+		counting: make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	p, err := NewProviderManager(peer.ID("testing"), ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	var releaseOnce sync.Once
+	release := func() { releaseOnce.Do(func() { close(dstore.release) }) }
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Stats(ctx)
+		done <- err
+	}()
+	<-dstore.counting
+
+	// the provider manager keeps serving while the records are counted
+	a := u.Hash([]byte("a"))
+	if err := p.AddProvider(ctx, a, peer.AddrInfo{ID: peer.ID("provider")}); err != nil {
+		t.Fatal(err)
+	}
+	provs, err := p.GetProviders(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 1 {
+		t.Fatalf("expected 1 provider, got %d", len(provs))
+	}
+
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,130 @@
+package providers
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ProviderRecordEnvelopeDomain is the domain string used for provider records contained in an Envelope.
+const ProviderRecordEnvelopeDomain = "libp2p-kad-dht-provider-record"
+
+// ProviderRecordEnvelopePayloadType is the type hint used to identify provider records in an Envelope.
+var ProviderRecordEnvelopePayloadType = []byte("/libp2p/kad-dht/provider-record")
+
+// MaxProviderRecordClockSkew is how far in the future the timestamp of a signed provider record
+// may be before the record is rejected.
+var MaxProviderRecordClockSkew = 5 * time.Minute
+
+func init() {
+	record.RegisterType(&ProviderRecord{})
+}
+
+// ProviderRecord states that a peer provides a key, at the given addresses. Wrapped in an Envelope
+// signed by the provider, it can be relayed and cached by other peers without having to trust them.
+type ProviderRecord struct {
+	// Key is the key the peer provides.
+	Key []byte
+	// Provider is the providing peer and the addresses it can be reached at.
+	Provider peer.AddrInfo
+	// Timestamp is the time the record was signed at.
+	Timestamp time.Time
+}
+
+var _ record.Record = (*ProviderRecord)(nil)
+
+// Domain is used when signing and validating ProviderRecords contained in Envelopes.
+func (r *ProviderRecord) Domain() string {
+	return ProviderRecordEnvelopeDomain
+}
+
+// Codec is a binary identifier for the ProviderRecord type.
+func (r *ProviderRecord) Codec() []byte {
+	return ProviderRecordEnvelopePayloadType
+}
+
+// MarshalRecord serializes a ProviderRecord to a byte slice.
+func (r *ProviderRecord) MarshalRecord() ([]byte, error) {
+	msg := pb.ProviderRecord{
+		Key:       r.Key,
+		PeerId:    []byte(r.Provider.ID),
+		Addrs:     make([][]byte, len(r.Provider.Addrs)),
+		Timestamp: r.Timestamp.UnixNano(),
+	}
+	for i, a := range r.Provider.Addrs {
+		msg.Addrs[i] = a.Bytes()
+	}
+	return msg.Marshal()
+}
+
+// UnmarshalRecord parses a ProviderRecord from a byte slice.
+func (r *ProviderRecord) UnmarshalRecord(data []byte) error {
+	var msg pb.ProviderRecord
+	if err := msg.Unmarshal(data); err != nil {
+		return err
+	}
+	id, err := peer.IDFromBytes(msg.PeerId)
+	if err != nil {
+		return err
+	}
+	addrs := make([]ma.Multiaddr, 0, len(msg.Addrs))
+	for _, b := range msg.Addrs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			log.Debugw("error decoding multiaddr for provider", "peer", id, "error", err)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	r.Key = msg.Key
+	r.Provider = peer.AddrInfo{ID: id, Addrs: addrs}
+	r.Timestamp = time.Unix(0, msg.Timestamp)
+	return nil
+}
+
+// SealProviderRecord creates a provider record for the given key and signs it with the private key
+// of the provider.
+func SealProviderRecord(key []byte, prov peer.AddrInfo, sk crypto.PrivKey) (*record.Envelope, error) {
+	rec := &ProviderRecord{
+		Key:       key,
+		Provider:  prov,
+		Timestamp: time.Now(),
+	}
+	return record.Seal(rec, sk)
+}
+
+// ConsumeProviderRecord unmarshals a signed provider record and verifies that it is signed by the
+// provider it announces, that it is for the given key and that it hasn't expired.
+func ConsumeProviderRecord(data []byte, key []byte) (*record.Envelope, *ProviderRecord, error) {
+	rec := new(ProviderRecord)
+	env, err := record.ConsumeTypedEnvelope(data, rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyProviderRecord(env, rec, key, time.Now()); err != nil {
+		return nil, nil, err
+	}
+	return env, rec, nil
+}
+
+func verifyProviderRecord(env *record.Envelope, rec *ProviderRecord, key []byte, now time.Time) error {
+	if !bytes.Equal(rec.Key, key) {
+		return fmt.Errorf("provider record is for a different key")
+	}
+	if !rec.Provider.ID.MatchesPublicKey(env.PublicKey) {
+		return fmt.Errorf("provider record for %s is not signed by the provider", rec.Provider.ID)
+	}
+	if rec.Timestamp.After(now.Add(MaxProviderRecordClockSkew)) {
+		return fmt.Errorf("provider record for %s is from the future", rec.Provider.ID)
+	}
+	if now.Sub(rec.Timestamp) > ProvideValidity {
+		return fmt.Errorf("provider record for %s has expired", rec.Provider.ID)
+	}
+	return nil
+}
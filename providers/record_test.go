@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	u "github.com/ipfs/boxo/util"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func newProviderIdentity(t *testing.T) (peer.AddrInfo, crypto.PrivKey) {
+	t.Helper()
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer.AddrInfo{
+		ID:    id,
+		Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")},
+	}, sk
+}
+
+func TestProviderRecordRoundtrip(t *testing.T) {
+	key := u.Hash([]byte("test"))
+	prov, sk := newProviderIdentity(t)
+
+	env, err := SealProviderRecord(key, prov, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := env.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, rec, err := ConsumeProviderRecord(buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Provider.ID != prov.ID {
+		t.Fatalf("expected provider %s, got %s", prov.ID, rec.Provider.ID)
+	}
+	if len(rec.Provider.Addrs) != 1 || !rec.Provider.Addrs[0].Equal(prov.Addrs[0]) {
+		t.Fatalf("unexpected provider addresses %v", rec.Provider.Addrs)
+	}
+
+	if _, _, err := ConsumeProviderRecord(buf, u.Hash([]byte("other"))); err == nil {
+		t.Fatal("expected a record for another key to be rejected")
+	}
+}
+
+func TestProviderRecordVerification(t *testing.T) {
+	key := u.Hash([]byte("test"))
+	prov, _ := newProviderIdentity(t)
+	_, otherSk := newProviderIdentity(t)
+
+	// signed by someone else than the provider
+	env, err := SealProviderRecord(key, prov, otherSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := env.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ConsumeProviderRecord(buf, key); err == nil {
+		t.Fatal("expected a record not signed by the provider to be rejected")
+	}
+
+	now := time.Now()
+	rec := &ProviderRecord{Key: key, Provider: prov, Timestamp: now.Add(-ProvideValidity - time.Minute)}
+	env = &record.Envelope{PublicKey: otherSk.GetPublic()}
+	if err := verifyProviderRecord(env, rec, key, now); err == nil {
+		t.Fatal("expected an expired record to be rejected")
+	}
+	rec.Timestamp = now.Add(2 * MaxProviderRecordClockSkew)
+	if err := verifyProviderRecord(env, rec, key, now); err == nil {
+		t.Fatal("expected a record from the future to be rejected")
+	}
+}
+
+func TestSignedProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewProviderManager(peer.ID("testing"), ps, dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	key := u.Hash([]byte("test"))
+	signedProv, sk := newProviderIdentity(t)
+	env, err := SealProviderRecord(key, signedProv, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddSignedProvider(ctx, key, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddProvider(ctx, key, peer.AddrInfo{ID: peer.ID("unsigned")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// signed providers are regular providers too
+	provs, err := pm.GetProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(provs))
+	}
+
+	recs, err := pm.GetSignedProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || !recs[0].Equal(env) {
+		t.Fatalf("expected the signed provider record back, got %v", recs)
+	}
+
+	// an unsigned announcement replaces the signed one
+	if err := pm.AddProvider(ctx, key, signedProv); err != nil {
+		t.Fatal(err)
+	}
+	recs, err = pm.GetSignedProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Fatalf("expected no signed provider records, got %d", len(recs))
+	}
+}
@@ -0,0 +1,369 @@
+package providers
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	peerstoreImpl "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+	"github.com/multiformats/go-base32"
+)
+
+const (
+	// TTLProvidersKeyPrefix is the namespace of the datastore keys used by the TTLProviderStore.
+	TTLProvidersKeyPrefix = "/ttl-providers"
+
+	// ttlRecordsPrefix holds the provider records, as /records/<key>/<provider> -> expiration time.
+	ttlRecordsPrefix = TTLProvidersKeyPrefix + "/records/"
+	// ttlExpiryPrefix holds the expiration index, as /expiry/<expiration time>/<key>/<provider>.
+	// Expiration times are zero padded so that the index is ordered by time.
+	ttlExpiryPrefix = TTLProvidersKeyPrefix + "/expiry/"
+)
+
+var defaultTTLCleanupInterval = 5 * time.Minute
+
+// TTLPolicy chooses the TTL of a provider record. requested is the TTL asked for by the announcer,
+// zero if none. A non positive TTL rejects the record.
+type TTLPolicy func(key []byte, prov peer.ID, requested time.Duration) time.Duration
+
+// DefaultTTLPolicy grants the requested TTL up to ProvideValidity, and ProvideValidity to the
+// records announced without a TTL.
+func DefaultTTLPolicy(_ []byte, _ peer.ID, requested time.Duration) time.Duration {
+	if requested <= 0 || requested > ProvideValidity {
+		return ProvideValidity
+	}
+	return requested
+}
+
+// ContextWithTTL attaches the TTL requested for the provider records added with the returned
+// context. The ADD_PROVIDER requests sent with it ask the remote peers for the same TTL, which they
+// hand to their own store, rounded up to the second. Stores that don't support per-record TTLs
+// ignore it.
+func ContextWithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, internal.ProviderTTLKey{}, ttl)
+}
+
+func ttlFromContext(ctx context.Context) time.Duration {
+	ttl, _ := ctx.Value(internal.ProviderTTLKey{}).(time.Duration)
+	return ttl
+}
+
+// TTLProviderStore is a ProviderStore where every provider record carries its own TTL. Expired
+// records are removed through a time ordered index, so that the garbage collection only visits the
+// records that expired instead of scanning the whole store. The index is ordered by the datastore
+// keys, the store is therefore best used on top of a datastore with ordered keys (e.g. leveldb or
+// badger).
+type TTLProviderStore struct {
+	self   peer.ID
+	pstore peerstore.Peerstore
+	dstore ds.Datastore
+
+	policy          TTLPolicy
+	cleanupInterval time.Duration
+
+	// mu serializes the updates of the records with their index entries.
+	mu sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var (
+	_ ProviderStore = (*TTLProviderStore)(nil)
+	_ StatsReporter = (*TTLProviderStore)(nil)
+)
+
+// TTLStoreOption is a function that sets a TTLProviderStore option.
+type TTLStoreOption func(*TTLProviderStore) error
+
+// WithTTLPolicy sets the policy choosing the TTL of every provider record.
+// Defaults to DefaultTTLPolicy.
+func WithTTLPolicy(p TTLPolicy) TTLStoreOption {
+	return func(s *TTLProviderStore) error {
+		if p == nil {
+			return fmt.Errorf("the ttl policy must not be nil")
+		}
+		s.policy = p
+		return nil
+	}
+}
+
+// TTLCleanupInterval sets the time between the removals of the expired records.
+// Defaults to 5m.
+func TTLCleanupInterval(d time.Duration) TTLStoreOption {
+	return func(s *TTLProviderStore) error {
+		if d <= 0 {
+			return fmt.Errorf("cleanup interval must be positive, got %s", d)
+		}
+		s.cleanupInterval = d
+		return nil
+	}
+}
+
+// NewTTLProviderStore returns a TTLProviderStore keeping its records in dstore.
+func NewTTLProviderStore(local peer.ID, ps peerstore.Peerstore, dstore ds.Datastore, opts ...TTLStoreOption) (*TTLProviderStore, error) {
+	s := &TTLProviderStore{
+		self:            local,
+		pstore:          ps,
+		dstore:          dstore,
+		policy:          DefaultTTLPolicy,
+		cleanupInterval: defaultTTLCleanupInterval,
+	}
+	for i, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, fmt.Errorf("ttl provider store option %d failed: %s", i, err)
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.cleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := s.removeExpired(s.ctx, now); err != nil && s.ctx.Err() == nil {
+					log.Error("failed to remove expired provider records: ", err)
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Close stops the garbage collection.
+func (s *TTLProviderStore) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// AddProvider adds a provider, with the TTL requested in the context if any (see ContextWithTTL),
+// subject to the TTL policy.
+func (s *TTLProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	return s.AddProviderWithTTL(ctx, key, prov, ttlFromContext(ctx))
+}
+
+// AddProviderWithTTL adds a provider with the given requested TTL, subject to the TTL policy.
+func (s *TTLProviderStore) AddProviderWithTTL(ctx context.Context, key []byte, prov peer.AddrInfo, ttl time.Duration) error {
+	ctx, span := internal.StartSpan(ctx, "TTLProviderStore.AddProvider")
+	defer span.End()
+
+	ttl = s.policy(key, prov.ID, ttl)
+	if ttl <= 0 {
+		return nil
+	}
+	if prov.ID != s.self { // don't add own addrs.
+		addrTTL := ProviderAddrTTL
+		if ttl < addrTTL {
+			addrTTL = ttl
+		}
+		s.pstore.AddAddrs(prov.ID, prov.Addrs, addrTTL)
+	}
+	expiry := clampExpiry(time.Now().Add(ttl))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recKey := ds.NewKey(mkTTLRecordKey(key, prov.ID))
+	var oldIndexKey *ds.Key
+	if old, err := s.dstore.Get(ctx, recKey); err == nil {
+		if oldExpiry, err := readTimeValue(old); err == nil && !oldExpiry.Equal(expiry) {
+			k := ds.NewKey(mkTTLExpiryKey(oldExpiry, key, prov.ID))
+			oldIndexKey = &k
+		}
+	} else if err != ds.ErrNotFound {
+		return err
+	}
+
+	// The new index entry is written before the record and the old entry removed last, so that a
+	// record is always indexed even if the writes aren't committed together: at worst an index
+	// entry is left behind, which removeRecord drops once it expires.
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, expiry.UnixNano())
+	return s.write(ctx, func(w ds.Write) error {
+		if err := w.Put(ctx, ds.NewKey(mkTTLExpiryKey(expiry, key, prov.ID)), nil); err != nil {
+			return err
+		}
+		if err := w.Put(ctx, recKey, buf[:n]); err != nil {
+			return err
+		}
+		if oldIndexKey != nil {
+			if err := w.Delete(ctx, *oldIndexKey); err != nil && err != ds.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// write applies f to a batch of the datastore if it supports batching, so that the updates of a
+// record and its index entries are committed together, or directly to the datastore otherwise.
+func (s *TTLProviderStore) write(ctx context.Context, f func(w ds.Write) error) error {
+	b, ok := s.dstore.(ds.Batching)
+	if !ok {
+		return f(s.dstore)
+	}
+	batch, err := b.Batch(ctx)
+	if err == ds.ErrBatchUnsupported {
+		return f(s.dstore)
+	} else if err != nil {
+		return err
+	}
+	if err := f(batch); err != nil {
+		return err
+	}
+	return batch.Commit(ctx)
+}
+
+// GetProviders returns the providers of the given key whose records didn't expire.
+func (s *TTLProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	ctx, span := internal.StartSpan(ctx, "TTLProviderStore.GetProviders")
+	defer span.End()
+
+	prefix := ttlRecordsPrefix + base32.RawStdEncoding.EncodeToString(key) + "/"
+	res, err := s.dstore.Query(ctx, dsq.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	var provs []peer.ID
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		// expired records may not have been collected yet
+		expiry, err := readTimeValue(e.Value)
+		if err != nil || !now.Before(expiry) {
+			continue
+		}
+		p, err := base32.RawStdEncoding.DecodeString(e.Key[strings.LastIndex(e.Key, "/")+1:])
+		if err != nil {
+			continue
+		}
+		provs = append(provs, peer.ID(p))
+	}
+	return peerstoreImpl.PeerInfos(s.pstore, provs), nil
+}
+
+// removeExpired removes the records that expired by now. It walks the expiration index in time
+// order, and stops at the first record that is still valid.
+func (s *TTLProviderStore) removeExpired(ctx context.Context, now time.Time) error {
+	res, err := s.dstore.Query(ctx, dsq.Query{
+		Prefix:   ttlExpiryPrefix,
+		KeysOnly: true,
+		Orders:   []dsq.Order{dsq.OrderByKey{}},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		expiry, key, p, err := parseTTLExpiryKey(e.Key)
+		if err != nil {
+			log.Error("removing malformed provider record index entry: ", err)
+			if err := s.dstore.Delete(ctx, ds.RawKey(e.Key)); err != nil && err != ds.ErrNotFound {
+				return err
+			}
+			continue
+		}
+		if expiry.After(now) {
+			return nil
+		}
+		if err := s.removeRecord(ctx, e.Key, expiry, key, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRecord removes an expired index entry along with its record, unless the record was renewed.
+func (s *TTLProviderStore) removeRecord(ctx context.Context, indexKey string, expiry time.Time, key []byte, p peer.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recKey := ds.NewKey(mkTTLRecordKey(key, p))
+	cur, err := s.dstore.Get(ctx, recKey)
+	switch err {
+	case nil:
+		if t, err := readTimeValue(cur); err != nil || t.Equal(expiry) {
+			if err := s.dstore.Delete(ctx, recKey); err != nil && err != ds.ErrNotFound {
+				return err
+			}
+		}
+	case ds.ErrNotFound:
+	default:
+		return err
+	}
+	if err := s.dstore.Delete(ctx, ds.RawKey(indexKey)); err != nil && err != ds.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Stats counts the provider records in the store.
+func (s *TTLProviderStore) Stats(ctx context.Context) (Stats, error) {
+	return countProviderRecords(ctx, s.dstore, ttlRecordsPrefix)
+}
+
+// clampExpiry keeps expiration times within the range of positive unix nanoseconds, as the index
+// is only ordered by time for non negative times padded to the same length.
+func clampExpiry(t time.Time) time.Time {
+	if t.Before(time.Unix(0, 0)) {
+		return time.Unix(0, 0)
+	}
+	if max := time.Unix(0, math.MaxInt64); t.After(max) {
+		return max
+	}
+	return t
+}
+
+func mkTTLRecordKey(key []byte, p peer.ID) string {
+	return ttlRecordsPrefix + base32.RawStdEncoding.EncodeToString(key) + "/" + base32.RawStdEncoding.EncodeToString([]byte(p))
+}
+
+func mkTTLExpiryKey(expiry time.Time, key []byte, p peer.ID) string {
+	return fmt.Sprintf("%s%020d/%s/%s", ttlExpiryPrefix, expiry.UnixNano(),
+		base32.RawStdEncoding.EncodeToString(key), base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+func parseTTLExpiryKey(k string) (time.Time, []byte, peer.ID, error) {
+	parts := strings.Split(strings.TrimPrefix(k, ttlExpiryPrefix), "/")
+	if len(parts) != 3 {
+		return time.Time{}, nil, "", fmt.Errorf("invalid provider record index key %q", k)
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, nil, "", err
+	}
+	key, err := base32.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, nil, "", err
+	}
+	p, err := base32.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return time.Time{}, nil, "", err
+	}
+	return time.Unix(0, nsec), key, peer.ID(p), nil
+}
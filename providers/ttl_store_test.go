@@ -0,0 +1,227 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	u "github.com/ipfs/boxo/util"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+)
+
+func newTestTTLStore(t *testing.T, dstore ds.Datastore, opts ...TTLStoreOption) *TTLProviderStore {
+	t.Helper()
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewTTLProviderStore(peer.ID("testing"), ps, dstore, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func countKeys(t *testing.T, dstore ds.Datastore, prefix string) int {
+	t.Helper()
+	res, err := dstore.Query(context.Background(), dsq.Query{Prefix: prefix, KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestTTLProviderStore(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	s := newTestTTLStore(t, dstore)
+
+	key := u.Hash([]byte("test"))
+	if err := s.AddProviderWithTTL(ctx, key, peer.AddrInfo{ID: "short"}, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProvider(ContextWithTTL(ctx, time.Hour), key, peer.AddrInfo{ID: "long"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProvider(ctx, key, peer.AddrInfo{ID: "default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	provs, err := s.GetProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 3 {
+		t.Fatalf("expected 3 providers, got %d", len(provs))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// the expired record is no longer returned, even before being collected
+	provs, err = s.GetProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(provs))
+	}
+
+	if err := s.removeExpired(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, dstore, ttlRecordsPrefix); n != 2 {
+		t.Fatalf("expected 2 records left, got %d", n)
+	}
+	if n := countKeys(t, dstore, ttlExpiryPrefix); n != 2 {
+		t.Fatalf("expected 2 index entries left, got %d", n)
+	}
+
+	// the default policy caps the TTL at ProvideValidity
+	if err := s.removeExpired(ctx, time.Now().Add(ProvideValidity+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, dstore, TTLProvidersKeyPrefix); n != 0 {
+		t.Fatalf("expected an empty store, got %d entries", n)
+	}
+}
+
+func TestTTLProviderStoreRenew(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	s := newTestTTLStore(t, dstore)
+
+	key := u.Hash([]byte("test"))
+	prov := peer.AddrInfo{ID: "prov"}
+	if err := s.AddProviderWithTTL(ctx, key, prov, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProviderWithTTL(ctx, key, prov, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, dstore, ttlExpiryPrefix); n != 1 {
+		t.Fatalf("expected the renewed record to be indexed once, got %d entries", n)
+	}
+
+	if err := s.removeExpired(ctx, time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	provs, err := s.GetProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 1 {
+		t.Fatal("the renewed record was removed")
+	}
+}
+
+// failingRecordsDatastore fails the writes of the provider records once failing is set. It doesn't
+// support batching, so that the writes of a record and its index entries aren't atomic.
+type failingRecordsDatastore struct {
+	ds.Datastore
+	failing bool
+}
+
+func (d *failingRecordsDatastore) Put(ctx context.Context, k ds.Key, v []byte) error {
+	if d.failing && strings.HasPrefix(k.String(), ttlRecordsPrefix) {
+		return errors.New("failed to write record")
+	}
+	return d.Datastore.Put(ctx, k, v)
+}
+
+func TestTTLProviderStoreRenewFailure(t *testing.T) {
+	ctx := context.Background()
+	dstore := &failingRecordsDatastore{Datastore: dssync.MutexWrap(ds.NewMapDatastore())}
+	s := newTestTTLStore(t, dstore)
+
+	key := u.Hash([]byte("test"))
+	prov := peer.AddrInfo{ID: "prov"}
+	if err := s.AddProviderWithTTL(ctx, key, prov, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	dstore.failing = true
+	if err := s.AddProviderWithTTL(ctx, key, prov, time.Hour); err == nil {
+		t.Fatal("expected the renewal to fail")
+	}
+
+	// the record that failed to be renewed is still collected.
+	if err := s.removeExpired(ctx, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, dstore, ttlRecordsPrefix); n != 0 {
+		t.Fatalf("expected the expired record to be removed, got %d records", n)
+	}
+	if n := countKeys(t, dstore, ttlExpiryPrefix); n != 0 {
+		t.Fatalf("expected the index to be empty, got %d entries", n)
+	}
+}
+
+func TestTTLProviderStorePolicy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestTTLStore(t, dssync.MutexWrap(ds.NewMapDatastore()),
+		WithTTLPolicy(func(key []byte, p peer.ID, requested time.Duration) time.Duration {
+			if p == "banned" {
+				return 0
+			}
+			return time.Hour
+		}),
+		TTLCleanupInterval(10*time.Millisecond),
+	)
+
+	key := u.Hash([]byte("test"))
+	if err := s.AddProvider(ctx, key, peer.AddrInfo{ID: "banned"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProviderWithTTL(ctx, key, peer.AddrInfo{ID: "prov"}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// let the garbage collection run, the policy extended the TTL
+	time.Sleep(50 * time.Millisecond)
+	provs, err := s.GetProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 1 || provs[0].ID != "prov" {
+		t.Fatalf("expected only the allowed provider, got %v", provs)
+	}
+}
+
+func TestTTLProviderStoreClampExpiry(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	s := newTestTTLStore(t, dstore,
+		WithTTLPolicy(func([]byte, peer.ID, time.Duration) time.Duration { return math.MaxInt64 }),
+	)
+
+	key := u.Hash([]byte("test"))
+	if err := s.AddProvider(ctx, key, peer.AddrInfo{ID: "forever"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the expiration time doesn't overflow into the past
+	if err := s.removeExpired(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	provs, err := s.GetProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 1 {
+		t.Fatalf("expected 1 provider, got %d", len(provs))
+	}
+	if n := countKeys(t, dstore, ttlExpiryPrefix); n != 1 {
+		t.Fatalf("expected 1 index entry, got %d", n)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"sync"
 	"time"
 
@@ -61,6 +62,20 @@ type query struct {
 
 	// stopFn is used to determine if we should stop the WHOLE disjoint query.
 	stopFn stopFn
+
+	// path is the index of this query among the paths of a disjoint query.
+	path int
+	// claims tracks the peers owned by each path of a disjoint query, nil for single path queries.
+	claims *disjointClaims
+
+	// params are the lookup parameters in effect when the query started.
+	params lookupParams
+
+	// diversity limits the peers of the same network heard of by this query, nil if unlimited.
+	diversity *queryDiversityFilter
+
+	// trace records the lookup for the DHT's lookup tracer, nil if lookups aren't traced.
+	trace *lookupTraceRecorder
 }
 
 type lookupWithFollowupResult struct {
@@ -111,45 +126,61 @@ func (dht *IpfsDHT) runLookupWithFollowup(ctx context.Context, target string, qu
 		return lookupRes, nil
 	}
 
-	doneCh := make(chan struct{}, len(queryPeers))
+	if _, completed := dht.runFollowup(ctx, queryPeers, queryFn, func() bool { return stopFn(qps) }); !completed {
+		lookupRes.completed = false
+	}
+	return lookupRes, nil
+}
+
+// followupResult is the outcome of querying a peer in a followup.
+type followupResult struct {
+	peer     peer.ID
+	err      error
+	duration time.Duration
+}
+
+// runFollowup runs the query function against all the peers in parallel. It waits for all the queries
+// to complete before returning, aborting the ongoing ones when the context is cancelled or the stop
+// function returns true, and returns whether none of them had to be aborted. The outcome of every
+// query is returned, the aborted ones fail with the context error.
+func (dht *IpfsDHT) runFollowup(ctx context.Context, peers []peer.ID, queryFn queryFn, stopFn func() bool) ([]followupResult, bool) {
+	doneCh := make(chan followupResult, len(peers))
 	followUpCtx, cancelFollowUp := context.WithCancel(ctx)
 	defer cancelFollowUp()
-	for _, p := range queryPeers {
+	for _, p := range peers {
 		qp := p
 		go func() {
-			_, _ = queryFn(followUpCtx, qp)
-			doneCh <- struct{}{}
+			start := dht.clock.Now()
+			_, err := queryFn(followUpCtx, qp)
+			doneCh <- followupResult{peer: qp, err: err, duration: dht.clock.Now().Sub(start)}
 		}()
 	}
 
-	// wait for all queries to complete before returning, aborting ongoing queries if we've been externally stopped
-	followupsCompleted := 0
+	results := make([]followupResult, 0, len(peers))
+	completed := true
 processFollowUp:
-	for i := 0; i < len(queryPeers); i++ {
+	for i := 0; i < len(peers); i++ {
 		select {
-		case <-doneCh:
-			followupsCompleted++
-			if stopFn(qps) {
+		case r := <-doneCh:
+			results = append(results, r)
+			if stopFn() {
 				cancelFollowUp()
-				if i < len(queryPeers)-1 {
-					lookupRes.completed = false
+				if i < len(peers)-1 {
+					completed = false
 				}
 				break processFollowUp
 			}
 		case <-ctx.Done():
-			lookupRes.completed = false
+			completed = false
 			cancelFollowUp()
 			break processFollowUp
 		}
 	}
 
-	if !lookupRes.completed {
-		for i := followupsCompleted; i < len(queryPeers); i++ {
-			<-doneCh
-		}
+	for len(results) < len(peers) {
+		results = append(results, <-doneCh)
 	}
-
-	return lookupRes, nil
+	return results, completed
 }
 
 func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn, stopFn stopFn) (*lookupWithFollowupResult, *qpeerset.QueryPeerset, error) {
@@ -167,6 +198,11 @@ func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn
 		return nil, nil, kb.ErrLookupFailure
 	}
 
+	if dht.disjointPaths > 1 {
+		return dht.runDisjointQuery(ctx, target, seedPeers, dht.disjointPaths, queryFn, stopFn)
+	}
+
+	params := dht.getLookupParams()
 	q := &query{
 		id:         uuid.New(),
 		key:        target,
@@ -175,6 +211,8 @@ func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn
 		queryPeers: qpeerset.NewQueryPeerset(target),
 		seedPeers:  seedPeers,
 		peerTimes:  make(map[peer.ID]time.Duration),
+		params:     params,
+		diversity:  dht.newQueryDiversityFilter(params),
 		terminated: false,
 		queryFn:    queryFn,
 		stopFn:     stopFn,
@@ -191,6 +229,41 @@ func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn
 	return res, q.queryPeers, nil
 }
 
+// LookupInfo describes a lookup path in progress.
+type LookupInfo struct {
+	ID uuid.UUID
+	// Key is the lookup target.
+	Key []byte
+	// Path is the index of the path within a disjoint lookup, 0 for regular lookups.
+	Path  int
+	Start time.Time
+}
+
+// InFlightLookups returns the lookup paths in progress, oldest first.
+func (dht *IpfsDHT) InFlightLookups() []LookupInfo {
+	dht.lookupsLk.Lock()
+	lookups := make([]LookupInfo, 0, len(dht.lookups))
+	for _, l := range dht.lookups {
+		lookups = append(lookups, l)
+	}
+	dht.lookupsLk.Unlock()
+
+	sort.Slice(lookups, func(i, j int) bool { return lookups[i].Start.Before(lookups[j].Start) })
+	return lookups
+}
+
+func (dht *IpfsDHT) trackLookup(q *query) {
+	dht.lookupsLk.Lock()
+	defer dht.lookupsLk.Unlock()
+	dht.lookups[q] = LookupInfo{ID: q.id, Key: []byte(q.key), Path: q.path, Start: dht.clock.Now()}
+}
+
+func (dht *IpfsDHT) untrackLookup(q *query) {
+	dht.lookupsLk.Lock()
+	defer dht.lookupsLk.Unlock()
+	delete(dht.lookups, q)
+}
+
 func (q *query) recordPeerIsValuable(p peer.ID) {
 	if !q.dht.routingTable.UpdateLastUsefulAt(p, time.Now()) {
 		// not in routing table
@@ -269,6 +342,10 @@ type queryUpdate struct {
 	unreachable []peer.ID
 
 	queryDuration time.Duration
+
+	// outcome and err describe the RPC to the cause, for the lookup traces.
+	outcome LookupRPCOutcome
+	err     error
 }
 
 func (q *query) run() {
@@ -278,7 +355,14 @@ func (q *query) run() {
 	pathCtx, cancelPath := context.WithCancel(ctx)
 	defer cancelPath()
 
-	alpha := q.dht.alpha
+	alpha := q.params.alpha
+
+	if q.dht.lookupTracer != nil || q.dht.recentTraces != nil {
+		q.trace = newLookupTraceRecorder(q)
+	}
+
+	q.dht.trackLookup(q)
+	defer q.dht.untrackLookup(q)
 
 	ch := make(chan *queryUpdate, alpha)
 	ch <- &queryUpdate{cause: q.dht.self, heard: q.seedPeers}
@@ -342,9 +426,13 @@ func (q *query) spawnQuery(ctx context.Context, cause peer.ID, queryPeer peer.ID
 			nil,
 		),
 	)
+	if q.trace != nil {
+		q.trace.sent(queryPeer, q.queryPeers.GetReferrer(queryPeer))
+	}
 	q.queryPeers.SetState(queryPeer, qpeerset.PeerWaiting)
+	timeout := q.rpcTimeout(queryPeer)
 	q.waitGroup.Add(1)
-	go q.queryPeer(ctx, ch, queryPeer)
+	go q.queryPeer(ctx, ch, queryPeer, timeout)
 }
 
 func (q *query) isReadyToTerminate(ctx context.Context, nPeersToQuery int) (bool, LookupTerminationReason, []peer.ID) {
@@ -377,7 +465,7 @@ func (q *query) isReadyToTerminate(ctx context.Context, nPeersToQuery int) (bool
 // From the set of all nodes that are not unreachable,
 // if the closest beta nodes are all queried, the lookup can terminate.
 func (q *query) isLookupTermination() bool {
-	peers := q.queryPeers.GetClosestNInStates(q.dht.beta, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
+	peers := q.queryPeers.GetClosestNInStates(q.params.beta, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
 	for _, p := range peers {
 		if q.queryPeers.GetState(p) != qpeerset.PeerQueried {
 			return false
@@ -410,40 +498,74 @@ func (q *query) terminate(ctx context.Context, cancel context.CancelFunc, reason
 	)
 	cancel() // abort outstanding queries
 	q.terminated = true
+
+	if q.trace != nil {
+		lt := q.trace.finish(reason)
+		if q.dht.lookupTracer != nil {
+			if err := q.dht.lookupTracer.Trace(lt); err != nil {
+				logger.Warnw("failed to write lookup trace", "error", err)
+			}
+		}
+		if q.dht.recentTraces != nil {
+			q.dht.recentTraces.add(lt)
+		}
+	}
 }
 
-// queryPeer queries a single peer and reports its findings on the channel.
+// queryPeer queries a single peer and reports its findings on the channel. The query RPC is aborted
+// after the given timeout, if any. The dial then takes a few more round trips than the RPC, so it is
+// bounded by the maximum RPC timeout instead.
 // queryPeer does not access the query state in queryPeers!
-func (q *query) queryPeer(ctx context.Context, ch chan<- *queryUpdate, p peer.ID) {
+func (q *query) queryPeer(ctx context.Context, ch chan<- *queryUpdate, p peer.ID, timeout time.Duration) {
 	defer q.waitGroup.Done()
 
 	ctx, span := internal.StartSpan(ctx, "IpfsDHT.QueryPeer")
 	defer span.End()
 
-	dialCtx, queryCtx := ctx, ctx
+	dialCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = q.dht.clock.WithTimeout(ctx, q.dht.rpcTimeoutMax)
+		defer cancel()
+	}
 
 	// dial the peer
+	startDial := q.dht.clock.Now()
 	if err := q.dht.dialPeer(dialCtx, p); err != nil {
 		// remove the peer if there was a dial failure..but not because of a context cancellation
+		outcome := LookupRPCDialFailure
 		if dialCtx.Err() == nil {
 			q.dht.peerStoppedDHT(p)
+		} else if ctx.Err() == nil {
+			outcome = LookupRPCTimeout
 		}
-		ch <- &queryUpdate{cause: p, unreachable: []peer.ID{p}}
+		ch <- &queryUpdate{cause: p, unreachable: []peer.ID{p}, queryDuration: q.dht.clock.Now().Sub(startDial), outcome: outcome, err: err}
 		return
 	}
 
-	startQuery := time.Now()
+	queryCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = q.dht.clock.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	startQuery := q.dht.clock.Now()
 	// send query RPC to the remote peer
 	newPeers, err := q.queryFn(queryCtx, p)
 	if err != nil {
+		outcome := LookupRPCQueryFailure
 		if queryCtx.Err() == nil {
 			q.dht.peerStoppedDHT(p)
+		} else if ctx.Err() == nil {
+			// the peer is only slow, hand its slot over to another peer but keep it in the routing table.
+			outcome = LookupRPCTimeout
 		}
-		ch <- &queryUpdate{cause: p, unreachable: []peer.ID{p}}
+		ch <- &queryUpdate{cause: p, unreachable: []peer.ID{p}, queryDuration: q.dht.clock.Now().Sub(startQuery), outcome: outcome, err: err}
 		return
 	}
 
-	queryDuration := time.Since(startQuery)
+	queryDuration := q.dht.clock.Now().Sub(startQuery)
 
 	// query successful, try to add to RT
 	q.dht.validPeerFound(p)
@@ -465,33 +587,45 @@ func (q *query) queryPeer(ctx context.Context, ch chan<- *queryUpdate, p peer.ID
 		// add the next peer to the query if matches the query target even if it would otherwise fail the query filter
 		// TODO: this behavior is really specific to how FindPeer works and not GetClosestPeers or any other function
 		isTarget := string(next.ID) == q.key
-		if isTarget || q.dht.queryPeerFilter(q.dht, *next) {
+		if isTarget || q.params.peerFilter(q.dht, *next) {
 			q.dht.maybeAddAddrs(next.ID, next.Addrs, pstore.TempAddrTTL)
 			saw = append(saw, next.ID)
 		}
 	}
 
-	ch <- &queryUpdate{cause: p, heard: saw, queried: []peer.ID{p}, queryDuration: queryDuration}
+	ch <- &queryUpdate{cause: p, heard: saw, queried: []peer.ID{p}, queryDuration: queryDuration, outcome: LookupRPCSuccess}
 }
 
 func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 	if q.terminated {
 		panic("update should not be invoked after the logical lookup termination")
 	}
+	var skipped []peer.ID
+	if q.diversity != nil {
+		if up.cause == q.dht.self {
+			// the seed peers have already passed the routing table diversity filter.
+			q.diversity.exempt(up.heard)
+		} else {
+			up.heard, skipped = q.diversity.filter(up.heard, q.key)
+		}
+	}
+
+	update := NewLookupUpdateEvent(
+		up.cause,
+		up.cause,
+		up.heard,       // heard
+		nil,            // waiting
+		up.queried,     // queried
+		up.unreachable, // unreachable
+	)
+	update.Skipped = NewPeerKadIDSlice(skipped)
 	PublishLookupEvent(ctx,
 		NewLookupEvent(
 			q.dht.self,
 			q.id,
 			q.key,
 			nil,
-			NewLookupUpdateEvent(
-				up.cause,
-				up.cause,
-				up.heard,       // heard
-				nil,            // waiting
-				up.queried,     // queried
-				up.unreachable, // unreachable
-			),
+			update,
 			nil,
 		),
 	)
@@ -499,6 +633,9 @@ func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 		if p == q.dht.self { // don't add self.
 			continue
 		}
+		if q.claims != nil && !q.claims.claim(p, q.path) { // owned by another disjoint path.
+			continue
+		}
 		q.queryPeers.TryAdd(p, up.cause)
 	}
 	for _, p := range up.queried {
@@ -523,6 +660,9 @@ func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 			panic(fmt.Errorf("kademlia protocol error: tried to transition to the unreachable state from state %v", st))
 		}
 	}
+	if q.trace != nil && up.cause != q.dht.self {
+		q.trace.done(up)
+	}
 }
 
 func (dht *IpfsDHT) dialPeer(ctx context.Context, p peer.ID) error {
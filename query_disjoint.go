@@ -0,0 +1,138 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// disjointClaims keeps track of which lookup path every peer of a disjoint query belongs to.
+// A peer is only ever used by the first path that hears about it, so that a single malicious
+// peer can not steer more than one path.
+type disjointClaims struct {
+	mu    sync.Mutex
+	owner map[peer.ID]int
+}
+
+// claim assigns p to the given path if no other path owns it yet. It returns true if p belongs to
+// the path.
+func (c *disjointClaims) claim(p peer.ID, path int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if owner, ok := c.owner[p]; ok {
+		return owner == path
+	}
+	c.owner[p] = path
+	return true
+}
+
+// runDisjointQuery runs the query over the given number of disjoint paths, as described in the
+// S/Kademlia paper. The seed peers are distributed over the paths, and every path runs as an
+// independent query that only ever uses the peers it owns. The results of all the paths are merged.
+func (dht *IpfsDHT) runDisjointQuery(ctx context.Context, target string, seedPeers []peer.ID, paths int, queryFn queryFn, stopFn stopFn) (*lookupWithFollowupResult, *qpeerset.QueryPeerset, error) {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.RunDisjointQuery")
+	defer span.End()
+
+	// no point in running paths that don't have any peer to start from
+	if paths > len(seedPeers) {
+		paths = len(seedPeers)
+	}
+
+	claims := &disjointClaims{owner: make(map[peer.ID]int, len(seedPeers))}
+	claims.owner[dht.self] = -1
+
+	pathSeeds := make([][]peer.ID, paths)
+	for i, p := range seedPeers {
+		path := i % paths
+		claims.owner[p] = path
+		pathSeeds[path] = append(pathSeeds[path], p)
+	}
+
+	params := dht.getLookupParams()
+	queries := make([]*query, paths)
+	var wg sync.WaitGroup
+	for i := range queries {
+		q := &query{
+			id:         uuid.New(),
+			key:        target,
+			ctx:        ctx,
+			dht:        dht,
+			queryPeers: qpeerset.NewQueryPeerset(target),
+			seedPeers:  pathSeeds[i],
+			peerTimes:  make(map[peer.ID]time.Duration),
+			params:     params,
+			diversity:  dht.newQueryDiversityFilter(params),
+			terminated: false,
+			queryFn:    queryFn,
+			stopFn:     stopFn,
+			path:       i,
+			claims:     claims,
+		}
+		queries[i] = q
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.run()
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() == nil {
+		for _, q := range queries {
+			q.recordValuablePeers()
+		}
+	}
+
+	return mergeDisjointResults(dht.bucketSize, kb.ConvertKey(target), queries)
+}
+
+// mergeDisjointResults merges the lookup results of the paths of a disjoint query, along with
+// their peer sets.
+func mergeDisjointResults(bucketSize int, target kb.ID, queries []*query) (*lookupWithFollowupResult, *qpeerset.QueryPeerset, error) {
+	merged := &lookupWithFollowupResult{completed: true}
+	qps := qpeerset.NewQueryPeerset(queries[0].key)
+	peerState := make(map[peer.ID]qpeerset.PeerState)
+	var peers, closest []peer.ID
+
+	for _, q := range queries {
+		res := q.constructLookupResult(target)
+		merged.completed = merged.completed && res.completed
+		for i, p := range res.peers {
+			peerState[p] = res.state[i]
+		}
+		peers = append(peers, res.peers...)
+		closest = append(closest, res.closest...)
+
+		// the paths own distinct peers, so their peer sets don't overlap.
+		for _, p := range q.queryPeers.GetClosestInStates(qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried, qpeerset.PeerUnreachable) {
+			qps.TryAdd(p, q.queryPeers.GetReferrer(p))
+			qps.SetState(p, q.queryPeers.GetState(p))
+		}
+	}
+
+	peers = kb.SortClosestPeers(peers, target)
+	if len(peers) > bucketSize {
+		peers = peers[:bucketSize]
+	}
+	closest = kb.SortClosestPeers(closest, target)
+	if len(closest) > bucketSize {
+		closest = closest[:bucketSize]
+	}
+
+	merged.peers = peers
+	merged.closest = closest
+	merged.state = make([]qpeerset.PeerState, len(peers))
+	for i, p := range peers {
+		merged.state[i] = peerState[p]
+	}
+	return merged, qps, nil
+}
@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// queryDiversityFilter limits the number of peers of a single lookup that share an IP group or an
+// ASN, so that a referrer can't fill a lookup with peers from the same network. Peers are grouped by
+// all of their addresses: IPv4 addresses by /16 prefix and IPv6 addresses by /32 prefix, and by the
+// ASN of the addresses known to the DHT's ASNResolver. A peer is only added if none of its groups is
+// full.
+//
+// A queryDiversityFilter belongs to a single lookup path and isn't safe for concurrent use.
+type queryDiversityFilter struct {
+	maxPerIPGroup int
+	maxPerASN     int
+
+	addrs func(peer.ID) []ma.Multiaddr
+	asn   ASNResolver
+
+	peers    map[peer.ID]struct{}
+	ipGroups map[string]int
+	asns     map[string]int
+}
+
+// newQueryDiversityFilter returns the diversity filter of a lookup path with the given parameters,
+// or nil if the lookups aren't filtered.
+func (dht *IpfsDHT) newQueryDiversityFilter(params lookupParams) *queryDiversityFilter {
+	if params.maxPerIPGroup == 0 && params.maxPerASN == 0 {
+		return nil
+	}
+	return &queryDiversityFilter{
+		maxPerIPGroup: params.maxPerIPGroup,
+		maxPerASN:     params.maxPerASN,
+		addrs:         dht.peerstore.Addrs,
+		asn:           dht.asnResolver,
+		peers:         make(map[peer.ID]struct{}),
+		ipGroups:      make(map[string]int),
+		asns:          make(map[string]int),
+	}
+}
+
+// filter splits the given peers into the ones that can be added to the lookup and the ones that
+// would exceed its limits. The peers that can be added count towards the limits from now on. The
+// target of the lookup is never skipped.
+func (f *queryDiversityFilter) filter(peers []peer.ID, target string) (allowed, skipped []peer.ID) {
+	allowed = make([]peer.ID, 0, len(peers))
+	for _, p := range peers {
+		if string(p) == target || f.tryAdd(p) {
+			allowed = append(allowed, p)
+		} else {
+			skipped = append(skipped, p)
+		}
+	}
+	return allowed, skipped
+}
+
+// exempt allows the given peers without counting them towards the limits.
+func (f *queryDiversityFilter) exempt(peers []peer.ID) {
+	for _, p := range peers {
+		f.peers[p] = struct{}{}
+	}
+}
+
+func (f *queryDiversityFilter) tryAdd(p peer.ID) bool {
+	if _, ok := f.peers[p]; ok {
+		return true
+	}
+
+	ipGroups, asns := make(map[string]struct{}), make(map[string]struct{})
+	for _, a := range f.addrs(p) {
+		ip, err := manet.ToIP(a)
+		if err != nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ipGroups[ip4.Mask(net.CIDRMask(16, 32)).String()] = struct{}{}
+		} else {
+			ipGroups[ip.Mask(net.CIDRMask(32, 128)).String()] = struct{}{}
+		}
+		if asn, err := f.asn.ASN(ip); err == nil && asn != "" {
+			asns[asn] = struct{}{}
+		}
+	}
+
+	for g := range ipGroups {
+		if f.maxPerIPGroup > 0 && f.ipGroups[g] >= f.maxPerIPGroup {
+			return false
+		}
+	}
+	for asn := range asns {
+		if f.maxPerASN > 0 && f.asns[asn] >= f.maxPerASN {
+			return false
+		}
+	}
+
+	for g := range ipGroups {
+		f.ipGroups[g]++
+	}
+	for asn := range asns {
+		f.asns[asn]++
+	}
+	f.peers[p] = struct{}{}
+	return true
+}
@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryDiversityFilter(t *testing.T) {
+	addrs := map[peer.ID][]ma.Multiaddr{
+		"a": {ma.StringCast("/ip4/1.2.3.4/tcp/1")},
+		"b": {ma.StringCast("/ip4/1.2.200.1/udp/1/quic-v1")},
+		"c": {ma.StringCast("/ip4/1.2.3.5/tcp/1")},
+		"d": {ma.StringCast("/ip4/5.6.7.8/tcp/1"), ma.StringCast("/ip4/1.2.3.6/tcp/1")},
+		"e": {ma.StringCast("/ip4/5.6.7.9/tcp/1")},
+		"f": {ma.StringCast("/dns4/example.com/tcp/1")},
+		"g": {ma.StringCast("/ip6/2001:db8::1/tcp/1")},
+		"h": {ma.StringCast("/ip6/2001:db8:1::1/tcp/1")},
+		"i": {ma.StringCast("/ip6/2001:db8:2::1/tcp/1")},
+	}
+	f := &queryDiversityFilter{
+		maxPerIPGroup: 2,
+		addrs:         func(p peer.ID) []ma.Multiaddr { return addrs[p] },
+		asn:           NewASNTable(),
+		peers:         make(map[peer.ID]struct{}),
+		ipGroups:      make(map[string]int),
+		asns:          make(map[string]int),
+	}
+
+	allowed, skipped := f.filter([]peer.ID{"a", "b", "c", "d", "e", "f"}, "target")
+	require.Equal(t, []peer.ID{"a", "b", "e", "f"}, allowed)
+	require.Equal(t, []peer.ID{"c", "d"}, skipped)
+
+	// peers already in the lookup, exempted peers and the target are always allowed
+	f.exempt([]peer.ID{"g"})
+	allowed, skipped = f.filter([]peer.ID{"a", "c", "g", "h", "i", "d"}, "c")
+	require.Equal(t, []peer.ID{"a", "c", "g", "h", "i"}, allowed)
+	require.Equal(t, []peer.ID{"d"}, skipped)
+
+	// peers are grouped by the ASN of their addresses too
+	table := NewASNTable()
+	_, prefix, _ := net.ParseCIDR("1.2.0.0/15")
+	table.Add(prefix, "64496")
+	addrs["j"] = []ma.Multiaddr{ma.StringCast("/ip4/1.3.0.1/tcp/1")}
+	f.maxPerIPGroup, f.maxPerASN, f.asn = 0, 1, table
+	f.peers, f.ipGroups, f.asns = make(map[peer.ID]struct{}), make(map[string]int), make(map[string]int)
+
+	allowed, skipped = f.filter([]peer.ID{"j", "a", "e"}, "target")
+	require.Equal(t, []peer.ID{"j", "e"}, allowed)
+	require.Equal(t, []peer.ID{"a"}, skipped)
+}
+
+func TestQueryDiversityLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// all the test peers listen on the loopback interface, they all belong to the same IP group.
+	d := setupDHT(ctx, t, false, QueryDiversityLimits(2, 0))
+	hub := setupDHT(ctx, t, false)
+	others := setupDHTS(t, ctx, 5)
+	connect(t, ctx, d, hub)
+	for _, o := range others {
+		connect(t, ctx, hub, o)
+	}
+
+	lctx, events := RegisterForLookupEvents(ctx)
+	done := make(chan struct{})
+	heard, skipped := make(map[peer.ID]struct{}), make(map[peer.ID]struct{})
+	go func() {
+		defer close(done)
+		for ev := range events {
+			if ev.Response == nil || ev.Response.Cause.Peer == d.self {
+				continue
+			}
+			for _, p := range ev.Response.Heard {
+				heard[p.Peer] = struct{}{}
+			}
+			for _, p := range ev.Response.Skipped {
+				skipped[p.Peer] = struct{}{}
+			}
+		}
+	}()
+
+	_, err := d.GetClosestPeers(lctx, "key")
+	require.NoError(t, err)
+	cancel()
+	<-done
+
+	delete(heard, hub.self)
+	require.Len(t, heard, 2)
+	require.Len(t, skipped, len(others)-2)
+}
@@ -3,10 +3,18 @@ package dht
 import (
 	"context"
 	"fmt"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
 	tu "github.com/libp2p/go-libp2p-testing/etc"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/test"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
 
 	"github.com/stretchr/testify/require"
 )
@@ -116,3 +124,180 @@ func checkRoutingTable(a, b *IpfsDHT) bool {
 	// under high load, this may not happen as immediately as we would like.
 	return a.routingTable.Find(b.self) != "" && b.routingTable.Find(a.self) != ""
 }
+
+func TestDisjointQuery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dhts := setupDHTS(t, ctx, 15)
+	for i := range dhts {
+		connect(t, ctx, dhts[i], dhts[(i+1)%len(dhts)])
+		connect(t, ctx, dhts[i], dhts[(i+5)%len(dhts)])
+	}
+
+	d := setupDHT(ctx, t, false, DisjointPaths(3))
+	for _, other := range dhts[:6] {
+		connect(t, ctx, d, other)
+	}
+
+	key := "disjoint"
+	var mu sync.Mutex
+	queried := make(map[peer.ID]int)
+	getClosest := d.pmGetClosestPeers(key)
+	res, qps, err := d.runQuery(ctx, key, func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
+		mu.Lock()
+		queried[p]++
+		mu.Unlock()
+		return getClosest(ctx, p)
+	}, func(*qpeerset.QueryPeerset) bool { return false })
+	require.NoError(t, err)
+	require.True(t, res.completed)
+	require.NotEmpty(t, res.peers)
+	require.Len(t, res.state, len(res.peers))
+	for p, n := range queried {
+		require.Equal(t, 1, n, "peer %s was queried by more than one path", p)
+		require.NotEqual(t, qpeerset.PeerHeard, qps.GetState(p))
+	}
+
+	// the usual operations work on top of disjoint lookups
+	require.NoError(t, dhts[10].Provide(ctx, testCaseCids[0], true))
+	provs, err := d.FindProviders(ctx, testCaseCids[0])
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+	require.Equal(t, dhts[10].self, provs[0].ID)
+}
+
+func TestDisjointClaims(t *testing.T) {
+	c := &disjointClaims{owner: make(map[peer.ID]int)}
+	require.True(t, c.claim("a", 0))
+	require.True(t, c.claim("a", 0))
+	require.False(t, c.claim("a", 1))
+	require.True(t, c.claim("b", 1))
+	require.False(t, c.claim("b", 0))
+}
+
+func TestAdaptiveRPCTimeouts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// with only two peers to query, the lookup can't terminate before hearing back from both.
+	dhts := setupDHTS(t, ctx, 2)
+	d := setupDHT(ctx, t, false, AdaptiveRPCTimeouts(5, 50*time.Millisecond, 200*time.Millisecond))
+	for _, other := range dhts {
+		connect(t, ctx, d, other)
+	}
+
+	slow := dhts[0].self
+	getClosest := d.pmGetClosestPeers("timeout")
+	start := time.Now()
+	_, qps, err := d.runQuery(ctx, "timeout", func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
+		if p == slow {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return getClosest(ctx, p)
+	}, func(*qpeerset.QueryPeerset) bool { return false })
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 5*time.Second, "the slow peer held up the lookup")
+	require.Equal(t, qpeerset.PeerUnreachable, qps.GetState(slow))
+	// slow peers aren't evicted
+	require.NotEmpty(t, d.routingTable.Find(slow))
+}
+
+func TestAdaptiveRPCTimeoutsDial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// a peer accepting connections without ever completing the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	addr, err := manet.FromNetAddr(l.Addr())
+	require.NoError(t, err)
+
+	d := setupDHT(ctx, t, false, AdaptiveRPCTimeouts(5, 50*time.Millisecond, 200*time.Millisecond))
+	hung := test.RandPeerIDFatal(t)
+	d.peerstore.AddAddr(hung, addr, peerstore.PermanentAddrTTL)
+	_, err = d.routingTable.TryAddPeer(hung, true, false)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, qps, err := d.runQuery(ctx, "timeout", func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
+		return nil, nil
+	}, func(*qpeerset.QueryPeerset) bool { return false })
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 5*time.Second, "the dial wasn't bounded by the rpc timeout")
+	require.Equal(t, qpeerset.PeerUnreachable, qps.GetState(hung))
+	// peers slow to dial aren't evicted
+	require.NotEmpty(t, d.routingTable.Find(hung))
+}
+
+func TestRPCTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, AdaptiveRPCTimeouts(4, 100*time.Millisecond, time.Second))
+	q := &query{dht: d, peerTimes: make(map[peer.ID]time.Duration)}
+	p := peer.ID("unknown")
+
+	require.Equal(t, time.Second, q.rpcTimeout(p), "nothing known yet")
+
+	q.peerTimes["a"] = 10 * time.Millisecond
+	require.Equal(t, 100*time.Millisecond, q.rpcTimeout(p), "clamped to the minimum")
+
+	q.peerTimes["b"] = 100 * time.Millisecond
+	q.peerTimes["c"] = 200 * time.Millisecond
+	require.Equal(t, 400*time.Millisecond, q.rpcTimeout(p))
+
+	q.peerTimes["b"] = 500 * time.Millisecond
+	q.peerTimes["c"] = 500 * time.Millisecond
+	require.Equal(t, time.Second, q.rpcTimeout(p), "clamped to the maximum")
+
+	d.peerstore.RecordLatency(p, 600*time.Millisecond)
+	q.peerTimes = map[peer.ID]time.Duration{"a": time.Millisecond}
+	require.Equal(t, time.Second, q.rpcTimeout(p), "the peer latency is used")
+
+	q.dht.rpcTimeoutMultiplier = 0
+	require.Zero(t, q.rpcTimeout(p))
+}
+
+func TestRunFollowup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := setupDHT(ctx, t, false)
+	peers := []peer.ID{"a", "b", "c"}
+
+	// the first query completes, the others are aborted once stopped.
+	queryFn := func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
+		if p == "a" {
+			return nil, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	results, completed := d.runFollowup(ctx, peers, queryFn, func() bool { return true })
+	require.False(t, completed)
+	require.Len(t, results, len(peers))
+	for _, r := range results {
+		if r.peer == "a" {
+			require.NoError(t, r.err)
+		} else {
+			require.ErrorIs(t, r.err, context.Canceled)
+		}
+	}
+
+	results, completed = d.runFollowup(ctx, peers, func(context.Context, peer.ID) ([]*peer.AddrInfo, error) {
+		return nil, nil
+	}, func() bool { return false })
+	require.True(t, completed)
+	require.Len(t, results, len(peers))
+}
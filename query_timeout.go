@@ -0,0 +1,50 @@
+package dht
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// rpcTimeout returns the timeout of the RPC to p, or zero if RPCs are only bounded by the lookup
+// context. It must be called from the goroutine running the query, as it reads peerTimes.
+func (q *query) rpcTimeout(p peer.ID) time.Duration {
+	dht := q.dht
+	if dht.rpcTimeoutMultiplier == 0 {
+		return 0
+	}
+
+	// The latency EWMA is a round trip, while the lookup RPCs also include opening a stream. Use
+	// whichever is larger of the two so that a single fast RTT doesn't make the timeout too tight.
+	expected := dht.peerstore.LatencyEWMA(p)
+	if observed := q.medianPeerTime(); observed > expected {
+		expected = observed
+	}
+	if expected == 0 {
+		return dht.rpcTimeoutMax
+	}
+
+	timeout := time.Duration(float64(expected) * dht.rpcTimeoutMultiplier)
+	if timeout < dht.rpcTimeoutMin {
+		return dht.rpcTimeoutMin
+	}
+	if timeout > dht.rpcTimeoutMax {
+		return dht.rpcTimeoutMax
+	}
+	return timeout
+}
+
+// medianPeerTime returns the median duration of the successful RPCs of the query, zero if none
+// completed yet.
+func (q *query) medianPeerTime() time.Duration {
+	if len(q.peerTimes) == 0 {
+		return 0
+	}
+	times := make([]time.Duration, 0, len(q.peerTimes))
+	for _, d := range q.peerTimes {
+		times = append(times, d)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
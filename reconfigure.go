@@ -0,0 +1,106 @@
+package dht
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	dhtcfg "github.com/libp2p/go-libp2p-kad-dht/internal/config"
+)
+
+// lookupParams are the lookup parameters that can be changed with Reconfigure. A lookup keeps the
+// parameters in effect when it started.
+type lookupParams struct {
+	alpha      int
+	beta       int
+	peerFilter QueryFilterFunc
+
+	maxPerIPGroup int
+	maxPerASN     int
+}
+
+func (dht *IpfsDHT) getLookupParams() lookupParams {
+	dht.paramsLk.RLock()
+	defer dht.paramsLk.RUnlock()
+	return lookupParams{
+		alpha:         dht.alpha,
+		beta:          dht.beta,
+		peerFilter:    dht.queryPeerFilter,
+		maxPerIPGroup: dht.queryMaxPerIPGroup,
+		maxPerASN:     dht.queryMaxPerASN,
+	}
+}
+
+// allowedInRT returns true if the routing table filter accepts the peer.
+func (dht *IpfsDHT) allowedInRT(p peer.ID) bool {
+	dht.paramsLk.RLock()
+	filter := dht.routingTablePeerFilter
+	dht.paramsLk.RUnlock()
+	return filter == nil || filter(dht, p)
+}
+
+// Reconfigure changes the configuration of the running DHT, keeping its routing table. Only the
+// following options can be given:
+//   - Concurrency, Resiliency, QueryFilter and QueryDiversityLimits, which apply to the lookups
+//     started afterwards.
+//   - LookupCheckConcurrency.
+//   - RoutingTableRefreshPeriod, which also reschedules the next periodic refresh.
+//   - RoutingTableFilter. The peers of the routing table it rejects are removed.
+//
+// The resulting configuration is validated like the one given to New, nothing is changed if it is
+// invalid. Note that the usefulness grace period of the routing table peers, which depends on the
+// concurrency and the refresh period, can't be changed and keeps its initial value.
+func (dht *IpfsDHT) Reconfigure(opts ...Option) error {
+	// the options can only set the fields we know how to change at runtime.
+	var probe dhtcfg.Config
+	if err := probe.Apply(opts...); err != nil {
+		return err
+	}
+	probe.Concurrency, probe.Resiliency, probe.LookupCheckConcurrency = 0, 0, 0
+	probe.QueryPeerFilter, probe.QueryDiversity.MaxPerIPGroup, probe.QueryDiversity.MaxPerASN = nil, 0, 0
+	probe.RoutingTable.RefreshInterval, probe.RoutingTable.PeerFilter = 0, nil
+	if !reflect.DeepEqual(probe, dhtcfg.Config{}) {
+		return fmt.Errorf("only the concurrency, resiliency, lookup check concurrency, routing table refresh period and filters can be reconfigured")
+	}
+
+	dht.reconfigureLk.Lock()
+	defer dht.reconfigureLk.Unlock()
+
+	cfg := dht.cfg
+	if err := cfg.Apply(opts...); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	dht.paramsLk.Lock()
+	dht.alpha = cfg.Concurrency
+	dht.beta = cfg.Resiliency
+	dht.queryPeerFilter = cfg.QueryPeerFilter
+	dht.routingTablePeerFilter = cfg.RoutingTable.PeerFilter
+	dht.queryMaxPerIPGroup = cfg.QueryDiversity.MaxPerIPGroup
+	dht.queryMaxPerASN = cfg.QueryDiversity.MaxPerASN
+	dht.paramsLk.Unlock()
+
+	// the lookup checks in progress give their capacity back when they finish, the capacity may
+	// be negative until then.
+	dht.lookupChecksLk.Lock()
+	dht.lookupCheckCapacity += cfg.LookupCheckConcurrency - dht.lookupCheckConcurrency
+	dht.lookupCheckConcurrency = cfg.LookupCheckConcurrency
+	dht.lookupChecksLk.Unlock()
+
+	if cfg.RoutingTable.RefreshInterval != dht.cfg.RoutingTable.RefreshInterval || cfg.Concurrency != dht.cfg.Concurrency {
+		dht.rtRefreshManager.SetRefreshInterval(cfg.RoutingTable.RefreshInterval, computeMaxLastSuccessfulOutboundThreshold(cfg))
+	}
+
+	for _, p := range dht.routingTable.ListPeers() {
+		if !dht.allowedInRT(p) {
+			dht.removePeerFromRT(p, RoutingTablePeerRemoved, rtReasonFiltered)
+		}
+	}
+
+	dht.cfg = cfg
+	return nil
+}
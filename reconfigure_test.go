@@ -0,0 +1,43 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconfigure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+	others := setupDHTS(t, ctx, 2)
+	for _, o := range others {
+		connect(t, ctx, d, o)
+	}
+	require.Equal(t, 2, d.routingTable.Size())
+
+	require.NoError(t, d.Reconfigure(Concurrency(3), Resiliency(2), LookupCheckConcurrency(5), RoutingTableRefreshPeriod(time.Hour)))
+	params := d.getLookupParams()
+	require.Equal(t, 3, params.alpha)
+	require.Equal(t, 2, params.beta)
+	d.lookupChecksLk.Lock()
+	require.Equal(t, 5, d.lookupCheckCapacity)
+	d.lookupChecksLk.Unlock()
+	_, err := d.GetClosestPeers(ctx, "key")
+	require.NoError(t, err)
+
+	// invalid and unsupported changes are refused without changing anything
+	require.Error(t, d.Reconfigure(Concurrency(4), Resiliency(0)))
+	require.Error(t, d.Reconfigure(Concurrency(4), BucketSize(10)))
+	require.Equal(t, 3, d.getLookupParams().alpha)
+
+	// the peers the new routing table filter rejects are removed
+	rejected := others[0].self
+	require.NoError(t, d.Reconfigure(RoutingTableFilter(func(_ interface{}, p peer.ID) bool { return p != rejected })))
+	require.Equal(t, []peer.ID{others[1].self}, d.routingTable.ListPeers())
+	require.False(t, d.allowedInRT(rejected))
+}
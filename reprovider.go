@@ -0,0 +1,410 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-base32"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+const (
+	// reprovideKeysPrefix is the datastore namespace holding the keys tracked by the reprovider.
+	reprovideKeysPrefix = "/reprovider/keys/"
+	// reprovideLastRunKey is the datastore key holding the time the last reprovide run finished.
+	reprovideLastRunKey = "/reprovider/lastrun"
+
+	// reprovideKeyTimeout bounds the time spent announcing a single key.
+	reprovideKeyTimeout = time.Minute
+
+	// defaultReprovideRegionPrefixLen is used to group keys into keyspace regions when there is
+	// no network size estimate available.
+	defaultReprovideRegionPrefixLen = 4
+	// maxReprovideRegionPrefixLen caps the number of keyspace regions to 2^16.
+	maxReprovideRegionPrefixLen = 16
+)
+
+// ReproviderState describes what the reprovider is currently doing.
+type ReproviderState int
+
+const (
+	// ReproviderIdle indicates that the reprovider is waiting for the next scheduled run.
+	ReproviderIdle ReproviderState = iota
+	// ReproviderRunning indicates that the reprovider is currently announcing its keys.
+	ReproviderRunning
+)
+
+func (s ReproviderState) String() string {
+	switch s {
+	case ReproviderIdle:
+		return "idle"
+	case ReproviderRunning:
+		return "running"
+	}
+	panic("unreachable")
+}
+
+// ReproviderStat is a snapshot of the reprovider state and of the progress of its current (or most
+// recent) run.
+type ReproviderStat struct {
+	// State is the current state of the reprovider.
+	State ReproviderState
+	// Regions is the number of keyspace regions the keys of the run were batched into.
+	Regions int
+	// RegionsDone is the number of regions that have been fully reprovided during the run.
+	RegionsDone int
+	// Keys is the number of keys announced during the run.
+	Keys int
+	// KeysProvided is the number of keys successfully announced during the run.
+	KeysProvided int
+	// KeysFailed is the number of keys that could not be announced during the run.
+	KeysFailed int
+	// LastRunStart is the time the run started.
+	LastRunStart time.Time
+	// LastRunEnd is the time the most recent completed run finished.
+	LastRunEnd time.Time
+	// NextRun is the time the next run is scheduled for.
+	NextRun time.Time
+}
+
+// Reprovider keeps a persistent set of keys and announces them to the network on a schedule, so
+// that the provider records do not expire. Keys are batched by keyspace region, so that consecutive
+// announcements hit the same part of the network.
+type Reprovider struct {
+	dht         *IpfsDHT
+	dstore      ds.Datastore
+	interval    time.Duration
+	concurrency int
+
+	triggerCh chan chan error
+
+	statLk sync.RWMutex
+	stat   ReproviderStat
+}
+
+func newReprovider(dht *IpfsDHT, interval time.Duration, concurrency int) *Reprovider {
+	return &Reprovider{
+		dht:         dht,
+		dstore:      dht.datastore,
+		interval:    interval,
+		concurrency: concurrency,
+		triggerCh:   make(chan chan error),
+	}
+}
+
+// Reprovider returns the DHT's reprovider, or nil if it has not been enabled with EnableReprovider.
+func (dht *IpfsDHT) Reprovider() *Reprovider {
+	return dht.reprovider
+}
+
+// Add adds the given keys to the set of keys that are periodically reprovided.
+// Adding a key does not announce it, use IpfsDHT.Provide for that.
+func (r *Reprovider) Add(ctx context.Context, keys ...multihash.Multihash) error {
+	now := time.Now()
+	for _, k := range keys {
+		if err := r.dstore.Put(ctx, mkReprovideDsKey(k), encodeReprovideTime(now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove stops reproviding the given keys.
+// The provider records already stored in the network will expire on their own.
+func (r *Reprovider) Remove(ctx context.Context, keys ...multihash.Multihash) error {
+	for _, k := range keys {
+		if err := r.dstore.Delete(ctx, mkReprovideDsKey(k)); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns all the keys that are periodically reprovided.
+func (r *Reprovider) Keys(ctx context.Context) ([]multihash.Multihash, error) {
+	res, err := r.dstore.Query(ctx, dsq.Query{Prefix: reprovideKeysPrefix, KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var keys []multihash.Multihash
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		k, err := base32.RawStdEncoding.DecodeString(e.Key[strings.LastIndex(e.Key, "/")+1:])
+		if err != nil {
+			logger.Warnw("invalid reprovider key in datastore", "key", e.Key, "error", err)
+			continue
+		}
+		keys = append(keys, multihash.Multihash(k))
+	}
+	return keys, nil
+}
+
+// Stat returns a snapshot of the reprovider state and progress.
+func (r *Reprovider) Stat() ReproviderStat {
+	r.statLk.RLock()
+	defer r.statLk.RUnlock()
+	return r.stat
+}
+
+// Trigger asks the reprovider to announce all of its keys now, instead of waiting for the next
+// scheduled run.
+//
+// The returned channel will block until the run finishes, then yield the
+// error and close. The channel is buffered and safe to ignore.
+func (r *Reprovider) Trigger() <-chan error {
+	resp := make(chan error, 1)
+	go func() {
+		select {
+		case r.triggerCh <- resp:
+		case <-r.dht.ctx.Done():
+			resp <- r.dht.ctx.Err()
+			close(resp)
+		}
+	}()
+	return resp
+}
+
+func (r *Reprovider) run() {
+	r.dht.wg.Add(1)
+	go func() {
+		defer r.dht.wg.Done()
+
+		timer := time.NewTimer(r.firstRunDelay(r.dht.ctx))
+		defer timer.Stop()
+
+		for {
+			var respCh chan error
+			select {
+			case <-timer.C:
+			case respCh = <-r.triggerCh:
+			case <-r.dht.ctx.Done():
+				return
+			}
+
+			err := r.reprovide(r.dht.ctx)
+			if err != nil {
+				logger.Warnw("failed to reprovide keys", "error", err)
+			}
+			if respCh != nil {
+				respCh <- err
+				close(respCh)
+			}
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(r.interval)
+			r.updateStat(func(s *ReproviderStat) { s.NextRun = time.Now().Add(r.interval) })
+		}
+	}()
+}
+
+// firstRunDelay returns the time to wait for the first run, based on when the last run before a
+// restart finished.
+func (r *Reprovider) firstRunDelay(ctx context.Context) time.Duration {
+	delay := r.interval
+	buf, err := r.dstore.Get(ctx, ds.NewKey(reprovideLastRunKey))
+	if err == nil {
+		if lastRun, err := decodeReprovideTime(buf); err == nil {
+			delay = time.Until(lastRun.Add(r.interval))
+			if delay < 0 {
+				delay = 0
+			}
+			r.updateStat(func(s *ReproviderStat) { s.LastRunEnd = lastRun })
+		}
+	} else if err != ds.ErrNotFound {
+		logger.Warnw("failed to read last reprovide time", "error", err)
+	}
+	r.updateStat(func(s *ReproviderStat) { s.NextRun = time.Now().Add(delay) })
+	return delay
+}
+
+// reprovide announces all the tracked keys, one keyspace region at a time.
+func (r *Reprovider) reprovide(ctx context.Context) error {
+	ctx, span := internal.StartSpan(ctx, "Reprovider.Reprovide")
+	defer span.End()
+
+	keys, err := r.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	regions := groupByRegion(keys, r.dht.regionPrefixLen())
+
+	r.updateStat(func(s *ReproviderStat) {
+		*s = ReproviderStat{
+			State:        ReproviderRunning,
+			Regions:      len(regions),
+			Keys:         len(keys),
+			LastRunStart: time.Now(),
+			LastRunEnd:   s.LastRunEnd,
+			NextRun:      s.NextRun,
+		}
+	})
+	defer r.updateStat(func(s *ReproviderStat) { s.State = ReproviderIdle })
+
+	logger.Infow("reproviding keys", "keys", len(keys), "regions", len(regions))
+
+	for _, region := range regions {
+		r.reprovideRegion(ctx, region)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.updateStat(func(s *ReproviderStat) { s.RegionsDone++ })
+	}
+
+	now := time.Now()
+	if err := r.dstore.Put(ctx, ds.NewKey(reprovideLastRunKey), encodeReprovideTime(now)); err != nil {
+		logger.Warnw("failed to persist last reprovide time", "error", err)
+	}
+
+	stat := r.Stat()
+	r.updateStat(func(s *ReproviderStat) { s.LastRunEnd = now })
+	logger.Infow("finished reproviding keys", "provided", stat.KeysProvided, "failed", stat.KeysFailed, "took", now.Sub(stat.LastRunStart))
+
+	if stat.KeysFailed > 0 {
+		return fmt.Errorf("failed to reprovide %d out of %d keys", stat.KeysFailed, stat.Keys)
+	}
+	return nil
+}
+
+// reprovideRegion announces the keys of a single keyspace region, running at most concurrency
+// announcements in parallel.
+func (r *Reprovider) reprovideRegion(ctx context.Context, keys []multihash.Multihash) {
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(k multihash.Multihash) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ok := r.reprovideKey(ctx, k)
+			r.updateStat(func(s *ReproviderStat) {
+				if ok {
+					s.KeysProvided++
+				} else {
+					s.KeysFailed++
+				}
+			})
+		}(k)
+	}
+	wg.Wait()
+}
+
+func (r *Reprovider) reprovideKey(ctx context.Context, k multihash.Multihash) bool {
+	ctx, cancel := context.WithTimeout(ctx, reprovideKeyTimeout)
+	defer cancel()
+
+	// our own provider record expires like any other one
+	if err := r.dht.providerStore.AddProvider(ctx, k, peer.AddrInfo{ID: r.dht.self}); err != nil {
+		logger.Debugw("failed to add self as provider", "mh", internal.LoggableProviderRecordBytes(k), "error", err)
+	}
+
+	if err := r.dht.provide(ctx, k); err != nil {
+		logger.Debugw("failed to reprovide key", "mh", internal.LoggableProviderRecordBytes(k), "error", err)
+		return false
+	}
+
+	// only touch keys that haven't been removed in the meantime
+	dsk := mkReprovideDsKey(k)
+	if has, err := r.dstore.Has(ctx, dsk); err == nil && has {
+		if err := r.dstore.Put(ctx, dsk, encodeReprovideTime(time.Now())); err != nil {
+			logger.Debugw("failed to update reprovide time", "mh", internal.LoggableProviderRecordBytes(k), "error", err)
+		}
+	}
+	return true
+}
+
+// regionPrefixLen returns the number of leading keyspace bits that identify a region, aiming for
+// regions containing about bucketSize peers each.
+func (dht *IpfsDHT) regionPrefixLen() int {
+	ns, err := dht.nsEstimator.NetworkSize()
+	if err != nil || int(ns) <= dht.bucketSize {
+		return defaultReprovideRegionPrefixLen
+	}
+	l := int(math.Floor(math.Log2(float64(ns) / float64(dht.bucketSize))))
+	if l > maxReprovideRegionPrefixLen {
+		return maxReprovideRegionPrefixLen
+	}
+	return l
+}
+
+func (r *Reprovider) updateStat(f func(*ReproviderStat)) {
+	r.statLk.Lock()
+	defer r.statLk.Unlock()
+	f(&r.stat)
+}
+
+// groupByRegion sorts the keys by their position in the Kademlia keyspace and groups the ones sharing
+// the same first prefixLen bits.
+func groupByRegion[K ~string | ~[]byte](keys []K, prefixLen int) [][]K {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	kadIDs := make([]kb.ID, len(keys))
+	idx := make([]int, len(keys))
+	for i, k := range keys {
+		kadIDs[i] = kb.ConvertKey(string(k))
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return bytes.Compare(kadIDs[idx[i]], kadIDs[idx[j]]) < 0
+	})
+
+	var regions [][]K
+	var current []K
+	for n, i := range idx {
+		if n > 0 && kb.CommonPrefixLen(kadIDs[idx[n-1]], kadIDs[i]) < prefixLen {
+			regions = append(regions, current)
+			current = nil
+		}
+		current = append(current, keys[i])
+	}
+	return append(regions, current)
+}
+
+func mkReprovideDsKey(k multihash.Multihash) ds.Key {
+	return ds.NewKey(reprovideKeysPrefix + base32.RawStdEncoding.EncodeToString(k))
+}
+
+func encodeReprovideTime(t time.Time) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, t.UnixNano())
+	return buf[:n]
+}
+
+func decodeReprovideTime(data []byte) (time.Time, error) {
+	nsec, n := binary.Varint(data)
+	if n <= 0 {
+		return time.Time{}, fmt.Errorf("failed to parse time")
+	}
+	return time.Unix(0, nsec), nil
+}
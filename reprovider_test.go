@@ -0,0 +1,122 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReprovider(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider := setupDHT(ctx, t, false, EnableReprovider())
+	dhts := setupDHTS(t, ctx, 4)
+	for _, d := range dhts {
+		connect(t, ctx, provider, d)
+	}
+
+	r := provider.Reprovider()
+	require.NotNil(t, r)
+
+	keys := make([]multihash.Multihash, 0, 10)
+	for _, c := range testCaseCids[:10] {
+		keys = append(keys, c.Hash())
+	}
+	require.NoError(t, r.Add(ctx, keys...))
+
+	stored, err := r.Keys(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, keys, stored)
+
+	// nothing has been announced yet, Add only tracks the keys
+	provs, err := dhts[0].providerStore.GetProviders(ctx, keys[0])
+	require.NoError(t, err)
+	require.Empty(t, provs)
+
+	require.NoError(t, <-r.Trigger())
+
+	stat := r.Stat()
+	require.Equal(t, ReproviderIdle, stat.State)
+	require.Equal(t, len(keys), stat.Keys)
+	require.Equal(t, len(keys), stat.KeysProvided)
+	require.Zero(t, stat.KeysFailed)
+	require.Equal(t, stat.Regions, stat.RegionsDone)
+	require.False(t, stat.LastRunEnd.IsZero())
+
+	for _, c := range testCaseCids[:10] {
+		provs, err := dhts[0].FindProviders(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, provs, 1)
+		require.Equal(t, provider.self, provs[0].ID)
+	}
+
+	require.NoError(t, r.Remove(ctx, keys[0]))
+	stored, err = r.Keys(ctx)
+	require.NoError(t, err)
+	require.Len(t, stored, len(keys)-1)
+}
+
+func TestReproviderTracksProvidedKeys(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	a := setupDHT(ctx, t, false, EnableReprovider(), Datastore(dstore))
+	b := setupDHT(ctx, t, false)
+	connect(t, ctx, a, b)
+
+	require.NoError(t, a.Provide(ctx, testCaseCids[0], true))
+	// keys that are only stored locally aren't reprovided
+	require.NoError(t, a.Provide(ctx, testCaseCids[1], false))
+
+	keys, err := a.Reprovider().Keys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []multihash.Multihash{testCaseCids[0].Hash()}, keys)
+
+	require.NoError(t, <-a.Reprovider().Trigger())
+	require.NoError(t, a.Close())
+
+	// a restarted node picks up the keys and the schedule from the datastore
+	restarted := setupDHT(ctx, t, false, EnableReprovider(), Datastore(dstore))
+	keys, err = restarted.Reprovider().Keys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []multihash.Multihash{testCaseCids[0].Hash()}, keys)
+
+	stat := restarted.Reprovider().Stat()
+	require.False(t, stat.LastRunEnd.IsZero())
+	require.WithinDuration(t, stat.LastRunEnd.Add(22*time.Hour), stat.NextRun, time.Second)
+}
+
+func TestGroupByRegion(t *testing.T) {
+	keys := make([]multihash.Multihash, 0, len(testCaseCids))
+	for _, c := range testCaseCids {
+		keys = append(keys, c.Hash())
+	}
+
+	require.Len(t, groupByRegion(keys, 0), 1)
+	require.Len(t, groupByRegion(keys, 256), len(keys))
+	require.Nil(t, groupByRegion[multihash.Multihash](nil, 4))
+
+	regions := groupByRegion(keys, 4)
+	total := 0
+	for i, region := range regions {
+		total += len(region)
+		first := kb.ConvertKey(string(region[0]))
+		for _, k := range region[1:] {
+			require.GreaterOrEqual(t, kb.CommonPrefixLen(first, kb.ConvertKey(string(k))), 4)
+		}
+		if i > 0 {
+			prev := kb.ConvertKey(string(regions[i-1][0]))
+			require.Less(t, kb.CommonPrefixLen(prev, first), 4)
+		}
+	}
+	require.Equal(t, len(keys), total)
+}
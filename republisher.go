@@ -0,0 +1,357 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	u "github.com/ipfs/boxo/util"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/multiformats/go-base32"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+)
+
+const (
+	// republishRecordsPrefix is the datastore namespace holding the records tracked by the republisher.
+	republishRecordsPrefix = "/republisher/records/"
+	// republishLastRunKey is the datastore key holding the time the last republish run finished.
+	republishLastRunKey = "/republisher/lastrun"
+
+	// republishKeyTimeout bounds the time spent republishing a single record.
+	republishKeyTimeout = time.Minute
+)
+
+var (
+	// ErrRecordSuperseded is reported for a republished record when a newer record for the same key
+	// is stored locally. The older record isn't republished.
+	ErrRecordSuperseded = errors.New("record superseded by a newer local record")
+	// ErrNoPeersStored is reported for a record, republished or sent by ProvideMany and PutMany, when
+	// none of the closest peers accepted it.
+	ErrNoPeersStored = errors.New("no peer accepted the record")
+)
+
+// RepublishStatus is the outcome of the most recent republish of a record.
+type RepublishStatus struct {
+	// LastAttempt is the time the record was last republished, successfully or not.
+	LastAttempt time.Time
+	// LastSuccess is the time the record was last successfully stored on the network.
+	LastSuccess time.Time
+	// Peers is the number of peers that accepted the record during the last attempt.
+	Peers int
+	// Err is the error of the last attempt, nil if it succeeded.
+	Err error
+}
+
+// Republisher keeps a persistent set of the records put by this node, and periodically stores them
+// again on the peers that are closest to their key at the time, so that the records outlive the
+// peers that originally stored them. Records are validated again before being republished.
+type Republisher struct {
+	dht         *IpfsDHT
+	dstore      ds.Datastore
+	interval    time.Duration
+	concurrency int
+
+	triggerCh chan chan error
+
+	statusLk sync.RWMutex
+	status   map[string]RepublishStatus
+}
+
+func newRepublisher(dht *IpfsDHT, interval time.Duration, concurrency int) *Republisher {
+	return &Republisher{
+		dht:         dht,
+		dstore:      dht.datastore,
+		interval:    interval,
+		concurrency: concurrency,
+		triggerCh:   make(chan chan error),
+		status:      make(map[string]RepublishStatus),
+	}
+}
+
+// Republisher returns the DHT's record republisher, or nil if it has not been enabled with
+// EnableRepublisher.
+func (dht *IpfsDHT) Republisher() *Republisher {
+	return dht.republisher
+}
+
+// add starts tracking the record, replacing the one previously tracked for the same key.
+func (r *Republisher) add(ctx context.Context, key string, rec *recpb.Record) error {
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := r.dstore.Put(ctx, mkRepublishDsKey(key), data); err != nil {
+		return err
+	}
+
+	r.statusLk.Lock()
+	defer r.statusLk.Unlock()
+	r.status[key] = RepublishStatus{}
+	return nil
+}
+
+// Remove stops republishing the record of the given key.
+// The copies already stored in the network will expire on their own.
+func (r *Republisher) Remove(ctx context.Context, key string) error {
+	if err := r.dstore.Delete(ctx, mkRepublishDsKey(key)); err != nil && err != ds.ErrNotFound {
+		return err
+	}
+
+	r.statusLk.Lock()
+	defer r.statusLk.Unlock()
+	delete(r.status, key)
+	return nil
+}
+
+// Keys returns the keys of all the records that are periodically republished.
+func (r *Republisher) Keys(ctx context.Context) ([]string, error) {
+	res, err := r.dstore.Query(ctx, dsq.Query{Prefix: republishRecordsPrefix, KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var keys []string
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		k, err := base32.RawStdEncoding.DecodeString(e.Key[strings.LastIndex(e.Key, "/")+1:])
+		if err != nil {
+			logger.Warnw("invalid republisher key in datastore", "key", e.Key, "error", err)
+			continue
+		}
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Status returns the outcome of the most recent republish of the record of the given key. The status
+// is kept in memory, the second return value is false if the key isn't republished or if it hasn't
+// been added or republished since the DHT started.
+func (r *Republisher) Status(key string) (RepublishStatus, bool) {
+	r.statusLk.RLock()
+	defer r.statusLk.RUnlock()
+	s, ok := r.status[key]
+	return s, ok
+}
+
+// Statuses returns the status of all the records known since the DHT started, by key.
+func (r *Republisher) Statuses() map[string]RepublishStatus {
+	r.statusLk.RLock()
+	defer r.statusLk.RUnlock()
+	statuses := make(map[string]RepublishStatus, len(r.status))
+	for k, s := range r.status {
+		statuses[k] = s
+	}
+	return statuses
+}
+
+// Trigger asks the republisher to republish all of its records now, instead of waiting for the
+// next scheduled run.
+//
+// The returned channel will block until the run finishes, then yield the
+// error and close. The channel is buffered and safe to ignore.
+func (r *Republisher) Trigger() <-chan error {
+	resp := make(chan error, 1)
+	go func() {
+		select {
+		case r.triggerCh <- resp:
+		case <-r.dht.ctx.Done():
+			resp <- r.dht.ctx.Err()
+			close(resp)
+		}
+	}()
+	return resp
+}
+
+func (r *Republisher) run() {
+	r.dht.wg.Add(1)
+	go func() {
+		defer r.dht.wg.Done()
+
+		timer := time.NewTimer(r.firstRunDelay(r.dht.ctx))
+		defer timer.Stop()
+
+		for {
+			var respCh chan error
+			select {
+			case <-timer.C:
+			case respCh = <-r.triggerCh:
+			case <-r.dht.ctx.Done():
+				return
+			}
+
+			err := r.republish(r.dht.ctx)
+			if err != nil {
+				logger.Warnw("failed to republish records", "error", err)
+			}
+			if respCh != nil {
+				respCh <- err
+				close(respCh)
+			}
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(r.interval)
+		}
+	}()
+}
+
+// firstRunDelay returns the time to wait for the first run, based on when the last run before a
+// restart finished.
+func (r *Republisher) firstRunDelay(ctx context.Context) time.Duration {
+	buf, err := r.dstore.Get(ctx, ds.NewKey(republishLastRunKey))
+	if err != nil {
+		if err != ds.ErrNotFound {
+			logger.Warnw("failed to read last republish time", "error", err)
+		}
+		return r.interval
+	}
+	lastRun, err := decodeReprovideTime(buf)
+	if err != nil {
+		return r.interval
+	}
+	if delay := time.Until(lastRun.Add(r.interval)); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// republish stores all the tracked records on the network again, running at most concurrency
+// republishes in parallel.
+func (r *Republisher) republish(ctx context.Context) error {
+	ctx, span := internal.StartSpan(ctx, "Republisher.Republish")
+	defer span.End()
+
+	keys, err := r.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Infow("republishing records", "records", len(keys))
+
+	var failed int
+	var failedLk sync.Mutex
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if !r.republishKey(ctx, k) {
+				failedLk.Lock()
+				failed++
+				failedLk.Unlock()
+			}
+		}(k)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err := r.dstore.Put(ctx, ds.NewKey(republishLastRunKey), encodeReprovideTime(time.Now())); err != nil {
+		logger.Warnw("failed to persist last republish time", "error", err)
+	}
+	logger.Infow("finished republishing records", "records", len(keys), "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("failed to republish %d out of %d records", failed, len(keys))
+	}
+	return nil
+}
+
+// republishKey validates the record of the given key and stores it on the closest peers, recording
+// the outcome in the status of the key.
+func (r *Republisher) republishKey(ctx context.Context, key string) bool {
+	ctx, cancel := context.WithTimeout(ctx, republishKeyTimeout)
+	defer cancel()
+
+	start := time.Now()
+	peers, err := r.republishRecord(ctx, key)
+	if err != nil {
+		logger.Debugw("failed to republish record", "key", internal.LoggableRecordKeyString(key), "error", err)
+	}
+
+	r.statusLk.Lock()
+	defer r.statusLk.Unlock()
+	s := r.status[key]
+	s.LastAttempt = start
+	s.Peers = peers
+	s.Err = err
+	if err == nil {
+		s.LastSuccess = start
+	}
+	r.status[key] = s
+	return err == nil
+}
+
+func (r *Republisher) republishRecord(ctx context.Context, key string) (int, error) {
+	data, err := r.dstore.Get(ctx, mkRepublishDsKey(key))
+	if err != nil {
+		return 0, err
+	}
+	rec := new(recpb.Record)
+	if err := proto.Unmarshal(data, rec); err != nil {
+		return 0, err
+	}
+
+	// the validity of a record may change over time, e.g. IPNS records expire.
+	if err := r.dht.Validator.Validate(key, rec.GetValue()); err != nil {
+		return 0, fmt.Errorf("invalid record: %w", err)
+	}
+
+	local, err := r.dht.getLocal(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if local != nil && !bytes.Equal(local.GetValue(), rec.GetValue()) {
+		i, err := r.dht.Validator.Select(key, [][]byte{rec.GetValue(), local.GetValue()})
+		if err != nil {
+			return 0, err
+		}
+		if i != 0 {
+			return 0, ErrRecordSuperseded
+		}
+	}
+
+	rec.TimeReceived = u.FormatRFC3339(time.Now())
+	if err := r.dht.putLocal(ctx, key, rec); err != nil {
+		return 0, err
+	}
+	res, err := r.dht.putRecordToClosestPeers(ctx, key, rec)
+	if err != nil {
+		return 0, err
+	}
+	if res.Stored == 0 {
+		return 0, ErrNoPeersStored
+	}
+	return res.Stored, nil
+}
+
+func mkRepublishDsKey(key string) ds.Key {
+	return ds.NewKey(republishRecordsPrefix + base32.RawStdEncoding.EncodeToString([]byte(key)))
+}
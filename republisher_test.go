@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/stretchr/testify/require"
+
+	test "github.com/libp2p/go-libp2p-kad-dht/internal/testing"
+)
+
+// expiringValidator is a test validator whose records can be made invalid after they were put.
+type expiringValidator struct {
+	test.TestValidator
+	expired atomic.Bool
+}
+
+func (v *expiringValidator) Validate(key string, value []byte) error {
+	if v.expired.Load() {
+		return errors.New("expired")
+	}
+	return v.TestValidator.Validate(key, value)
+}
+
+func TestRepublisher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	validator := &expiringValidator{}
+	publisher := setupDHT(ctx, t, false, EnableRepublisher(), NamespacedValidator("v", validator))
+	dhts := setupDHTS(t, ctx, 4)
+	for _, d := range dhts {
+		connect(t, ctx, publisher, d)
+	}
+
+	r := publisher.Republisher()
+	require.NotNil(t, r)
+
+	key := "/v/republished"
+	require.NoError(t, publisher.PutValue(ctx, key, []byte("valid")))
+
+	keys, err := r.Keys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{key}, keys)
+	status, ok := r.Status(key)
+	require.True(t, ok)
+	require.True(t, status.LastAttempt.IsZero(), "nothing was republished yet")
+
+	// the peers lost the record, e.g. they restarted with an empty datastore
+	for _, d := range dhts {
+		require.NoError(t, d.datastore.Delete(ctx, mkDsKey(key)))
+	}
+
+	require.NoError(t, <-r.Trigger())
+	status, ok = r.Status(key)
+	require.True(t, ok)
+	require.NoError(t, status.Err)
+	require.Equal(t, len(dhts), status.Peers)
+	require.Equal(t, status.LastAttempt, status.LastSuccess)
+	for _, d := range dhts {
+		rec, err := d.getLocal(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, rec, "record not republished to %s", d.self)
+		require.Equal(t, []byte("valid"), rec.GetValue())
+	}
+
+	// records that aren't valid anymore are reported and not republished
+	validator.expired.Store(true)
+	require.Error(t, <-r.Trigger())
+	failed, ok := r.Status(key)
+	require.True(t, ok)
+	require.Error(t, failed.Err)
+	require.Zero(t, failed.Peers)
+	require.True(t, failed.LastAttempt.After(status.LastAttempt))
+	require.Equal(t, status.LastSuccess, failed.LastSuccess)
+
+	require.NoError(t, r.Remove(ctx, key))
+	keys, err = r.Keys(ctx)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	_, ok = r.Status(key)
+	require.False(t, ok)
+}
+
+func TestRepublisherSuperseded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	publisher := setupDHT(ctx, t, false, EnableRepublisher(), NamespacedValidator("v", test.TestValidator{}))
+	peer := setupDHT(ctx, t, false, NamespacedValidator("v", test.TestValidator{}))
+	connect(t, ctx, publisher, peer)
+
+	key := "/v/superseded"
+	require.NoError(t, publisher.PutValue(ctx, key, []byte("valid")))
+
+	// another node stored a newer record on us
+	require.NoError(t, publisher.putLocal(ctx, key, record.MakePutRecord(key, []byte("newer"))))
+
+	require.Error(t, <-publisher.Republisher().Trigger())
+	status, ok := publisher.Republisher().Status(key)
+	require.True(t, ok)
+	require.ErrorIs(t, status.Err, ErrRecordSuperseded)
+
+	rec, err := publisher.getLocal(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("newer"), rec.GetValue())
+}
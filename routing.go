@@ -23,6 +23,7 @@ import (
 	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
 	kb "github.com/libp2p/go-libp2p-kbucket"
 	record "github.com/libp2p/go-libp2p-record"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
 	"github.com/multiformats/go-multihash"
 )
 
@@ -30,27 +31,90 @@ import (
 
 // Basic Put/Get
 
+// ErrQuorumNotReached is returned by PutValue when fewer peers than required by the Quorum option
+// stored the record.
+var ErrQuorumNotReached = errors.New("not enough peers stored the record")
+
+// PeerPutResult is the outcome of storing a record on a single peer.
+type PeerPutResult struct {
+	Peer peer.ID
+	// Err is the reason the peer didn't store the record, nil if it did.
+	Err error
+	// Duration is the time it took the peer to answer.
+	Duration time.Duration
+}
+
+// PutValueResult describes where a record was stored by PutValueDetailed.
+type PutValueResult struct {
+	// Peers are the outcomes of storing the record on each of the closest peers to its key.
+	Peers []PeerPutResult
+	// Stored is the number of peers that stored the record.
+	Stored int
+}
+
 // PutValue adds value corresponding to given Key.
 // This is the top level "Store" operation of the DHT
+//
+// When the Quorum option is set to n > 0, PutValue fails with ErrQuorumNotReached if fewer than n
+// peers stored the record. The record is still stored locally and on the peers that accepted it.
 func (dht *IpfsDHT) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) (err error) {
 	ctx, end := tracer.PutValue(dhtName, ctx, key, value, opts...)
 	defer func() { end(err) }()
 
+	_, err = dht.putValue(ctx, key, value, opts...)
+	return err
+}
+
+// PutValueDetailed is like PutValue, but also returns the outcome of storing the record on every
+// peer it was sent to. The result is returned along with ErrQuorumNotReached when the quorum isn't
+// reached, and is nil for any other error.
+func (dht *IpfsDHT) PutValueDetailed(ctx context.Context, key string, value []byte, opts ...routing.Option) (*PutValueResult, error) {
+	ctx, span := internal.StartSpan(ctx, "IpfsDHT.PutValueDetailed", trace.WithAttributes(internal.KeyAsAttribute("Key", key)))
+	defer span.End()
+
+	return dht.putValue(ctx, key, value, opts...)
+}
+
+func (dht *IpfsDHT) putValue(ctx context.Context, key string, value []byte, opts ...routing.Option) (*PutValueResult, error) {
 	if !dht.enableValues {
-		return routing.ErrNotSupported
+		return nil, routing.ErrNotSupported
 	}
 
+	var cfg routing.Options
+	if err := cfg.Apply(opts...); err != nil {
+		return nil, err
+	}
+	quorum := internalConfig.GetQuorum(&cfg)
+
 	logger.Debugw("putting value", "key", internal.LoggableRecordKeyString(key))
 
+	rec, err := dht.putValueLocal(ctx, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := dht.putRecordToClosestPeers(ctx, key, rec)
+	if err != nil {
+		return nil, err
+	}
+	if res.Stored < quorum {
+		return res, fmt.Errorf("%w: %d out of %d required", ErrQuorumNotReached, res.Stored, quorum)
+	}
+	return res, nil
+}
+
+// putValueLocal validates the value and stores it in the local datastore, unless we already have a
+// newer value for the key. It returns the record to send to the network.
+func (dht *IpfsDHT) putValueLocal(ctx context.Context, key string, value []byte) (*recpb.Record, error) {
 	// don't even allow local users to put bad values.
 	if err := dht.Validator.Validate(key, value); err != nil {
-		return err
+		return nil, err
 	}
 
 	old, err := dht.getLocal(ctx, key)
 	if err != nil {
 		// Means something is wrong with the datastore.
-		return err
+		return nil, err
 	}
 
 	// Check if we have an old value that's not the same as the new one.
@@ -58,10 +122,10 @@ func (dht *IpfsDHT) PutValue(ctx context.Context, key string, value []byte, opts
 		// Check to see if the new one is better.
 		i, err := dht.Validator.Select(key, [][]byte{value, old.GetValue()})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if i != 0 {
-			return fmt.Errorf("can't replace a newer value with an older value")
+			return nil, fmt.Errorf("can't replace a newer value with an older value")
 		}
 	}
 
@@ -69,35 +133,48 @@ func (dht *IpfsDHT) PutValue(ctx context.Context, key string, value []byte, opts
 	rec.TimeReceived = u.FormatRFC3339(time.Now())
 	err = dht.putLocal(ctx, key, rec)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	if dht.republisher != nil {
+		if err := dht.republisher.add(ctx, key, rec); err != nil {
+			logger.Warnw("failed to add record to republisher", "key", internal.LoggableRecordKeyString(key), "error", err)
+		}
+	}
+	return rec, nil
+}
+
+// putRecordToClosestPeers stores the record on the closest peers to its key, and reports the outcome
+// for each of them. The record is sent to all the peers by a followup, the outstanding requests are
+// aborted when the context is cancelled.
+func (dht *IpfsDHT) putRecordToClosestPeers(ctx context.Context, key string, rec *recpb.Record) (*PutValueResult, error) {
 	peers, err := dht.GetClosestPeers(ctx, key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	wg := sync.WaitGroup{}
-	for _, p := range peers {
-		wg.Add(1)
-		go func(p peer.ID) {
-			ctx, cancel := context.WithCancel(ctx)
-			defer cancel()
-			defer wg.Done()
-			routing.PublishQueryEvent(ctx, &routing.QueryEvent{
-				Type: routing.Value,
-				ID:   p,
-			})
+	putFn := func(ctx context.Context, p peer.ID) ([]*peer.AddrInfo, error) {
+		routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+			Type: routing.Value,
+			ID:   p,
+		})
 
-			err := dht.protoMessenger.PutValue(ctx, p, rec)
-			if err != nil {
-				logger.Debugf("failed putting value to peer: %s", err)
-			}
-		}(p)
+		err := dht.protoMessenger.PutValue(ctx, p, rec)
+		if err != nil {
+			logger.Debugf("failed putting value to peer: %s", err)
+		}
+		return nil, err
 	}
-	wg.Wait()
+	results, _ := dht.runFollowup(ctx, peers, putFn, func() bool { return false })
 
-	return nil
+	res := &PutValueResult{Peers: make([]PeerPutResult, 0, len(results))}
+	for _, r := range results {
+		if r.err == nil {
+			res.Stored++
+		}
+		res.Peers = append(res.Peers, PeerPutResult{Peer: r.peer, Err: r.err, Duration: r.duration})
+	}
+	return res, nil
 }
 
 // recvdVal stores a value and the peer from which we got the value.
@@ -272,7 +349,7 @@ func (dht *IpfsDHT) updatePeerValues(ctx context.Context, key string, val []byte
 				}
 				return
 			}
-			ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+			ctx, cancel := dht.clock.WithTimeout(ctx, time.Second*30)
 			defer cancel()
 			err := dht.protoMessenger.PutValue(ctx, p, fixupRec)
 			if err != nil {
@@ -402,6 +479,36 @@ func (dht *IpfsDHT) Provide(ctx context.Context, key cid.Cid, brdcst bool) (err
 		return nil
 	}
 
+	// remember the key so that it gets announced again before it expires
+	if dht.reprovider != nil {
+		if err := dht.reprovider.Add(ctx, keyMH); err != nil {
+			logger.Warnw("failed to add key to reprovider", "mh", internal.LoggableProviderRecordBytes(keyMH), "error", err)
+		}
+	}
+
+	return dht.provide(ctx, keyMH)
+}
+
+// provide announces the given key to the closest peers in the network, using the optimistic provide
+// approach if it is enabled and falling back to the classic approach otherwise.
+func (dht *IpfsDHT) provide(ctx context.Context, keyMH multihash.Multihash) error {
+	if dht.encryptedProviders != nil {
+		// the encrypted provider record is announced alongside the classic one, so that the key can
+		// be found with both kinds of lookups.
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- dht.provideEncrypted(ctx, keyMH)
+		}()
+		err := dht.provideClassic(ctx, keyMH)
+		if encErr := <-errCh; err == nil {
+			err = encErr
+		}
+		return err
+	}
+	return dht.provideClassic(ctx, keyMH)
+}
+
+func (dht *IpfsDHT) provideClassic(ctx context.Context, keyMH multihash.Multihash) error {
 	if dht.enableOptProv {
 		err := dht.optimisticProvide(ctx, keyMH)
 		if errors.Is(err, netsize.ErrNotEnoughData) {
@@ -451,16 +558,14 @@ func (dht *IpfsDHT) classicProvide(ctx context.Context, keyMH multihash.Multihas
 		return err
 	}
 
+	self, signed := dht.selfProviderRecord(keyMH)
 	wg := sync.WaitGroup{}
 	for _, p := range peers {
 		wg.Add(1)
 		go func(p peer.ID) {
 			defer wg.Done()
 			logger.Debugf("putProvider(%s, %s)", internal.LoggableProviderRecordBytes(keyMH), p)
-			err := dht.protoMessenger.PutProviderAddrs(ctx, p, keyMH, peer.AddrInfo{
-				ID:    dht.self,
-				Addrs: dht.filterAddrs(dht.host.Addrs()),
-			})
+			err := dht.protoMessenger.PutSignedProviderAddrs(ctx, p, keyMH, self, signed)
 			if err != nil {
 				logger.Debug(err)
 			}
@@ -573,7 +678,19 @@ func (dht *IpfsDHT) findProvidersAsyncRoutine(ctx context.Context, key multihash
 				ID:   p,
 			})
 
-			provs, closest, err := dht.protoMessenger.GetProviders(ctx, p, key)
+			var (
+				provs, closest []*peer.AddrInfo
+				err            error
+			)
+			if dht.enableSignedProviders {
+				var signed [][]byte
+				provs, signed, closest, err = dht.protoMessenger.GetSignedProviders(ctx, p, key)
+				if err == nil {
+					provs = verifySignedProviders(key, p, provs, signed)
+				}
+			} else {
+				provs, closest, err = dht.protoMessenger.GetProviders(ctx, p, key)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -9,6 +9,9 @@ import (
 // values from before returning the best one. Zero means the DHT query
 // should complete instead of returning early.
 //
+// For PutValue, it is the number of peers that must store the record for
+// the put to succeed. Zero means the put succeeds as long as the lookup does.
+//
 // Default: 0
 func Quorum(n int) routing.Option {
 	return func(opts *routing.Options) error {
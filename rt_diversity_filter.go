@@ -9,6 +9,7 @@ import (
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
 
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 var _ peerdiversity.PeerIPGroupFilter = (*rtPeerIPGroupFilter)(nil)
@@ -22,6 +23,13 @@ type rtPeerIPGroupFilter struct {
 
 	cplIpGroupCount   map[int]map[peerdiversity.PeerIPGroupKey]int
 	tableIpGroupCount map[peerdiversity.PeerIPGroupKey]int
+
+	// the peers are also grouped by the ASN of their addresses when asn is set. The ASNs each peer
+	// was counted under are remembered, as its addresses are gone by the time it is removed.
+	asn           ASNResolver
+	cplAsnCount   map[int]map[string]int
+	tableAsnCount map[string]int
+	peerAsns      map[peer.ID][]string
 }
 
 // NewRTPeerDiversityFilter constructs the `PeerIPGroupFilter` that will be used to configure
@@ -54,7 +62,16 @@ func (r *rtPeerIPGroupFilter) Allow(g peerdiversity.PeerGroupInfo) bool {
 
 	c, ok := r.cplIpGroupCount[cpl]
 	allow := !ok || c[key] < r.maxPerCpl
-	return allow
+	if !allow || r.asn == nil {
+		return allow
+	}
+
+	for _, asn := range r.resolveAsns(g.Id) {
+		if r.tableAsnCount[asn] >= r.maxForTable || r.cplAsnCount[cpl][asn] >= r.maxPerCpl {
+			return false
+		}
+	}
+	return true
 }
 
 func (r *rtPeerIPGroupFilter) Increment(g peerdiversity.PeerGroupInfo) {
@@ -70,6 +87,20 @@ func (r *rtPeerIPGroupFilter) Increment(g peerdiversity.PeerGroupInfo) {
 	}
 
 	r.cplIpGroupCount[cpl][key] = r.cplIpGroupCount[cpl][key] + 1
+
+	// Increment is called for every IP group of the peer, its ASNs are only counted once.
+	if _, ok := r.peerAsns[g.Id]; r.asn == nil || ok {
+		return
+	}
+	asns := r.resolveAsns(g.Id)
+	r.peerAsns[g.Id] = asns
+	if _, ok := r.cplAsnCount[cpl]; !ok {
+		r.cplAsnCount[cpl] = make(map[string]int)
+	}
+	for _, asn := range asns {
+		r.tableAsnCount[asn]++
+		r.cplAsnCount[cpl][asn]++
+	}
 }
 
 func (r *rtPeerIPGroupFilter) Decrement(g peerdiversity.PeerGroupInfo) {
@@ -91,6 +122,25 @@ func (r *rtPeerIPGroupFilter) Decrement(g peerdiversity.PeerGroupInfo) {
 	if len(r.cplIpGroupCount[cpl]) == 0 {
 		delete(r.cplIpGroupCount, cpl)
 	}
+
+	asns, ok := r.peerAsns[g.Id]
+	if !ok {
+		return
+	}
+	delete(r.peerAsns, g.Id)
+	for _, asn := range asns {
+		r.tableAsnCount[asn]--
+		if r.tableAsnCount[asn] == 0 {
+			delete(r.tableAsnCount, asn)
+		}
+		r.cplAsnCount[cpl][asn]--
+		if r.cplAsnCount[cpl][asn] == 0 {
+			delete(r.cplAsnCount[cpl], asn)
+		}
+	}
+	if len(r.cplAsnCount[cpl]) == 0 {
+		delete(r.cplAsnCount, cpl)
+	}
 }
 
 func (r *rtPeerIPGroupFilter) PeerAddresses(p peer.ID) []ma.Multiaddr {
@@ -101,3 +151,38 @@ func (r *rtPeerIPGroupFilter) PeerAddresses(p peer.ID) []ma.Multiaddr {
 	}
 	return addr
 }
+
+// setASNResolver makes the filter group the peers by the ASN of their addresses too, with the same
+// limits as the IP groups. It must be called before the filter is used.
+func (r *rtPeerIPGroupFilter) setASNResolver(asn ASNResolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.asn = asn
+	r.cplAsnCount = make(map[int]map[string]int)
+	r.tableAsnCount = make(map[string]int)
+	r.peerAsns = make(map[peer.ID][]string)
+}
+
+// resolveAsns returns the distinct ASNs of the addresses of p.
+func (r *rtPeerIPGroupFilter) resolveAsns(p peer.ID) []string {
+	var asns []string
+	for _, a := range r.PeerAddresses(p) {
+		ip, err := manet.ToIP(a)
+		if err != nil {
+			continue
+		}
+		asn, err := r.asn.ASN(ip)
+		if err != nil || asn == "" {
+			continue
+		}
+		dup := false
+		for _, known := range asns {
+			dup = dup || known == asn
+		}
+		if !dup {
+			asns = append(asns, asn)
+		}
+	}
+	return asns
+}
@@ -2,11 +2,14 @@ package dht
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
 	kb "github.com/libp2p/go-libp2p-kbucket"
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 
@@ -54,6 +57,42 @@ func TestRTPeerDiversityFilter(t *testing.T) {
 	require.False(t, r.Allow(g2))
 }
 
+func TestRTPeerDiversityFilterASN(t *testing.T) {
+	ctx := context.Background()
+	newHost := func() host.Host {
+		h, err := bhost.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableReuseport), new(bhost.HostOpts))
+		require.NoError(t, err)
+		h.Start()
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	h, h2, h3 := newHost(), newHost(), newHost()
+	require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: h3.ID(), Addrs: h3.Addrs()}))
+
+	table := NewASNTable()
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	table.Add(loopback, "64496")
+
+	r := NewRTPeerDiversityFilter(h, 1, 3)
+	r.setASNResolver(table)
+
+	// the peers are in different IP groups but share the same ASN
+	g2 := peerdiversity.PeerGroupInfo{Id: h2.ID(), Cpl: 1, IPGroupKey: "a"}
+	g3 := peerdiversity.PeerGroupInfo{Id: h3.ID(), Cpl: 1, IPGroupKey: "b"}
+	require.True(t, r.Allow(g2))
+	r.Increment(g2)
+	require.False(t, r.Allow(g3))
+
+	g3.Cpl = 2
+	require.True(t, r.Allow(g3))
+
+	// it works after removing h2
+	g3.Cpl = 1
+	r.Decrement(g2)
+	require.True(t, r.Allow(g3))
+}
+
 func TestRoutingTableEndToEndMaxPerCpl(t *testing.T) {
 	ctx := context.Background()
 	h, err := bhost.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableReuseport), new(bhost.HostOpts))
@@ -0,0 +1,192 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// RoutingTableEventType is the type of a routing table change.
+type RoutingTableEventType int
+
+const (
+	// RoutingTablePeerAdded indicates that a peer was added to the routing table.
+	RoutingTablePeerAdded RoutingTableEventType = iota
+	// RoutingTablePeerEvicted indicates that a peer was evicted from the routing table because it
+	// failed its periodic liveliness check.
+	RoutingTablePeerEvicted
+	// RoutingTablePeerRemoved indicates that a peer was removed from the routing table, because it
+	// stopped speaking the DHT protocol, because it was replaced by another peer or because the
+	// routing table filter set with Reconfigure rejects it.
+	RoutingTablePeerRemoved
+	// RoutingTablePeerRejected indicates that the routing table refused a peer, e.g. because of the
+	// diversity filter.
+	RoutingTablePeerRejected
+	// RoutingTablePeerDropped indicates that a newly found peer was dropped without being checked
+	// because the maximal number of concurrent lookup checks was reached.
+	RoutingTablePeerDropped
+)
+
+// MarshalJSON returns the JSON encoding of the passed routing table event type.
+func (t RoutingTableEventType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t RoutingTableEventType) String() string {
+	switch t {
+	case RoutingTablePeerAdded:
+		return "added"
+	case RoutingTablePeerEvicted:
+		return "evicted"
+	case RoutingTablePeerRemoved:
+		return "removed"
+	case RoutingTablePeerRejected:
+		return "rejected"
+	case RoutingTablePeerDropped:
+		return "dropped"
+	}
+	panic("unreachable")
+}
+
+// RoutingTableEvent is emitted for every change, or refused change, of the routing table.
+type RoutingTableEvent struct {
+	// Type is the kind of change.
+	Type RoutingTableEventType
+	// Peer is the peer the change is about.
+	Peer peer.ID
+	// CPL is the common prefix length of the peer with our own key, i.e. the bucket it belongs to.
+	CPL int
+	// Reason is a human readable explanation of the change.
+	Reason string
+}
+
+const (
+	rtReasonQueried       = "answered a query"
+	rtReasonBootstrapping = "answered a query while bootstrapping"
+	rtReasonRestored      = "restored from the routing table snapshot"
+	rtReasonStoppedDHT    = "stopped speaking the DHT protocol"
+	rtReasonReplaced      = "replaced by a new peer"
+	rtReasonNoCapacity    = "lookup check capacity exhausted"
+	rtReasonFiltered      = "rejected by the routing table filter"
+)
+
+// RoutingTableEventBufferSize is the number of routing table events to buffer per subscriber.
+var RoutingTableEventBufferSize = 16
+
+type rtEventChannel struct {
+	mu  sync.Mutex
+	ctx context.Context
+	ch  chan<- *RoutingTableEvent
+}
+
+// waitThenClose is spawned in a goroutine when the channel is registered. This
+// safely cleans up the channel when the context has been canceled.
+func (e *rtEventChannel) waitThenClose() {
+	<-e.ctx.Done()
+	e.mu.Lock()
+	close(e.ch)
+	e.ch = nil
+	e.mu.Unlock()
+}
+
+// send sends an event on the event channel. Events are emitted while the routing table is locked,
+// so the event is dropped rather than blocking if the subscriber doesn't keep up.
+func (e *rtEventChannel) send(ev *RoutingTableEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Closed.
+	if e.ch == nil {
+		return
+	}
+	select {
+	case e.ch <- ev:
+	default:
+		logger.Debugw("dropping routing table event, subscriber is too slow", "peer", ev.Peer, "type", ev.Type)
+	}
+}
+
+// rtEvents keeps track of the routing table event subscribers, along with the reasons of the
+// removals that are about to happen.
+type rtEvents struct {
+	mu       sync.Mutex
+	subs     map[*rtEventChannel]struct{}
+	removals map[peer.ID]*RoutingTableEvent
+}
+
+// RegisterForRoutingTableEvents returns a channel on which the changes of the routing table are
+// emitted. The channel is closed once the passed context is canceled, which MUST happen when the
+// caller is no longer interested in routing table events.
+//
+// Events are dropped if the channel buffer (see RoutingTableEventBufferSize) is full.
+func (dht *IpfsDHT) RegisterForRoutingTableEvents(ctx context.Context) <-chan *RoutingTableEvent {
+	ch := make(chan *RoutingTableEvent, RoutingTableEventBufferSize)
+	ech := &rtEventChannel{ch: ch, ctx: ctx}
+
+	dht.rtEvents.mu.Lock()
+	if dht.rtEvents.subs == nil {
+		dht.rtEvents.subs = make(map[*rtEventChannel]struct{})
+	}
+	dht.rtEvents.subs[ech] = struct{}{}
+	dht.rtEvents.mu.Unlock()
+
+	go func() {
+		ech.waitThenClose()
+		dht.rtEvents.mu.Lock()
+		delete(dht.rtEvents.subs, ech)
+		dht.rtEvents.mu.Unlock()
+	}()
+	return ch
+}
+
+// publishRoutingTableEvent emits a routing table event to all the subscribers.
+func (dht *IpfsDHT) publishRoutingTableEvent(typ RoutingTableEventType, p peer.ID, reason string) {
+	dht.rtEvents.mu.Lock()
+	defer dht.rtEvents.mu.Unlock()
+	if len(dht.rtEvents.subs) == 0 {
+		return
+	}
+	ev := &RoutingTableEvent{
+		Type:   typ,
+		Peer:   p,
+		CPL:    kb.CommonPrefixLen(dht.selfKey, kb.ConvertPeerID(p)),
+		Reason: reason,
+	}
+	for ech := range dht.rtEvents.subs {
+		ech.send(ev)
+	}
+}
+
+// removePeerFromRT removes p from the routing table, recording why so that the PeerRemoved
+// callback can emit the matching event.
+func (dht *IpfsDHT) removePeerFromRT(p peer.ID, typ RoutingTableEventType, reason string) {
+	dht.rtEvents.mu.Lock()
+	if dht.rtEvents.removals == nil {
+		dht.rtEvents.removals = make(map[peer.ID]*RoutingTableEvent)
+	}
+	dht.rtEvents.removals[p] = &RoutingTableEvent{Type: typ, Reason: reason}
+	dht.rtEvents.mu.Unlock()
+
+	dht.routingTable.RemovePeer(p)
+
+	// the peer may not have been in the routing table at all.
+	dht.rtEvents.mu.Lock()
+	delete(dht.rtEvents.removals, p)
+	dht.rtEvents.mu.Unlock()
+}
+
+// peerRemovedFromRT emits the event for a peer that just left the routing table. Removals that
+// didn't go through removePeerFromRT happen when the routing table replaces a peer.
+func (dht *IpfsDHT) peerRemovedFromRT(p peer.ID) {
+	dht.rtEvents.mu.Lock()
+	ev, ok := dht.rtEvents.removals[p]
+	delete(dht.rtEvents.removals, p)
+	dht.rtEvents.mu.Unlock()
+
+	if !ok {
+		ev = &RoutingTableEvent{Type: RoutingTablePeerRemoved, Reason: rtReasonReplaced}
+	}
+	dht.publishRoutingTableEvent(ev.Type, p, ev.Reason)
+}
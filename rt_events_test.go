@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTableEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+	other := setupDHT(ctx, t, false)
+	dropped := setupDHT(ctx, t, false)
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	evs := d.RegisterForRoutingTableEvents(subCtx)
+
+	// waitFor skips the events about other peers, or other changes, triggered in the background.
+	waitFor := func(typ RoutingTableEventType, p peer.ID) *RoutingTableEvent {
+		t.Helper()
+		for {
+			select {
+			case ev := <-evs:
+				require.NotNil(t, ev)
+				if ev.Type == typ && ev.Peer == p {
+					return ev
+				}
+			case <-ctx.Done():
+				t.Fatal(ctx.Err())
+				return nil
+			}
+		}
+	}
+	cpl := kb.CommonPrefixLen(d.selfKey, kb.ConvertPeerID(other.self))
+
+	connect(t, ctx, d, other)
+	ev := waitFor(RoutingTablePeerAdded, other.self)
+	require.Equal(t, cpl, ev.CPL)
+	require.NotEmpty(t, ev.Reason)
+
+	d.peerStoppedDHT(other.self)
+	ev = waitFor(RoutingTablePeerRemoved, other.self)
+	require.Equal(t, cpl, ev.CPL)
+	require.Equal(t, rtReasonStoppedDHT, ev.Reason)
+
+	d.lookupChecksLk.Lock()
+	capacity := d.lookupCheckCapacity
+	d.lookupCheckCapacity = 0
+	d.lookupChecksLk.Unlock()
+	connectNoSync(t, ctx, d, dropped)
+	ev = waitFor(RoutingTablePeerDropped, dropped.self)
+	require.Equal(t, rtReasonNoCapacity, ev.Reason)
+	d.lookupChecksLk.Lock()
+	d.lookupCheckCapacity = capacity
+	d.lookupChecksLk.Unlock()
+
+	// removing a peer that isn't in the routing table doesn't leave its reason behind
+	d.removePeerFromRT(dropped.self, RoutingTablePeerEvicted, "failed ping")
+	d.rtEvents.mu.Lock()
+	require.Empty(t, d.rtEvents.removals)
+	d.rtEvents.mu.Unlock()
+
+	subCancel()
+	for range evs {
+	}
+}
+
+func TestRoutingTableEventTypeJSON(t *testing.T) {
+	b, err := RoutingTablePeerEvicted.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, `"evicted"`, string(b))
+}
@@ -0,0 +1,237 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// rtSnapshotKey is the datastore key holding the latest routing table snapshot.
+	rtSnapshotKey = "/routing-table/snapshot"
+	// rtSnapshotVersion is bumped whenever the snapshot format changes in an incompatible way.
+	rtSnapshotVersion = 1
+
+	// rtRestoreConcurrency bounds the number of snapshot peers validated in parallel on startup.
+	rtRestoreConcurrency = 32
+)
+
+// rtSnapshot is the persisted form of the routing table.
+type rtSnapshot struct {
+	Version int
+	Taken   time.Time
+	Peers   []rtSnapshotPeer
+}
+
+// rtSnapshotPeer is the persisted form of a single routing table entry.
+type rtSnapshotPeer struct {
+	ID                            peer.ID
+	Addrs                         [][]byte
+	AddedAt                       time.Time
+	LastUsefulAt                  time.Time
+	LastSuccessfulOutboundQueryAt time.Time
+}
+
+// takeRoutingTableSnapshot captures the current routing table along with the known addresses
+// of its peers. Peers without any known address are left out since we couldn't dial them back.
+func (dht *IpfsDHT) takeRoutingTableSnapshot() *rtSnapshot {
+	infos := dht.routingTable.GetPeerInfos()
+	snap := &rtSnapshot{
+		Version: rtSnapshotVersion,
+		Taken:   time.Now(),
+		Peers:   make([]rtSnapshotPeer, 0, len(infos)),
+	}
+	for _, pi := range infos {
+		addrs := dht.peerstore.Addrs(pi.Id)
+		if len(addrs) == 0 {
+			continue
+		}
+		sp := rtSnapshotPeer{
+			ID:                            pi.Id,
+			Addrs:                         make([][]byte, 0, len(addrs)),
+			AddedAt:                       pi.AddedAt,
+			LastUsefulAt:                  pi.LastUsefulAt,
+			LastSuccessfulOutboundQueryAt: pi.LastSuccessfulOutboundQueryAt,
+		}
+		for _, a := range addrs {
+			sp.Addrs = append(sp.Addrs, a.Bytes())
+		}
+		snap.Peers = append(snap.Peers, sp)
+	}
+	return snap
+}
+
+// saveRoutingTableSnapshot writes a snapshot of the routing table to the datastore. Nothing is
+// written until the previous snapshot was restored, nor if the routing table is empty, so that a
+// restart doesn't lose the peers of the previous snapshot.
+func (dht *IpfsDHT) saveRoutingTableSnapshot(ctx context.Context) error {
+	if !dht.rtRestored.Load() {
+		logger.Debug("routing table snapshot not restored yet, not persisting the routing table")
+		return nil
+	}
+	snap := dht.takeRoutingTableSnapshot()
+	if len(snap.Peers) == 0 {
+		logger.Debug("routing table is empty, not persisting it")
+		return nil
+	}
+	buf, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := dht.datastore.Put(ctx, ds.NewKey(rtSnapshotKey), buf); err != nil {
+		return fmt.Errorf("failed to persist routing table snapshot: %w", err)
+	}
+	logger.Debugw("persisted routing table snapshot", "peers", len(snap.Peers))
+	return nil
+}
+
+// loadRoutingTableSnapshot reads the latest routing table snapshot from the datastore.
+// It returns nil, nil if there is no snapshot.
+func (dht *IpfsDHT) loadRoutingTableSnapshot(ctx context.Context) (*rtSnapshot, error) {
+	buf, err := dht.datastore.Get(ctx, ds.NewKey(rtSnapshotKey))
+	if err == ds.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap := new(rtSnapshot)
+	if err := json.Unmarshal(buf, snap); err != nil {
+		return nil, fmt.Errorf("failed to decode routing table snapshot: %w", err)
+	}
+	if snap.Version != rtSnapshotVersion {
+		return nil, fmt.Errorf("unsupported routing table snapshot version %d", snap.Version)
+	}
+	return snap, nil
+}
+
+// restoreRoutingTable seeds the routing table with the peers of the persisted snapshot.
+// Every peer is validated with a lookupCheck before being added, so that peers that went away or
+// stopped speaking our protocol while we were offline don't end up in the routing table.
+// The routing table is persisted again only once the restore completed.
+func (dht *IpfsDHT) restoreRoutingTable(ctx context.Context) {
+	snap, err := dht.loadRoutingTableSnapshot(ctx)
+	if err != nil {
+		logger.Warnw("failed to load routing table snapshot", "error", err)
+		dht.rtRestored.Store(true)
+		return
+	}
+	if snap == nil || len(snap.Peers) == 0 {
+		dht.rtRestored.Store(true)
+		return
+	}
+
+	var (
+		wg       sync.WaitGroup
+		restored int
+		mu       sync.Mutex
+	)
+	sem := make(chan struct{}, rtRestoreConcurrency)
+	for _, sp := range snap.Peers {
+		if sp.ID == dht.self {
+			continue
+		}
+		addrs := make([]ma.Multiaddr, 0, len(sp.Addrs))
+		for _, b := range sp.Addrs {
+			a, err := ma.NewMultiaddrBytes(b)
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, a)
+		}
+		addrs = dht.filterAddrs(addrs)
+		if len(addrs) == 0 {
+			continue
+		}
+		dht.peerstore.AddAddrs(sp.ID, addrs, peerstore.TempAddrTTL)
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(sp rtSnapshotPeer) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if dht.restoreSnapshotPeer(ctx, sp) {
+				mu.Lock()
+				restored++
+				mu.Unlock()
+			}
+		}(sp)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	dht.rtRestored.Store(true)
+
+	logger.Infow("restored routing table from snapshot", "restored", restored, "snapshot", len(snap.Peers), "taken", snap.Taken)
+}
+
+// restoreSnapshotPeer validates a single snapshot peer and adds it to the routing table,
+// carrying over its LastUsefulAt. It returns true if the peer was added.
+func (dht *IpfsDHT) restoreSnapshotPeer(ctx context.Context, sp rtSnapshotPeer) bool {
+	livelinessCtx, cancel := context.WithTimeout(ctx, dht.lookupCheckTimeout)
+	defer cancel()
+
+	if err := dht.lookupCheck(livelinessCtx, sp.ID); err != nil {
+		logger.Debugw("dropping routing table snapshot peer", "peer", sp.ID, "error", err)
+		return false
+	}
+	if !dht.allowedInRT(sp.ID) {
+		return false
+	}
+
+	// restored peers are replaceable, just like the peers we find while bootstrapping.
+	added, err := dht.routingTable.TryAddPeer(sp.ID, true, true)
+	if err != nil {
+		dht.publishRoutingTableEvent(RoutingTablePeerRejected, sp.ID, err.Error())
+		return false
+	}
+	if !added {
+		return false
+	}
+	dht.publishRoutingTableEvent(RoutingTablePeerAdded, sp.ID, rtReasonRestored)
+	// The lookupCheck above refreshed LastSuccessfulOutboundQueryAt, only LastUsefulAt needs to be
+	// carried over from the snapshot.
+	if !sp.LastUsefulAt.IsZero() {
+		dht.routingTable.UpdateLastUsefulAt(sp.ID, sp.LastUsefulAt)
+	}
+	// keep the addresses around for as long as the peer stays in the routing table.
+	dht.peerstore.UpdateAddrs(sp.ID, peerstore.TempAddrTTL, peerstore.RecentlyConnectedAddrTTL)
+	return true
+}
+
+// runRoutingTablePersistence periodically persists the routing table to the datastore.
+// A final snapshot is taken on Close.
+func (dht *IpfsDHT) runRoutingTablePersistence() {
+	dht.wg.Add(1)
+	go func() {
+		defer dht.wg.Done()
+
+		ticker := time.NewTicker(dht.rtPersistInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := dht.saveRoutingTableSnapshot(dht.ctx); err != nil {
+					logger.Warnw("failed to persist routing table", "error", err)
+				}
+			case <-dht.ctx.Done():
+				return
+			}
+		}
+	}()
+}
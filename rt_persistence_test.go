@@ -0,0 +1,116 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTablePersistence(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	a := setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore))
+	dhts := setupDHTS(t, ctx, 3)
+	for _, d := range dhts {
+		connect(t, ctx, a, d)
+	}
+
+	usefulAt := time.Now().Add(-time.Hour).Round(0)
+	require.True(t, a.routingTable.UpdateLastUsefulAt(dhts[0].self, usefulAt))
+
+	// the snapshot is written on Close
+	require.NoError(t, a.Close())
+
+	snap, err := a.loadRoutingTableSnapshot(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, snap)
+	require.Len(t, snap.Peers, len(dhts))
+
+	// a restarted node isn't connected to anyone, it must reach the old peers through the snapshot
+	restarted := setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore))
+	require.Eventually(t, func() bool {
+		return restarted.routingTable.Size() == len(dhts)
+	}, 10*time.Second, 10*time.Millisecond)
+
+	for _, d := range dhts {
+		require.NotEmpty(t, restarted.routingTable.Find(d.self))
+	}
+	for _, pi := range restarted.routingTable.GetPeerInfos() {
+		if pi.Id == dhts[0].self {
+			require.True(t, usefulAt.Equal(pi.LastUsefulAt))
+		}
+	}
+
+	// the snapshot is restored without the fix low peers routine too.
+	noFix := setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore), disableFixLowPeersRoutine(t))
+	require.Eventually(t, func() bool {
+		return noFix.routingTable.Size() == len(dhts)
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestRoutingTablePersistenceDropsUnreachablePeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	a := setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore))
+	alive := setupDHT(ctx, t, false)
+	gone := setupDHT(ctx, t, false)
+	connect(t, ctx, a, alive)
+	connect(t, ctx, a, gone)
+	require.NoError(t, a.Close())
+
+	require.NoError(t, gone.Close())
+	require.NoError(t, gone.host.Close())
+
+	restarted := setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore))
+	require.Eventually(t, func() bool {
+		return restarted.routingTable.Find(alive.self) != ""
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Empty(t, restarted.routingTable.Find(gone.self))
+}
+
+func TestRoutingTablePersistenceKeepsSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	a := setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore))
+	gone := setupDHT(ctx, t, false)
+	connect(t, ctx, a, gone)
+	require.NoError(t, a.Close())
+
+	// a node closed right after its start keeps the snapshot it didn't restore yet.
+	restarted := setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore))
+	require.NoError(t, restarted.Close())
+	snap, err := restarted.loadRoutingTableSnapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, snap.Peers, 1)
+
+	// the snapshot isn't replaced by the empty routing table of a node that couldn't reach its peers.
+	require.NoError(t, gone.Close())
+	require.NoError(t, gone.host.Close())
+	restarted = setupDHT(ctx, t, false, RoutingTablePersistence(time.Hour), Datastore(dstore))
+	require.Eventually(t, restarted.rtRestored.Load, 10*time.Second, 10*time.Millisecond)
+	require.Zero(t, restarted.routingTable.Size())
+	require.NoError(t, restarted.Close())
+	snap, err = restarted.loadRoutingTableSnapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, snap.Peers, 1)
+	require.Equal(t, gone.self, snap.Peers[0].ID)
+}
+
+func TestRoutingTablePersistenceOption(t *testing.T) {
+	ctx := context.Background()
+	_, err := New(ctx, nil, RoutingTablePersistence(0))
+	require.Error(t, err)
+}
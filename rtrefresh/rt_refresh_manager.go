@@ -49,12 +49,43 @@ type RtRefreshManager struct {
 	// interval between two periodic refreshes.
 	// also, a cpl wont be refreshed if the time since it was last refreshed
 	// is below the interval..unless a "forced" refresh is done.
+	// Both are guarded by intervalLk as they can be changed with SetRefreshInterval.
+	intervalLk                         sync.RWMutex
 	refreshInterval                    time.Duration
 	successfulOutboundQueryGracePeriod time.Duration
+	intervalChanged                    chan struct{}
 
 	triggerRefresh chan *triggerRefreshReq // channel to write refresh requests to.
 
 	refreshDoneCh chan struct{} // write to this channel after every refresh
+
+	evictPeerFnc func(p peer.ID, err error) // removes a peer that failed its liveliness check from the Routing Table
+
+	statusLk sync.Mutex
+	status   Status
+}
+
+// Status describes the state of the Routing Table refreshes.
+type Status struct {
+	// Refreshing is true while a refresh is running.
+	Refreshing bool
+	// LastRefresh is the time the last refresh finished, zero if none has finished yet.
+	LastRefresh time.Time
+	// LastError is the error the last refresh failed with, nil if it succeeded.
+	LastError error
+}
+
+// Option configures optional behaviour of the RtRefreshManager.
+type Option func(*RtRefreshManager)
+
+// EvictPeerFunc sets the function used to evict the peers that failed their liveliness check, along
+// with the error they failed with. The function is expected to remove the peer from the Routing Table.
+//
+// Defaults to removing the peer from the Routing Table.
+func EvictPeerFunc(f func(p peer.ID, err error)) Option {
+	return func(r *RtRefreshManager) {
+		r.evictPeerFnc = f
+	}
 }
 
 func NewRtRefreshManager(h host.Host, rt *kbucket.RoutingTable, autoRefresh bool,
@@ -64,10 +95,11 @@ func NewRtRefreshManager(h host.Host, rt *kbucket.RoutingTable, autoRefresh bool
 	refreshQueryTimeout time.Duration,
 	refreshInterval time.Duration,
 	successfulOutboundQueryGracePeriod time.Duration,
-	refreshDoneCh chan struct{}) (*RtRefreshManager, error) {
+	refreshDoneCh chan struct{},
+	opts ...Option) (*RtRefreshManager, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
-	return &RtRefreshManager{
+	r := &RtRefreshManager{
 		ctx:       ctx,
 		cancel:    cancel,
 		h:         h,
@@ -83,9 +115,17 @@ func NewRtRefreshManager(h host.Host, rt *kbucket.RoutingTable, autoRefresh bool
 		refreshInterval:                    refreshInterval,
 		successfulOutboundQueryGracePeriod: successfulOutboundQueryGracePeriod,
 
+		intervalChanged: make(chan struct{}, 1),
+
 		triggerRefresh: make(chan *triggerRefreshReq),
 		refreshDoneCh:  refreshDoneCh,
-	}, nil
+
+		evictPeerFnc: func(p peer.ID, _ error) { rt.RemovePeer(p) },
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
 }
 
 func (r *RtRefreshManager) Start() {
@@ -129,6 +169,27 @@ func (r *RtRefreshManager) RefreshNoWait() {
 	}
 }
 
+// SetRefreshInterval changes the interval between two periodic refreshes, and the grace period
+// after their last successful outbound query during which the peers aren't pinged. The next
+// periodic refresh is scheduled one new interval from now.
+func (r *RtRefreshManager) SetRefreshInterval(refreshInterval, successfulOutboundQueryGracePeriod time.Duration) {
+	r.intervalLk.Lock()
+	r.refreshInterval = refreshInterval
+	r.successfulOutboundQueryGracePeriod = successfulOutboundQueryGracePeriod
+	r.intervalLk.Unlock()
+
+	select {
+	case r.intervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (r *RtRefreshManager) intervals() (refreshInterval, successfulOutboundQueryGracePeriod time.Duration) {
+	r.intervalLk.RLock()
+	defer r.intervalLk.RUnlock()
+	return r.refreshInterval, r.successfulOutboundQueryGracePeriod
+}
+
 // pingAndEvictPeers pings Routing Table peers that haven't been heard of/from
 // in the interval they should have been and evict them if they don't reply.
 func (r *RtRefreshManager) pingAndEvictPeers(ctx context.Context) {
@@ -138,9 +199,10 @@ func (r *RtRefreshManager) pingAndEvictPeers(ctx context.Context) {
 	var peersChecked int
 	var alive int64
 	var wg sync.WaitGroup
+	_, gracePeriod := r.intervals()
 	peers := r.rt.GetPeerInfos()
 	for _, ps := range peers {
-		if time.Since(ps.LastSuccessfulOutboundQueryAt) <= r.successfulOutboundQueryGracePeriod {
+		if time.Since(ps.LastSuccessfulOutboundQueryAt) <= gracePeriod {
 			continue
 		}
 
@@ -158,14 +220,14 @@ func (r *RtRefreshManager) pingAndEvictPeers(ctx context.Context) {
 			if err := r.h.Connect(livelinessCtx, peer.AddrInfo{ID: ps.Id}); err != nil {
 				logger.Debugw("evicting peer after failed connection", "peer", peerIdStr, "error", err)
 				span.RecordError(err)
-				r.rt.RemovePeer(ps.Id)
+				r.evictPeerFnc(ps.Id, err)
 				return
 			}
 
 			if err := r.refreshPingFnc(livelinessCtx, ps.Id); err != nil {
 				logger.Debugw("evicting peer after failed ping", "peer", peerIdStr, "error", err)
 				span.RecordError(err)
-				r.rt.RemovePeer(ps.Id)
+				r.evictPeerFnc(ps.Id, err)
 				return
 			}
 
@@ -180,15 +242,17 @@ func (r *RtRefreshManager) pingAndEvictPeers(ctx context.Context) {
 func (r *RtRefreshManager) loop() {
 	defer r.refcount.Done()
 
+	var refreshTickr *time.Ticker
 	var refreshTickrCh <-chan time.Time
 	if r.enableAutoRefresh {
-		err := r.doRefresh(r.ctx, true)
+		err := r.refresh(r.ctx, true)
 		if err != nil {
 			logger.Warn("failed when refreshing routing table", err)
 		}
-		t := time.NewTicker(r.refreshInterval)
-		defer t.Stop()
-		refreshTickrCh = t.C
+		refreshInterval, _ := r.intervals()
+		refreshTickr = time.NewTicker(refreshInterval)
+		defer refreshTickr.Stop()
+		refreshTickrCh = refreshTickr.C
 	}
 
 	for {
@@ -196,6 +260,12 @@ func (r *RtRefreshManager) loop() {
 		var forced bool
 		select {
 		case <-refreshTickrCh:
+		case <-r.intervalChanged:
+			if refreshTickr != nil {
+				refreshInterval, _ := r.intervals()
+				refreshTickr.Reset(refreshInterval)
+			}
+			continue
 		case triggerRefreshReq := <-r.triggerRefresh:
 			if triggerRefreshReq.respCh != nil {
 				waiting = append(waiting, triggerRefreshReq.respCh)
@@ -224,7 +294,7 @@ func (r *RtRefreshManager) loop() {
 		r.pingAndEvictPeers(ctx)
 
 		// Query for self and refresh the required buckets
-		err := r.doRefresh(ctx, forced)
+		err := r.refresh(ctx, forced)
 		for _, w := range waiting {
 			w <- err
 			close(w)
@@ -237,6 +307,27 @@ func (r *RtRefreshManager) loop() {
 	}
 }
 
+// Status returns the state of the Routing Table refreshes.
+func (r *RtRefreshManager) Status() Status {
+	r.statusLk.Lock()
+	defer r.statusLk.Unlock()
+	return r.status
+}
+
+// refresh runs a refresh, keeping track of its status.
+func (r *RtRefreshManager) refresh(ctx context.Context, forceRefresh bool) error {
+	r.statusLk.Lock()
+	r.status.Refreshing = true
+	r.statusLk.Unlock()
+
+	err := r.doRefresh(ctx, forceRefresh)
+
+	r.statusLk.Lock()
+	r.status = Status{LastRefresh: time.Now(), LastError: err}
+	r.statusLk.Unlock()
+	return err
+}
+
 func (r *RtRefreshManager) doRefresh(ctx context.Context, forceRefresh bool) error {
 	ctx, span := internal.StartSpan(ctx, "RefreshManager.doRefresh")
 	defer span.End()
@@ -300,7 +391,7 @@ func min(a int, b int) int {
 }
 
 func (r *RtRefreshManager) refreshCplIfEligible(ctx context.Context, cpl uint, lastRefreshedAt time.Time) error {
-	if time.Since(lastRefreshedAt) <= r.refreshInterval {
+	if refreshInterval, _ := r.intervals(); time.Since(lastRefreshedAt) <= refreshInterval {
 		logger.Debugf("not running refresh for cpl %d as time since last refresh not above interval", cpl)
 		return nil
 	}
@@ -0,0 +1,143 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/libp2p/go-libp2p-kad-dht/internal"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// signedProvidersSuffix is appended to the DHT protocol to form the protocol extension used to
+// negotiate signed provider records. Both protocols share the same wire format, peers speaking
+// the extension additionally return signed provider records in GET_PROVIDERS responses.
+const signedProvidersSuffix protocol.ID = "/signed-providers"
+
+type signedProvidersCtxKey struct{}
+
+// withSignedProviders marks the context of a request received over the signed provider records
+// protocol extension.
+func withSignedProviders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, signedProvidersCtxKey{}, struct{}{})
+}
+
+func wantsSignedProviders(ctx context.Context) bool {
+	return ctx.Value(signedProvidersCtxKey{}) != nil
+}
+
+// selfProviderRecord returns our own provider info, along with a signed provider record for the
+// given key if signed provider records are enabled.
+func (dht *IpfsDHT) selfProviderRecord(key multihash.Multihash) (peer.AddrInfo, []byte) {
+	self := peer.AddrInfo{
+		ID:    dht.self,
+		Addrs: dht.filterAddrs(dht.host.Addrs()),
+	}
+	if !dht.enableSignedProviders {
+		return self, nil
+	}
+
+	sk := dht.peerstore.PrivKey(dht.self)
+	if sk == nil {
+		logger.Warnw("no private key to sign the provider record with", "mh", internal.LoggableProviderRecordBytes(key))
+		return self, nil
+	}
+	env, err := providers.SealProviderRecord(key, self, sk)
+	if err != nil {
+		logger.Warnw("failed to sign provider record", "mh", internal.LoggableProviderRecordBytes(key), "error", err)
+		return self, nil
+	}
+	signed, err := env.Marshal()
+	if err != nil {
+		logger.Warnw("failed to marshal provider record", "mh", internal.LoggableProviderRecordBytes(key), "error", err)
+		return self, nil
+	}
+	return self, signed
+}
+
+// addSignedProvider verifies the signed provider record of an ADD_PROVIDER entry and stores the
+// provider. Unlike unsigned entries, signed ones don't have to come from the provider itself.
+func (dht *IpfsDHT) addSignedProvider(ctx context.Context, key []byte, pbp pb.Message_Peer) error {
+	env, rec, err := providers.ConsumeProviderRecord(pbp.SignedRecord, key)
+	if err != nil {
+		return err
+	}
+	if rec.Provider.ID != peer.ID(pbp.Id) {
+		return fmt.Errorf("provider record is for %s, not %s", rec.Provider.ID, peer.ID(pbp.Id))
+	}
+	addrs := dht.filterAddrs(rec.Provider.Addrs)
+	if len(addrs) < 1 {
+		return fmt.Errorf("no valid addresses for provider %s", rec.Provider.ID)
+	}
+
+	// the signed addresses can't be filtered, a record with addresses we wouldn't announce is only
+	// kept as an unsigned entry with the filtered addresses.
+	if sps, ok := dht.providerStore.(providers.SignedProviderStore); ok && len(addrs) == len(rec.Provider.Addrs) {
+		return sps.AddSignedProvider(ctx, key, env)
+	}
+	return dht.providerStore.AddProvider(ctx, key, peer.AddrInfo{
+		ID:    rec.Provider.ID,
+		Addrs: addrs,
+	})
+}
+
+// attachSignedProviderRecords sets the signed provider records we have for the given key on the
+// matching provider entries.
+func (dht *IpfsDHT) attachSignedProviderRecords(ctx context.Context, key []byte, pbps []pb.Message_Peer) error {
+	sps, ok := dht.providerStore.(providers.SignedProviderStore)
+	if !ok {
+		return nil
+	}
+	envs, err := sps.GetSignedProviders(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(envs) == 0 {
+		return nil
+	}
+
+	signed := make(map[peer.ID][]byte, len(envs))
+	for _, env := range envs {
+		// the signer is the provider, ConsumeProviderRecord checked it when the record came in.
+		p, err := peer.IDFromPublicKey(env.PublicKey)
+		if err != nil {
+			continue
+		}
+		buf, err := env.Marshal()
+		if err != nil {
+			continue
+		}
+		signed[p] = buf
+	}
+	for i := range pbps {
+		pbps[i].SignedRecord = signed[peer.ID(pbps[i].Id)]
+	}
+	return nil
+}
+
+// verifySignedProviders checks the signed provider records returned by a peer for the given key.
+// Providers with a valid record are returned with the signed addresses, providers with an invalid
+// record are dropped and providers without a record are returned as is.
+func verifySignedProviders(key multihash.Multihash, from peer.ID, provs []*peer.AddrInfo, signed [][]byte) []*peer.AddrInfo {
+	out := provs[:0]
+	for i, prov := range provs {
+		if len(signed[i]) == 0 {
+			out = append(out, prov)
+			continue
+		}
+		_, rec, err := providers.ConsumeProviderRecord(signed[i], key)
+		if err == nil && rec.Provider.ID != prov.ID {
+			err = fmt.Errorf("provider record is for %s, not %s", rec.Provider.ID, prov.ID)
+		}
+		if err != nil {
+			logger.Debugw("dropping provider with invalid signed record", "from", from, "provider", prov.ID, "error", err)
+			continue
+		}
+		out = append(out, &rec.Provider)
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+func TestSignedProviderRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	server := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	client := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	connect(t, ctx, provider, server)
+	connect(t, ctx, client, server)
+
+	ext := server.signedProvidersProto
+	require.NotEmpty(t, ext)
+	supported, err := provider.peerstore.SupportsProtocols(server.self, ext)
+	require.NoError(t, err)
+	require.Equal(t, []protocol.ID{ext}, supported)
+
+	require.NoError(t, provider.Provide(ctx, testCaseCids[0], true))
+
+	key := testCaseCids[0].Hash()
+	recs, err := server.providerStore.(providers.SignedProviderStore).GetSignedProviders(ctx, key)
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+
+	provs, err := client.FindProviders(ctx, testCaseCids[0])
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+	require.Equal(t, provider.self, provs[0].ID)
+	require.NotEmpty(t, provs[0].Addrs)
+}
+
+func TestSignedProviderRecordsRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	relay := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	server := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+
+	addProvider := func(key []byte, prov peer.AddrInfo, signed []byte) {
+		pmes := pb.NewMessage(pb.Message_ADD_PROVIDER, key, 0)
+		pmes.ProviderPeers = pb.RawPeerInfosToPBPeers([]peer.AddrInfo{prov})
+		pmes.ProviderPeers[0].SignedRecord = signed
+		_, err := server.handleAddProvider(ctx, relay.self, pmes)
+		require.NoError(t, err)
+	}
+	getProviders := func(key []byte) []peer.AddrInfo {
+		provs, err := server.providerStore.GetProviders(ctx, key)
+		require.NoError(t, err)
+		return provs
+	}
+
+	// a signed record relayed by another peer is accepted
+	key := testCaseCids[0].Hash()
+	self, signed := provider.selfProviderRecord(key)
+	require.NotNil(t, signed)
+	addProvider(key, self, signed)
+	provs := getProviders(key)
+	require.Len(t, provs, 1)
+	require.Equal(t, provider.self, provs[0].ID)
+
+	// the same record doesn't vouch for another key
+	otherKey := testCaseCids[1].Hash()
+	addProvider(otherKey, self, signed)
+	require.Empty(t, getProviders(otherKey))
+
+	// an unsigned relayed record is still ignored
+	otherKey = testCaseCids[2].Hash()
+	addProvider(otherKey, self, nil)
+	require.Empty(t, getProviders(otherKey))
+
+	// the relay can't forge records for the provider
+	otherKey = testCaseCids[3].Hash()
+	forged, err := providers.SealProviderRecord(otherKey, self, relay.peerstore.PrivKey(relay.self))
+	require.NoError(t, err)
+	buf, err := forged.Marshal()
+	require.NoError(t, err)
+	addProvider(otherKey, self, buf)
+	require.Empty(t, getProviders(otherKey))
+}
+
+func TestSignedProviderRecordsAddressFilter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	publicOnly := func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		return ma.FilterAddrs(addrs, manet.IsPublicAddr)
+	}
+	provider := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	server := setupDHT(ctx, t, false, EnableSignedProviderRecords(), AddressFilter(publicOnly))
+
+	public := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	private := ma.StringCast("/ip4/10.0.0.1/tcp/4001")
+	addProvider := func(key []byte, addrs ...ma.Multiaddr) {
+		prov := peer.AddrInfo{ID: provider.self, Addrs: addrs}
+		env, err := providers.SealProviderRecord(key, prov, provider.peerstore.PrivKey(provider.self))
+		require.NoError(t, err)
+		signed, err := env.Marshal()
+		require.NoError(t, err)
+		pmes := pb.NewMessage(pb.Message_ADD_PROVIDER, key, 0)
+		pmes.ProviderPeers = pb.RawPeerInfosToPBPeers([]peer.AddrInfo{prov})
+		pmes.ProviderPeers[0].SignedRecord = signed
+		_, err = server.handleAddProvider(ctx, provider.self, pmes)
+		require.NoError(t, err)
+	}
+	signedRecords := func(key []byte) int {
+		recs, err := server.providerStore.(providers.SignedProviderStore).GetSignedProviders(ctx, key)
+		require.NoError(t, err)
+		return len(recs)
+	}
+
+	// a record with only addresses we announce is kept signed
+	key := testCaseCids[0].Hash()
+	addProvider(key, public)
+	require.Equal(t, 1, signedRecords(key))
+
+	// a record with a filtered address is only kept unsigned, without the filtered address
+	key = testCaseCids[1].Hash()
+	addProvider(key, public, private)
+	require.Zero(t, signedRecords(key))
+	resp, err := server.handleGetProviders(ctx, provider.self, pb.NewMessage(pb.Message_GET_PROVIDERS, key, 0))
+	require.NoError(t, err)
+	require.Len(t, resp.ProviderPeers, 1)
+	require.Empty(t, resp.ProviderPeers[0].SignedRecord)
+	require.Equal(t, []ma.Multiaddr{public}, resp.ProviderPeers[0].Addresses())
+}
+
+func TestSignedProviderRecordsCompat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	server := setupDHT(ctx, t, false)
+	client := setupDHT(ctx, t, false, EnableSignedProviderRecords())
+	connect(t, ctx, provider, server)
+	connect(t, ctx, client, server)
+
+	// a server without signed provider records support takes the unsigned record
+	require.NoError(t, provider.Provide(ctx, testCaseCids[0], true))
+	provs, err := client.FindProviders(ctx, testCaseCids[0])
+	require.NoError(t, err)
+	require.Len(t, provs, 1)
+	require.Equal(t, provider.self, provs[0].ID)
+}
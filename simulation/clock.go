@@ -0,0 +1,257 @@
+package simulation
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock. Time only moves forward when the clock is advanced, either explicitly
+// or by the Network it belongs to (see Config.ManualClock). Timers firing at the same time fire in
+// the order of their keys, the ones without a key first, and then in the order they were created.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers timerHeap
+	seq    uint64
+	// changes counts the timers created, stopped and fired, to detect when the clock is idle.
+	changes uint64
+}
+
+// NewClock returns a virtual clock starting at the given time.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Timer is a timer of a virtual clock.
+type Timer struct {
+	C <-chan time.Time
+
+	c     chan time.Time
+	f     func()
+	clock *Clock
+	when  time.Time
+	key   string
+	seq   uint64
+	index int // in the heap of the clock, -1 once fired or stopped
+}
+
+// Now returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since returns the virtual time elapsed since t.
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// NewTimer returns a timer sending the virtual time on its channel once d has elapsed.
+func (c *Clock) NewTimer(d time.Duration) *Timer {
+	return c.newTimer(d, "")
+}
+
+func (c *Clock) newTimer(d time.Duration, key string) *Timer {
+	ch := make(chan time.Time, 1)
+	t := &Timer{C: ch, c: ch, key: key}
+	c.add(t, d)
+	return t
+}
+
+// AfterFunc calls f once d has elapsed. f is called by the goroutine advancing the clock, before
+// the clock moves any further, it must not wait for the clock.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{f: f}
+	c.add(t, d)
+	return t
+}
+
+// Sleep blocks until d has elapsed or the context is done.
+func (c *Clock) Sleep(ctx context.Context, d time.Duration) error {
+	return c.sleep(ctx, d, "")
+}
+
+// sleep is Sleep with a keyed timer.
+func (c *Clock) sleep(ctx context.Context, d time.Duration, key string) error {
+	t := c.newTimer(d, key)
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	}
+}
+
+// deadlineKey is the context key of the timer of the contexts returned by WithTimeout.
+type deadlineKey struct{}
+
+// WithTimeout returns a copy of ctx cancelled once d has elapsed in virtual time, or once the
+// returned function is called. Unlike with context.WithTimeout, the context has no deadline and its
+// error is context.Canceled, context.Cause returns context.DeadlineExceeded when it timed out.
+func (c *Clock) WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := c.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+	return context.WithValue(ctx, deadlineKey{}, t), func() {
+		t.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// keyDeadline sets the key of the timer of a context returned by WithTimeout, unless it has one
+// already. The nodes create their timeouts concurrently, the keys order them deterministically.
+func (c *Clock) keyDeadline(ctx context.Context, key string) {
+	t, ok := ctx.Value(deadlineKey{}).(*Timer)
+	if !ok || t.clock != c {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.key == "" && t.index >= 0 {
+		t.key = key
+		heap.Fix(&c.timers, t.index)
+	}
+}
+
+func (c *Clock) add(t *Timer, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.clock = c
+	t.when = c.now.Add(d)
+	t.seq = c.seq
+	c.seq++
+	c.changes++
+	heap.Push(&c.timers, t)
+}
+
+// Stop prevents the timer from firing. It returns false if the timer already fired or was stopped.
+func (t *Timer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	c.changes++
+	return true
+}
+
+// Pending returns the number of timers that haven't fired yet.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// Advance moves the clock forward by d, firing the timers due in the meantime.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for c.fireNext(end) {
+	}
+	c.mu.Lock()
+	if c.now.Before(end) {
+		c.now = end
+	}
+	c.mu.Unlock()
+}
+
+// AdvanceToNext moves the clock forward to the next timer and fires all the timers due at that
+// time. It returns false if there is no timer left.
+func (c *Clock) AdvanceToNext() bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	next := c.timers[0].when
+	c.mu.Unlock()
+	for c.fireNext(next) {
+	}
+	return true
+}
+
+// step moves the clock forward to the next timer and fires only that one. It returns false if there
+// is no timer left.
+func (c *Clock) step() bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	next := c.timers[0].when
+	c.mu.Unlock()
+	return c.fireNext(next)
+}
+
+// fireNext fires the next timer if it is due at or before end.
+func (c *Clock) fireNext(end time.Time) bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 || c.timers[0].when.After(end) {
+		c.mu.Unlock()
+		return false
+	}
+	t := heap.Pop(&c.timers).(*Timer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.changes++
+	now := c.now
+	c.mu.Unlock()
+
+	if t.f != nil {
+		t.f()
+	} else {
+		t.c <- now
+	}
+	return true
+}
+
+func (c *Clock) changeCount() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.changes
+}
+
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int { return len(h) }
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		if h[i].key != h[j].key {
+			return h[i].key < h[j].key
+		}
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
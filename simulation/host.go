@@ -0,0 +1,272 @@
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+	mstream "github.com/multiformats/go-multistream"
+)
+
+// errNoStreams is returned when opening streams, the simulated nodes only exchange messages
+// through their pb.MessageSender.
+var errNoStreams = errors.New("streams aren't supported by the simulated network")
+
+// simHost is the host.Host of a simulated node. It doesn't have any transport, connections are
+// links of the simulated network.
+type simHost struct {
+	node *Node
+	ps   peerstore.Peerstore
+	mux  *mstream.MultistreamMuxer[protocol.ID]
+	bus  event.Bus
+
+	emitters struct {
+		connectedness    event.Emitter
+		identified       event.Emitter
+		protocolsUpdated event.Emitter
+	}
+
+	net simNet
+}
+
+var _ host.Host = (*simHost)(nil)
+
+func newSimHost(node *Node, sk ic.PrivKey) (*simHost, error) {
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.AddPrivKey(node.ID, sk); err != nil {
+		return nil, err
+	}
+	if err := ps.AddPubKey(node.ID, sk.GetPublic()); err != nil {
+		return nil, err
+	}
+
+	h := &simHost{
+		node: node,
+		ps:   ps,
+		mux:  mstream.NewMultistreamMuxer[protocol.ID](),
+		bus:  eventbus.NewBus(),
+	}
+	h.net.h = h
+	h.net.notifiees = make(map[network.Notifiee]struct{})
+
+	if h.emitters.connectedness, err = h.bus.Emitter(new(event.EvtPeerConnectednessChanged)); err != nil {
+		return nil, err
+	}
+	if h.emitters.identified, err = h.bus.Emitter(new(event.EvtPeerIdentificationCompleted)); err != nil {
+		return nil, err
+	}
+	if h.emitters.protocolsUpdated, err = h.bus.Emitter(new(event.EvtPeerProtocolsUpdated)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *simHost) ID() peer.ID                      { return h.node.ID }
+func (h *simHost) Peerstore() peerstore.Peerstore   { return h.ps }
+func (h *simHost) Addrs() []ma.Multiaddr            { return []ma.Multiaddr{h.node.Addr} }
+func (h *simHost) Network() network.Network         { return &h.net }
+func (h *simHost) Mux() protocol.Switch             { return h.mux }
+func (h *simHost) ConnManager() connmgr.ConnManager { return &connmgr.NullConnMgr{} }
+func (h *simHost) EventBus() event.Bus              { return h.bus }
+
+func (h *simHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	h.ps.AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+	_, err := h.net.DialPeer(ctx, pi.ID)
+	return err
+}
+
+// SetStreamHandler registers the protocol, which the peers then see as supported by the node. The
+// handler itself is never called.
+func (h *simHost) SetStreamHandler(pid protocol.ID, _ network.StreamHandler) {
+	h.mux.AddHandler(pid, noStreamHandler)
+	h.node.net.protocolsChanged(h.node)
+}
+
+func (h *simHost) SetStreamHandlerMatch(pid protocol.ID, match func(protocol.ID) bool, _ network.StreamHandler) {
+	h.mux.AddHandlerWithFunc(pid, match, noStreamHandler)
+	h.node.net.protocolsChanged(h.node)
+}
+
+func (h *simHost) RemoveStreamHandler(pid protocol.ID) {
+	h.mux.RemoveHandler(pid)
+	h.node.net.protocolsChanged(h.node)
+}
+
+func (h *simHost) NewStream(context.Context, peer.ID, ...protocol.ID) (network.Stream, error) {
+	return nil, errNoStreams
+}
+
+func (h *simHost) Close() error {
+	h.node.net.disconnectAll(h.node)
+	return h.ps.Close()
+}
+
+func noStreamHandler(protocol.ID, io.ReadWriteCloser) error {
+	return errNoStreams
+}
+
+// simNet is the network.Network of a simulated node.
+type simNet struct {
+	h *simHost
+
+	notifieesLk sync.Mutex
+	notifiees   map[network.Notifiee]struct{}
+}
+
+var _ network.Network = (*simNet)(nil)
+
+func (n *simNet) Peerstore() peerstore.Peerstore { return n.h.ps }
+func (n *simNet) LocalPeer() peer.ID             { return n.h.node.ID }
+
+func (n *simNet) DialPeer(ctx context.Context, p peer.ID) (network.Conn, error) {
+	to := n.h.node.net.Node(p)
+	if to == nil {
+		return nil, fmt.Errorf("unknown peer %s", p)
+	}
+	return n.h.node.net.dial(ctx, n.h.node, to)
+}
+
+func (n *simNet) ClosePeer(p peer.ID) error {
+	if to := n.h.node.net.Node(p); to != nil {
+		n.h.node.net.disconnect(n.h.node, to)
+	}
+	return nil
+}
+
+func (n *simNet) Connectedness(p peer.ID) network.Connectedness {
+	if n.h.node.net.conn(n.h.node, p) != nil {
+		return network.Connected
+	}
+	return network.NotConnected
+}
+
+func (n *simNet) Peers() []peer.ID {
+	conns := n.h.node.net.conns(n.h.node)
+	peers := make([]peer.ID, 0, len(conns))
+	for _, c := range conns {
+		peers = append(peers, c.RemotePeer())
+	}
+	return peers
+}
+
+func (n *simNet) Conns() []network.Conn {
+	return n.h.node.net.conns(n.h.node)
+}
+
+func (n *simNet) ConnsToPeer(p peer.ID) []network.Conn {
+	if c := n.h.node.net.conn(n.h.node, p); c != nil {
+		return []network.Conn{c}
+	}
+	return nil
+}
+
+func (n *simNet) Notify(nf network.Notifiee) {
+	n.notifieesLk.Lock()
+	defer n.notifieesLk.Unlock()
+	n.notifiees[nf] = struct{}{}
+}
+
+func (n *simNet) StopNotify(nf network.Notifiee) {
+	n.notifieesLk.Lock()
+	defer n.notifieesLk.Unlock()
+	delete(n.notifiees, nf)
+}
+
+func (n *simNet) notify(f func(network.Notifiee)) {
+	n.notifieesLk.Lock()
+	nfs := make([]network.Notifiee, 0, len(n.notifiees))
+	for nf := range n.notifiees {
+		nfs = append(nfs, nf)
+	}
+	n.notifieesLk.Unlock()
+	for _, nf := range nfs {
+		f(nf)
+	}
+}
+
+func (n *simNet) Close() error                           { return nil }
+func (n *simNet) SetStreamHandler(network.StreamHandler) {}
+
+func (n *simNet) NewStream(context.Context, peer.ID) (network.Stream, error) {
+	return nil, errNoStreams
+}
+
+func (n *simNet) Listen(...ma.Multiaddr) error             { return nil }
+func (n *simNet) ListenAddresses() []ma.Multiaddr          { return n.h.Addrs() }
+func (n *simNet) ResourceManager() network.ResourceManager { return &network.NullResourceManager{} }
+
+func (n *simNet) InterfaceListenAddresses() ([]ma.Multiaddr, error) {
+	return n.h.Addrs(), nil
+}
+
+// simConn is one side of a link between two simulated nodes.
+type simConn struct {
+	id     string
+	local  *Node
+	remote *Node
+	stat   network.ConnStats
+	mu     sync.Mutex
+	closed bool
+}
+
+var _ network.Conn = (*simConn)(nil)
+
+func (c *simConn) ID() string                 { return c.id }
+func (c *simConn) LocalPeer() peer.ID         { return c.local.ID }
+func (c *simConn) RemotePeer() peer.ID        { return c.remote.ID }
+func (c *simConn) RemotePublicKey() ic.PubKey { return c.local.host.ps.PubKey(c.remote.ID) }
+func (c *simConn) ConnState() network.ConnectionState {
+	return network.ConnectionState{Transport: "simulation"}
+}
+func (c *simConn) LocalMultiaddr() ma.Multiaddr  { return c.local.Addr }
+func (c *simConn) RemoteMultiaddr() ma.Multiaddr { return c.remote.Addr }
+func (c *simConn) Stat() network.ConnStats       { return c.stat }
+func (c *simConn) Scope() network.ConnScope      { return &network.NullScope{} }
+func (c *simConn) GetStreams() []network.Stream  { return nil }
+
+func (c *simConn) NewStream(context.Context) (network.Stream, error) {
+	return nil, errNoStreams
+}
+
+func (c *simConn) Close() error {
+	c.local.net.disconnect(c.local, c.remote)
+	return nil
+}
+
+func (c *simConn) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *simConn) setClosed() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+}
+
+func newSimConn(local, remote *Node, dir network.Direction, opened time.Time) *simConn {
+	return &simConn{
+		id:     fmt.Sprintf("%d-%d", local.Index, remote.Index),
+		local:  local,
+		remote: remote,
+		stat:   network.ConnStats{Stats: network.Stats{Direction: dir, Opened: opened}},
+	}
+}
@@ -0,0 +1,120 @@
+package simulation
+
+import (
+	"math/rand"
+	"time"
+)
+
+// LatencyModel returns the one-way latency of a message sent between two nodes, identified by
+// their index in the network. The random source is derived from the seed of the network, the nodes
+// and the number of messages already sent between them.
+type LatencyModel interface {
+	Latency(from, to int, r *rand.Rand) time.Duration
+}
+
+// LossModel decides whether a message sent between two nodes, identified by their index in the
+// network, is lost. The random source is derived like the one of the LatencyModel.
+type LossModel interface {
+	Lost(from, to int, r *rand.Rand) bool
+}
+
+// ChurnModel returns how long a node stays online, or offline, before switching state. A zero
+// duration keeps the node in its current state forever. The random source is derived from the
+// seed of the network and the index of the node.
+type ChurnModel interface {
+	Session(node int, online bool, r *rand.Rand) time.Duration
+}
+
+// FixedLatency is a LatencyModel where every message takes the same time.
+type FixedLatency time.Duration
+
+func (l FixedLatency) Latency(_, _ int, _ *rand.Rand) time.Duration {
+	return time.Duration(l)
+}
+
+// UniformLatency is a LatencyModel where the latency of every message is drawn uniformly between
+// Min and Max.
+type UniformLatency struct {
+	Min, Max time.Duration
+}
+
+func (l UniformLatency) Latency(_, _ int, r *rand.Rand) time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + time.Duration(r.Int63n(int64(l.Max-l.Min)))
+}
+
+// NoLoss is a LossModel where no message is lost.
+type NoLoss struct{}
+
+func (NoLoss) Lost(_, _ int, _ *rand.Rand) bool {
+	return false
+}
+
+// UniformLoss is a LossModel where every message is lost with the same probability.
+type UniformLoss float64
+
+func (p UniformLoss) Lost(_, _ int, r *rand.Rand) bool {
+	return r.Float64() < float64(p)
+}
+
+// NoChurn is a ChurnModel where the nodes stay online.
+type NoChurn struct{}
+
+func (NoChurn) Session(_ int, _ bool, _ *rand.Rand) time.Duration {
+	return 0
+}
+
+// ExponentialChurn is a ChurnModel where the online and offline sessions of the nodes follow
+// exponential distributions of the given means. A zero mean keeps the nodes in that state forever.
+type ExponentialChurn struct {
+	MeanUptime   time.Duration
+	MeanDowntime time.Duration
+}
+
+func (c ExponentialChurn) Session(_ int, online bool, r *rand.Rand) time.Duration {
+	mean := c.MeanDowntime
+	if online {
+		mean = c.MeanUptime
+	}
+	if mean <= 0 {
+		return 0
+	}
+	// never return zero, which would end the churn of the node.
+	return time.Duration(r.ExpFloat64()*float64(mean)) + 1
+}
+
+// splitMix64 is a rand.Source64 cheap enough to be seeded for every message.
+type splitMix64 uint64
+
+func newRand(seed uint64) *rand.Rand {
+	s := splitMix64(seed)
+	return rand.New(&s)
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *splitMix64) Seed(seed int64) {
+	*s = splitMix64(seed)
+}
+
+// mix derives a seed from the given values.
+func mix(values ...uint64) uint64 {
+	var h uint64
+	for _, v := range values {
+		s := splitMix64(h ^ v)
+		h = s.Uint64()
+	}
+	return h
+}
@@ -0,0 +1,546 @@
+// Package simulation runs IpfsDHT nodes over an in-memory network, to test the behaviour of the DHT
+// at scale without real hosts, sockets or timing.
+//
+// The nodes exchange their messages through a pb.MessageSender delivering them directly to the
+// handlers of the remote DHT. Connections, and the identify events the DHT relies on, are
+// simulated by the hosts of the nodes. Messages are delayed by a virtual Clock according to a
+// LatencyModel, may be lost according to a LossModel, and the nodes go offline and back online
+// according to a ChurnModel. All the random decisions of the network, as well as the keys and
+// addresses of the nodes, derive from the seed of the network.
+//
+// The DHTs of the nodes time their lookups with the clock of the network too (see dht.WithClock), so
+// the request timeouts and the adaptive RPC timeouts fire in virtual time. By default the clock
+// advances on its own, one timer at a time, once every goroutine of the process is blocked: the
+// nodes have then done all they could at the current time and wait for the clock. The timers the
+// network creates for the messages and the request timeouts are keyed by the nodes they concern, so
+// that the ones due at the same time fire in the same order in every run. Runs with the same seed
+// are reproducible: they send the same requests at the same virtual times, which Network.Trace
+// records so that runs can be compared.
+//
+// Timers created concurrently without a key, e.g. by the interceptors of the nodes, fire in the
+// order they were created when they are due at the same time, which may differ from run to run.
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+var (
+	errOffline     = errors.New("node is offline")
+	errUnreachable = errors.New("peer is unreachable")
+	errTimeout     = errors.New("message timed out")
+)
+
+// Config configures a simulated network. The zero value is a valid configuration.
+type Config struct {
+	// Seed seeds all the random decisions of the network.
+	Seed int64
+	// Latency defaults to FixedLatency(50 * time.Millisecond).
+	Latency LatencyModel
+	// Loss defaults to NoLoss.
+	Loss LossModel
+	// Churn defaults to NoChurn.
+	Churn ChurnModel
+	// Timeout is how long a request waits, in virtual time, for a lost message or a dial to an
+	// offline node before failing. Defaults to 10 seconds.
+	Timeout time.Duration
+	// Start is the initial virtual time. Defaults to 2020-01-01 UTC.
+	Start time.Time
+	// ManualClock disables the automatic advance of the clock, which then has to be advanced
+	// by the caller.
+	ManualClock bool
+	// SettleTime is how often, in wall clock time, the network checks whether the nodes are idle
+	// to advance the clock on its own. Defaults to 1 millisecond.
+	SettleTime time.Duration
+	// TraceMessages records the requests sent over the network, see Network.Trace.
+	TraceMessages bool
+}
+
+// Network is a simulated network of DHT nodes.
+type Network struct {
+	cfg   Config
+	clock *Clock
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	rand  *rand.Rand // keys and addresses of the nodes
+	nodes []*Node
+	byID  map[peer.ID]*Node
+	addrs map[string]struct{}
+	seqs  map[[2]int]uint64 // number of messages sent over each link
+	trace []Message
+
+	stacks []byte // buffer of the idle checks of the clock
+}
+
+// Node is a node of a simulated network.
+type Node struct {
+	// Index is the position of the node in the network, in order of addition.
+	Index int
+	ID    peer.ID
+	Addr  ma.Multiaddr
+	Host  host.Host
+	DHT   *dht.IpfsDHT
+
+	net       *Network
+	host      *simHost
+	churnRand *rand.Rand
+
+	// guarded by the mutex of the network
+	online bool
+	conns  map[peer.ID]*simConn
+}
+
+// NewNetwork creates an empty simulated network.
+func NewNetwork(cfg Config) *Network {
+	if cfg.Latency == nil {
+		cfg.Latency = FixedLatency(50 * time.Millisecond)
+	}
+	if cfg.Loss == nil {
+		cfg.Loss = NoLoss{}
+	}
+	if cfg.Churn == nil {
+		cfg.Churn = NoChurn{}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if cfg.SettleTime <= 0 {
+		cfg.SettleTime = time.Millisecond
+	}
+
+	n := &Network{
+		cfg:   cfg,
+		clock: NewClock(cfg.Start),
+		rand:  rand.New(rand.NewSource(cfg.Seed)),
+		byID:  make(map[peer.ID]*Node),
+		addrs: make(map[string]struct{}),
+		seqs:  make(map[[2]int]uint64),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	if !cfg.ManualClock {
+		n.wg.Add(1)
+		go n.runClock()
+	}
+	return n
+}
+
+// Clock returns the virtual clock of the network.
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// runClock fires the next timer whenever the nodes are idle, i.e. when no timer was created, stopped
+// or fired during the settle time and all the other goroutines are blocked. Firing the timers one by
+// one, and only once the nodes are done with the previous one, makes the runs reproducible.
+func (n *Network) runClock() {
+	defer n.wg.Done()
+
+	// the settle time is waited for after every check rather than ticked, the checks may take
+	// longer than that and must leave the other goroutines time to run.
+	t := time.NewTimer(n.cfg.SettleTime)
+	defer t.Stop()
+	last := n.clock.changeCount()
+	for ; ; t.Reset(n.cfg.SettleTime) {
+		select {
+		case <-t.C:
+		case <-n.ctx.Done():
+			return
+		}
+		if changes := n.clock.changeCount(); changes != last {
+			last = changes
+			continue
+		}
+		if n.clock.Pending() == 0 || !n.idle() {
+			continue
+		}
+		n.clock.step()
+		last = n.clock.changeCount()
+	}
+}
+
+// idle returns true if every goroutine but the calling one is blocked. The nodes then can't do
+// anything more until the clock fires a timer. The clocks of other networks, which may be checking
+// whether their own nodes are idle, are ignored.
+func (n *Network) idle() bool {
+	if n.stacks == nil {
+		n.stacks = make([]byte, 1<<20)
+	}
+	size := runtime.Stack(n.stacks, true)
+	for size == len(n.stacks) {
+		n.stacks = make([]byte, 2*len(n.stacks))
+		size = runtime.Stack(n.stacks, true)
+	}
+
+	// the stacks are separated by blank lines, starting with the one of the calling goroutine, and
+	// their headers read "goroutine 42 [state, 2 minutes]:".
+	stacks := bytes.Split(n.stacks[:size], []byte("\n\n"))
+	for _, stack := range stacks[1:] {
+		start := bytes.IndexByte(stack, '[')
+		end := bytes.IndexAny(stack, ",]")
+		if start < 0 || end < start {
+			return false
+		}
+		state := string(stack[start+1 : end])
+		busy := state == "running" || state == "runnable" || state == "syscall" || strings.HasPrefix(state, "GC")
+		if busy && !bytes.Contains(stack, []byte(".(*Network).runClock(")) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddNode adds a node running a DHT configured with the given options to the network. The nodes run
+// in server mode unless configured otherwise. The node is online, but not connected to any other
+// node.
+func (n *Network) AddNode(ctx context.Context, opts ...dht.Option) (*Node, error) {
+	n.mu.Lock()
+	index := len(n.nodes)
+	sk, _, err := crypto.GenerateEd25519Key(n.rand)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	addr := n.newAddr()
+	n.mu.Unlock()
+
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{
+		Index:     index,
+		ID:        id,
+		Addr:      addr,
+		net:       n,
+		churnRand: newRand(mix(uint64(n.cfg.Seed), uint64(index))),
+		online:    true,
+		conns:     make(map[peer.ID]*simConn),
+	}
+	if node.host, err = newSimHost(node, sk); err != nil {
+		return nil, err
+	}
+	node.Host = node.host
+
+	n.mu.Lock()
+	n.nodes = append(n.nodes, node)
+	n.byID[id] = node
+	n.mu.Unlock()
+
+	opts = append([]dht.Option{dht.Mode(dht.ModeServer), dht.WithClock(n.clock)}, opts...)
+	opts = append(opts, dht.WithCustomMessageSender(n.newMessageSender))
+	if node.DHT, err = dht.New(ctx, node.host, opts...); err != nil {
+		n.mu.Lock()
+		n.nodes = n.nodes[:len(n.nodes)-1]
+		delete(n.byID, id)
+		n.mu.Unlock()
+		_ = node.host.Close()
+		return nil, err
+	}
+
+	n.scheduleChurn(node)
+	return node, nil
+}
+
+// AddNodes adds count nodes to the network, see AddNode.
+func (n *Network) AddNodes(ctx context.Context, count int, opts ...dht.Option) ([]*Node, error) {
+	nodes := make([]*Node, 0, count)
+	for i := 0; i < count; i++ {
+		node, err := n.AddNode(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// newAddr returns a new public IPv4 address.
+func (n *Network) newAddr() ma.Multiaddr {
+	for {
+		ip := n.rand.Uint32()
+		addr, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/%d.%d.%d.%d/tcp/4001", byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip)))
+		if err != nil || !manet.IsPublicAddr(addr) {
+			continue
+		}
+		if _, ok := n.addrs[string(addr.Bytes())]; ok {
+			continue
+		}
+		n.addrs[string(addr.Bytes())] = struct{}{}
+		return addr
+	}
+}
+
+// Nodes returns the nodes of the network, in order of addition.
+func (n *Network) Nodes() []*Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*Node(nil), n.nodes...)
+}
+
+// Node returns the node with the given ID, or nil if there is none.
+func (n *Network) Node(p peer.ID) *Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.byID[p]
+}
+
+// Connect connects two nodes, as if the first one dialed the second.
+func (n *Network) Connect(ctx context.Context, a, b *Node) error {
+	_, err := n.dial(ctx, a, b)
+	return err
+}
+
+// FillRoutingTables adds the other nodes of the network to the routing table of every node, as
+// long as their buckets have room, in order of addition. It is much faster than bootstrapping the
+// nodes by running lookups.
+func (n *Network) FillRoutingTables() {
+	nodes := n.Nodes()
+	for _, a := range nodes {
+		rt := a.DHT.RoutingTable()
+		for _, b := range nodes {
+			if a == b {
+				continue
+			}
+			if added, _ := rt.TryAddPeer(b.ID, true, false); added {
+				a.host.ps.AddAddr(b.ID, b.Addr, peerstore.PermanentAddrTTL)
+				_ = a.host.ps.SetProtocols(b.ID, b.host.mux.Protocols()...)
+			}
+		}
+	}
+}
+
+// Close closes all the nodes of the network.
+func (n *Network) Close() error {
+	n.cancel()
+	n.wg.Wait()
+
+	var errs []error
+	for _, node := range n.Nodes() {
+		if err := node.DHT.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := node.Host.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Online returns true if the node is online.
+func (node *Node) Online() bool {
+	node.net.mu.Lock()
+	defer node.net.mu.Unlock()
+	return node.online
+}
+
+// SetOnline takes the node online or offline. Going offline closes the connections of the node.
+func (node *Node) SetOnline(online bool) {
+	node.net.mu.Lock()
+	node.online = online
+	node.net.mu.Unlock()
+	if !online {
+		node.net.disconnectAll(node)
+	}
+}
+
+func (n *Network) scheduleChurn(node *Node) {
+	d := n.cfg.Churn.Session(node.Index, node.Online(), node.churnRand)
+	if d <= 0 {
+		return
+	}
+	n.clock.AfterFunc(d, func() {
+		if n.ctx.Err() != nil {
+			return
+		}
+		node.SetOnline(!node.Online())
+		n.scheduleChurn(node)
+	})
+}
+
+// messageRand returns the random source of the next message sent from a to b, and the key of its
+// timer.
+func (n *Network) messageRand(a, b *Node) (*rand.Rand, string) {
+	n.mu.Lock()
+	link := [2]int{a.Index, b.Index}
+	seq := n.seqs[link]
+	n.seqs[link]++
+	n.mu.Unlock()
+	return newRand(mix(uint64(n.cfg.Seed), uint64(a.Index), uint64(b.Index), seq)), timerKey(a, b, fmt.Sprintf("message %d", seq))
+}
+
+// timerKey returns the key of a timer of the link from a to b, see Clock.
+func timerKey(a, b *Node, what string) string {
+	return fmt.Sprintf("%06d/%06d/%s", a.Index, b.Index, what)
+}
+
+// transmit waits for a message to go from a to b, or fails after the timeout if it is lost.
+func (n *Network) transmit(ctx context.Context, a, b *Node) error {
+	r, key := n.messageRand(a, b)
+	if n.cfg.Loss.Lost(a.Index, b.Index, r) {
+		if err := n.clock.sleep(ctx, n.cfg.Timeout, key); err != nil {
+			return err
+		}
+		return errTimeout
+	}
+	if err := n.clock.sleep(ctx, n.cfg.Latency.Latency(a.Index, b.Index, r), key); err != nil {
+		return err
+	}
+	if !a.Online() || !b.Online() {
+		return errUnreachable
+	}
+	return nil
+}
+
+func (n *Network) conn(a *Node, p peer.ID) *simConn {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return a.conns[p]
+}
+
+func (n *Network) conns(a *Node) []network.Conn {
+	n.mu.Lock()
+	conns := make([]network.Conn, 0, len(a.conns))
+	for _, c := range a.conns {
+		conns = append(conns, c)
+	}
+	n.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID() < conns[j].ID() })
+	return conns
+}
+
+// dial connects a to b, which takes a round trip.
+func (n *Network) dial(ctx context.Context, a, b *Node) (*simConn, error) {
+	if c := n.conn(a, b.ID); c != nil {
+		return c, nil
+	}
+	n.clock.keyDeadline(ctx, timerKey(a, b, "dial timeout"))
+	if a == b {
+		return nil, fmt.Errorf("can't dial self")
+	}
+	if !a.Online() {
+		return nil, errOffline
+	}
+	if !b.Online() {
+		if err := n.clock.sleep(ctx, n.cfg.Timeout, timerKey(a, b, "dial")); err != nil {
+			return nil, err
+		}
+		return nil, errUnreachable
+	}
+	if err := n.transmit(ctx, a, b); err != nil {
+		return nil, err
+	}
+	if err := n.transmit(ctx, b, a); err != nil {
+		return nil, err
+	}
+	return n.link(a, b), nil
+}
+
+// link opens a connection between a and b, and has their hosts identify each other.
+func (n *Network) link(a, b *Node) *simConn {
+	n.mu.Lock()
+	if c, ok := a.conns[b.ID]; ok {
+		n.mu.Unlock()
+		return c
+	}
+	now := n.clock.Now()
+	ca := newSimConn(a, b, network.DirOutbound, now)
+	cb := newSimConn(b, a, network.DirInbound, now)
+	a.conns[b.ID] = ca
+	b.conns[a.ID] = cb
+	n.mu.Unlock()
+
+	for _, side := range []struct {
+		local, remote *Node
+		conn          *simConn
+	}{{a, b, ca}, {b, a, cb}} {
+		h := side.local.host
+		h.ps.AddAddr(side.remote.ID, side.remote.Addr, peerstore.ConnectedAddrTTL)
+		_ = h.ps.AddPubKey(side.remote.ID, side.remote.host.ps.PubKey(side.remote.ID))
+		_ = h.ps.SetProtocols(side.remote.ID, side.remote.host.mux.Protocols()...)
+		h.net.notify(func(nf network.Notifiee) { nf.Connected(&h.net, side.conn) })
+		_ = h.emitters.connectedness.Emit(event.EvtPeerConnectednessChanged{Peer: side.remote.ID, Connectedness: network.Connected})
+		_ = h.emitters.identified.Emit(event.EvtPeerIdentificationCompleted{Peer: side.remote.ID})
+	}
+	return ca
+}
+
+// disconnect closes the connection between a and b, if any.
+func (n *Network) disconnect(a, b *Node) {
+	n.mu.Lock()
+	ca, ok := a.conns[b.ID]
+	cb := b.conns[a.ID]
+	delete(a.conns, b.ID)
+	delete(b.conns, a.ID)
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, side := range []struct {
+		local, remote *Node
+		conn          *simConn
+	}{{a, b, ca}, {b, a, cb}} {
+		h := side.local.host
+		side.conn.setClosed()
+		h.ps.UpdateAddrs(side.remote.ID, peerstore.ConnectedAddrTTL, peerstore.RecentlyConnectedAddrTTL)
+		h.net.notify(func(nf network.Notifiee) { nf.Disconnected(&h.net, side.conn) })
+		_ = h.emitters.connectedness.Emit(event.EvtPeerConnectednessChanged{Peer: side.remote.ID, Connectedness: network.NotConnected})
+	}
+}
+
+func (n *Network) disconnectAll(a *Node) {
+	for _, c := range n.conns(a) {
+		n.disconnect(a, c.(*simConn).remote)
+	}
+}
+
+// protocolsChanged tells the peers connected to a about its new protocols.
+func (n *Network) protocolsChanged(a *Node) {
+	protos := a.host.mux.Protocols()
+	for _, c := range n.conns(a) {
+		b := c.(*simConn).remote
+		_ = b.host.ps.SetProtocols(a.ID, protos...)
+		_ = b.host.emitters.protocolsUpdated.Emit(event.EvtPeerProtocolsUpdated{Peer: a.ID, Added: protos})
+	}
+}
+
+// protocolFor returns the first of the given protocols b supports.
+func (n *Network) protocolFor(b *Node, protos []protocol.ID) (protocol.ID, bool) {
+	supported := b.host.mux.Protocols()
+	for _, p := range protos {
+		for _, s := range supported {
+			if p == s {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
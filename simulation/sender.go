@@ -0,0 +1,93 @@
+package simulation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// messageSender delivers the messages of a node to the handlers of the remote DHTs.
+type messageSender struct {
+	net    *Network
+	node   *Node
+	protos []protocol.ID
+}
+
+var _ pb.MessageSenderWithDisconnect = (*messageSender)(nil)
+
+func (n *Network) newMessageSender(h host.Host, protos []protocol.ID) pb.MessageSenderWithDisconnect {
+	return &messageSender{net: n, node: n.Node(h.ID()), protos: protos}
+}
+
+func (m *messageSender) SendRequest(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
+	return m.send(ctx, p, pmes, true)
+}
+
+func (m *messageSender) SendMessage(ctx context.Context, p peer.ID, pmes *pb.Message) error {
+	_, err := m.send(ctx, p, pmes, false)
+	return err
+}
+
+func (m *messageSender) OnDisconnect(context.Context, peer.ID) {}
+
+// send delivers the message to p, connecting to it first if needed, and waits for the response if
+// requested.
+func (m *messageSender) send(ctx context.Context, p peer.ID, pmes *pb.Message, wantResponse bool) (*pb.Message, error) {
+	to := m.net.Node(p)
+	if to == nil {
+		return nil, fmt.Errorf("unknown peer %s", p)
+	}
+	m.net.clock.keyDeadline(ctx, timerKey(m.node, to, "request timeout"))
+	if _, err := m.net.dial(ctx, m.node, to); err != nil {
+		return nil, err
+	}
+	proto, ok := m.net.protocolFor(to, m.protos)
+	if !ok {
+		return nil, fmt.Errorf("peer %s doesn't support the dht protocols", p)
+	}
+
+	// the nodes don't share the messages, as if they were sent over the wire.
+	req, err := clone(pmes)
+	if err != nil {
+		return nil, err
+	}
+	m.net.record(m.net.clock.Now(), m.node, to, req)
+	if err := m.net.transmit(ctx, m.node, to); err != nil {
+		return nil, err
+	}
+	resp, err := to.DHT.HandleRequest(m.node.ID, proto, req)
+	if err != nil {
+		return nil, fmt.Errorf("stream reset: %w", err)
+	}
+	if !wantResponse {
+		return nil, nil
+	}
+	if resp == nil {
+		// the peer accepted the request but won't answer, we only find out once we time out.
+		if err := m.net.clock.sleep(ctx, m.net.cfg.Timeout, timerKey(m.node, to, "response")); err != nil {
+			return nil, err
+		}
+		return nil, errTimeout
+	}
+	if err := m.net.transmit(ctx, to, m.node); err != nil {
+		return nil, err
+	}
+	return clone(resp)
+}
+
+func clone(pmes *pb.Message) (*pb.Message, error) {
+	b, err := pmes.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var c pb.Message
+	if err := c.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,165 @@
+package simulation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+
+	var fired []int
+	done := make(chan int, 3)
+	c.AfterFunc(2*time.Second, func() { done <- 2 })
+	c.AfterFunc(time.Second, func() { done <- 1 })
+	stopped := c.NewTimer(time.Second)
+	require.True(t, stopped.Stop())
+	require.False(t, stopped.Stop())
+	t3 := c.NewTimer(3 * time.Second)
+	require.Equal(t, 3, c.Pending())
+
+	c.Advance(2500 * time.Millisecond)
+	require.Equal(t, start.Add(2500*time.Millisecond), c.Now())
+	fired = append(fired, <-done, <-done)
+	require.ElementsMatch(t, []int{1, 2}, fired)
+
+	require.True(t, c.AdvanceToNext())
+	require.Equal(t, start.Add(3*time.Second), <-t3.C)
+	require.False(t, c.AdvanceToNext())
+	require.Zero(t, c.Pending())
+
+	// the timers due at the same time fire in the order of their keys, then of creation.
+	tb := c.newTimer(time.Second, "b")
+	ta := c.newTimer(time.Second, "a")
+	t0 := c.NewTimer(time.Second)
+	for _, timer := range []*Timer{t0, ta, tb} {
+		require.True(t, c.step())
+		require.Len(t, timer.C, 1)
+	}
+
+	ctx, cancel := c.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.keyDeadline(ctx, "deadline")
+	c.Advance(999 * time.Millisecond)
+	require.NoError(t, ctx.Err())
+	require.True(t, c.step())
+	require.ErrorIs(t, context.Cause(ctx), context.DeadlineExceeded)
+}
+
+// closestPeers returns the ids of the count nodes closest to the key.
+func closestPeers(nodes []*Node, key string, count int) []peer.ID {
+	ids := make([]peer.ID, 0, len(nodes))
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+	}
+	return kb.SortClosestPeers(ids, kb.ConvertKey(key))[:count]
+}
+
+func runNetwork(t *testing.T, cfg Config, size int, opts ...dht.Option) (*Network, []peer.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	n := NewNetwork(cfg)
+	t.Cleanup(func() { require.NoError(t, n.Close()) })
+	nodes, err := n.AddNodes(ctx, size, append([]dht.Option{dht.DisableAutoRefresh()}, opts...)...)
+	require.NoError(t, err)
+	n.FillRoutingTables()
+
+	peers, err := nodes[0].DHT.GetClosestPeers(ctx, "key")
+	require.NoError(t, err)
+	return n, peers
+}
+
+func TestNetworkLookup(t *testing.T) {
+	n, peers := runNetwork(t, Config{Seed: 1, Latency: UniformLatency{Min: 10 * time.Millisecond, Max: 100 * time.Millisecond}}, 300)
+
+	require.ElementsMatch(t, closestPeers(n.Nodes(), "key", len(peers)), peers)
+	require.Greater(t, n.Clock().Since(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), 100*time.Millisecond)
+}
+
+func TestNetworkDeterminism(t *testing.T) {
+	cfg := Config{Seed: 42, Latency: UniformLatency{Min: 10 * time.Millisecond, Max: 100 * time.Millisecond}, Loss: UniformLoss(0.05), TraceMessages: true}
+	// the lost messages time out on the adaptive RPC timeouts of the DHT, in virtual time.
+	opts := []dht.Option{dht.AdaptiveRPCTimeouts(2, 100*time.Millisecond, time.Second)}
+	n1, peers1 := runNetwork(t, cfg, 100, opts...)
+	n2, peers2 := runNetwork(t, cfg, 100, opts...)
+
+	nodes1, nodes2 := n1.Nodes(), n2.Nodes()
+	for i := range nodes1 {
+		require.Equal(t, nodes1[i].ID, nodes2[i].ID)
+		require.Equal(t, nodes1[i].Addr, nodes2[i].Addr)
+	}
+	require.Equal(t, peers1, peers2)
+	require.NotEmpty(t, n1.Trace())
+	require.Equal(t, n1.Trace(), n2.Trace())
+	require.Equal(t, n1.Clock().Now(), n2.Clock().Now())
+
+	cfg.Seed++
+	n3, _ := runNetwork(t, cfg, 2)
+	require.NotEqual(t, nodes1[0].ID, n3.Nodes()[0].ID)
+}
+
+func TestNetworkChurn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	run := func() [][]bool {
+		n := NewNetwork(Config{Seed: 7, ManualClock: true, Churn: ExponentialChurn{MeanUptime: time.Hour, MeanDowntime: time.Hour}})
+		defer n.Close()
+		nodes, err := n.AddNodes(ctx, 20, dht.DisableAutoRefresh())
+		require.NoError(t, err)
+
+		var states [][]bool
+		for i := 0; i < 10; i++ {
+			n.Clock().Advance(30 * time.Minute)
+			online := make([]bool, len(nodes))
+			for j, node := range nodes {
+				online[j] = node.Online()
+			}
+			states = append(states, online)
+		}
+		return states
+	}
+
+	states := run()
+	require.Equal(t, states, run())
+	var offline int
+	for _, online := range states[len(states)-1] {
+		if !online {
+			offline++
+		}
+	}
+	require.NotZero(t, offline)
+	require.Less(t, offline, 20)
+}
+
+func TestNetworkOffline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	n := NewNetwork(Config{Seed: 3})
+	defer n.Close()
+	nodes, err := n.AddNodes(ctx, 2, dht.DisableAutoRefresh())
+	require.NoError(t, err)
+
+	require.NoError(t, n.Connect(ctx, nodes[0], nodes[1]))
+	require.Equal(t, []peer.ID{nodes[1].ID}, nodes[0].Host.Network().Peers())
+	require.NoError(t, nodes[0].DHT.Ping(ctx, nodes[1].ID))
+
+	nodes[1].SetOnline(false)
+	require.Empty(t, nodes[0].Host.Network().Peers())
+	start := n.Clock().Now()
+	require.Error(t, nodes[0].DHT.Ping(ctx, nodes[1].ID))
+	require.GreaterOrEqual(t, n.Clock().Since(start), 10*time.Second)
+
+	nodes[1].SetOnline(true)
+	require.NoError(t, nodes[0].DHT.Ping(ctx, nodes[1].ID))
+}
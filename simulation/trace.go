@@ -0,0 +1,54 @@
+package simulation
+
+import (
+	"sort"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// Message is a request sent over the simulated network, as recorded when Config.TraceMessages is
+// set.
+type Message struct {
+	// At is the virtual time the request was sent at.
+	At time.Time
+	// From and To are the indexes of the sending and receiving nodes.
+	From, To int
+	Type     pb.Message_MessageType
+	Key      string
+}
+
+// record adds a request to the trace of the network, if enabled.
+func (n *Network) record(at time.Time, from, to *Node, pmes *pb.Message) {
+	if !n.cfg.TraceMessages {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.trace = append(n.trace, Message{At: at, From: from.Index, To: to.Index, Type: pmes.GetType(), Key: string(pmes.GetKey())})
+}
+
+// Trace returns the requests sent over the network so far, when Config.TraceMessages is set. The
+// requests are ordered by virtual time, then by sender, receiver, type and key.
+func (n *Network) Trace() []Message {
+	n.mu.Lock()
+	trace := append([]Message(nil), n.trace...)
+	n.mu.Unlock()
+
+	sort.Slice(trace, func(i, j int) bool {
+		a, b := trace[i], trace[j]
+		switch {
+		case !a.At.Equal(b.At):
+			return a.At.Before(b.At)
+		case a.From != b.From:
+			return a.From < b.From
+		case a.To != b.To:
+			return a.To < b.To
+		case a.Type != b.Type:
+			return a.Type < b.Type
+		default:
+			return a.Key < b.Key
+		}
+	})
+	return trace
+}
@@ -68,6 +68,9 @@ func (dht *IpfsDHT) startNetworkSubscriber() error {
 				case event.EvtPeerConnectednessChanged:
 					if evt.Connectedness != network.Connected {
 						dht.msgSender.OnDisconnect(dht.ctx, evt.Peer)
+						if dht.doubleHashMsgSender != nil {
+							dht.doubleHashMsgSender.OnDisconnect(dht.ctx, evt.Peer)
+						}
 					}
 				case event.EvtLocalReachabilityChanged:
 					if dht.auto == ModeAuto || dht.auto == ModeAutoServer {
@@ -137,5 +140,5 @@ func (dht *IpfsDHT) validRTPeer(p peer.ID) (bool, error) {
 		return false, err
 	}
 
-	return dht.routingTablePeerFilter == nil || dht.routingTablePeerFilter(dht, p), nil
+	return dht.allowedInRT(p), nil
 }